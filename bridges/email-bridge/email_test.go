@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/cmaster11/overseer/test"
+	"github.com/cmaster11/overseer/utils"
 )
 
 func TestEmailTemplate(t *testing.T) {
@@ -48,3 +50,59 @@ func TestEmailTemplate(t *testing.T) {
 
 	t.Logf("email body:\n%s", buf.String())
 }
+
+func TestEmailDigestTemplate(t *testing.T) {
+
+	errString := "an error!"
+	testLabelString := "My label"
+
+	quietHours, err := utils.ParseQuietHours("22:00-07:00")
+	if err != nil {
+		t.Fatalf("failed to parse quiet hours: %+v", err)
+	}
+
+	templateMap := getTemplateMapFromDigest([]*test.Result{
+		{
+			Input:  "asasd",
+			Target: "1234",
+			Time:   time.Now().Unix(),
+			Type:   "my-type",
+			Tag:    "my-tag",
+			Error:  &errString,
+		},
+		{
+			Input:     "asasd",
+			Target:    "1234",
+			Time:      time.Now().Unix(),
+			Type:      "my-type",
+			Recovered: true,
+			TestLabel: &testLabelString,
+		},
+	}, quietHours)
+
+	buf := &bytes.Buffer{}
+	err = TemplateDigestSubject.Execute(buf, templateMap)
+	if err != nil {
+		t.Errorf("failed to execute digest subject template: %+v", err)
+	}
+
+	if !strings.Contains(buf.String(), "2 notifications") {
+		t.Errorf("unexpected digest subject: %s", buf.String())
+	}
+
+	t.Logf("digest subject: %s", buf.String())
+
+	buf = &bytes.Buffer{}
+	err = TemplateDigestBody.Execute(buf, templateMap)
+	if err != nil {
+		t.Errorf("failed to execute digest body template: %+v", err)
+	}
+
+	for _, expected := range []string{"[ERR] (my-tag) asasd", "Error: an error!", "[RECOVERED] My label", "22:00-07:00"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("digest body doesn't contain '%s':\n%s", expected, buf.String())
+		}
+	}
+
+	t.Logf("digest body:\n%s", buf.String())
+}
@@ -9,6 +9,9 @@
 //
 // When a test fails an email will sent via SMTP
 //
+// The subject, and body, of the emails are rendered from the text/templates
+// below, which can be replaced via -subject-template and -body-template.
+//
 // Alberto
 // --
 //
@@ -69,6 +72,9 @@ Details: {{.details}}
 {{- end}}
 
 Tag: {{if .tag}}{{.tag}}{{else}}None{{end}}
+{{- if .severity}}
+Severity: {{.severity}}
+{{- end}}
 {{- if .testLabel}}
 Test label: {{.testLabel}}
 {{- end}}
@@ -82,14 +88,46 @@ First error time: {{.firstErrorTimeDate}}
 {{- end}}
 `)))
 
+// TemplateDigestSubject is our text/template which is used to generate the
+// subject of the digest of notifications held during quiet hours.
+var TemplateDigestSubject = template.Must(template.New("tmpl").Parse(strings.TrimSpace(`
+Overseer [DIGEST]: {{len .results}} notification{{if ne (len .results) 1}}s{{end}} held during quiet hours ({{.quietHours}})
+`)))
+
+// TemplateDigestBody is our text/template which is used to generate the
+// digest of notifications held during quiet hours.
+var TemplateDigestBody = template.Must(template.New("tmpl").Parse(strings.TrimSpace(`
+Overseer: the following notifications were held during quiet hours ({{.quietHours}}).
+{{range .results}}
+- [{{if .error}}ERR{{else if .recovered}}RECOVERED{{else}}OK{{end}}]
+{{- if .tag}} ({{.tag}}){{end}} {{if .testLabel}}{{.testLabel}}{{else}}{{.input}}{{end}}
+  Target: {{.target}}
+  Time: {{.date}}
+{{- if .error}}
+  Error: {{.error}}
+{{- end}}
+{{end}}
+`)))
+
 type EmailBridge struct {
 	Sender *utils.EmailSender
 
 	// The email we notify
 	Emails []string
 
+	// The templates of the emails about single results
+	SubjectTemplate *template.Template
+	BodyTemplate    *template.Template
+
 	SendTestSuccess   bool
 	SendTestRecovered bool
+
+	// During quiet hours non-critical notifications are held, and
+	// sent as a digest once they are over.
+	QuietHours *utils.QuietHours
+
+	// The notifications held during quiet hours
+	held []*test.Result
 }
 
 func getTemplateMapFromTestResult(testResult *test.Result) map[string]interface{} {
@@ -98,6 +136,11 @@ func getTemplateMapFromTestResult(testResult *test.Result) map[string]interface{
 		firstErrorTimeDate = time.Unix(*testResult.FirstErrorTime, 0).UTC().String()
 	}
 
+	duration := ""
+	if testResult.Duration != nil {
+		duration = (time.Duration(*testResult.Duration) * time.Millisecond).String()
+	}
+
 	return map[string]interface{}{
 		"error":              testResult.Error,
 		"isDedup":            testResult.IsDedup,
@@ -110,6 +153,8 @@ func getTemplateMapFromTestResult(testResult *test.Result) map[string]interface{
 		"firstErrorTimeDate": firstErrorTimeDate,
 		"details":            testResult.Details,
 		"testLabel":          testResult.TestLabel,
+		"severity":           testResult.Severity,
+		"duration":           duration,
 	}
 }
 
@@ -141,9 +186,52 @@ func (bridge *EmailBridge) Process(msg []byte) {
 		return
 	}
 
+	//
+	// During quiet hours only critical notifications are sent straight
+	// away, the others are held for the digest.
+	//
+	if bridge.QuietHours.Active(time.Now()) && testResult.Severity != test.SeverityCritical {
+		fmt.Printf("Holding result during quiet hours: %+v\n", testResult)
+		bridge.held = append(bridge.held, testResult)
+		return
+	}
+
 	fmt.Printf("Processing result: %+v\n", testResult)
 
-	templateMap := getTemplateMapFromTestResult(testResult)
+	bridge.send(bridge.SubjectTemplate, bridge.BodyTemplate, getTemplateMapFromTestResult(testResult))
+}
+
+//
+// Once quiet hours are over send a digest of the notifications which
+// were held during them, if any.
+//
+func (bridge *EmailBridge) FlushDigest(now time.Time) {
+	if len(bridge.held) == 0 || bridge.QuietHours.Active(now) {
+		return
+	}
+
+	fmt.Printf("Sending digest of %d results held during quiet hours\n", len(bridge.held))
+
+	bridge.send(TemplateDigestSubject, TemplateDigestBody, getTemplateMapFromDigest(bridge.held, bridge.QuietHours))
+	bridge.held = nil
+}
+
+func getTemplateMapFromDigest(results []*test.Result, quietHours *utils.QuietHours) map[string]interface{} {
+	var resultMaps []map[string]interface{}
+	for _, result := range results {
+		resultMaps = append(resultMaps, getTemplateMapFromTestResult(result))
+	}
+
+	return map[string]interface{}{
+		"results":    resultMaps,
+		"quietHours": quietHours.String(),
+	}
+}
+
+//
+// Render the given templates, and send the resulting email.
+//
+func (bridge *EmailBridge) send(subjectTemplate *template.Template, bodyTemplate *template.Template, templateMap map[string]interface{}) {
 
 	//
 	// Render our template into a buffer.
@@ -152,7 +240,7 @@ func (bridge *EmailBridge) Process(msg []byte) {
 
 	{
 		buf := &bytes.Buffer{}
-		err = TemplateSubject.Execute(buf, templateMap)
+		err := subjectTemplate.Execute(buf, templateMap)
 		if err != nil {
 			fmt.Printf("Failed to compile email-template subject %s\n", err.Error())
 			return
@@ -163,7 +251,7 @@ func (bridge *EmailBridge) Process(msg []byte) {
 
 	{
 		buf := &bytes.Buffer{}
-		err = TemplateBody.Execute(buf, templateMap)
+		err := bodyTemplate.Execute(buf, templateMap)
 		if err != nil {
 			fmt.Printf("Failed to compile email-template body %s\n", err.Error())
 			return
@@ -175,7 +263,7 @@ func (bridge *EmailBridge) Process(msg []byte) {
 	// Prepare email to send
 	message := bridge.Sender.WritePlainEmail(bridge.Emails, subject, body)
 
-	err = bridge.Sender.SendRawMail(bridge.Emails, message)
+	err := bridge.Sender.SendRawMail(bridge.Emails, message)
 
 	if err != nil {
 		fmt.Printf("Waiting for process to terminate failed: %s\n", err.Error())
@@ -202,9 +290,30 @@ func main() {
 	emailStr := flag.String("email", "", "The email addresses to notify, separated by comma")
 	sendTestSuccess := flag.Bool("send-test-success", false, "Send also test results when successful")
 	sendTestRecovered := flag.Bool("send-test-recovered", false, "Send also test results when a test recovers from failure (valid only when used together with deduplication rules)")
+	quietHoursStr := flag.String("quiet-hours", "", "Hold non-critical notifications during this daily period, in local time (e.g. 22:00-07:00), and send them as a digest afterwards")
+	subjectTemplateFile := flag.String("subject-template", "", "A file holding the text/template of the email subject, to replace the built-in one")
+	bodyTemplateFile := flag.String("body-template", "", "A file holding the text/template of the email body, to replace the built-in one")
 
 	flag.Parse()
 
+	quietHours, err := utils.ParseQuietHours(*quietHoursStr)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	subjectTemplate, err := utils.LoadTemplate(*subjectTemplateFile, TemplateSubject)
+	if err != nil {
+		fmt.Printf("Failed to load the subject template: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	bodyTemplate, err := utils.LoadTemplate(*bodyTemplateFile, TemplateBody)
+	if err != nil {
+		fmt.Printf("Failed to load the body template: %s\n", err.Error())
+		os.Exit(1)
+	}
+
 	emailSender := utils.NewEmailSender(*smtpHost, *smtpPort, *smtpUsername, *smtpPassword)
 
 	emailsSplit := strings.Split(*emailStr, ",")
@@ -237,7 +346,7 @@ func main() {
 	//
 	// And run a ping, just to make sure it worked.
 	//
-	_, err := r.Ping().Result()
+	_, err = r.Ping().Result()
 	if err != nil {
 		fmt.Printf("Redis connection failed: %s\n", err.Error())
 		os.Exit(1)
@@ -246,8 +355,20 @@ func main() {
 	bridge := EmailBridge{
 		Sender:            emailSender,
 		Emails:            emailsValid,
+		SubjectTemplate:   subjectTemplate,
+		BodyTemplate:      bodyTemplate,
 		SendTestRecovered: *sendTestRecovered,
 		SendTestSuccess:   *sendTestSuccess,
+		QuietHours:        quietHours,
+	}
+
+	//
+	// With quiet hours we need to wake up regularly, to send the digest
+	// once they are over.
+	//
+	var popTimeout time.Duration
+	if quietHours != nil {
+		popTimeout = time.Minute
 	}
 
 	for {
@@ -255,7 +376,7 @@ func main() {
 		//
 		// Get test-results
 		//
-		msg, _ := r.BLPop(0, *redisQueueKey).Result()
+		msg, _ := r.BLPop(popTimeout, *redisQueueKey).Result()
 
 		//
 		// If they were non-empty, process them.
@@ -267,5 +388,7 @@ func main() {
 		if len(msg) >= 1 {
 			bridge.Process([]byte(msg[1]))
 		}
+
+		bridge.FlushDigest(time.Now())
 	}
 }
@@ -9,6 +9,14 @@
 //
 // When a test fails an email will sent via sendmail
 //
+// The email is rendered from the text/template below, which can be
+// replaced via -template.
+//
+// Non-critical failures can be held during quiet hours, and sent as a
+// single digest once they are over:
+//
+//     $ ./sendmail-bridge -email=sysadmin@example.com -quiet-hours=22:00-07:00
+//
 // Steve
 // --
 //
@@ -23,8 +31,10 @@ import (
 	"os"
 	"os/exec"
 	"text/template"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
+	"github.com/cmaster11/overseer/utils"
 
 	"github.com/go-redis/redis"
 )
@@ -34,6 +44,9 @@ var r *redis.Client
 
 // Template is our text/template which is used to generate the email
 // notification to the user.
+//
+// The fields available are From, To, Target, Type, Input, Failure,
+// Duration, TestLabel, Tag and Severity.
 var Template = `From: {{.From}}
 To: {{.To}}
 Subject: The {{.Type}} test failed against {{.Target}}
@@ -50,9 +63,65 @@ The failure was:
 
 `
 
+// DigestTemplate is our text/template which is used to generate the
+// digest of the failures held during quiet hours.
+//
+// The fields available are From, To, QuietHours and Results, each of the
+// latter having the fields of Template.
+var DigestTemplate = `From: {{.From}}
+To: {{.To}}
+Subject: {{len .Results}} test failure{{if ne (len .Results) 1}}s{{end}} held during quiet hours ({{.QuietHours}})
+
+The following test failures were held during quiet hours ({{.QuietHours}}).
+{{range .Results}}
+- The {{.Type}} test failed against {{.Target}}.
+
+   {{.Input}}
+
+  The failure was:
+
+   {{.Failure}}
+{{end}}
+`
+
+// TemplateParms are the fields of the template of a single failure.
+type TemplateParms struct {
+	To        string
+	From      string
+	Target    string
+	Type      string
+	Input     string
+	Failure   string
+	Duration  string
+	TestLabel string
+	Tag       string
+	Severity  string
+}
+
+// DigestParms are the fields of the template of a digest.
+type DigestParms struct {
+	To         string
+	From       string
+	QuietHours string
+	Results    []TemplateParms
+}
+
 type EmailBridge struct {
 	// The email we notify
 	Email string
+
+	// The template of the emails
+	Template *template.Template
+
+	// The sendmail binary, /usr/sbin/sendmail if empty
+	Sendmail string
+
+	// During quiet hours non-critical failures are held, and sent as a
+	// digest once they are over.
+	QuietHours *utils.QuietHours
+
+	// The failures held during quiet hours
+	held []TemplateParms
 }
 
 //
@@ -73,20 +142,7 @@ func (bridge *EmailBridge) Process(msg []byte) {
 	}
 
 	//
-	// Here is a temporary structure we'll use to popular our email
-	// template.
-	//
-	type TemplateParms struct {
-		To      string
-		From    string
-		Target  string
-		Type    string
-		Input   string
-		Failure string
-	}
-
-	//
-	// Populate it appropriately.
+	// Populate the fields of our email template.
 	//
 	var x TemplateParms
 	x.To = bridge.Email
@@ -95,14 +151,58 @@ func (bridge *EmailBridge) Process(msg []byte) {
 	x.Target = testResult.Target
 	x.Input = testResult.Input
 	x.Failure = *testResult.Error
+	x.Tag = testResult.Tag
+	x.Severity = testResult.Severity
+	if testResult.Duration != nil {
+		x.Duration = (time.Duration(*testResult.Duration) * time.Millisecond).String()
+	}
+	if testResult.TestLabel != nil {
+		x.TestLabel = *testResult.TestLabel
+	}
+
+	//
+	// During quiet hours only critical failures are sent straight away,
+	// the others are held for the digest.
+	//
+	if bridge.QuietHours.Active(time.Now()) && testResult.Severity != test.SeverityCritical {
+		fmt.Printf("Holding failure during quiet hours: %+v\n", testResult)
+		bridge.held = append(bridge.held, x)
+		return
+	}
+
+	bridge.send(bridge.Template, x)
+}
+
+//
+// Once quiet hours are over send a digest of the failures which were held
+// during them, if any.
+//
+func (bridge *EmailBridge) FlushDigest(now time.Time) {
+	if len(bridge.held) == 0 || bridge.QuietHours.Active(now) {
+		return
+	}
+
+	fmt.Printf("Sending digest of %d failures held during quiet hours\n", len(bridge.held))
+
+	bridge.send(template.Must(template.New("digest").Parse(DigestTemplate)), DigestParms{
+		To:         bridge.Email,
+		From:       bridge.Email,
+		QuietHours: bridge.QuietHours.String(),
+		Results:    bridge.held,
+	})
+	bridge.held = nil
+}
+
+//
+// Render the given template, and send the resulting email via sendmail.
+//
+func (bridge *EmailBridge) send(tmpl *template.Template, data interface{}) {
 
 	//
 	// Render our template into a buffer.
 	//
-	src := string(Template)
-	t := template.Must(template.New("tmpl").Parse(src))
 	buf := &bytes.Buffer{}
-	err = t.Execute(buf, x)
+	err := tmpl.Execute(buf, data)
 	if err != nil {
 		fmt.Printf("Failed to compile email-template %s\n", err.Error())
 		return
@@ -111,7 +211,11 @@ func (bridge *EmailBridge) Process(msg []byte) {
 	//
 	// Prepare to run sendmail, with a pipe we can write our message to.
 	//
-	sendmail := exec.Command("/usr/sbin/sendmail", "-f", bridge.Email, bridge.Email)
+	binary := bridge.Sendmail
+	if binary == "" {
+		binary = "/usr/sbin/sendmail"
+	}
+	sendmail := exec.Command(binary, "-f", bridge.Email, bridge.Email)
 	stdin, err := sendmail.StdinPipe()
 	if err != nil {
 		fmt.Printf("Error sending email: %s\n", err.Error())
@@ -164,6 +268,9 @@ func main() {
 	redisHost := flag.String("redis-host", "127.0.0.1:6379", "Specify the address of the redis queue.")
 	redisPass := flag.String("redis-pass", "", "Specify the password of the redis queue.")
 	var email = flag.String("email", "", "The email address to notify")
+	var templateFile = flag.String("template", "", "A file holding the text/template of the email, to replace the built-in one")
+	var sendmailPath = flag.String("sendmail", "/usr/sbin/sendmail", "The sendmail binary used to send the emails")
+	quietHoursStr := flag.String("quiet-hours", "", "Hold non-critical failures during this daily period, in local time (e.g. 22:00-07:00), and send them as a digest afterwards")
 	flag.Parse()
 
 	//
@@ -174,6 +281,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	quietHours, err := utils.ParseQuietHours(*quietHoursStr)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+
 	//
 	// Create the redis client
 	//
@@ -186,14 +299,32 @@ func main() {
 	//
 	// And run a ping, just to make sure it worked.
 	//
-	_, err := r.Ping().Result()
+	_, err = r.Ping().Result()
 	if err != nil {
 		fmt.Printf("Redis connection failed: %s\n", err.Error())
 		os.Exit(1)
 	}
 
+	tmpl, err := utils.LoadTemplate(*templateFile, template.Must(template.New("tmpl").Parse(Template)))
+	if err != nil {
+		fmt.Printf("Failed to load the template: %s\n", err.Error())
+		os.Exit(1)
+	}
+
 	bridge := EmailBridge{
-		Email: *email,
+		Email:      *email,
+		Template:   tmpl,
+		Sendmail:   *sendmailPath,
+		QuietHours: quietHours,
+	}
+
+	//
+	// With quiet hours we need to wake up regularly, to send the digest
+	// once they are over.
+	//
+	var popTimeout time.Duration
+	if quietHours != nil {
+		popTimeout = time.Minute
 	}
 
 	for {
@@ -201,7 +332,7 @@ func main() {
 		//
 		// Get test-results
 		//
-		msg, _ := r.BLPop(0, "overseer.results").Result()
+		msg, _ := r.BLPop(popTimeout, "overseer.results").Result()
 
 		//
 		// If they were non-empty, process them.
@@ -213,5 +344,7 @@ func main() {
 		if len(msg) >= 1 {
 			bridge.Process([]byte(msg[1]))
 		}
+
+		bridge.FlushDigest(time.Now())
 	}
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/cmaster11/overseer/utils"
+)
+
+// fakeSendmail writes a sendmail replacement into the given directory,
+// which saves its arguments and the message piped to it, one file per
+// email sent.  It returns the path of the binary.
+func fakeSendmail(t *testing.T, dir string) string {
+	script := fmt.Sprintf(`#!/bin/sh
+n=$(ls '%[1]s' | grep -c '^message')
+echo "$@" > '%[1]s'/args$n
+cat > '%[1]s'/message$n
+`, dir)
+
+	path := filepath.Join(dir, "sendmail")
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write the fake sendmail: %s", err)
+	}
+	return path
+}
+
+// sent returns the arguments, and message, of each email sent.
+func sent(t *testing.T, dir string) ([]string, []string) {
+	var args, messages []string
+	for i := 0; ; i++ {
+		message, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("message%d", i)))
+		if os.IsNotExist(err) {
+			return args, messages
+		}
+		if err != nil {
+			t.Fatalf("failed to read the email sent: %s", err)
+		}
+		arg, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("args%d", i)))
+		if err != nil {
+			t.Fatalf("failed to read the arguments of sendmail: %s", err)
+		}
+		args = append(args, strings.TrimSpace(string(arg)))
+		messages = append(messages, string(message))
+	}
+}
+
+// newBridge returns a bridge sending emails via a fake sendmail, within
+// the given directory.
+func newBridge(t *testing.T, dir string) *EmailBridge {
+	return &EmailBridge{
+		Email:    "sysadmin@example.com",
+		Template: template.Must(template.New("tmpl").Parse(Template)),
+		Sendmail: fakeSendmail(t, dir),
+	}
+}
+
+func TestSendFailure(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "sendmail")
+	if err != nil {
+		t.Fatalf("failed to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bridge := newBridge(t, dir)
+
+	// Passing tests aren't sent
+	bridge.Process([]byte(`{"input": "example.com must run ssh", "target": "1.2.3.4", "type": "ssh", "time": 1600000000}`))
+	bridge.Process([]byte(`{"input": "example.com must run http", "target": "1.2.3.4", "type": "http", "time": 1600000000, "error": "status code was 500 not 2xx"}`))
+
+	args, messages := sent(t, dir)
+	if len(messages) != 1 {
+		t.Fatalf("expected a single email, got %d", len(messages))
+	}
+
+	if args[0] != "-f sysadmin@example.com sysadmin@example.com" {
+		t.Errorf("unexpected arguments of sendmail: %s", args[0])
+	}
+
+	for _, expected := range []string{
+		"From: sysadmin@example.com\nTo: sysadmin@example.com\nSubject: The http test failed against 1.2.3.4\n\n",
+		"   example.com must run http\n",
+		"   status code was 500 not 2xx\n",
+	} {
+		if !strings.Contains(messages[0], expected) {
+			t.Errorf("expected the email to contain %q, got:\n%s", expected, messages[0])
+		}
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "sendmail")
+	if err != nil {
+		t.Fatalf("failed to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	//
+	// Quiet hours from an hour ago to an hour from now.
+	//
+	now := time.Now()
+	period := fmt.Sprintf("%s-%s", now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04"))
+	quietHours, err := utils.ParseQuietHours(period)
+	if err != nil {
+		t.Fatalf("failed to parse quiet hours: %s", err)
+	}
+
+	bridge := newBridge(t, dir)
+	bridge.QuietHours = quietHours
+
+	bridge.Process([]byte(`{"input": "example.com must run http", "target": "1.2.3.4", "type": "http", "time": 1600000000, "error": "timeout"}`))
+	bridge.Process([]byte(`{"input": "example.com must run ssh", "target": "1.2.3.4", "type": "ssh", "time": 1600000000, "error": "refused", "severity": "critical"}`))
+	bridge.Process([]byte(`{"input": "example.com must run ftp", "target": "1.2.3.4", "type": "ftp", "time": 1600000000, "error": "refused", "severity": "warning"}`))
+
+	// Only the critical failure is sent during quiet hours
+	_, messages := sent(t, dir)
+	if len(messages) != 1 || !strings.Contains(messages[0], "Subject: The ssh test failed") {
+		t.Fatalf("expected only the critical failure to be sent, got %v", messages)
+	}
+
+	bridge.FlushDigest(now)
+	if _, messages = sent(t, dir); len(messages) != 1 {
+		t.Fatalf("expected no digest during quiet hours, got %d emails", len(messages))
+	}
+
+	bridge.FlushDigest(now.Add(2 * time.Hour))
+	_, messages = sent(t, dir)
+	if len(messages) != 2 {
+		t.Fatalf("expected the digest once quiet hours are over, got %d emails", len(messages))
+	}
+
+	for _, expected := range []string{
+		"From: sysadmin@example.com\nTo: sysadmin@example.com\nSubject: 2 test failures held during quiet hours (" + period + ")\n\n",
+		"- The http test failed against 1.2.3.4.\n\n   example.com must run http\n\n  The failure was:\n\n   timeout\n",
+		"- The ftp test failed against 1.2.3.4.\n",
+	} {
+		if !strings.Contains(messages[1], expected) {
+			t.Errorf("expected the digest to contain %q, got:\n%s", expected, messages[1])
+		}
+	}
+
+	// The digest is only sent once
+	bridge.FlushDigest(now.Add(2 * time.Hour))
+	if _, messages = sent(t, dir); len(messages) != 2 {
+		t.Errorf("expected the digest to be sent once, got %d emails", len(messages))
+	}
+}
@@ -9,6 +9,12 @@
 //
 // When a test fails a webhook will sent
 //
+// To reduce the number of requests results can be batched, and sent as a
+// single JSON array once enough of them have accumulated, or after an
+// interval, whichever happens first:
+//
+//     $ ./webhook-bridge -url=https://example.com/bla -batch-size=50 -batch-interval=30s
+//
 // Alberto
 // --
 //
@@ -17,14 +23,20 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
+	"github.com/cmaster11/overseer/utils"
 	"github.com/go-redis/redis"
 )
 
@@ -33,6 +45,21 @@ var webhookURL *string
 var sendTestSuccess *bool
 var sendTestRecovered *bool
 
+// During quiet hours non-critical results are held, and sent as a
+// digest once they are over.
+var quietHours *utils.QuietHours
+var held []json.RawMessage
+
+// Results are batched until there are batchSize of them, or the oldest
+// is batchInterval old.
+var batchSize *int
+var batchInterval *time.Duration
+var batch []json.RawMessage
+var batchStart time.Time
+
+// Guards the results which are held, or batched
+var lock sync.Mutex
+
 // The redis handle
 var r *redis.Client
 
@@ -64,8 +91,99 @@ func process(msg []byte) {
 		return
 	}
 
+	//
+	// During quiet hours only critical results are sent straight away,
+	// the others are held for the digest.
+	//
+	if quietHours.Active(time.Now()) && testResult.Severity != test.SeverityCritical {
+		fmt.Printf("Holding result during quiet hours: %+v\n", testResult)
+		held = append(held, json.RawMessage(msg))
+		return
+	}
+
 	fmt.Printf("Processing result: %+v\n", testResult)
 
+	if *batchSize <= 1 && *batchInterval == 0 {
+		post(msg)
+		return
+	}
+
+	if len(batch) == 0 {
+		batchStart = time.Now()
+	}
+	batch = append(batch, json.RawMessage(msg))
+
+	if *batchSize > 0 && len(batch) >= *batchSize {
+		flushBatch()
+	}
+}
+
+//
+// Send the batched results, if any, as a single JSON array.
+//
+func flushBatch() {
+	if len(batch) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		fmt.Printf("Failed to encode batch: %s\n", err.Error())
+		return
+	}
+
+	fmt.Printf("Sending batch of %d results\n", len(batch))
+
+	post(payload)
+	batch = nil
+}
+
+//
+// Send the batched results once the oldest has waited for the batch
+// interval, even if the batch isn't full.
+//
+func flushStaleBatch(now time.Time) {
+	if *batchInterval > 0 && len(batch) > 0 && now.Sub(batchStart) >= *batchInterval {
+		flushBatch()
+	}
+}
+
+//
+// Once quiet hours are over send the results which were held during them,
+// if any, as a single JSON array.
+//
+func flushDigest(now time.Time) {
+	if quietHours.Active(now) {
+		return
+	}
+	sendDigest()
+}
+
+//
+// Send the results which were held during quiet hours, if any, as a
+// single JSON array.
+//
+func sendDigest() {
+	if len(held) == 0 {
+		return
+	}
+
+	digest, err := json.Marshal(held)
+	if err != nil {
+		fmt.Printf("Failed to encode digest: %s\n", err.Error())
+		return
+	}
+
+	fmt.Printf("Sending digest of %d results held during quiet hours\n", len(held))
+
+	post(digest)
+	held = nil
+}
+
+//
+// Post the given payload to the webhook.
+//
+func post(msg []byte) {
 	res, err := http.Post(*webhookURL, "application/json", bytes.NewBuffer(msg))
 	if err != nil {
 		fmt.Printf("Failed to execute webhook request: %s\n", err.Error())
@@ -107,6 +225,9 @@ func main() {
 	webhookURL = flag.String("url", "", "The url address to notify")
 	sendTestSuccess = flag.Bool("send-test-success", false, "Send also test results when successful")
 	sendTestRecovered = flag.Bool("send-test-recovered", false, "Send also test results when a test recovers from failure (valid only when used together with deduplication rules)")
+	quietHoursStr := flag.String("quiet-hours", "", "Hold non-critical results during this daily period, in local time (e.g. 22:00-07:00), and send them as a digest afterwards")
+	batchSize = flag.Int("batch-size", 1, "Send results as a JSON array once this many have accumulated (1 sends each result immediately)")
+	batchInterval = flag.Duration("batch-interval", 0, "Send batched results once the oldest has waited this long, even if the batch isn't full (at least 1s)")
 	flag.Parse()
 
 	//
@@ -123,6 +244,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	//
+	// Redis waits for whole seconds, and for ever given no time at all.
+	//
+	if *batchInterval != 0 && *batchInterval < time.Second {
+		fmt.Printf("The batch interval must be at least one second, got %s\n", *batchInterval)
+		os.Exit(1)
+	}
+
+	quietHours, err = utils.ParseQuietHours(*quietHoursStr)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+
 	//
 	// Create the redis client
 	//
@@ -143,12 +278,43 @@ func main() {
 
 	fmt.Printf("webhook bridge started with url %s\n", *webhookURL)
 
+	//
+	// With quiet hours we need to wake up regularly, to send the digest
+	// once they are over.
+	//
+	var popTimeout time.Duration
+	if quietHours != nil {
+		popTimeout = time.Minute
+	}
+
+	//
+	// Likewise with a batch interval, to send batches which aren't full.
+	//
+	if *batchInterval > 0 && (popTimeout == 0 || *batchInterval < popTimeout) {
+		popTimeout = *batchInterval
+	}
+
+	//
+	// Don't lose the batched, or held, results when we're stopped, even
+	// if the quiet hours aren't over yet.
+	//
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+
+		lock.Lock()
+		flushBatch()
+		sendDigest()
+		os.Exit(0)
+	}()
+
 	for {
 
 		//
 		// Get test-results
 		//
-		msg, _ := r.BLPop(0, *redisQueueKey).Result()
+		msg, _ := r.BLPop(popTimeout, *redisQueueKey).Result()
 
 		//
 		// If they were non-empty, process them.
@@ -157,8 +323,13 @@ func main() {
 		//
 		//   msg[1] will be the value removed from the list.
 		//
+		lock.Lock()
 		if len(msg) >= 1 {
 			process([]byte(msg[1]))
 		}
+
+		flushStaleBatch(time.Now())
+		flushDigest(time.Now())
+		lock.Unlock()
 	}
 }
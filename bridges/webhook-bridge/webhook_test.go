@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhook is a fake webhook, which records the size of each batch posted
+// to it.
+type webhook struct {
+	server *httptest.Server
+
+	lock    sync.Mutex
+	batches []int
+}
+
+func newWebhook(t *testing.T) *webhook {
+	w := &webhook{}
+	w.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("failed to read the posted batch: %s", err)
+			return
+		}
+
+		var results []json.RawMessage
+		if err := json.Unmarshal(body, &results); err != nil {
+			t.Errorf("expected a JSON array of results, got %s", body)
+			return
+		}
+
+		w.lock.Lock()
+		w.batches = append(w.batches, len(results))
+		w.lock.Unlock()
+	}))
+	return w
+}
+
+// posted returns the size of each batch posted so far.
+func (w *webhook) posted() []int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]int(nil), w.batches...)
+}
+
+// setup points the bridge at the given webhook, batching results by the
+// given size and interval.
+func setup(w *webhook, size int, interval time.Duration) {
+	send := false
+	webhookURL = &w.server.URL
+	sendTestSuccess = &send
+	sendTestRecovered = &send
+	quietHours = nil
+	batchSize = &size
+	batchInterval = &interval
+	batch = nil
+	held = nil
+}
+
+// failure returns the JSON of a failed test-result.
+func failure(i int) []byte {
+	return []byte(fmt.Sprintf(`{"input": "example.com must run http", "target": "1.2.3.%d", "type": "http", "time": 1600000000, "error": "timeout"}`, i))
+}
+
+func TestBatchSize(t *testing.T) {
+	w := newWebhook(t)
+	defer w.server.Close()
+	setup(w, 3, 0)
+
+	// Passing tests aren't batched
+	process([]byte(`{"input": "example.com must run http", "target": "1.2.3.4", "type": "http", "time": 1600000000}`))
+
+	for i := 0; i < 7; i++ {
+		process(failure(i))
+	}
+
+	if batches := w.posted(); fmt.Sprint(batches) != "[3 3]" {
+		t.Fatalf("expected two full batches of 3 results, got %v", batches)
+	}
+
+	// The final partial batch is sent on shutdown
+	flushBatch()
+	if batches := w.posted(); fmt.Sprint(batches) != "[3 3 1]" {
+		t.Fatalf("expected the final partial batch to be sent, got %v", batches)
+	}
+
+	// Once
+	flushBatch()
+	if batches := w.posted(); len(batches) != 3 {
+		t.Fatalf("expected no empty batch to be sent, got %v", batches)
+	}
+}
+
+func TestBatchInterval(t *testing.T) {
+	w := newWebhook(t)
+	defer w.server.Close()
+	setup(w, 10, 30*time.Second)
+
+	process(failure(1))
+	process(failure(2))
+
+	flushStaleBatch(batchStart.Add(29 * time.Second))
+	if batches := w.posted(); len(batches) != 0 {
+		t.Fatalf("expected the batch to be held for the interval, got %v", batches)
+	}
+
+	flushStaleBatch(batchStart.Add(30 * time.Second))
+	if batches := w.posted(); fmt.Sprint(batches) != "[2]" {
+		t.Fatalf("expected a partial batch of 2 results once the interval passed, got %v", batches)
+	}
+}
@@ -0,0 +1,208 @@
+// Coverage
+//
+// The coverage sub-command parses configuration files, without running
+// any of their tests, and shows what they monitor: how many tests there
+// are of each protocol, tag and domain, and which hosts are tested more
+// than once.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cmaster11/overseer/parser"
+	"github.com/cmaster11/overseer/test"
+	"github.com/google/subcommands"
+	"golang.org/x/net/publicsuffix"
+)
+
+// The names under which tests are counted when they have no tag, or are
+// run against an IP address rather than a domain.
+const (
+	coverageNoTag     = "(none)"
+	coverageAddresses = "(addresses)"
+)
+
+type coverageCmd struct {
+}
+
+// coverageStats are the counts of the tests parsed.
+type coverageStats struct {
+	Tests int
+
+	// The number of tests of each protocol, tag, and domain
+	Protocols map[string]int
+	Tags      map[string]int
+	Domains   map[string]int
+
+	// The protocols each host is tested with, once per test
+	Hosts map[string][]string
+}
+
+//
+// Glue
+//
+func (*coverageCmd) Name() string     { return "coverage" }
+func (*coverageCmd) Synopsis() string { return "Show what configuration files monitor" }
+func (*coverageCmd) Usage() string {
+	return `coverage [file1] [file2] .. [fileN] :
+  Parse the given configuration files, without running any tests, and show
+  what they monitor:
+
+  * The number of tests of each protocol.
+  * The number of tests of each tag, as given by test-label.
+  * The number of tests of each domain, e.g. both www.example.com and
+    https://api.example.com/ count towards example.com.
+  * The hosts which appear in more than one test, with their protocols.
+
+  This helps to spot gaps, e.g. a domain which has no dns tests.
+`
+}
+
+//
+// Flag setup.
+//
+func (p *coverageCmd) SetFlags(f *flag.FlagSet) {
+}
+
+// newCoverageStats returns empty statistics.
+func newCoverageStats() *coverageStats {
+	return &coverageStats{
+		Protocols: make(map[string]int),
+		Tags:      make(map[string]int),
+		Domains:   make(map[string]int),
+		Hosts:     make(map[string][]string),
+	}
+}
+
+// add counts the given test.
+func (s *coverageStats) add(tst test.Test) {
+	s.Tests++
+	s.Protocols[tst.Type]++
+
+	tag := coverageNoTag
+	if tst.TestLabel != nil && *tst.TestLabel != "" {
+		tag = *tst.TestLabel
+	}
+	s.Tags[tag]++
+
+	host := coverageHost(tst.Target)
+	s.Domains[coverageDomain(host)]++
+	s.Hosts[host] = append(s.Hosts[host], tst.Type)
+}
+
+// coverageHost returns the host a target refers to, which is either the
+// target itself or the host of a URL.
+func coverageHost(target string) string {
+	if strings.Contains(target, "://") {
+		if u, err := url.Parse(target); err == nil && u.Hostname() != "" {
+			target = u.Hostname()
+		}
+	}
+	return strings.TrimSuffix(strings.ToLower(target), ".")
+}
+
+// coverageDomain returns the registered domain of a host, e.g.
+// example.co.uk for www.example.co.uk.
+func coverageDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return coverageAddresses
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// write shows the statistics.
+func (s *coverageStats) write(out io.Writer) {
+	fmt.Fprintf(out, "%d tests\n", s.Tests)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	for _, section := range []struct {
+		title  string
+		counts map[string]int
+	}{
+		{"PROTOCOL", s.Protocols},
+		{"TAG", s.Tags},
+		{"DOMAIN", s.Domains},
+	} {
+		fmt.Fprintf(w, "\n%s\tTESTS\n", section.title)
+		for _, name := range coverageSorted(section.counts) {
+			fmt.Fprintf(w, "%s\t%d\n", name, section.counts[name])
+		}
+	}
+
+	shared := make(map[string]int)
+	for host, protocols := range s.Hosts {
+		if len(protocols) > 1 {
+			shared[host] = len(protocols)
+		}
+	}
+
+	if len(shared) > 0 {
+		fmt.Fprintf(w, "\nHOST\tTESTS\tPROTOCOLS\n")
+		for _, host := range coverageSorted(shared) {
+			protocols := append([]string{}, s.Hosts[host]...)
+			sort.Strings(protocols)
+			fmt.Fprintf(w, "%s\t%d\t%s\n", host, shared[host], strings.Join(protocols, ", "))
+		}
+	}
+
+	w.Flush()
+}
+
+// coverageSorted returns the names of the counts, the highest count first
+// and then alphabetically.
+func coverageSorted(counts map[string]int) []string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+//
+// Entry-point.
+//
+func (p *coverageCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	if f.NArg() < 1 {
+		fmt.Printf("Usage: overseer coverage file1 [file2 ..]\n")
+		return subcommands.ExitUsageError
+	}
+
+	stats := newCoverageStats()
+
+	for _, file := range f.Args() {
+		helper := parser.New()
+
+		err := helper.ParseFile(file, func(tst test.Test) error {
+			stats.add(tst)
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Error parsing file: %s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+	}
+
+	stats.write(os.Stdout)
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,199 @@
+// Dead-letters
+//
+// The dead-letters sub-command lists the jobs which workers gave up on, as
+// they repeatedly failed to execute, and can requeue them.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/go-redis/redis"
+	"github.com/google/subcommands"
+)
+
+type deadLettersCmd struct {
+	RedisDB          int
+	RedisHost        string
+	RedisPassword    string
+	RedisSocket      string
+	RedisDialTimeout time.Duration
+
+	// Should the jobs be moved back to their queues?
+	Requeue bool
+
+	// Should the jobs be discarded?
+	Clear bool
+
+	_r *redis.Client
+}
+
+//
+// Glue
+//
+func (*deadLettersCmd) Name() string     { return "dead-letters" }
+func (*deadLettersCmd) Synopsis() string { return "List, or requeue, jobs which repeatedly failed to execute" }
+func (*deadLettersCmd) Usage() string {
+	return `dead-letters [-requeue|-clear]:
+  List the jobs which workers moved to the dead-letter list, as they failed
+  to execute too many times (see the -dead-letter-after flag of the worker
+  sub-command), along with their last error.
+
+  With -requeue the jobs are moved back to the queues they were taken from,
+  to be retried afresh, e.g. once a bug in a protocol-test is fixed.  With
+  -clear they are discarded.
+`
+}
+
+//
+// Flag setup.
+//
+func (p *deadLettersCmd) SetFlags(f *flag.FlagSet) {
+
+	//
+	// Create the default options here
+	//
+	// This is done so we can load defaults via a configuration-file
+	// if present.
+	//
+	var defaults deadLettersCmd
+	defaults.RedisHost = "localhost:6379"
+	defaults.RedisPassword = ""
+	defaults.RedisDB = 0
+	defaults.RedisSocket = ""
+	defaults.RedisDialTimeout = 5 * time.Second
+
+	//
+	// If we have a configuration file then load it
+	//
+	if len(os.Getenv("OVERSEER")) > 0 {
+		cfg, err := ioutil.ReadFile(os.Getenv("OVERSEER"))
+		if err == nil {
+			err = json.Unmarshal(cfg, &defaults)
+			if err != nil {
+				fmt.Printf("WARNING: Error loading overseer.json - %s\n",
+					err.Error())
+			}
+		} else {
+			fmt.Printf("WARNING: Failed to read configuration-file - %s\n", err.Error())
+		}
+	}
+
+	f.IntVar(&p.RedisDB, "redis-db", defaults.RedisDB, "Specify the database-number for redis.")
+	f.StringVar(&p.RedisHost, "redis-host", defaults.RedisHost, "Specify the address of the redis queue.")
+	f.StringVar(&p.RedisPassword, "redis-pass", defaults.RedisPassword, "Specify the password for the redis queue.")
+	f.StringVar(&p.RedisSocket, "redis-socket", defaults.RedisSocket, "If set, will be used for the redis connections.")
+	f.DurationVar(&p.RedisDialTimeout, "redis-timeout", defaults.RedisDialTimeout, "Redis connection timeout.")
+	f.BoolVar(&p.Requeue, "requeue", false, "Move the jobs back to their queues, to be retried.")
+	f.BoolVar(&p.Clear, "clear", false, "Discard the jobs.")
+}
+
+// requeue moves a dead-lettered job back to its queue, as though it had
+// just been enqueued.
+func (p *deadLettersCmd) requeue(entry string) error {
+
+	//
+	// Only the one removing the job requeues it, should this run
+	// twice at once.
+	//
+	removed, err := p._r.LRem(deadJobs, 1, entry).Result()
+	if err != nil || removed == 0 {
+		return err
+	}
+
+	job := decodeJob(entry)
+
+	queue := job.Queue
+	if queue == "" {
+		queue = jobQueues[test.PriorityNormal]
+	}
+
+	encoded, err := json.Marshal(queuedJob{Input: job.Input, Enqueued: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+
+	_, err = p._r.RPush(queue, encoded).Result()
+	return err
+}
+
+//
+// Entry-point.
+//
+func (p *deadLettersCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	if p.Requeue && p.Clear {
+		fmt.Printf("Only one of -requeue and -clear may be given\n")
+		return subcommands.ExitFailure
+	}
+
+	//
+	// Connect to the redis-host.
+	//
+	if p.RedisSocket != "" {
+		p._r = redis.NewClient(&redis.Options{
+			Network:     "unix",
+			Addr:        p.RedisSocket,
+			Password:    p.RedisPassword,
+			DB:          p.RedisDB,
+			DialTimeout: p.RedisDialTimeout,
+		})
+	} else {
+		p._r = redis.NewClient(&redis.Options{
+			Addr:        p.RedisHost,
+			Password:    p.RedisPassword,
+			DB:          p.RedisDB,
+			DialTimeout: p.RedisDialTimeout,
+		})
+	}
+
+	entries, err := p._r.LRange(deadJobs, 0, -1).Result()
+	if err != nil {
+		fmt.Printf("Error reading dead-lettered jobs from redis: %s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+
+	if len(entries) == 0 {
+		fmt.Printf("No dead-lettered jobs found.\n")
+		return subcommands.ExitSuccess
+	}
+
+	if p.Clear {
+		for _, entry := range entries {
+			if _, err = p._r.LRem(deadJobs, 1, entry).Result(); err != nil {
+				fmt.Printf("Error discarding job `%s`: %s\n", entry, err.Error())
+				return subcommands.ExitFailure
+			}
+		}
+		fmt.Printf("Discarded %d jobs.\n", len(entries))
+		return subcommands.ExitSuccess
+	}
+
+	if p.Requeue {
+		for _, entry := range entries {
+			if err = p.requeue(entry); err != nil {
+				fmt.Printf("Error requeueing job `%s`: %s\n", entry, err.Error())
+				return subcommands.ExitFailure
+			}
+		}
+		fmt.Printf("Requeued %d jobs.\n", len(entries))
+		return subcommands.ExitSuccess
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "JOB\tQUEUE\tATTEMPTS\tERROR\n")
+	for _, entry := range entries {
+		job := decodeJob(entry)
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", job.Input, job.Queue, job.Attempts, job.Error)
+	}
+	w.Flush()
+
+	return subcommands.ExitSuccess
+}
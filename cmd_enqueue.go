@@ -10,21 +10,102 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/cmaster11/overseer/parser"
 	"github.com/cmaster11/overseer/test"
+	"github.com/cmaster11/overseer/utils"
 	"github.com/go-redis/redis"
 	"github.com/google/subcommands"
 )
 
+// jobQueues are the redis lists jobs are queued in, by priority.
+//
+// Jobs of a normal priority use the original list, so that existing
+// configurations, and workers, keep working.
+var jobQueues = map[string]string{
+	test.PriorityHigh:   "overseer.jobs.high",
+	test.PriorityNormal: "overseer.jobs",
+	test.PriorityLow:    "overseer.jobs.low",
+}
+
+// jobQueuesInOrder are the redis lists jobs are queued in, in the order
+// workers drain them.
+var jobQueuesInOrder = []string{
+	jobQueues[test.PriorityHigh],
+	jobQueues[test.PriorityNormal],
+	jobQueues[test.PriorityLow],
+}
+
+// delayedJobs is the redis sorted set jobs wait in until they are due,
+// scored by the unix time at which they are.  Workers move them to their
+// queue once they are.
+const delayedJobs = "overseer.jobs.delayed"
+
+// deadJobs is the redis list jobs are moved to once they have failed to
+// execute too many times, along with their error, so that they can't
+// loop forever.
+const deadJobs = "overseer.dead"
+
+// queuedJob is the envelope a test is queued in.
+type queuedJob struct {
+	// The test, as a line of input
+	Input string `json:"input"`
+
+	// Unix time, in seconds, at which the test was enqueued, or at which
+	// it became due if it was delayed
+	Enqueued int64 `json:"enqueued"`
+
+	// The queue a delayed job is moved to once it is due, or which a
+	// dead-lettered job was taken from
+	Queue string `json:"queue,omitempty"`
+
+	// How many times the job failed to execute, e.g. as it couldn't be
+	// parsed
+	Attempts int `json:"attempts,omitempty"`
+
+	// Why the job last failed to execute, once it is dead-lettered
+	Error string `json:"error,omitempty"`
+}
+
+// decodeJob returns the job found in a queue entry.
+//
+// Entries which aren't an envelope are jobs from older versions, which
+// queued the bare input, and have no enqueue time.
+func decodeJob(entry string) queuedJob {
+	if strings.HasPrefix(entry, "{") {
+		var job queuedJob
+		if err := json.Unmarshal([]byte(entry), &job); err == nil && job.Input != "" {
+			return job
+		}
+	}
+	return queuedJob{Input: entry}
+}
+
 type enqueueCmd struct {
 	RedisDB          int
 	RedisHost        string
 	RedisPassword    string
 	RedisSocket      string
 	RedisDialTimeout time.Duration
+	Dedupe           bool
+	Filter           string
+	Delay            time.Duration
 	_r               *redis.Client
+
+	// The filter tests must match, if any
+	_filter *test.Filter
+
+	// How many tests were parsed, and how many matched the filter
+	_parsed  int
+	_matched int
+
+	// The redis set of the jobs enqueued by this run, if deduplicating
+	_dedupeKey string
+
+	// How many duplicate jobs were skipped
+	_duplicates int
 }
 
 //
@@ -77,6 +158,9 @@ func (p *enqueueCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&p.RedisPassword, "redis-pass", defaults.RedisPassword, "Specify the password for the redis queue.")
 	f.StringVar(&p.RedisSocket, "redis-socket", defaults.RedisSocket, "If set, will be used for the redis connections.")
 	f.DurationVar(&p.RedisDialTimeout, "redis-timeout", defaults.RedisDialTimeout, "Redis connection timeout.")
+	f.BoolVar(&p.Dedupe, "dedupe", defaults.Dedupe, "Skip jobs which were already enqueued by this run.")
+	f.StringVar(&p.Filter, "filter", defaults.Filter, "Only enqueue tests whose label or target match this glob, or /regexp/.")
+	f.DurationVar(&p.Delay, "delay", defaults.Delay, "If set, the jobs are only run once this long has passed.")
 }
 
 //
@@ -84,7 +168,50 @@ func (p *enqueueCmd) SetFlags(f *flag.FlagSet) {
 // has been successfully parsed.
 //
 func (p *enqueueCmd) enqueueTest(tst test.Test) error {
-	_, err := p._r.RPush("overseer.jobs", tst.Input).Result()
+	p._parsed++
+	if !p._filter.Match(tst) {
+		return nil
+	}
+	p._matched++
+
+	if p._dedupeKey != "" {
+		added, err := p._r.SAdd(p._dedupeKey, utils.GetMD5Hash(tst.Input)).Result()
+		if err != nil {
+			return err
+		}
+		if added == 0 {
+			p._duplicates++
+			return nil
+		}
+
+		if _, err = p._r.Expire(p._dedupeKey, time.Hour).Result(); err != nil {
+			return err
+		}
+	}
+
+	queue := jobQueues[test.PriorityNormal]
+	if tst.Priority != "" {
+		queue = jobQueues[tst.Priority]
+	}
+
+	if p.Delay > 0 {
+		due := time.Now().Add(p.Delay).Unix()
+
+		entry, err := json.Marshal(queuedJob{Input: tst.Input, Enqueued: due, Queue: queue})
+		if err != nil {
+			return err
+		}
+
+		_, err = p._r.ZAdd(delayedJobs, redis.Z{Score: float64(due), Member: entry}).Result()
+		return err
+	}
+
+	entry, err := json.Marshal(queuedJob{Input: tst.Input, Enqueued: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+
+	_, err = p._r.RPush(queue, entry).Result()
 	return err
 }
 
@@ -93,6 +220,15 @@ func (p *enqueueCmd) enqueueTest(tst test.Test) error {
 //
 func (p *enqueueCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 
+	if p.Filter != "" {
+		filter, err := test.NewFilter(p.Filter)
+		if err != nil {
+			fmt.Printf("Invalid filter: %s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+		p._filter = filter
+	}
+
 	//
 	// Connect to the redis-host.
 	//
@@ -122,6 +258,17 @@ func (p *enqueueCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{
 		return subcommands.ExitFailure
 	}
 
+	//
+	// Jobs are deduplicated via a set which belongs to this run
+	// alone, so that neither earlier runs, nor concurrent ones,
+	// can suppress our jobs.  It expires in case we don't get
+	// to remove it ourselves.
+	//
+	if p.Dedupe {
+		p._dedupeKey = fmt.Sprintf("overseer.enqueue-dedupe.%d.%d", os.Getpid(), time.Now().UnixNano())
+		defer p._r.Del(p._dedupeKey)
+	}
+
 	//
 	// For each file on the command-line we can now parse and
 	// enqueue the jobs
@@ -152,5 +299,12 @@ func (p *enqueueCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{
 		}
 	}
 
+	if p._filter != nil {
+		fmt.Printf("%d of %d tests matched the filter\n", p._matched, p._parsed)
+	}
+	if p.Dedupe {
+		fmt.Printf("Skipped %d duplicate jobs\n", p._duplicates)
+	}
+
 	return subcommands.ExitSuccess
 }
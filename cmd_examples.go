@@ -40,7 +40,10 @@ func (p *examplesCmd) SetFlags(f *flag.FlagSet) {
 //
 // If the filter is empty then show all.
 //
-func showExamples(filter string) {
+// If list is set only the name, and example, of each handler is shown,
+// rather than the arguments it supports too.
+//
+func showExamples(filter string, list bool) {
 
 	re := regexp.MustCompile(filter)
 
@@ -60,10 +63,18 @@ func showExamples(filter string) {
 		// Create an instance of it
 		x := protocols.ProtocolHandler(name)
 
+		if list {
+			fmt.Printf("%s\n", name)
+		}
+
 		// Show the output of that function
 		output := x.Example()
 		fmt.Printf("%s\n", output)
 
+		if list {
+			continue
+		}
+
 		fmt.Printf("Arguments which are supported are now shown:\n\n")
 
 		fmt.Printf("  %10s|%s\n", "Name", "Valid Value")
@@ -101,10 +112,10 @@ func (p *examplesCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface
 
 	if len(f.Args()) > 0 {
 		for _, name := range f.Args() {
-			showExamples(name)
+			showExamples(name, false)
 		}
 	} else {
-		showExamples(".*")
+		showExamples(".*", false)
 	}
 	return subcommands.ExitSuccess
 }
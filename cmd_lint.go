@@ -0,0 +1,89 @@
+// Lint
+//
+// The lint sub-command checks configuration files for errors, without
+// running any of their tests.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/cmaster11/overseer/parser"
+	"github.com/cmaster11/overseer/test"
+	"github.com/google/subcommands"
+)
+
+type lintCmd struct {
+}
+
+//
+// Glue
+//
+func (*lintCmd) Name() string     { return "lint" }
+func (*lintCmd) Synopsis() string { return "Check configuration files for errors" }
+func (*lintCmd) Usage() string {
+	return `lint [file1] [file2] .. [fileN] :
+  Check the given configuration files for errors, such as unknown protocols,
+  or invalid arguments, without running any tests.
+
+  Every error is reported, and the exit-code is non-zero if there were any.
+
+  Executable files, whose output would be parsed by the other sub-commands,
+  are reported as errors rather than executed.
+`
+}
+
+//
+// Flag setup.
+//
+func (p *lintCmd) SetFlags(f *flag.FlagSet) {
+}
+
+//
+// Entry-point.
+//
+func (p *lintCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	if f.NArg() < 1 {
+		fmt.Printf("Usage: overseer lint file1 [file2 ..]\n")
+		return subcommands.ExitUsageError
+	}
+
+	problems := 0
+	tests := 0
+
+	for _, file := range f.Args() {
+		helper := parser.New()
+
+		//
+		// Checking a file mustn't run whatever it holds.
+		//
+		helper.NoExecute = true
+
+		//
+		// Report each error, and carry on.
+		//
+		helper.OnError = func(err *parser.LineError) {
+			fmt.Printf("%s:%d: %s\n", err.File, err.Line, err.Err.Error())
+			problems++
+		}
+
+		err := helper.ParseFile(file, func(tst test.Test) error {
+			tests++
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("%s: %s\n", file, err.Error())
+			problems++
+		}
+	}
+
+	if problems > 0 {
+		fmt.Printf("%d problems found\n", problems)
+		return subcommands.ExitFailure
+	}
+
+	fmt.Printf("%d tests are valid\n", tests)
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,57 @@
+// List protocols
+//
+// The list-protocols sub-command shows the name and usage of every
+// registered protocol-tester.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"regexp"
+
+	"github.com/cmaster11/overseer/protocols"
+	"github.com/google/subcommands"
+)
+
+type listProtocolsCmd struct {
+	// If set, only this protocol is shown
+	Protocol string
+}
+
+//
+// Glue
+//
+func (*listProtocolsCmd) Name() string     { return "list-protocols" }
+func (*listProtocolsCmd) Synopsis() string { return "List the available protocol-tests." }
+func (*listProtocolsCmd) Usage() string {
+	return `list-protocols [-name protocol] :
+  Show the name, and usage, of each of our protocol-tests.
+`
+}
+
+//
+// Flag setup.
+//
+func (p *listProtocolsCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.Protocol, "name", "", "Only show the protocol with this name.")
+}
+
+//
+// Entry-point.
+//
+func (p *listProtocolsCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	if p.Protocol == "" {
+		showExamples(".*", true)
+		return subcommands.ExitSuccess
+	}
+
+	if protocols.ProtocolHandler(p.Protocol) == nil {
+		fmt.Printf("Unknown protocol '%s'\n", p.Protocol)
+		return subcommands.ExitFailure
+	}
+	showExamples("^"+regexp.QuoteMeta(p.Protocol)+"$", true)
+
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,246 @@
+// Local
+//
+// The local sub-command runs the tests from configuration files directly,
+// without a redis queue, and exits with a status reflecting the results.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/parser"
+	"github.com/cmaster11/overseer/protocols"
+	"github.com/cmaster11/overseer/test"
+	"github.com/google/subcommands"
+)
+
+type localCmd struct {
+	// Should we run tests against IPv4 addresses?
+	IPv4 bool
+
+	// Should we run tests against IPv6 addresses?
+	IPv6 bool
+
+	// How long should tests run for?
+	Timeout time.Duration
+
+	// How long to wait for a test past its timeout, before abandoning it
+	TimeoutGrace time.Duration
+
+	// Should the testing, and the tests, be verbose?
+	Verbose bool
+
+	// If set, only tests whose label or target match are run
+	Filter string
+
+	// The exit-codes for each kind of failure
+	ExitAssertion    int
+	ExitConnectivity int
+	ExitConfig       int
+}
+
+//
+// Glue
+//
+func (*localCmd) Name() string     { return "local" }
+func (*localCmd) Synopsis() string { return "Run the tests from configuration files once" }
+func (*localCmd) Usage() string {
+	return `local [file1] [file2] .. [fileN] :
+  Run the tests from the given configuration files, without a redis queue,
+  and report the results.
+
+  The exit-code reflects the most serious kind of failure:
+
+    0  All tests passed.
+    1  A test failed an assertion (-exit-assertion).
+    2  A target couldn't be reached (-exit-connectivity).
+    3  A configuration was invalid (-exit-config).
+
+  As in the worker, a test is given the -timeout, unless it has its own
+  'with timeout', and is abandoned as failed should it still be running
+  after the -timeout-grace.
+`
+}
+
+//
+// Flag setup.
+//
+func (p *localCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&p.IPv4, "4", true, "Enable IPv4 tests.")
+	f.BoolVar(&p.IPv6, "6", true, "Enable IPv6 tests.")
+	f.DurationVar(&p.Timeout, "timeout", 10*time.Second, "The global timeout for all tests.")
+	f.DurationVar(&p.TimeoutGrace, "timeout-grace", 5*time.Second, "How long to wait for a test past its timeout, before abandoning it as timed out.")
+	f.BoolVar(&p.Verbose, "verbose", false, "Show more output.")
+	f.StringVar(&p.Filter, "filter", "", "Only run tests whose label or target match this glob, or /regexp/.")
+
+	f.IntVar(&p.ExitAssertion, "exit-assertion", 1, "The exit-code when a test fails an assertion.")
+	f.IntVar(&p.ExitConnectivity, "exit-connectivity", 2, "The exit-code when a target can't be reached.")
+	f.IntVar(&p.ExitConfig, "exit-config", 3, "The exit-code when a configuration is invalid.")
+}
+
+// exitCode returns the exit-code for the given kind of failure.
+func (p *localCmd) exitCode(kind test.FailureKind) subcommands.ExitStatus {
+	switch kind {
+	case test.FailureAssertion:
+		return subcommands.ExitStatus(p.ExitAssertion)
+	case test.FailureConnectivity:
+		return subcommands.ExitStatus(p.ExitConnectivity)
+	case test.FailureConfig:
+		return subcommands.ExitStatus(p.ExitConfig)
+	}
+	return subcommands.ExitSuccess
+}
+
+// targets returns the addresses the given test should be run against.
+func (p *localCmd) targets(handler protocols.ProtocolTest, tst test.Test) ([]string, error) {
+	if !handler.ShouldResolveHostname() {
+		return []string{tst.Target}, nil
+	}
+
+	host := tst.Target
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return nil, &test.ConfigError{Err: err}
+		}
+		host = u.Hostname()
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, &test.ConnectivityError{Err: fmt.Errorf("failed to resolve name %s", host)}
+	}
+
+	var targets []string
+	for _, ip := range ips {
+		if ip.To4() != nil && p.IPv4 {
+			targets = append(targets, ip.String())
+		}
+		if ip.To4() == nil && p.IPv6 {
+			targets = append(targets, ip.String())
+		}
+	}
+
+	if tst.MaxTargetsCount > 0 && len(targets) > tst.MaxTargetsCount {
+		sort.Strings(targets)
+		targets = targets[:tst.MaxTargetsCount]
+	}
+
+	return targets, nil
+}
+
+// runTest runs a single test against each of its targets, reporting the
+// results, and returns the most serious kind of failure.
+func (p *localCmd) runTest(tst test.Test) test.FailureKind {
+	handler := protocols.ProtocolHandler(tst.Type)
+
+	opts := test.Options{
+		Timeout: p.Timeout,
+		Verbose: p.Verbose,
+	}
+
+	input := tst.Sanitize()
+
+	targets, err := p.targets(handler, tst)
+	if err != nil {
+		kind := test.Classify(err)
+		fmt.Printf("FAIL [%s]: %s - %s\n", kind, input, err.Error())
+		return kind
+	}
+
+	worst := test.FailureNone
+	for _, target := range targets {
+		if p.Verbose {
+			fmt.Printf("Running '%s' test against %s (%s)\n", tst.Type, tst.Target, target)
+		}
+
+		//
+		// As in the worker, a per-test timeout overrides ours, a test
+		// which overruns it is abandoned, and a panic fails the test.
+		//
+		resolved, active := tst.Resolve(time.Now())
+		if p.Verbose && len(active) > 0 {
+			fmt.Printf("Conditions active: %s\n", strings.Join(active, ", "))
+		}
+
+		err := runWithGrace("", handler, resolved, target, opts, p.TimeoutGrace)
+		if err != nil && len(active) > 0 {
+			err = fmt.Errorf("%w (while %s applied)", err, strings.Join(active, ", "))
+		}
+		kind := test.Classify(err)
+		if kind > worst {
+			worst = kind
+		}
+
+		if err != nil {
+			fmt.Printf("FAIL [%s]: %s (%s) - %s\n", kind, input, target, err.Error())
+		} else {
+			fmt.Printf("PASS: %s (%s)\n", input, target)
+		}
+	}
+
+	return worst
+}
+
+//
+// Entry-point.
+//
+func (p *localCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	if f.NArg() < 1 {
+		fmt.Printf("Usage: overseer local [flags] file1 [file2 ..]\n")
+		return subcommands.ExitUsageError
+	}
+
+	var filter *test.Filter
+	if p.Filter != "" {
+		var err error
+		filter, err = test.NewFilter(p.Filter)
+		if err != nil {
+			fmt.Printf("Invalid filter: %s\n", err.Error())
+			return subcommands.ExitUsageError
+		}
+	}
+
+	//
+	// Parse all the files first, so that a broken configuration
+	// doesn't result in a partial run.
+	//
+	parsed := 0
+	var tests []test.Test
+	for _, file := range f.Args() {
+		helper := parser.New()
+
+		err := helper.ParseFile(file, func(tst test.Test) error {
+			parsed++
+			if filter.Match(tst) {
+				tests = append(tests, tst)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Error parsing file: %s\n", err.Error())
+			return p.exitCode(test.FailureConfig)
+		}
+	}
+
+	if filter != nil {
+		fmt.Printf("%d of %d tests matched the filter\n", len(tests), parsed)
+	}
+
+	worst := test.FailureNone
+	for _, tst := range tests {
+		kind := p.runTest(tst)
+		if kind > worst {
+			worst = kind
+		}
+	}
+
+	return p.exitCode(worst)
+}
@@ -0,0 +1,424 @@
+// Report
+//
+// The report sub-command aggregates the latest test results published by
+// workers in different regions, and classifies each test as healthy, or
+// suffering a regional or global outage.
+//
+// The availability of each test is shown too, both as the flat percentage
+// of results which passed and, optionally, weighted towards the recent
+// results.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/go-redis/redis"
+	"github.com/google/subcommands"
+)
+
+// The region used for results published by workers without a tag.
+const reportDefaultRegion = "default"
+
+// The classifications of a test, across all regions.
+const (
+	reportHealthy  = "healthy"
+	reportRegional = "regional outage"
+	reportGlobal   = "global outage"
+)
+
+type reportCmd struct {
+	RedisDB          int
+	RedisHost        string
+	RedisPassword    string
+	RedisSocket      string
+	RedisDialTimeout time.Duration
+
+	// The queue to read results from
+	Queue string
+
+	// Results older than this are ignored
+	Since time.Duration
+
+	// The age at which a result counts half as much towards the
+	// recency-weighted availability, zero to not compute it
+	HalfLife time.Duration
+
+	_r *redis.Client
+}
+
+// reportRow is the status of a single test, across all regions.
+type reportRow struct {
+	Test string
+
+	// The status of the test in each region which reported it,
+	// true if the test passed.
+	Regions map[string]bool
+
+	Classification string
+
+	// The percentage of results which passed.
+	Availability float64
+
+	// The percentage of results which passed, each weighted by its age.
+	Recent float64
+}
+
+//
+// Glue
+//
+func (*reportCmd) Name() string     { return "report" }
+func (*reportCmd) Synopsis() string { return "Report the status of tests across regions" }
+func (*reportCmd) Usage() string {
+	return `report [file1 file2 .. fileN]:
+  Show the latest status of each test, as reported by the workers of each
+  region, and classify the test as healthy, or suffering a regional or a
+  global outage.
+
+  The region of a result is the tag of the worker which published it, see
+  the -tag flag of the worker sub-command.
+
+  Results are read from the redis results-queue, without removing them, or
+  from the named files if any are given (one JSON result per line, use "-"
+  to read from STDIN).  A queue dedicated to the report can be populated
+  via the queue-bridge.
+
+  The availability of each test is the percentage of its results which
+  passed.  With -half-life the availability is also computed with each
+  result weighted by its age, a result of that age counting half as much
+  as one published now, which gives a better picture of its current health.
+`
+}
+
+//
+// Flag setup.
+//
+func (p *reportCmd) SetFlags(f *flag.FlagSet) {
+
+	//
+	// Create the default options here
+	//
+	// This is done so we can load defaults via a configuration-file
+	// if present.
+	//
+	var defaults reportCmd
+	defaults.RedisHost = "localhost:6379"
+	defaults.RedisPassword = ""
+	defaults.RedisDB = 0
+	defaults.RedisSocket = ""
+	defaults.RedisDialTimeout = 5 * time.Second
+	defaults.Queue = "overseer.results"
+	defaults.Since = time.Hour
+	defaults.HalfLife = 0
+
+	//
+	// If we have a configuration file then load it
+	//
+	if len(os.Getenv("OVERSEER")) > 0 {
+		cfg, err := ioutil.ReadFile(os.Getenv("OVERSEER"))
+		if err == nil {
+			err = json.Unmarshal(cfg, &defaults)
+			if err != nil {
+				fmt.Printf("WARNING: Error loading overseer.json - %s\n",
+					err.Error())
+			}
+		} else {
+			fmt.Printf("WARNING: Failed to read configuration-file - %s\n", err.Error())
+		}
+	}
+
+	f.IntVar(&p.RedisDB, "redis-db", defaults.RedisDB, "Specify the database-number for redis.")
+	f.StringVar(&p.RedisHost, "redis-host", defaults.RedisHost, "Specify the address of the redis queue.")
+	f.StringVar(&p.RedisPassword, "redis-pass", defaults.RedisPassword, "Specify the password for the redis queue.")
+	f.StringVar(&p.RedisSocket, "redis-socket", defaults.RedisSocket, "If set, will be used for the redis connections.")
+	f.DurationVar(&p.RedisDialTimeout, "redis-timeout", defaults.RedisDialTimeout, "Redis connection timeout.")
+	f.StringVar(&p.Queue, "queue", defaults.Queue, "The redis queue to read results from.")
+	f.DurationVar(&p.Since, "since", defaults.Since, "Ignore results older than this, 0 to use all of them.")
+	f.DurationVar(&p.HalfLife, "half-life", defaults.HalfLife, "Also show the availability with results weighted by their age, halving every period of this length.")
+}
+
+// loadQueue reads all the results in the redis queue, without consuming
+// them.
+func (p *reportCmd) loadQueue() ([]*test.Result, error) {
+
+	//
+	// Connect to the redis-host.
+	//
+	if p.RedisSocket != "" {
+		p._r = redis.NewClient(&redis.Options{
+			Network:     "unix",
+			Addr:        p.RedisSocket,
+			Password:    p.RedisPassword,
+			DB:          p.RedisDB,
+			DialTimeout: p.RedisDialTimeout,
+		})
+	} else {
+		p._r = redis.NewClient(&redis.Options{
+			Addr:        p.RedisHost,
+			Password:    p.RedisPassword,
+			DB:          p.RedisDB,
+			DialTimeout: p.RedisDialTimeout,
+		})
+	}
+
+	entries, err := p._r.LRange(p.Queue, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*test.Result
+	for _, entry := range entries {
+		result, errJSON := test.ResultFromJSON([]byte(entry))
+		if errJSON != nil {
+			fmt.Printf("WARNING: Skipping invalid result '%s' - %s\n", entry, errJSON)
+			continue
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// loadFile reads the results from the given file, one per line.
+func (p *reportCmd) loadFile(file string) ([]*test.Result, error) {
+	var reader io.Reader = os.Stdin
+
+	if file != "-" {
+		handle, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer handle.Close()
+		reader = handle
+	}
+
+	var results []*test.Result
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		result, err := test.ResultFromJSON([]byte(line))
+		if err != nil {
+			fmt.Printf("WARNING: Skipping invalid result '%s' - %s\n", line, err)
+			continue
+		}
+		results = append(results, result)
+	}
+
+	return results, scanner.Err()
+}
+
+// buildReport aggregates the latest result of each test, in each region,
+// ignoring any results published before the given time.
+//
+// A test is regarded as down in a region if the latest result of any of
+// its targets in that region is a failure.
+//
+// The availability of a test is computed from all its results, and if a
+// half-life is given the weight of each result halves with every half-life
+// which passed between its publication and now.
+func buildReport(results []*test.Result, since time.Time, now time.Time, halfLife time.Duration) ([]reportRow, []string) {
+
+	// test -> region -> target -> latest result
+	latest := make(map[string]map[string]map[string]*test.Result)
+
+	// test -> the number of results, and of those which passed
+	total := make(map[string]float64)
+	passed := make(map[string]float64)
+
+	// test -> the same, weighted by the age of each result
+	weighted := make(map[string]float64)
+	weightedPassed := make(map[string]float64)
+
+	for _, result := range results {
+		if result.Time < since.Unix() {
+			continue
+		}
+
+		weight := 1.0
+		if halfLife > 0 {
+			age := now.Sub(time.Unix(result.Time, 0))
+			if age < 0 {
+				age = 0
+			}
+			weight = math.Pow(0.5, float64(age)/float64(halfLife))
+		}
+
+		total[result.Input]++
+		weighted[result.Input] += weight
+		if result.Error == nil {
+			passed[result.Input]++
+			weightedPassed[result.Input] += weight
+		}
+
+		region := result.Tag
+		if region == "" {
+			region = reportDefaultRegion
+		}
+
+		if latest[result.Input] == nil {
+			latest[result.Input] = make(map[string]map[string]*test.Result)
+		}
+		if latest[result.Input][region] == nil {
+			latest[result.Input][region] = make(map[string]*test.Result)
+		}
+
+		previous := latest[result.Input][region][result.Target]
+		if previous == nil || previous.Time <= result.Time {
+			latest[result.Input][region][result.Target] = result
+		}
+	}
+
+	regionSeen := make(map[string]bool)
+	var rows []reportRow
+
+	for input, regions := range latest {
+		row := reportRow{
+			Test:         input,
+			Regions:      make(map[string]bool),
+			Availability: 100 * passed[input] / total[input],
+		}
+
+		//
+		// The weights of results over ~1000 half-lives old round down
+		// to zero, in which case they're all regarded as equally old.
+		//
+		row.Recent = row.Availability
+		if weighted[input] > 0 {
+			row.Recent = 100 * weightedPassed[input] / weighted[input]
+		}
+
+		down := 0
+		for region, targets := range regions {
+			regionSeen[region] = true
+
+			up := true
+			for _, result := range targets {
+				if result.Error != nil {
+					up = false
+				}
+			}
+
+			row.Regions[region] = up
+			if !up {
+				down++
+			}
+		}
+
+		switch {
+		case down == 0:
+			row.Classification = reportHealthy
+		case down == len(row.Regions):
+			row.Classification = reportGlobal
+		default:
+			row.Classification = reportRegional
+		}
+
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Test < rows[j].Test
+	})
+
+	var regionNames []string
+	for region := range regionSeen {
+		regionNames = append(regionNames, region)
+	}
+	sort.Strings(regionNames)
+
+	return rows, regionNames
+}
+
+//
+// Entry-point.
+//
+func (p *reportCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	var results []*test.Result
+
+	if len(f.Args()) > 0 {
+		for _, file := range f.Args() {
+			loaded, err := p.loadFile(file)
+			if err != nil {
+				fmt.Printf("Error reading results from %s: %s\n", file, err.Error())
+				return subcommands.ExitFailure
+			}
+			results = append(results, loaded...)
+		}
+	} else {
+		loaded, err := p.loadQueue()
+		if err != nil {
+			fmt.Printf("Error reading results from redis: %s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+		results = loaded
+	}
+
+	var since time.Time
+	if p.Since > 0 {
+		since = time.Now().Add(-p.Since)
+	}
+
+	rows, regions := buildReport(results, since, time.Now(), p.HalfLife)
+	if len(rows) == 0 {
+		fmt.Printf("No results found.\n")
+		return subcommands.ExitSuccess
+	}
+
+	//
+	// Output the matrix of test x region.
+	//
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "AVAILABILITY"
+	if p.HalfLife > 0 {
+		header += "\tRECENT"
+	}
+	fmt.Fprintf(w, "TEST\t%s\tSTATUS\t%s\n", strings.Join(regions, "\t"), header)
+
+	counts := make(map[string]int)
+	for _, row := range rows {
+		var cells []string
+		for _, region := range regions {
+			up, ok := row.Regions[region]
+			switch {
+			case !ok:
+				cells = append(cells, "-")
+			case up:
+				cells = append(cells, "up")
+			default:
+				cells = append(cells, "DOWN")
+			}
+		}
+
+		availability := fmt.Sprintf("%.2f%%", row.Availability)
+		if p.HalfLife > 0 {
+			availability += fmt.Sprintf("\t%.2f%%", row.Recent)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", row.Test, strings.Join(cells, "\t"), row.Classification, availability)
+		counts[row.Classification]++
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d healthy, %d regional outages, %d global outages\n", counts[reportHealthy], counts[reportRegional], counts[reportGlobal])
+
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// reportResult returns a result of the given test, published by a worker
+// of the given region the given time before now.
+func reportResult(input string, region string, target string, age time.Duration, now time.Time, failed bool) *test.Result {
+	result := &test.Result{
+		Input:  input,
+		Target: target,
+		Tag:    region,
+		Time:   now.Add(-age).Unix(),
+	}
+	if failed {
+		failure := "failed"
+		result.Error = &failure
+	}
+	return result
+}
+
+// Test the classification, and availability, of tests across regions
+func TestBuildReport(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	tests := []struct {
+		Name     string
+		Results  []*test.Result
+		HalfLife time.Duration
+
+		Regions        map[string]bool
+		Classification string
+		Availability   float64
+		Recent         float64
+	}{
+		{
+			Name: "healthy",
+			Results: []*test.Result{
+				reportResult("a", "eu", "1.1.1.1", time.Minute, now, false),
+				reportResult("a", "us", "1.1.1.1", time.Minute, now, false),
+			},
+			Regions:        map[string]bool{"eu": true, "us": true},
+			Classification: reportHealthy,
+			Availability:   100,
+			Recent:         100,
+		},
+		{
+			Name: "recovered",
+			Results: []*test.Result{
+				reportResult("a", "eu", "1.1.1.1", 2*time.Minute, now, true),
+				reportResult("a", "eu", "1.1.1.1", time.Minute, now, false),
+			},
+			Regions:        map[string]bool{"eu": true},
+			Classification: reportHealthy,
+			Availability:   50,
+			Recent:         50,
+		},
+		{
+			Name: "regional",
+			Results: []*test.Result{
+				reportResult("a", "eu", "1.1.1.1", time.Minute, now, false),
+				reportResult("a", "us", "1.1.1.1", time.Minute, now, false),
+				reportResult("a", "us", "2.2.2.2", time.Minute, now, true),
+			},
+			Regions:        map[string]bool{"eu": true, "us": false},
+			Classification: reportRegional,
+			Availability:   200.0 / 3,
+			Recent:         200.0 / 3,
+		},
+		{
+			Name: "global",
+			Results: []*test.Result{
+				reportResult("a", "eu", "1.1.1.1", time.Minute, now, true),
+				reportResult("a", "", "1.1.1.1", time.Minute, now, true),
+			},
+			Regions:        map[string]bool{"eu": false, reportDefaultRegion: false},
+			Classification: reportGlobal,
+			Availability:   0,
+			Recent:         0,
+		},
+		{
+			Name: "weighted",
+			Results: []*test.Result{
+				reportResult("a", "eu", "1.1.1.1", 0, now, false),
+				reportResult("a", "eu", "1.1.1.1", time.Hour, now, true),
+			},
+			HalfLife:       time.Hour,
+			Regions:        map[string]bool{"eu": true},
+			Classification: reportHealthy,
+			Availability:   50,
+			Recent:         100 * 1 / 1.5,
+		},
+		{
+			Name: "ancient",
+			Results: []*test.Result{
+				reportResult("a", "eu", "1.1.1.1", 2000*time.Hour, now, false),
+				reportResult("a", "eu", "1.1.1.1", 2001*time.Hour, now, true),
+				reportResult("a", "eu", "1.1.1.1", 2002*time.Hour, now, false),
+			},
+			HalfLife:       time.Hour,
+			Regions:        map[string]bool{"eu": true},
+			Classification: reportHealthy,
+			Availability:   200.0 / 3,
+			Recent:         200.0 / 3,
+		},
+	}
+
+	for _, tst := range tests {
+		rows, _ := buildReport(tst.Results, time.Time{}, now, tst.HalfLife)
+		if len(rows) != 1 {
+			t.Errorf("%s: expected a single row, got %d", tst.Name, len(rows))
+			continue
+		}
+		row := rows[0]
+
+		if !reflect.DeepEqual(row.Regions, tst.Regions) {
+			t.Errorf("%s: expected the regions %v, got %v", tst.Name, tst.Regions, row.Regions)
+		}
+		if row.Classification != tst.Classification {
+			t.Errorf("%s: expected the classification '%s', got '%s'", tst.Name, tst.Classification, row.Classification)
+		}
+		// Written so that NaN fails too
+		if !(math.Abs(row.Availability-tst.Availability) < 0.001) {
+			t.Errorf("%s: expected an availability of %.3f%%, got %.3f%%", tst.Name, tst.Availability, row.Availability)
+		}
+		if !(math.Abs(row.Recent-tst.Recent) < 0.001) {
+			t.Errorf("%s: expected a recent availability of %.3f%%, got %.3f%%", tst.Name, tst.Recent, row.Recent)
+		}
+	}
+}
+
+// Test that old results are ignored, and that rows and regions are sorted
+func TestBuildReportSince(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	results := []*test.Result{
+		reportResult("b", "us", "1.1.1.1", time.Minute, now, false),
+		reportResult("a", "eu", "1.1.1.1", time.Minute, now, false),
+		reportResult("a", "eu", "1.1.1.1", 2*time.Hour, now, true),
+		reportResult("c", "ap", "1.1.1.1", 2*time.Hour, now, true),
+	}
+
+	rows, regions := buildReport(results, now.Add(-time.Hour), now, 0)
+
+	if !reflect.DeepEqual(regions, []string{"eu", "us"}) {
+		t.Errorf("Expected the regions eu and us, got %v", regions)
+	}
+	if len(rows) != 2 || rows[0].Test != "a" || rows[1].Test != "b" {
+		t.Fatalf("Expected the rows of the tests a and b, got %v", rows)
+	}
+	if rows[0].Availability != 100 {
+		t.Errorf("Expected the old failure to be ignored, got %.2f%%", rows[0].Availability)
+	}
+}
@@ -10,14 +10,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"runtime"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/cmaster11/overseer/parser"
@@ -30,6 +34,14 @@ import (
 	_ "github.com/skx/golang-metrics"
 )
 
+const (
+	// The minimum number of latencies to record before a test's percentile is trusted
+	latencyMinSamples = 10
+
+	// How long the latencies of a test which is no longer run are kept for
+	latencySamplesExpiry = 7 * 24 * time.Hour
+)
+
 // This is our structure, largely populated by command-line arguments
 type workerCmd struct {
 	// How many parallel checks can we execute?
@@ -50,6 +62,9 @@ type workerCmd struct {
 	// Prior to retrying a failed test how long should we pause?
 	RetryDelay time.Duration
 
+	// Should the first failure of a test be confirmed by running it again straight away?
+	Confirm bool
+
 	// Default min duration
 	MinDuration time.Duration
 
@@ -77,9 +92,27 @@ type workerCmd struct {
 	// Tag applied to all results
 	Tag string
 
+	// The redis list results are published to
+	ResultsQueue string
+
+	// If set, only tests whose label or target match are run
+	Filter string
+
+	// If > 0, jobs which were enqueued longer ago than this are discarded
+	MaxAge time.Duration
+
 	// How long should tests run for?
 	Timeout time.Duration
 
+	// How long past its timeout do we wait for a test, before abandoning it?
+	TimeoutGrace time.Duration
+
+	// How long do we wait for running tests when asked to exit, before exiting anyway?
+	ShutdownGrace time.Duration
+
+	// How many times may a job fail to execute, before it is dead-lettered?
+	DeadLetterAttempts uint
+
 	// Should the testing, and the tests, be verbose?
 	Verbose bool
 
@@ -89,11 +122,74 @@ type workerCmd struct {
 	// Default period test threshold percentage, if not overridden by specific test setting
 	PeriodTestThreshold float32
 
+	// How many recent latencies of a test are kept, to learn its percentiles
+	LatencySamples uint
+
+	// Default percentage by which a latency may exceed its percentile, if not overridden by specific test setting
+	LatencyMargin float32
+
+	// The (optional) OpenTelemetry collector we export results to.
+	OTLPEndpoint string
+
+	// The service-name we report to the OpenTelemetry collector.
+	OTLPServiceName string
+
+	// The (optional) Slack incoming webhook results are posted to.
+	SlackWebhook string
+
+	// The (optional) URL results are posted to as JSON, along with the
+	// bearer token to authenticate with, and how often to retry a post.
+	WebhookURL     string
+	WebhookToken   string
+	WebhookRetries int
+
+	// Which results are passed to the notifiers, see notifyModeAll & etc.
+	NotifyMode string
+
+	// How long may a notifier take to deliver a single result?
+	NotifyTimeout time.Duration
+
+	// Where the "changes" notify-mode records the last state of each
+	// test, see stateStoreMemory & etc, and how long the state of a
+	// test which isn't run anymore is kept in redis.
+	StateStore  string
+	StateExpiry time.Duration
+
+	// The (optional) SQL database each result is also stored in: its
+	// driver, "mysql" or "postgres", its DSN, the table, and how many
+	// results are inserted at once.
+	SQLDriver    string
+	SQLDSN       string
+	SQLTable     string
+	SQLBatchSize int
+
+	// The (optional) address Prometheus metrics are served on, at
+	// /metrics.
+	MetricsAddr string
+
 	// The handle to our redis-server
 	_r *redis.Client
 
+	// The filter tests must match, if any
+	_filter *test.Filter
+
 	// The handle to our graphite-server
 	_g *graphite.Graphite
+
+	// The exporter to our OpenTelemetry collector
+	_otlp *otlpExporter
+
+	// The notifiers results are passed to
+	_notifiers []notifier
+
+	// Whether each test failed last time, for the "changes" notify-mode
+	_states stateStore
+
+	// The sinks results are stored in, besides the results-queue
+	_sinks []sink
+
+	// Resolves the hostnames of tests, net.LookupIP if nil
+	_lookupIP func(host string) ([]net.IP, error)
 }
 
 //
@@ -179,7 +275,11 @@ func (p *workerCmd) SetFlags(f *flag.FlagSet) {
 	defaults.MinDurationCacheFactor = 10
 	defaults.DedupDuration = 0
 	defaults.Tag = ""
+	defaults.ResultsQueue = "overseer.results"
 	defaults.Timeout = 10 * time.Second
+	defaults.TimeoutGrace = 5 * time.Second
+	defaults.ShutdownGrace = 30 * time.Second
+	defaults.DeadLetterAttempts = 3
 	defaults.Verbose = false
 	defaults.RedisHost = "localhost:6379"
 	defaults.RedisDB = 0
@@ -187,6 +287,17 @@ func (p *workerCmd) SetFlags(f *flag.FlagSet) {
 	defaults.RedisDialTimeout = 5 * time.Second
 	defaults.PeriodTestSleep = 5 * time.Second
 	defaults.PeriodTestThreshold = 0
+	defaults.LatencySamples = 100
+	defaults.LatencyMargin = 0.2
+	defaults.OTLPServiceName = "overseer"
+	defaults.NotifyMode = notifyModeFailures
+	defaults.NotifyTimeout = 5 * time.Second
+	defaults.StateStore = stateStoreMemory
+	defaults.StateExpiry = 7 * 24 * time.Hour
+	defaults.SQLDriver = "mysql"
+	defaults.SQLTable = "overseer_results"
+	defaults.SQLBatchSize = 100
+	defaults.WebhookRetries = 2
 
 	//
 	// If we have a configuration file then load it
@@ -220,11 +331,14 @@ func (p *workerCmd) SetFlags(f *flag.FlagSet) {
 
 	// Timeout
 	f.DurationVar(&p.Timeout, "timeout", defaults.Timeout, "The global timeout for all tests, in seconds.")
+	f.DurationVar(&p.TimeoutGrace, "timeout-grace", defaults.TimeoutGrace, "How long to wait for a test past its timeout, before abandoning it as timed out.")
+	f.DurationVar(&p.ShutdownGrace, "shutdown-grace", defaults.ShutdownGrace, "How long to wait for running tests to complete when asked to exit, before exiting anyway. 0 waits forever.")
 
 	// Retry
 	f.BoolVar(&p.Retry, "retry", defaults.Retry, "Should failing tests be retried a few times before raising a notification.")
-	f.UintVar(&p.RetryCount, "retry-count", defaults.RetryCount, "How many times to retry a test, before regarding it as a failure.")
+	f.UintVar(&p.RetryCount, "retry-count", defaults.RetryCount, "How many times to run a failing test in total, the first attempt included, before regarding it as a failure.")
 	f.DurationVar(&p.RetryDelay, "retry-delay", defaults.RetryDelay, "The time to sleep between failing tests.")
+	f.BoolVar(&p.Confirm, "confirm", defaults.Confirm, "Should the first failure of a test be confirmed by running it again immediately, before it counts as a failure.")
 
 	f.DurationVar(&p.DedupDuration, "dedup", defaults.DedupDuration, "The maximum duration of a deduplication.")
 	f.DurationVar(&p.MinDuration, "min-duration", defaults.MinDuration, "The minimum duration of an error, for it to generate an alert.")
@@ -240,14 +354,43 @@ func (p *workerCmd) SetFlags(f *flag.FlagSet) {
 
 	// Tag
 	f.StringVar(&p.Tag, "tag", defaults.Tag, "Specify the tag to add to all test-results.")
+	f.StringVar(&p.ResultsQueue, "results-queue", defaults.ResultsQueue, "Specify the redis list test-results are published to.")
+	f.StringVar(&p.Filter, "filter", defaults.Filter, "Only run tests whose label or target match this glob, or /regexp/. Other jobs are discarded.")
+	f.DurationVar(&p.MaxAge, "max-age", defaults.MaxAge, "If set, discard jobs which were enqueued longer ago than this, rather than running them.")
+	f.UintVar(&p.DeadLetterAttempts, "dead-letter-after", defaults.DeadLetterAttempts, "How many times a job may fail to execute, e.g. as it can't be parsed, before it is moved to the dead-letter list. 0 discards such jobs at once.")
 
 	// Period test
 	f.DurationVar(&p.PeriodTestSleep, "period-test-sleep", defaults.PeriodTestSleep, "The sleeping interval between subsequent tests in a period-test.")
 	f.Var(utils.NewPercentageValue(defaults.PeriodTestThreshold, &p.PeriodTestThreshold), "period-test-threshold", "The percentage of failures need to trigger an alert in a period-test.")
+
+	// Latency percentiles
+	f.UintVar(&p.LatencySamples, "latency-samples", defaults.LatencySamples, "How many recent latencies of a test to learn its percentiles from.")
+	f.Var(utils.NewPercentageValue(defaults.LatencyMargin, &p.LatencyMargin), "latency-margin", "The percentage by which a latency may exceed its percentile.")
+
+	// OpenTelemetry
+	f.StringVar(&p.OTLPEndpoint, "otlp-endpoint", defaults.OTLPEndpoint, "If set, export test results to this OpenTelemetry collector via OTLP/HTTP (e.g. http://collector:4318).")
+	f.StringVar(&p.OTLPServiceName, "otlp-service-name", defaults.OTLPServiceName, "The service name to report to the OpenTelemetry collector.")
+
+	// Notifiers
+	f.StringVar(&p.SlackWebhook, "slack-webhook", defaults.SlackWebhook, "If set, post test results to this Slack incoming webhook URL.")
+	f.StringVar(&p.WebhookURL, "webhook-url", defaults.WebhookURL, "If set, post test results as JSON to this URL.")
+	f.StringVar(&p.WebhookToken, "webhook-token", defaults.WebhookToken, "The bearer token to send to the -webhook-url, if any.")
+	f.IntVar(&p.WebhookRetries, "webhook-retries", defaults.WebhookRetries, "How many times to retry posting a result to the -webhook-url, if it fails.")
+	f.StringVar(&p.NotifyMode, "notify-mode", defaults.NotifyMode, "Which results to notify of: 'all', 'changes' (when a test starts failing or recovers), or 'failures'.")
+	f.DurationVar(&p.NotifyTimeout, "notify-timeout", defaults.NotifyTimeout, "How long to wait for a notification to be delivered, before giving up on it.")
+	f.StringVar(&p.StateStore, "state-store", defaults.StateStore, "Where the 'changes' notify-mode records the state of each test: 'memory', or 'redis' to share it between workers and restarts.")
+	f.DurationVar(&p.StateExpiry, "state-expiry", defaults.StateExpiry, "How long the state of a test which isn't run anymore is kept in redis.")
+	f.StringVar(&p.SQLDriver, "sql-driver", defaults.SQLDriver, "The driver of the -sql-dsn: 'mysql' or 'postgres'.")
+	f.StringVar(&p.SQLDSN, "sql-dsn", defaults.SQLDSN, "If set, store every test result in this SQL database too.")
+	f.StringVar(&p.SQLTable, "sql-table", defaults.SQLTable, "The table of the -sql-dsn to store results in, which is created if missing.")
+	f.IntVar(&p.SQLBatchSize, "sql-batch-size", defaults.SQLBatchSize, "How many results to insert into the -sql-dsn at once, at most.")
+	f.StringVar(&p.MetricsAddr, "metrics-addr", defaults.MetricsAddr, "If set, serve Prometheus metrics of the test results at /metrics on this address, e.g. ':9090'.")
 }
 
 // notify is used to store the result of a test in our redis queue.
-func (p *workerCmd) notify(testDefinition test.Test, uniqueHash *string, resultError error, details *string) error {
+//
+// The duration is how long the test took, or zero if it couldn't be run.
+func (p *workerCmd) notify(testDefinition test.Test, uniqueHash *string, resultError error, duration time.Duration, details *string) error {
 
 	//
 	// If we don't have a redis-server then return immediately.
@@ -271,6 +414,12 @@ func (p *workerCmd) notify(testDefinition test.Test, uniqueHash *string, resultE
 		Details:    details,
 		UniqueHash: uniqueHash,
 		TestLabel:  testDefinition.TestLabel,
+		Severity:   testDefinition.Severity,
+	}
+
+	if duration > 0 {
+		durationMs := int64(duration / time.Millisecond)
+		testResult.Duration = &durationMs
 	}
 
 	//
@@ -283,6 +432,15 @@ func (p *workerCmd) notify(testDefinition test.Test, uniqueHash *string, resultE
 		testResult.Error = &errorString
 	}
 
+	//
+	// The sinks store every run, whether it's notified or not.
+	//
+	for _, s := range p._sinks {
+		if err := s.Write(testResult); err != nil {
+			fmt.Printf("Failed to store the result of `%s` (%s): %s\n", testDefinition.Input, testDefinition.Target, err.Error())
+		}
+	}
+
 	now := time.Now()
 
 	// If test has a min duration rule, avoid triggering a notification if not needed, or clean the min duration cache if needed.
@@ -438,15 +596,74 @@ func (p *workerCmd) notify(testDefinition test.Test, uniqueHash *string, resultE
 	//
 	// Publish the message to the queue.
 	//
-	_, err = p._r.RPush("overseer.results", j).Result()
+	_, err = p._r.RPush(p.ResultsQueue, j).Result()
 	if err != nil {
 		fmt.Printf("Result addition failed: %s\n", err)
 		return err
 	}
 
+	p.runNotifiers(testDefinition, testResult, resultError)
+
 	return nil
 }
 
+// closeSinks stores the pending results of each sink, and closes it.
+func (p *workerCmd) closeSinks() {
+	for _, s := range p._sinks {
+		if err := s.Close(); err != nil {
+			fmt.Printf("Failed to close the result sink: %s\n", err.Error())
+		}
+	}
+}
+
+// runNotifiers passes the result of a test to each notifier, if the
+// notify-mode wants it.
+//
+// Failures to notify are only logged, so that they can't affect the
+// testing.
+func (p *workerCmd) runNotifiers(testDefinition test.Test, testResult *test.Result, resultError error) {
+	if len(p._notifiers) == 0 {
+		return
+	}
+
+	failed := resultError != nil
+
+	switch p.NotifyMode {
+	case notifyModeFailures:
+		if !failed {
+			return
+		}
+
+	case notifyModeChanges:
+		//
+		// A test we haven't seen before is regarded as having passed,
+		// so that its first failure is notified, but not its first
+		// pass.
+		//
+		// Should the state be unavailable the result is notified,
+		// as missing a failure is worse than a repeated one.
+		//
+		previous, err := p._states.Swap(testResult.Hash(), failed)
+		switch {
+		case err != nil:
+			fmt.Printf("Failed to record the state of `%s` (%s): %s\n", testDefinition.Input, testDefinition.Target, err.Error())
+
+		case previous == failed:
+			p.verbose(fmt.Sprintf("Skipping notifiers (state unchanged) for test `%s` (%s)\n", testDefinition.Input, testDefinition.Target))
+			return
+
+		case !failed:
+			testResult.Recovered = true
+		}
+	}
+
+	for _, n := range p._notifiers {
+		if err := n.Notify(testDefinition, testResult, resultError); err != nil {
+			fmt.Printf("Notification of `%s` (%s) failed: %s\n", testDefinition.Input, testDefinition.Target, err.Error())
+		}
+	}
+}
+
 func (p *workerCmd) getDeduplicationCacheKey(hash string) string {
 	return fmt.Sprintf("overseer.dedup-cache.%s", hash)
 }
@@ -644,6 +861,83 @@ func (p *workerCmd) clearMinDurationAlertShown(hash string) {
 	}
 }
 
+func (p *workerCmd) getLatencySamplesKey(hash string) string {
+	return fmt.Sprintf("overseer.latency-samples.%s", hash)
+}
+
+func (p *workerCmd) getLatencySamples(hash string) []float64 {
+	if p._r == nil {
+		return nil
+	}
+
+	cacheKey := p.getLatencySamplesKey(hash)
+	entries, err := p._r.LRange(cacheKey, 0, -1).Result()
+	if err != nil {
+		fmt.Printf("Failed to get latency samples key: %s\n", err)
+		return nil
+	}
+
+	var samples []float64
+	for _, entry := range entries {
+		sample, err := strconv.ParseFloat(entry, 64)
+		if err == nil {
+			samples = append(samples, sample)
+		}
+	}
+
+	return samples
+}
+
+func (p *workerCmd) addLatencySample(hash string, sample float64, expiry time.Duration) {
+	if p._r == nil {
+		return
+	}
+
+	cacheKey := p.getLatencySamplesKey(hash)
+	if _, err := p._r.LPush(cacheKey, sample).Result(); err != nil {
+		fmt.Printf("Failed to add latency sample: %s\n", err)
+		return
+	}
+	if _, err := p._r.LTrim(cacheKey, 0, int64(p.LatencySamples)-1).Result(); err != nil {
+		fmt.Printf("Failed to trim latency samples: %s\n", err)
+	}
+	if _, err := p._r.Expire(cacheKey, expiry).Result(); err != nil {
+		fmt.Printf("Failed to set latency samples expiry: %s\n", err)
+	}
+}
+
+// checkLatency compares the latency of a passing test against the
+// percentile of its recent latencies, and then records it.
+//
+// Until enough latencies have been recorded the test always passes.
+func (p *workerCmd) checkLatency(workerPrefix string, tst test.Test, target string, latency time.Duration) error {
+	hash := utils.GetMD5Hash(tst.Sanitize() + target + p.Tag)
+	current := float64(latency) / float64(time.Millisecond)
+
+	samples := p.getLatencySamples(hash)
+	p.addLatencySample(hash, current, latencySamplesExpiry)
+
+	if len(samples) < latencyMinSamples {
+		p.verbose(fmt.Sprintf(workerPrefix+"Learning latency, %d of %d samples recorded\n", len(samples)+1, latencyMinSamples))
+		return nil
+	}
+
+	margin := p.LatencyMargin
+	if tst.LatencyMargin != nil {
+		margin = *tst.LatencyMargin
+	}
+
+	name := "p" + strconv.FormatFloat(*tst.LatencyPercentile, 'f', -1, 64)
+	percentile := utils.Percentile(samples, *tst.LatencyPercentile)
+	p.verbose(fmt.Sprintf(workerPrefix+"Latency %.2fms, %s of %d samples %.2fms\n", current, name, len(samples), percentile))
+
+	if current > percentile*(1+float64(margin)) {
+		return fmt.Errorf("latency of %.2fms exceeded the %s of %.2fms by more than %.0f%%", current, name, percentile, margin*100)
+	}
+
+	return nil
+}
+
 // alphaNumeric removes all non alpha-numeric characters from the
 // given string, and returns it.  We replace the characters that
 // are invalid with `_`.
@@ -686,9 +980,87 @@ func (p *workerCmd) formatMetrics(tst test.Test, key string) string {
 	return prefix + tst.Type + "." + p.alphaNumeric(tst.Target) + "." + key
 }
 
+// runProtocolTest invokes the protocol-handler to run a single test, but
+// stops waiting for it once the test timeout, plus a grace period, has
+// passed.
+//
+// Protocol-handlers are expected to respect their own timeout, this
+// protects the worker from any which don't.  A handler which overruns is
+// abandoned, and the test is regarded as failed.
+//
+// Any conditional arguments whose conditions hold now are applied first,
+// and a failure names the conditions which were active.
+func (p *workerCmd) runProtocolTest(workerPrefix string, handler protocols.ProtocolTest, tst test.Test, target string, opts test.Options) error {
+	tst, active := tst.Resolve(time.Now())
+	if len(active) > 0 {
+		p.verbose(fmt.Sprintf(workerPrefix+"Conditions active: %s\n", strings.Join(active, ", ")))
+	}
+
+	err := runWithGrace(workerPrefix, handler, tst, target, opts, p.TimeoutGrace)
+	if err != nil && len(active) > 0 {
+		err = fmt.Errorf("%w (while %s applied)", err, strings.Join(active, ", "))
+	}
+	return err
+}
+
+// runWithGrace runs a test whose conditional arguments were resolved, and
+// abandons it once its timeout, plus the grace period, has passed.
+//
+// This is shared by the worker and the local sub-command, see
+// runProtocolTest.
+func runWithGrace(workerPrefix string, handler protocols.ProtocolTest, tst test.Test, target string, opts test.Options, grace time.Duration) error {
+
+	//
+	// A per-test timeout overrides the global one, for the handler too.
+	//
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+		opts.Timeout = timeout
+	}
+
+	// Without a timeout there is nothing to enforce
+	if timeout <= 0 {
+		return runRecovered(handler, tst, target, opts)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+grace)
+	defer cancel()
+
+	// Buffered, so that an abandoned test can still terminate once it completes
+	resultCh := make(chan error, 1)
+	go func() {
+		resultCh <- runRecovered(handler, tst, target, opts)
+	}()
+
+	select {
+	case err := <-resultCh:
+		return err
+	case <-ctx.Done():
+		fmt.Printf(workerPrefix+"WARNING: '%s' test against %s (%s) overran its timeout of %s, abandoning it\n", tst.Type, tst.Target, target, timeout)
+		return &test.ConnectivityError{Err: fmt.Errorf("test timed out after %s", timeout+grace)}
+	}
+}
+
+// runRecovered invokes the protocol-handler to run a test, converting a
+// panic into the failure of the test, along with the stack trace, so that
+// a bug in one protocol-test can't take down the worker.
+func runRecovered(handler protocols.ProtocolTest, tst test.Test, target string, opts test.Options) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("test panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	return handler.RunTest(tst, target, opts)
+}
+
 // runTest is really the core of our application, as it is responsible
 // for receiving a test to execute, executing it, and then issuing
 // the notification with the result.
+//
+// The test is run against each address of its target, each result being
+// notified on its own, and an error is returned if any of them failed.
 func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) error {
 
 	workerPrefix := fmt.Sprintf("[W%d] ", workerIdx)
@@ -751,7 +1123,11 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 		timeA := time.Now()
 
 		// Now resolve the target to IPv4 & IPv6 addresses.
-		ips, err := net.LookupIP(testTarget)
+		lookupIP := net.LookupIP
+		if p._lookupIP != nil {
+			lookupIP = p._lookupIP
+		}
+		ips, err := lookupIP(testTarget)
 		if err != nil {
 
 			//
@@ -764,7 +1140,7 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 			//
 			// Notify the world about our DNS-failure.
 			//
-			p.notify(tst, nil, fmt.Errorf("failed to resolve name %s", testTarget), nil)
+			p.notify(tst, nil, fmt.Errorf("failed to resolve name %s", testTarget), 0, nil)
 
 			//
 			// Otherwise we're done.
@@ -813,7 +1189,19 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 		targets = targets[:tst.MaxTargetsCount]
 	}
 
+	//
+	// The addresses which failed, for the overall result.
+	//
+	var failures []string
+	failuresLock := new(sync.Mutex)
+
 	testEndFn := func(startTime time.Time, target string, attempts uint, result error, details *string) {
+		if result != nil {
+			failuresLock.Lock()
+			failures = append(failures, fmt.Sprintf("%s: %s", target, result.Error()))
+			failuresLock.Unlock()
+		}
+
 		//
 		// Now the test is complete we can record the time it
 		// took to carry out, and the number of attempts it
@@ -850,11 +1238,16 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 		//
 		tstCopy.Input = tst.Sanitize()
 
+		//
+		// Export the result to OpenTelemetry, if enabled.
+		//
+		p._otlp.Export(tstCopy, p.Tag, attempts, result, startTime, duration)
+
 		//
 		// Now we can trigger the notification with our updated
 		// copy of the test.
 		//
-		p.notify(tstCopy, tmp.GetUniqueHashForTest(tstCopy, opts), result, details)
+		p.notify(tstCopy, tmp.GetUniqueHashForTest(tstCopy, opts), result, duration, details)
 	}
 
 	wg := &sync.WaitGroup{}
@@ -864,7 +1257,7 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 	//
 	for _, target := range targets {
 		wg.Add(1)
-		go func() {
+		go func(target string) {
 
 			// Is this a period test?
 			if tst.PeriodTestDuration != nil {
@@ -897,7 +1290,7 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 					currentOpts := opts
 					currentOpts.PeriodTestIndex = iteration
 					currentOpts.PeriodTestStartTime = iterationStartTime.UnixNano() / int64(time.Millisecond)
-					err := tmp.RunTest(tst, target, currentOpts)
+					err := p.runProtocolTest(workerPrefix, tmp, tst, target, currentOpts)
 
 					iterationDuration := time.Since(iterationStartTime)
 					iterationElapsedString := fmt.Sprintf("%.2fms", float64(iterationDuration)/float64(time.Millisecond))
@@ -942,16 +1335,13 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 			p.verbose(fmt.Sprintf(workerPrefix+"Running '%s' test against %s (%s)\n", testType, testTarget, target))
 
 			//
-			// We'll repeat failing tests up to five times by default
+			// We'll run failing tests as many times as the
+			// options specify, five attempts in total by default.
 			//
 			var attempt uint = 0
-			var maxAttempts uint = p.RetryCount
-
-			//
-			// If retrying is disabled then don't retry.
-			//
-			if !p.Retry {
-				maxAttempts = attempt + 1
+			var maxAttempts uint = 1
+			if opts.Retry > 0 {
+				maxAttempts = uint(opts.Retry) + 1
 			}
 
 			if tst.MaxRetries != nil {
@@ -982,6 +1372,7 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 			// This is designed to cope with transient failures, at a
 			// cost that flapping services might be missed.
 			//
+			var latency time.Duration
 			for attempt < maxAttempts {
 				attempt++
 				c++
@@ -989,7 +1380,23 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 				//
 				// Run the test
 				//
-				result = tmp.RunTest(tst, target, opts)
+				attemptStart := time.Now()
+				result = p.runProtocolTest(workerPrefix, tmp, tst, target, opts)
+				latency = time.Since(attemptStart)
+
+				//
+				// A first failure may be confirmed by running the test
+				// again straight away, so that a single lost packet
+				// doesn't count, without delaying genuine failures.
+				//
+				if result != nil && attempt == 1 && p.Confirm {
+					p.verbose(fmt.Sprintf(workerPrefix+"[%d/%d] Test failed, confirming: %s\n", attempt, maxAttempts, result.Error()))
+
+					c++
+					attemptStart = time.Now()
+					result = p.runProtocolTest(workerPrefix, tmp, tst, target, opts)
+					latency = time.Since(attemptStart)
+				}
 
 				//
 				// If the test passed then we're good.
@@ -1015,16 +1422,31 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 						//
 						// Sleep before retrying the failing test.
 						//
-						p.verbose(fmt.Sprintf(workerPrefix+"Sleeping for %s before retrying\n", p.RetryDelay.String()))
+						p.verbose(fmt.Sprintf(workerPrefix+"Sleeping for %s before retrying\n", opts.RetryDelay.String()))
 
-						time.Sleep(p.RetryDelay)
+						time.Sleep(opts.RetryDelay)
 					}
 				}
 			}
 
+			//
+			// If the test was retried report how often, so that it
+			// is clear it wasn't a transient failure.
+			//
+			if result != nil && c > 1 {
+				result = fmt.Errorf("%w (after %d attempts)", result, c)
+			}
+
+			//
+			// A passing test may still be slower than usual.
+			//
+			if result == nil && tst.LatencyPercentile != nil {
+				result = p.checkLatency(workerPrefix, tst, target, latency)
+			}
+
 			testEndFn(timeA, target, c, result, nil)
 			wg.Done()
-		}()
+		}(target)
 	}
 
 	wg.Wait()
@@ -1053,6 +1475,15 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 		}
 	}
 
+	//
+	// Each address was reported on its own, but overall the test
+	// fails if any of them failed.
+	//
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("%d of %d addresses failed: %s", len(failures), len(targets), strings.Join(failures, "; "))
+	}
+
 	return nil
 }
 
@@ -1067,6 +1498,15 @@ func (p *workerCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}
 		return subcommands.ExitFailure
 	}
 
+	if p.Filter != "" {
+		filter, err := test.NewFilter(p.Filter)
+		if err != nil {
+			fmt.Printf("Invalid filter: %s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+		p._filter = filter
+	}
+
 	//
 	// Connect to the redis-host.
 	//
@@ -1101,6 +1541,73 @@ func (p *workerCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}
 	//
 	p.MetricsFromEnvironment()
 
+	//
+	// Setup our OpenTelemetry exporter, if enabled
+	//
+	if p.OTLPEndpoint != "" {
+		p._otlp = newOTLPExporter(p.OTLPEndpoint, p.OTLPServiceName)
+	}
+
+	//
+	// Setup our SQL sink, if enabled, which inserts the results still
+	// pending once the workers have stopped.
+	//
+	if p.SQLDSN != "" {
+		sqlSink, err := newSQLSink(p.SQLDriver, p.SQLDSN, p.SQLTable, p.SQLBatchSize)
+		if err != nil {
+			fmt.Printf("SQL connection failed: %s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+		p._sinks = append(p._sinks, sqlSink)
+	}
+	defer p.closeSinks()
+
+	//
+	// Serve our Prometheus metrics, if enabled, which are counted from
+	// the results like any sink.
+	//
+	if p.MetricsAddr != "" {
+		listener, err := net.Listen("tcp", p.MetricsAddr)
+		if err != nil {
+			fmt.Printf("Failed to serve metrics: %s\n", err.Error())
+			return subcommands.ExitFailure
+		}
+		defer listener.Close()
+
+		metrics := newPrometheusMetrics()
+		p._sinks = append(p._sinks, metrics)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		go http.Serve(listener, mux)
+	}
+
+	//
+	// Setup our notifiers, if any
+	//
+	switch p.NotifyMode {
+	case notifyModeAll, notifyModeChanges, notifyModeFailures:
+	default:
+		fmt.Printf("Invalid notify-mode '%s', must be one of '%s', '%s' or '%s'\n", p.NotifyMode, notifyModeAll, notifyModeChanges, notifyModeFailures)
+		return subcommands.ExitFailure
+	}
+	switch p.StateStore {
+	case stateStoreMemory:
+		p._states = newMemoryStateStore()
+	case stateStoreRedis:
+		p._states = newRedisStateStore(p._r, p.StateExpiry)
+	default:
+		fmt.Printf("Invalid state-store '%s', must be one of '%s' or '%s'\n", p.StateStore, stateStoreMemory, stateStoreRedis)
+		return subcommands.ExitFailure
+	}
+
+	if p.SlackWebhook != "" {
+		p._notifiers = append(p._notifiers, newSlackNotifier(p.SlackWebhook, p.NotifyTimeout))
+	}
+	if p.WebhookURL != "" {
+		p._notifiers = append(p._notifiers, newWebhookNotifier(p.WebhookURL, p.WebhookToken, p.WebhookRetries, p.NotifyTimeout))
+	}
+
 	//
 	// Setup the options passed to each test, by copying our
 	// global ones.
@@ -1108,23 +1615,25 @@ func (p *workerCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}
 	var opts test.Options
 	opts.Verbose = p.Verbose
 	opts.Timeout = p.Timeout
-
+	opts.RetryDelay = p.RetryDelay
 	//
-	// Create a parser for our input
+	// The -retry-count is the total number of attempts, including the
+	// first, as it always has been, so the retries are one fewer.
 	//
-	parse := parser.New()
+	if p.Retry && p.RetryCount > 1 {
+		opts.Retry = int(p.RetryCount) - 1
+	}
 
 	// We want a graceful shutdown, e.g. if a long-running test is active at the moment we need to wait for it to
 	// complete before brutally exiting!
-	shouldExit := sync.NewCond(&sync.Mutex{})
-	onSignalInterrupt(func() {
-		shouldExit.Broadcast()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	stopping := onShutdown(signals)
 
-		// If there is a second interrupt, immediately exit
-		onSignalInterrupt(func() {
-			os.Exit(0)
-		})
-	})
+	//
+	// Delayed jobs are moved to their queues once they are due.
+	//
+	go p.promoteDelayedJobs()
 
 	wg := &sync.WaitGroup{}
 	var idx uint
@@ -1133,18 +1642,113 @@ func (p *workerCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			p.workerLoop(workerIdx, shouldExit, &opts, parse)
+			p.workerLoop(workerIdx, stopping, &opts)
 		}()
 	}
 
-	wg.Wait()
+	if !awaitWorkers(wg, stopping, p.ShutdownGrace) {
+		fmt.Printf("Tests were still running after %s, exiting anyway\n", p.ShutdownGrace)
+		return subcommands.ExitFailure
+	}
 
 	return subcommands.ExitSuccess
 }
 
-func (p *workerCmd) workerLoop(workerIdx uint, shouldExit *sync.Cond, opts *test.Options, parse *parser.Parser) {
+// onShutdown returns a channel which is closed on the first signal, e.g.
+// SIGINT or SIGTERM, upon which the workers stop taking jobs.  A second
+// signal exits immediately.
+func onShutdown(signals <-chan os.Signal) <-chan struct{} {
+	stopping := make(chan struct{})
+	go func() {
+		<-signals
+		close(stopping)
+
+		// If there is a second interrupt, immediately exit
+		<-signals
+		os.Exit(0)
+	}()
+	return stopping
+}
+
+// awaitWorkers waits for the workers to exit, which they do once stopping
+// is closed and the tests they're running are complete.
+//
+// It returns false if they didn't exit within the grace period following
+// stopping being closed, unless the grace period is zero.
+func awaitWorkers(wg *sync.WaitGroup, stopping <-chan struct{}, grace time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-stopping:
+	}
+
+	var timeout <-chan time.Time
+	if grace > 0 {
+		timeout = time.After(grace)
+	}
+
+	select {
+	case <-done:
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+// promoteDelayedJobs moves the delayed jobs which are due to their queues,
+// checking for them every second.
+//
+// Every worker does so, but only the one which removes a job from the set
+// of delayed jobs queues it.
+func (p *workerCmd) promoteDelayedJobs() {
+	for {
+		entries, err := p._r.ZRangeByScore(delayedJobs, redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().Unix(), 10),
+			Count: 100,
+		}).Result()
+		if err != nil {
+			fmt.Printf("Failed to fetch delayed jobs: %s\n", err.Error())
+		}
+
+		for _, entry := range entries {
+			removed, err := p._r.ZRem(delayedJobs, entry).Result()
+			if err != nil || removed == 0 {
+				continue
+			}
+
+			queue := decodeJob(entry).Queue
+			if queue == "" {
+				queue = jobQueues[test.PriorityNormal]
+			}
+
+			if _, err = p._r.RPush(queue, entry).Result(); err != nil {
+				fmt.Printf("Failed to queue delayed job `%s`: %s\n", entry, err.Error())
+			}
+		}
+
+		// Catch up at once on a backlog
+		if len(entries) < 100 {
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func (p *workerCmd) workerLoop(workerIdx uint, stopping <-chan struct{}, opts *test.Options) {
 	fmt.Printf("worker %d started [tag=%s]\n", workerIdx, p.Tag)
 
+	//
+	// Parsing a line may define a variable, so each worker has a
+	// parser of its own.
+	//
+	parse := parser.New()
+
 	exitLock := &sync.Mutex{}
 	exit := false
 
@@ -1152,9 +1756,7 @@ func (p *workerCmd) workerLoop(workerIdx uint, shouldExit *sync.Cond, opts *test
 	testObjectChan := make(chan []string)
 
 	go func() {
-		shouldExit.L.Lock()
-		defer shouldExit.L.Unlock()
-		shouldExit.Wait()
+		<-stopping
 
 		exitLock.Lock()
 		defer exitLock.Unlock()
@@ -1172,15 +1774,15 @@ func (p *workerCmd) workerLoop(workerIdx uint, shouldExit *sync.Cond, opts *test
 			}
 			exitLock.Unlock()
 
-			// Get a job.
-			testObject, _ := p._r.BLPop(0, "overseer.jobs").Result()
+			// Get a job, from the queue of the highest priority which has one.
+			testObject, _ := p._r.BLPop(0, jobQueuesInOrder...).Result()
 
 			exitLock.Lock()
 			if exit {
 				exitLock.Unlock()
 				if len(testObject) >= 1 {
 					// Requeue! Let's not lose the test
-					if _, err := p._r.RPush("overseer.jobs", testObject[1]).Result(); err != nil {
+					if _, err := p._r.RPush(testObject[0], testObject[1]).Result(); err != nil {
 						fmt.Printf("failed to requeue job `%s`: %v\n", testObject[1], err)
 					} else {
 						fmt.Printf("job requeued: %s\n", testObject[1])
@@ -1190,29 +1792,30 @@ func (p *workerCmd) workerLoop(workerIdx uint, shouldExit *sync.Cond, opts *test
 				}
 				return
 			}
-			exitLock.Unlock()
+
+			// Sent while locked, so the channel can't be closed meanwhile
 			testObjectChan <- testObject
+			exitLock.Unlock()
 		}
 	}()
 
 	// Wait for jobs
-	workerAvailableChan <- true
+	exitLock.Lock()
+	if !exit {
+		workerAvailableChan <- true
+	}
+	exitLock.Unlock()
 	for testObject := range testObjectChan {
 		//
 		// Parse it
 		//
-		//   testObject[0] will be "overseer.jobs"
+		//   testObject[0] will be the queue, e.g. "overseer.jobs"
 		//
 		//   testObject[1] will be the value removed from the list.
 		//
 		if len(testObject) >= 1 {
-			var job test.Test
-			job, err := parse.ParseLine(testObject[1], nil)
-
-			if err == nil {
-				p.runTest(workerIdx, job, *opts)
-			} else {
-				fmt.Printf("Error parsing job from queue: %s - %s\n", testObject[1], err.Error())
+			if err := p.runJob(workerIdx, testObject[1], *opts, parse); err != nil {
+				p.failJob(testObject[0], testObject[1], err)
 			}
 		} else {
 			fmt.Printf("Popped unsupported value: %v\n", testObject)
@@ -1223,9 +1826,83 @@ func (p *workerCmd) workerLoop(workerIdx uint, shouldExit *sync.Cond, opts *test
 			exitLock.Unlock()
 			break
 		}
-		exitLock.Unlock()
 		workerAvailableChan <- true
+		exitLock.Unlock()
 	}
 
 	fmt.Printf("Worker %d exiting\n", workerIdx)
 }
+
+// runJob parses, and runs, a job taken from the queue.
+//
+// An error is returned if the job couldn't be executed, including if it
+// panicked, so that the worker carries on with the next one.  The failure
+// of the test itself isn't an error, as it is notified.
+func (p *workerCmd) runJob(workerIdx uint, entry string, opts test.Options, parse *parser.Parser) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("worker %d recovered from a panic running job `%s`: %v\n", workerIdx, entry, r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	queued := decodeJob(entry)
+
+	//
+	// Stale jobs, e.g. queued while no worker was running,
+	// are dropped, as newer ones are surely queued too.
+	//
+	var age time.Duration
+	if queued.Enqueued > 0 {
+		age = time.Since(time.Unix(queued.Enqueued, 0))
+	}
+
+	if p.MaxAge > 0 && age > p.MaxAge {
+		p.verbose(fmt.Sprintf("Discarding job enqueued %s ago: %s\n", age.Truncate(time.Second), queued.Input))
+	} else if job, errParse := parse.ParseLine(queued.Input, nil); errParse != nil {
+		fmt.Printf("Error parsing job from queue: %s - %s\n", queued.Input, errParse.Error())
+		return errParse
+	} else if !p._filter.Match(job) {
+		p.verbose(fmt.Sprintf("Skipping job not matching the filter: %s\n", job.Sanitize()))
+	} else {
+		if errTest := p.runTest(workerIdx, job, opts); errTest != nil {
+			p.verbose(fmt.Sprintf("Test `%s` failed: %s\n", job.Sanitize(), errTest.Error()))
+		}
+	}
+
+	return nil
+}
+
+// failJob requeues a job which failed to execute, unless it has failed as
+// many times as allowed, in which case it is moved to the dead-letter list
+// along with its error.
+func (p *workerCmd) failJob(queue string, entry string, err error) {
+	if p.DeadLetterAttempts == 0 {
+		return
+	}
+
+	job := decodeJob(entry)
+	job.Attempts++
+
+	destination := queue
+	if job.Attempts >= int(p.DeadLetterAttempts) {
+		job.Queue = queue
+		job.Error = err.Error()
+		destination = deadJobs
+	}
+
+	encoded, errJSON := json.Marshal(job)
+	if errJSON != nil {
+		fmt.Printf("Failed to encode job `%s`: %s\n", entry, errJSON.Error())
+		return
+	}
+
+	if _, errPush := p._r.RPush(destination, encoded).Result(); errPush != nil {
+		fmt.Printf("Failed to move job `%s` to %s: %s\n", entry, destination, errPush.Error())
+		return
+	}
+
+	if destination == deadJobs {
+		fmt.Printf("Job dead-lettered after %d attempts: %s\n", job.Attempts, job.Input)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/protocols"
+	"github.com/cmaster11/overseer/test"
+	"github.com/go-redis/redis"
+)
+
+// slowTest is a protocol-test which takes a while to complete.
+type slowTest struct {
+	delay time.Duration
+}
+
+func (s *slowTest) Arguments() map[string]string { return map[string]string{} }
+func (s *slowTest) ShouldResolveHostname() bool  { return false }
+func (s *slowTest) Example() string              { return "" }
+func (s *slowTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+func (s *slowTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	time.Sleep(s.delay)
+	return errors.New("slow failure")
+}
+
+// panickingTest is a protocol-test with a bug.
+type panickingTest struct {
+	slowTest
+}
+
+func (s *panickingTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var values []string
+	return errors.New(values[0])
+}
+
+func TestPanicFailsTest(t *testing.T) {
+	p := &workerCmd{TimeoutGrace: time.Second}
+
+	// With, and without, a timeout the handler is run differently
+	for _, timeout := range []time.Duration{0, time.Second} {
+		err := p.runProtocolTest("", &panickingTest{}, test.Test{Target: "example.com"}, "example.com", test.Options{Timeout: timeout})
+		if err == nil {
+			t.Fatalf("expected the panic to fail the test")
+		}
+		if !strings.HasPrefix(err.Error(), "test panicked: runtime error: index out of range") {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if !strings.Contains(err.Error(), "panickingTest") {
+			t.Errorf("expected the stack trace in the error, got: %s", err)
+		}
+	}
+}
+
+// startedTest is a slow protocol-test which tells when it has started.
+type startedTest struct {
+	slowTest
+	started chan struct{}
+	once    sync.Once
+}
+
+func (s *startedTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	s.once.Do(func() { close(s.started) })
+	return s.slowTest.RunTest(tst, target, opts)
+}
+
+// fakeRedis is a redis-server holding lists, which understands just
+// enough of the protocol for the worker to take jobs and publish results.
+type fakeRedis struct {
+	lists map[string][]string
+	lock  sync.Mutex
+
+	listener net.Listener
+	closed   chan struct{}
+}
+
+// newFakeRedis starts a fakeRedis, and returns a client of it.
+func newFakeRedis(t *testing.T) (*fakeRedis, *redis.Client) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	f := &fakeRedis{lists: make(map[string][]string), listener: l, closed: make(chan struct{})}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	return f, redis.NewClient(&redis.Options{Addr: l.Addr().String()})
+}
+
+// close stops the server.
+func (f *fakeRedis) close() {
+	close(f.closed)
+	f.listener.Close()
+}
+
+// list returns a copy of the given list.
+func (f *fakeRedis) list(key string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.lists[key]...)
+}
+
+// serve answers the commands of a client.
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		var args []string
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		for i := 0; i < n; i++ {
+			if _, err = r.ReadString('\n'); err != nil {
+				return
+			}
+			arg, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			args = append(args, strings.TrimSuffix(arg, "\r\n"))
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "RPUSH":
+			f.lock.Lock()
+			f.lists[args[1]] = append(f.lists[args[1]], args[2:]...)
+			length := len(f.lists[args[1]])
+			f.lock.Unlock()
+			fmt.Fprintf(conn, ":%d\r\n", length)
+
+		case "BLPOP":
+			// Wait for any of the lists to have a value
+			for {
+				var key, value string
+				f.lock.Lock()
+				for _, k := range args[1 : len(args)-1] {
+					if len(f.lists[k]) > 0 {
+						key, value = k, f.lists[k][0]
+						f.lists[k] = f.lists[k][1:]
+						break
+					}
+				}
+				f.lock.Unlock()
+
+				if key != "" {
+					fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(key), key, len(value), value)
+					break
+				}
+
+				select {
+				case <-f.closed:
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+
+		default:
+			fmt.Fprintf(conn, "+OK\r\n")
+		}
+	}
+}
+
+func TestShutdownWaitsForRunningTest(t *testing.T) {
+	handler := &startedTest{slowTest: slowTest{delay: 500 * time.Millisecond}, started: make(chan struct{})}
+	protocols.Register("worker-shutdown", func() protocols.ProtocolTest { return handler })
+
+	queue, r := newFakeRedis(t)
+	defer queue.close()
+	defer r.Close()
+	p := &workerCmd{ResultsQueue: "overseer.results", TimeoutGrace: time.Second, _r: r}
+
+	for i := 0; i < 2; i++ {
+		if err := r.RPush("overseer.jobs", "example.com must run worker-shutdown").Err(); err != nil {
+			t.Fatalf("failed to queue the job: %s", err)
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	stopping := onShutdown(signals)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.workerLoop(1, stopping, &test.Options{Timeout: 5 * time.Second})
+	}()
+
+	// The signal arrives while the first job is running
+	select {
+	case <-handler.started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the worker didn't take the job")
+	}
+	signals <- syscall.SIGTERM
+
+	if !awaitWorkers(wg, stopping, 5*time.Second) {
+		t.Fatalf("the running test wasn't waited for")
+	}
+
+	results := queue.list("overseer.results")
+	if len(results) != 1 {
+		t.Fatalf("expected the result of the running test to be published, got %v", results)
+	}
+	result, err := test.ResultFromJSON([]byte(results[0]))
+	if err != nil {
+		t.Fatalf("invalid result: %s", err)
+	}
+	if result.Error == nil || *result.Error != "slow failure" {
+		t.Errorf("unexpected result: %v", result.Error)
+	}
+
+	if jobs := queue.list("overseer.jobs"); len(jobs) != 1 {
+		t.Errorf("expected the worker to stop taking jobs, but the queue holds %v", jobs)
+	}
+}
+
+func TestShutdownGracePeriod(t *testing.T) {
+	stopping := make(chan struct{})
+	close(stopping)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	defer wg.Done()
+
+	start := time.Now()
+	if awaitWorkers(wg, stopping, 100*time.Millisecond) {
+		t.Fatalf("a test which never completes was waited for")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("the grace period wasn't respected, waited %s", time.Since(start))
+	}
+}
+
+func TestNoShutdown(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		wg.Done()
+	}()
+
+	// Workers exiting on their own don't need a signal
+	if !awaitWorkers(wg, make(chan struct{}), time.Millisecond) {
+		t.Fatalf("workers exiting on their own weren't waited for")
+	}
+}
+
+// addressesTest is a protocol-test which records the addresses it is run
+// against, and fails against those listed.
+type addressesTest struct {
+	slowTest
+	failing map[string]bool
+
+	tested []string
+	lock   sync.Mutex
+}
+
+func (s *addressesTest) ShouldResolveHostname() bool { return true }
+func (s *addressesTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	s.lock.Lock()
+	s.tested = append(s.tested, target)
+	s.lock.Unlock()
+
+	if s.failing[target] {
+		return errors.New("backend down")
+	}
+	return nil
+}
+
+func TestEveryAddressIsTested(t *testing.T) {
+	handler := &addressesTest{failing: map[string]bool{"10.0.0.2": true}}
+	protocols.Register("worker-addresses", func() protocols.ProtocolTest { return handler })
+
+	p := &workerCmd{IPv4: true, TimeoutGrace: time.Second}
+	p._lookupIP = func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}, nil
+	}
+
+	err := p.runTest(1, test.Test{Target: "www.example.com", Type: "worker-addresses", Input: "www.example.com must run worker-addresses"}, test.Options{})
+	if err == nil {
+		t.Fatalf("expected the test to fail, as an address failed")
+	}
+	if !strings.Contains(err.Error(), "1 of 3 addresses failed: 10.0.0.2: backend down") {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	sort.Strings(handler.tested)
+	if strings.Join(handler.tested, " ") != "10.0.0.1 10.0.0.2 10.0.0.3" {
+		t.Errorf("expected every address to be tested once, got %v", handler.tested)
+	}
+
+	handler.failing = nil
+	handler.tested = nil
+	if err = p.runTest(1, test.Test{Target: "www.example.com", Type: "worker-addresses"}, test.Options{}); err != nil {
+		t.Errorf("expected the test to pass, got %s", err.Error())
+	}
+	if len(handler.tested) != 3 {
+		t.Errorf("expected 3 addresses to be tested, got %v", handler.tested)
+	}
+}
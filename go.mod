@@ -4,9 +4,13 @@ go 1.13
 
 require (
 	github.com/cmaster11/k8s-event-watcher v0.0.8
+	github.com/eclipse/paho.mqtt.golang v1.3.5
 	github.com/emersion/go-imap v1.0.0-beta.2
+	github.com/emersion/go-sasl v0.0.0-20161116183048-7e096a0a6197
 	github.com/go-redis/redis v6.15.2+incompatible
-	github.com/go-sql-driver/mysql v1.4.1
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/gocql/gocql v1.0.0
+	github.com/golang/protobuf v1.4.1
 	github.com/google/subcommands v1.0.1
 	github.com/jlaffaye/ftp v0.0.0-20190126081051-8019e6774408
 	github.com/lib/pq v1.0.0
@@ -15,15 +19,20 @@ require (
 	github.com/mitchellh/mapstructure v1.1.2
 	github.com/onsi/ginkgo v1.8.0 // indirect
 	github.com/onsi/gomega v1.5.0 // indirect
+	github.com/pion/dtls/v2 v2.0.1
 	github.com/robfig/cron v0.0.0-20180505203441-b41be1df6967
 	github.com/simia-tech/go-pop3 v0.0.0-20150626094726-c9c20550a244
 	github.com/skx/golang-metrics v0.0.0-20180606065905-85a4b4e0641f
+	github.com/streadway/amqp v1.0.0
+	go.mongodb.org/mongo-driver v1.4.6
+	golang.org/x/crypto v0.0.0-20200602180216-279210d13fed
 	golang.org/x/lint v0.0.0-20191125180803-fdd1cda4f05f // indirect
-	golang.org/x/sys v0.0.0-20191010194322-b09406accb47 // indirect
-	golang.org/x/text v0.3.2 // indirect
+	golang.org/x/net v0.0.0-20200602114024-627f9648deb9
 	golang.org/x/tools v0.0.0-20200529172331-a64b76657301 // indirect
+	google.golang.org/grpc v1.33.2
+	google.golang.org/protobuf v1.25.0
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
-	gopkg.in/yaml.v2 v2.2.4
+	gopkg.in/yaml.v2 v2.2.8
 	honnef.co/go/tools v0.0.1-2020.1.4 // indirect
 	k8s.io/api v0.0.0-20190620084959-7cf5895f2711
 	k8s.io/apimachinery v0.0.0-20190612205821-1799e75a0719
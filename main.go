@@ -18,12 +18,18 @@ func main() {
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
+	subcommands.Register(&coverageCmd{}, "")
+	subcommands.Register(&deadLettersCmd{}, "")
 	subcommands.Register(&dumpCmd{}, "")
 	subcommands.Register(&enqueueCmd{}, "")
 	subcommands.Register(&examplesCmd{}, "")
+	subcommands.Register(&lintCmd{}, "")
+	subcommands.Register(&listProtocolsCmd{}, "")
+	subcommands.Register(&localCmd{}, "")
 	subcommands.Register(&versionCmd{}, "")
 	subcommands.Register(&workerCmd{}, "")
 	subcommands.Register(&k8sEventWatcherCmd{}, "")
+	subcommands.Register(&reportCmd{}, "")
 
 	flag.Parse()
 	ctx := context.Background()
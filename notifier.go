@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// The notify-modes, which control the results passed to the notifiers.
+const (
+	// Every result
+	notifyModeAll = "all"
+
+	// Results whose state differs from the previous one of the test
+	notifyModeChanges = "changes"
+
+	// Results of failing tests
+	notifyModeFailures = "failures"
+)
+
+// notifier is told of the results of tests, as they're published by the
+// worker, to notify a human of them directly.
+//
+// The result is the one published to the results-queue, the error is
+// that of the test, and nil if it passed.
+type notifier interface {
+	Notify(tst test.Test, result *test.Result, err error) error
+}
+
+// slackNotifier posts results to a Slack channel, via an incoming webhook.
+type slackNotifier struct {
+	// The URL of the incoming webhook
+	url string
+
+	client *http.Client
+}
+
+// newSlackNotifier returns a notifier posting to the given incoming
+// webhook, giving up on each post after the timeout.
+func newSlackNotifier(url string, timeout time.Duration) *slackNotifier {
+	return &slackNotifier{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Notify posts a message describing the result.
+func (s *slackNotifier) Notify(tst test.Test, result *test.Result, err error) error {
+	body, jsonErr := json.Marshal(map[string]string{"text": s.message(result, err)})
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	res, postErr := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if postErr != nil {
+		return postErr
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("slack rejected the message: %d %s", res.StatusCode, msg)
+	}
+	return nil
+}
+
+// message returns the text describing the result, and the error of the
+// test if it failed.
+func (s *slackNotifier) message(result *test.Result, err error) string {
+	status := ":white_check_mark: *Passed*"
+	switch {
+	case err != nil:
+		status = ":x: *Failed*"
+	case result.Recovered:
+		status = ":white_check_mark: *Recovered*"
+	}
+
+	text := fmt.Sprintf("%s: `%s` against %s", status, s.escape(result.Input), s.escape(result.Target))
+	if result.Tag != "" {
+		text += fmt.Sprintf(" (%s)", s.escape(result.Tag))
+	}
+	if err != nil {
+		text += "\n>" + strings.Replace(s.escape(err.Error()), "\n", "\n>", -1)
+	}
+	return text
+}
+
+// escape replaces the characters which Slack treats as markup.
+func (s *slackNotifier) escape(text string) string {
+	text = strings.Replace(text, "&", "&amp;", -1)
+	text = strings.Replace(text, "<", "&lt;", -1)
+	return strings.Replace(text, ">", "&gt;", -1)
+}
+
+// webhookNotifier posts results as JSON to an arbitrary URL.
+type webhookNotifier struct {
+	// The URL results are posted to
+	url string
+
+	// The (optional) bearer token sent along with each post
+	token string
+
+	// How many times a post is retried, after failing
+	retries int
+
+	client *http.Client
+}
+
+// webhookPayload is the JSON document posted by the webhookNotifier.
+type webhookPayload struct {
+	// The sanitized input of the test, and the address it was run against
+	Test   string `json:"test"`
+	Target string `json:"target"`
+
+	Type     string `json:"type"`
+	Tag      string `json:"tag"`
+	Severity string `json:"severity,omitempty"`
+
+	// One of "passed", "failed" or "recovered"
+	Status string `json:"status"`
+
+	// The error of the test, if it failed
+	Error *string `json:"error"`
+
+	// How long the test took, in milliseconds, if it was run
+	Duration *int64 `json:"duration,omitempty"`
+
+	// Unix time, in seconds, at which the result was published
+	Timestamp int64 `json:"timestamp"`
+}
+
+// newWebhookNotifier returns a notifier posting to the given URL, giving
+// up on each attempt after the timeout.
+func newWebhookNotifier(url string, token string, retries int, timeout time.Duration) *webhookNotifier {
+	return &webhookNotifier{
+		url:     url,
+		token:   token,
+		retries: retries,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Notify posts the result, retrying a second apart while the endpoint
+// fails or replies with a status other than 2xx.
+func (w *webhookNotifier) Notify(tst test.Test, result *test.Result, err error) error {
+	payload := webhookPayload{
+		Test:      result.Input,
+		Target:    result.Target,
+		Type:      result.Type,
+		Tag:       result.Tag,
+		Severity:  result.Severity,
+		Status:    "passed",
+		Error:     result.Error,
+		Duration:  result.Duration,
+		Timestamp: result.Time,
+	}
+	switch {
+	case err != nil:
+		payload.Status = "failed"
+	case result.Recovered:
+		payload.Status = "recovered"
+	}
+
+	body, jsonErr := json.Marshal(payload)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	var postErr error
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Second)
+		}
+		if postErr = w.post(body); postErr == nil {
+			return nil
+		}
+	}
+
+	if w.retries > 0 {
+		return fmt.Errorf("%s, after %d attempts", postErr.Error(), w.retries+1)
+	}
+	return postErr
+}
+
+// post makes a single attempt at posting the body.
+func (w *webhookNotifier) post(body []byte) error {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "overseer/worker")
+
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("the webhook rejected the result: %d %s", res.StatusCode, msg)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// otlpExporter sends test results to an OpenTelemetry collector, using
+// the JSON encoding of OTLP over HTTP.
+//
+// Each result becomes a span, covering the execution of the test, and a
+// data-point of the "overseer.test.duration" gauge.
+type otlpExporter struct {
+	// The base URL of the collector, e.g. http://collector:4318
+	endpoint string
+
+	// The service.name of the resource we report as
+	serviceName string
+
+	client *http.Client
+}
+
+// otlpKeyValue is an attribute, of which we only use string values.
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+// newOTLPExporter returns an exporter for the given collector.
+func newOTLPExporter(endpoint string, serviceName string) *otlpExporter {
+	return &otlpExporter{
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Export sends the result of a test, which started at the given time and
+// took the given duration.
+//
+// The export happens in the background, and failures are only logged, so
+// that a broken collector can't affect the testing.
+func (e *otlpExporter) Export(tst test.Test, tag string, attempts uint, result error, start time.Time, duration time.Duration) {
+	if e == nil {
+		return
+	}
+
+	status := "passed"
+	if result != nil {
+		status = "failed"
+	}
+
+	attributes := []otlpKeyValue{
+		e.attribute("overseer.target", tst.Target),
+		e.attribute("overseer.protocol", tst.Type),
+		e.attribute("overseer.input", tst.Input),
+		e.attribute("overseer.status", status),
+		e.attribute("overseer.attempts", strconv.FormatUint(uint64(attempts), 10)),
+	}
+	if tag != "" {
+		attributes = append(attributes, e.attribute("overseer.tag", tag))
+	}
+	if tst.Severity != "" {
+		attributes = append(attributes, e.attribute("overseer.severity", tst.Severity))
+	}
+	if result != nil {
+		attributes = append(attributes, e.attribute("overseer.error", result.Error()))
+	}
+
+	resource := map[string]interface{}{
+		"attributes": []otlpKeyValue{e.attribute("service.name", e.serviceName)},
+	}
+	scope := map[string]interface{}{
+		"name": "overseer",
+	}
+
+	//
+	// The span, whose status reflects the result of the test.
+	//
+	spanStatus := map[string]interface{}{"code": 1}
+	if result != nil {
+		spanStatus = map[string]interface{}{"code": 2, "message": result.Error()}
+	}
+
+	traces := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": resource,
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": scope,
+				"spans": []interface{}{map[string]interface{}{
+					"traceId":           e.id(16),
+					"spanId":            e.id(8),
+					"name":              fmt.Sprintf("%s test", tst.Type),
+					"kind":              3, // SPAN_KIND_CLIENT
+					"startTimeUnixNano": strconv.FormatInt(start.UnixNano(), 10),
+					"endTimeUnixNano":   strconv.FormatInt(start.Add(duration).UnixNano(), 10),
+					"attributes":        attributes,
+					"status":            spanStatus,
+				}},
+			}},
+		}},
+	}
+
+	//
+	// The duration, as a gauge in milliseconds.
+	//
+	metrics := map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": resource,
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope": scope,
+				"metrics": []interface{}{map[string]interface{}{
+					"name":        "overseer.test.duration",
+					"description": "The time taken to run a test, including any retries",
+					"unit":        "ms",
+					"gauge": map[string]interface{}{
+						"dataPoints": []interface{}{map[string]interface{}{
+							"timeUnixNano": strconv.FormatInt(start.Add(duration).UnixNano(), 10),
+							"asDouble":     float64(duration) / float64(time.Millisecond),
+							"attributes":   attributes,
+						}},
+					},
+				}},
+			}},
+		}},
+	}
+
+	go e.post("/v1/traces", traces)
+	go e.post("/v1/metrics", metrics)
+}
+
+// post sends the given payload to the collector.
+func (e *otlpExporter) post(path string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Printf("Failed to encode OTLP payload: %s\n", err.Error())
+		return
+	}
+
+	res, err := e.client.Post(e.endpoint+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("Failed to export to OTLP collector: %s\n", err.Error())
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		fmt.Printf("OTLP collector rejected export to %s: %d %s\n", path, res.StatusCode, msg)
+	}
+}
+
+// attribute returns a string attribute.
+func (e *otlpExporter) attribute(key string, value string) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	kv.Value.StringValue = value
+	return kv
+}
+
+// id returns a random, hex-encoded, trace or span ID.
+func (e *otlpExporter) id(size int) string {
+	buf := make([]byte, size)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+)
+
+// maxExpandedTargets is the largest number of addresses a CIDR block, or
+// range, may be expanded to.
+const maxExpandedTargets = 65536
+
+// expandTarget returns the addresses described by a target which is a
+// CIDR block, such as `192.168.1.0/24`, or a range of addresses, such as
+// `10.0.0.1-10.0.0.20`.
+//
+// If the target is neither nil is returned, so that it can be used as-is.
+//
+// Unless includeAll is set the network and broadcast addresses of IPv4
+// blocks are skipped.
+func expandTarget(target string, includeAll bool) ([]string, error) {
+
+	//
+	// Is this a CIDR block?
+	//
+	if strings.Contains(target, "/") {
+		ip, network, err := net.ParseCIDR(target)
+		if err != nil {
+			return nil, nil
+		}
+
+		ones, bits := network.Mask.Size()
+		if bits-ones > 16 {
+			return nil, fmt.Errorf("refusing to expand %s, it is larger than %d addresses", target, maxExpandedTargets)
+		}
+
+		first := network.IP
+		last := make(net.IP, len(first))
+		for i := range first {
+			last[i] = first[i] | ^network.Mask[i]
+		}
+
+		//
+		// Skip the network and broadcast addresses of IPv4 blocks,
+		// unless they are the only ones.
+		//
+		if ip.To4() != nil && !includeAll && bits-ones > 1 {
+			first = addToIP(first, 1)
+			last = addToIP(last, -1)
+		}
+
+		return ipRange(first, last), nil
+	}
+
+	//
+	// Is this a range?
+	//
+	if strings.Count(target, "-") == 1 {
+		parts := strings.Split(target, "-")
+		first := net.ParseIP(parts[0])
+		last := net.ParseIP(parts[1])
+		if first == nil || last == nil {
+			return nil, nil
+		}
+
+		if (first.To4() == nil) != (last.To4() == nil) {
+			return nil, fmt.Errorf("the range %s mixes IPv4 and IPv6 addresses", target)
+		}
+		if first.To4() != nil {
+			first = first.To4()
+			last = last.To4()
+		}
+
+		if bytes.Compare(first, last) > 0 {
+			return nil, fmt.Errorf("the range %s ends before it starts", target)
+		}
+
+		size := new(big.Int).Sub(new(big.Int).SetBytes(last), new(big.Int).SetBytes(first))
+		if size.Cmp(big.NewInt(maxExpandedTargets)) >= 0 {
+			return nil, fmt.Errorf("refusing to expand %s, it is larger than %d addresses", target, maxExpandedTargets)
+		}
+
+		return ipRange(first, last), nil
+	}
+
+	return nil, nil
+}
+
+// ipRange returns all the addresses from first to last, inclusive.
+func ipRange(first net.IP, last net.IP) []string {
+	var result []string
+
+	for ip := first; bytes.Compare(ip, last) <= 0; ip = addToIP(ip, 1) {
+		result = append(result, ip.String())
+
+		// Don't wrap around at the end of the address space
+		if ip.Equal(last) {
+			break
+		}
+	}
+
+	return result
+}
+
+// addToIP returns a copy of the given address, with delta added to it.
+func addToIP(ip net.IP, delta int64) net.IP {
+	value := new(big.Int).SetBytes(ip)
+	value.Add(value, big.NewInt(delta))
+
+	raw := value.Bytes()
+	result := make(net.IP, len(ip))
+	copy(result[len(result)-len(raw):], raw)
+	return result
+}
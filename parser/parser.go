@@ -18,9 +18,12 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -36,6 +39,63 @@ type Parser struct {
 	//
 	// Macros comprise of a name and a list of hostnames.
 	MACROS map[string][]string
+
+	// Storage for defined variables.
+	//
+	// Variables comprise of a name and a value, which replaces
+	// any `${NAME}` reference in the lines which follow.
+	VARIABLES map[string]string
+
+	// If not nil, errors in the lines of a file are passed to this
+	// function, and parsing continues with the next line, rather than
+	// stopping at the first error.
+	OnError func(err *LineError)
+
+	// If true, executable files are refused rather than executed, for
+	// when their output isn't needed, or they can't be trusted.
+	NoExecute bool
+
+	// The files currently being parsed, outermost first, used to
+	// detect include-cycles.
+	including []string
+}
+
+// LineError is an error found in a line of a file.
+type LineError struct {
+	// The file, as it was given to ParseFile
+	File string
+
+	// The number of the line, or the first line of a continued one
+	Line int
+
+	Err error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+}
+
+// maxIncludeDepth is the deepest that include-directives may be nested.
+const maxIncludeDepth = 16
+
+// genericArguments are the arguments every test-type accepts, in addition
+// to those of its protocol-test, as listed when an argument is unknown.
+var genericArguments = []string{
+	"dedup",
+	"include-network",
+	"latency-margin",
+	"latency-percentile",
+	"max-targets",
+	"min-duration",
+	"min-duration-cache-factor",
+	"period-test-duration",
+	"period-test-sleep",
+	"period-test-threshold",
+	"priority",
+	"retries",
+	"severity",
+	"test-label",
+	"timeout",
 }
 
 // ParsedTest is the function-signature of a callback function
@@ -46,6 +106,7 @@ type ParsedTest func(x test.Test) error
 func New() *Parser {
 	m := new(Parser)
 	m.MACROS = make(map[string][]string)
+	m.VARIABLES = make(map[string]string)
 	return m
 }
 
@@ -77,9 +138,40 @@ func (s *Parser) ParseFile(filename string, cb ParsedTest) error {
 	// This is the scanner we'll use
 	var scanner *bufio.Scanner
 
+	//
+	// Keep track of the files being parsed, so that an include-cycle,
+	// or overly-deep nesting, is caught rather than recursing forever.
+	//
+	name := filename
+	if filename != "-" {
+		if abs, err := filepath.Abs(filename); err == nil {
+			name = abs
+		}
+	}
+	for i, ent := range s.including {
+		if ent == name {
+			chain := append(append([]string{}, s.including[i:]...), name)
+			return fmt.Errorf("include cycle detected: %s", strings.Join(chain, " -> "))
+		}
+	}
+	if len(s.including) >= maxIncludeDepth {
+		return fmt.Errorf("includes nested too deeply, more than %d levels, including %s", maxIncludeDepth, filename)
+	}
+	s.including = append(s.including, name)
+	defer func() {
+		s.including = s.including[:len(s.including)-1]
+	}()
+
+	// The content we'll parse
+	var data []byte
+
 	// Read from stdin
 	if filename == "-" {
-		scanner = bufio.NewScanner(os.Stdin)
+		var err error
+		data, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
 	} else {
 
 		//
@@ -88,6 +180,10 @@ func (s *Parser) ParseFile(filename string, cb ParsedTest) error {
 		//
 		e, err := s.executable(filename)
 		if (err == nil) && (e) {
+			if s.NoExecute {
+				return fmt.Errorf("refusing to execute %s", filename)
+			}
+
 			cmd := exec.Command(filename)
 			var outb, errb bytes.Buffer
 			cmd.Stdout = &outb
@@ -96,31 +192,47 @@ func (s *Parser) ParseFile(filename string, cb ParsedTest) error {
 			if err != nil {
 				return err
 			}
-			reader := bytes.NewReader(outb.Bytes())
-			scanner = bufio.NewScanner(reader)
+			data = outb.Bytes()
 		} else {
 			//
 			// Otherwise just read it
 			//
-			var file *os.File
-			file, err = os.Open(filename)
+			data, err = ioutil.ReadFile(filename)
 			if err != nil {
 				return fmt.Errorf("error opening %s - %s", filename, err.Error())
 			}
-			defer file.Close()
-			scanner = bufio.NewScanner(file)
 		}
 	}
 
+	//
+	// Structured configuration is handled separately.
+	//
+	if s.isYAML(filename, data) {
+		return s.parseYAML(data, cb)
+	}
+
+	scanner = bufio.NewScanner(bytes.NewReader(data))
+
 	//
 	// We read into this string.
 	//
 	line := ""
 
+	//
+	// The number of the line we've read, and the one the current
+	// (possibly continued) line started on.
+	//
+	lineNumber := 0
+	startNumber := 0
+
 	//
 	// Loop
 	//
 	for scanner.Scan() {
+		lineNumber++
+		if line == "" {
+			startNumber = lineNumber
+		}
 
 		//
 		// Get the line, and strip leading/trailing space.
@@ -145,16 +257,25 @@ func (s *Parser) ParseFile(filename string, cb ParsedTest) error {
 		//
 		// OK we've either got a line that doesn't end
 		// with this, or we'll add
-		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(s.stripComment(line))
 
 		//
-		// If the line wasn't empty, and didn't start with
-		// a comment then process it.
+		// If the line wasn't empty, or entirely a comment,
+		// then process it.
 		//
-		if (line != "") && (!strings.HasPrefix(line, "#")) {
-			_, err := s.ParseLine(line, cb)
+		if line != "" {
+			var err error
+			if s.isInclude(line) {
+				err = s.parseInclude(filename, line, cb)
+			} else {
+				_, err = s.ParseLine(line, cb)
+			}
 			if err != nil {
-				return err
+				lineErr := &LineError{File: filename, Line: startNumber, Err: err}
+				if s.OnError == nil {
+					return lineErr
+				}
+				s.OnError(lineErr)
 			}
 		}
 
@@ -177,6 +298,112 @@ func (s *Parser) ParseFile(filename string, cb ParsedTest) error {
 	return nil
 }
 
+// includeRegex matches an include-directive.
+var includeRegex = regexp.MustCompile(`^include\s+(.+)$`)
+
+// isInclude returns true if the given line is an include-directive,
+// rather than a test against a host which happens to be named "include".
+func (s *Parser) isInclude(line string) bool {
+	return includeRegex.MatchString(line) && !strings.Contains(line, " must run ")
+}
+
+// parseInclude handles an include-directive, such as:
+//
+//   include team/web.cfg
+//
+// The included file is parsed with our state, so it sees any macros
+// and variables defined before the directive, and relative paths are
+// relative to the directory of the including file.
+func (s *Parser) parseInclude(filename string, line string, cb ParsedTest) error {
+	path, err := s.expandVariables(strings.TrimSpace(includeRegex.FindStringSubmatch(line)[1]))
+	if err != nil {
+		return err
+	}
+
+	path = s.TrimQuotes(path, '\'')
+	path = s.TrimQuotes(path, '"')
+
+	if !filepath.IsAbs(path) && filename != "-" {
+		path = filepath.Join(filepath.Dir(filename), path)
+	}
+
+	err = s.ParseFile(path, cb)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// stripComment removes any comment from the given line.
+//
+// A comment starts with a "#" which is either at the start of the line,
+// or which follows whitespace, so that URLs with fragments are left alone.
+// A "#" inside a quoted value, such as a password, doesn't start one.
+func (s *Parser) stripComment(line string) string {
+	var quote rune
+
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+				return line[:i]
+			}
+		}
+	}
+
+	return line
+}
+
+// referenceRegex matches a `${NAME}` reference to a variable.
+var referenceRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandVariables replaces each `${NAME}` reference in the given input
+// with the value of the variable, it is an error to reference a variable
+// which hasn't been defined.
+func (s *Parser) expandVariables(input string) (string, error) {
+	var err error
+
+	output := referenceRegex.ReplaceAllStringFunc(input, func(match string) string {
+		name := match[2 : len(match)-1]
+
+		value, ok := s.VARIABLES[name]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("undefined variable '%s' in input '%s'", name, input)
+			}
+			return match
+		}
+		return value
+	})
+
+	return output, err
+}
+
+// The regular expressions ParseLine uses, compiled once rather than for
+// every line.
+var (
+	// A variable-definition, such as "HOST = example.com"
+	variableRegex = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+
+	// A macro-definition, such as "SERVERS are host1, host2"
+	macroRegex = regexp.MustCompile(`^([A-Z0-9]+)\s+are\s+(.*)$`)
+
+	// A test, such as "example.com must run http"
+	testRegex = regexp.MustCompile(`^([^ \t]+)\s+must\s+run\s+([^\s]+)`)
+
+	// The target of a test, and the rest of its line
+	splitRegex = regexp.MustCompile(`^([^\s]+)\s+(.*)$`)
+
+	// An argument with a condition, such as "status[sat,sun]"
+	conditionalRegex = regexp.MustCompile(`^([^\[\]]+)\[([^\[\]]+)\]$`)
+)
+
 // ParseLine parses a single line of text, and invokes the supplied callback
 // function if a valid test was found.
 func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
@@ -198,11 +425,40 @@ func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
 	//  TARGET must run PROTOCOL [OPTIONAL EXTRA ARGS]
 	//
 
+	//
+	// Is this a variable-definition?
+	//
+	//  NAME = 'value'
+	//
+	// Unlike macros variables may be redefined, the new value is
+	// used by the lines which follow.
+	//
+	matchVariable := variableRegex.FindStringSubmatch(input)
+	if len(matchVariable) == 3 {
+		value, err := s.expandVariables(strings.TrimSpace(matchVariable[2]))
+		if err != nil {
+			return result, err
+		}
+
+		value = s.TrimQuotes(value, '\'')
+		value = s.TrimQuotes(value, '"')
+
+		s.VARIABLES[matchVariable[1]] = value
+		return result, nil
+	}
+
+	//
+	// Replace any variable-references with their values.
+	//
+	input, err := s.expandVariables(input)
+	if err != nil {
+		return result, err
+	}
+
 	//
 	// Is this a macro-definition?
 	//
-	macro := regexp.MustCompile(`^([A-Z0-9]+)\s+are\s+(.*)$`)
-	matchMacro := macro.FindStringSubmatch(input)
+	matchMacro := macroRegex.FindStringSubmatch(input)
 	if len(matchMacro) == 3 {
 
 		name := matchMacro[1]
@@ -232,8 +488,7 @@ func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
 	//
 	// Look to see if this line matches the testing line
 	//
-	re := regexp.MustCompile(`^([^ \t]+)\s+must\s+run\s+([^\s]+)`)
-	out := re.FindStringSubmatch(input)
+	out := testRegex.FindStringSubmatch(input)
 
 	//
 	// If it didn't then we have a malformed line
@@ -287,8 +542,7 @@ func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
 			//   ..
 			//   hostN must run xxx.
 			//
-			split := regexp.MustCompile(`^([^\s]+)\s+(.*)$`)
-			line := split.FindStringSubmatch(input)
+			line := splitRegex.FindStringSubmatch(input)
 
 			//
 			// Create a new test, with the macro-host
@@ -311,6 +565,32 @@ func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
 		return result, nil
 	}
 
+	//
+	// Is this target a CIDR block, or a range of addresses?
+	//
+	// If so we expand it in the same way as a macro, once
+	// for each address.  The network and broadcast addresses
+	// of a block are skipped unless `include-network` is set.
+	//
+	addresses, err := expandTarget(testTarget, s.ParseArguments(input)["include-network"] == "true")
+	if err != nil {
+		return result, fmt.Errorf("%s in input '%s'", err.Error(), input)
+	}
+	if len(addresses) > 0 {
+		line := splitRegex.FindStringSubmatch(input)
+
+		for _, address := range addresses {
+			newTst := fmt.Sprintf("%s %s", address, line[2])
+
+			_, err := s.ParseLine(newTst, cb)
+			if err != nil {
+				return result, err
+			}
+		}
+
+		return result, nil
+	}
+
 	//
 	// Create a temporary structure to hold our test
 	//
@@ -326,12 +606,30 @@ func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
 	//
 	expected := handler.Arguments()
 
+	//
+	// Arguments may have a condition, such as `status[sat,sun]`, in
+	// which case they're validated like the plain argument, and only
+	// applied while their condition holds.
 	//
 	// If there are arguments which are unknown then this is an error
 	//
 	// For each argument which was supplied..
 	//
 	for arg, val := range arguments {
+
+		condition := ""
+		if match := conditionalRegex.FindStringSubmatch(arg); match != nil {
+			arg = match[1]
+			condition = match[2]
+
+			if _, err := test.ParseCondition(condition); err != nil {
+				return result, fmt.Errorf("%s for argument '%s' of test-type '%s' in input '%s'", err.Error(), arg, testType, input)
+			}
+			if expected[arg] == "" {
+				return result, fmt.Errorf("argument '%s' for test-type '%s' in input '%s' can't have a condition, only the arguments of the protocol-test may", arg, testType, input)
+			}
+		}
+
 		switch arg {
 		// Is there a custom per-test override?
 		case "retries":
@@ -390,14 +688,17 @@ func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
 		case "timeout":
 			duration, err := time.ParseDuration(val)
 			if err != nil {
-				return result, fmt.Errorf("non-duration argument '%s' for test-type '%s' in input '%s'", arg, testType, input)
+				return result, fmt.Errorf("non-duration argument '%s' for test-type '%s' in input '%s': %s", arg, testType, input, err.Error())
 			}
 
 			if duration < 0 {
 				return result, fmt.Errorf("duration argument '%s' for test-type '%s' in input '%s' must be > 0", arg, testType, input)
 			}
 
-			result.Timeout = &duration
+			// A zero timeout keeps the worker-default one
+			if duration > 0 {
+				result.Timeout = &duration
+			}
 			continue
 
 		case "pt-duration", "period-test-duration":
@@ -442,6 +743,52 @@ func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
 			valCopy := val
 			result.TestLabel = &valCopy
 			continue
+		case "severity":
+			switch val {
+			case test.SeverityCritical, test.SeverityWarning, test.SeverityInfo:
+			default:
+				return result, fmt.Errorf("argument '%s' for test-type '%s' in input '%s' must be one of '%s', '%s' or '%s'", arg, testType, input, test.SeverityCritical, test.SeverityWarning, test.SeverityInfo)
+			}
+
+			result.Severity = val
+			continue
+		case "priority":
+			switch val {
+			case test.PriorityHigh, test.PriorityNormal, test.PriorityLow:
+			default:
+				return result, fmt.Errorf("argument '%s' for test-type '%s' in input '%s' must be one of '%s', '%s' or '%s'", arg, testType, input, test.PriorityHigh, test.PriorityNormal, test.PriorityLow)
+			}
+
+			result.Priority = val
+			continue
+
+			// Fail when the latency exceeds a percentile of the recent ones
+		case "latency-percentile":
+			percentile, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return result, fmt.Errorf("non-numeric argument '%s' for test-type '%s' in input '%s'", arg, testType, input)
+			}
+			if percentile < 1 || percentile > 99 {
+				return result, fmt.Errorf("argument '%s' for test-type '%s' in input '%s' must be between 1 and 99", arg, testType, input)
+			}
+
+			result.LatencyPercentile = &percentile
+			continue
+		case "latency-margin":
+			percentage, err := utils.ParsePercentage(val)
+			if err != nil {
+				return result, fmt.Errorf("non-percentage argument '%s' for test-type '%s' in input '%s': %s", arg, testType, input, err.Error())
+			}
+
+			result.LatencyMargin = &percentage
+			continue
+
+			// Used when expanding a CIDR block, see above
+		case "include-network":
+			if val != "true" && val != "false" {
+				return result, fmt.Errorf("argument '%s' for test-type '%s' in input '%s' must be 'true' or 'false'", arg, testType, input)
+			}
+			continue
 		}
 
 		//
@@ -450,7 +797,13 @@ func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
 		//
 		pattern := expected[arg]
 		if pattern == "" {
-			return result, fmt.Errorf("unsupported argument '%s' for test-type '%s' in input '%s'", arg, testType, input)
+			valid := make([]string, 0, len(expected))
+			for name := range expected {
+				valid = append(valid, name)
+			}
+			sort.Strings(valid)
+
+			return result, fmt.Errorf("unsupported argument '%s' for test-type '%s' in input '%s' - valid arguments are: %s, and for every test-type: %s", arg, testType, input, strings.Join(valid, ", "), strings.Join(genericArguments, ", "))
 		}
 
 		//
@@ -463,6 +816,15 @@ func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
 			return result, fmt.Errorf("unsupported argument '%s' for test-type '%s' in input '%s' - did not match pattern '%s'", arg, testType, input, pattern)
 		}
 
+		if condition != "" {
+			result.ConditionalArguments = append(result.ConditionalArguments, test.ConditionalArgument{
+				Name:      arg,
+				Condition: condition,
+				Value:     val,
+			})
+			continue
+		}
+
 		result.Arguments[arg] = val
 	}
 
@@ -493,6 +855,9 @@ func (s *Parser) TrimQuotes(in string, c byte) string {
 	return in
 }
 
+// argumentRegex matches the last argument of a line, such as "with port 22".
+var argumentRegex = regexp.MustCompile(`^(.*)\s+with\s+([^\s]+)\s+('.+'|\".+\"|\S+)`)
+
 // ParseArguments takes a string such as this:
 //
 //   foo must run http with username 'steve' with password 'bob'
@@ -507,8 +872,7 @@ func (s *Parser) ParseArguments(input string) map[string]string {
 	//
 	// Look for each option
 	//
-	expr := regexp.MustCompile(`^(.*)\s+with\s+([^\s]+)\s+('.+'|\".+\"|\S+)`)
-	match := expr.FindStringSubmatch(input)
+	match := argumentRegex.FindStringSubmatch(input)
 
 	for len(match) > 1 {
 		prefix := match[1]
@@ -537,7 +901,7 @@ func (s *Parser) ParseArguments(input string) map[string]string {
 
 		// Continue matching the tail of the string.
 		input = prefix
-		match = expr.FindStringSubmatch(input)
+		match = argumentRegex.FindStringSubmatch(input)
 	}
 	return res
 }
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
 )
@@ -303,6 +306,165 @@ comment must run http \
 	}
 }
 
+// Test parsing comments which follow a test, and blank lines.
+func TestInlineComments(t *testing.T) {
+
+	file, err := ioutil.TempFile(os.TempDir(), "prefix")
+	if err != nil {
+		t.Errorf("Error creating temporary-directory %s", err.Error())
+	}
+	defer os.Remove(file.Name())
+
+	// Write to the file
+	lines := "# A comment-only line\n" +
+		"   \t  \n" +
+		"\n" +
+		"   # An indented comment\n" +
+		"mail.example.com must run smtp # the primary MX\n" +
+		"mail.example.com must run imaps with username 'steve' with password 'se#cr#et' # check logins\n" +
+		"http://example.com/#fragment must run http\n"
+
+	err = ioutil.WriteFile(file.Name(), []byte(lines), 0644)
+	if err != nil {
+		t.Errorf("Error writing our test-case")
+	}
+
+	//
+	// The tests we expect to be parsed.
+	//
+	expected := []string{
+		"mail.example.com must run smtp",
+		"mail.example.com must run imaps with username 'steve' with password 'se#cr#et'",
+		"http://example.com/#fragment must run http",
+	}
+
+	//
+	// Now parse the file
+	//
+	var found []test.Test
+	p := New()
+	err = p.ParseFile(file.Name(), func(tst test.Test) error {
+		found = append(found, tst)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Expected no error, but found %s", err.Error())
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("Expected %d valid lines, found %d", len(expected), len(found))
+	}
+
+	for i, tst := range found {
+		if tst.Input != expected[i] {
+			t.Errorf("Expected input '%s', found '%s'", expected[i], tst.Input)
+		}
+	}
+
+	if found[1].Arguments["password"] != "se#cr#et" {
+		t.Errorf("The password was truncated: '%s'", found[1].Arguments["password"])
+	}
+	if found[2].Target != "http://example.com/#fragment" {
+		t.Errorf("The target was truncated: '%s'", found[2].Target)
+	}
+}
+
+// Test defining, and expanding, variables.
+func TestVariables(t *testing.T) {
+
+	file, err := ioutil.TempFile(os.TempDir(), "prefix")
+	if err != nil {
+		t.Errorf("Error creating temporary-directory %s", err.Error())
+	}
+	defer os.Remove(file.Name())
+
+	// Write to the file
+	lines := `
+SMTP_USER = 'steve@example.com'
+SMTP_PASS = 's3cret with spaces'
+HOST=mail.example.com
+
+${HOST} must run smtp with username '${SMTP_USER}' with password '${SMTP_PASS}'
+
+SMTP_PASS = "changed"
+${HOST} must run smtp with username "${SMTP_USER}" with password "${SMTP_PASS}" with port 587
+`
+	err = ioutil.WriteFile(file.Name(), []byte(lines), 0644)
+	if err != nil {
+		t.Errorf("Error writing our test-case")
+	}
+
+	//
+	// Now parse the file
+	//
+	var found []test.Test
+	p := New()
+	err = p.ParseFile(file.Name(), func(tst test.Test) error {
+		found = append(found, tst)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Expected no error, but found %s", err.Error())
+	}
+	if len(found) != 2 {
+		t.Fatalf("Expected two valid lines, found %d", len(found))
+	}
+
+	if found[0].Target != "mail.example.com" {
+		t.Errorf("The target wasn't expanded: '%s'", found[0].Target)
+	}
+	if found[0].Arguments["username"] != "steve@example.com" {
+		t.Errorf("The username wasn't expanded: '%s'", found[0].Arguments["username"])
+	}
+	if found[0].Arguments["password"] != "s3cret with spaces" {
+		t.Errorf("The password wasn't expanded: '%s'", found[0].Arguments["password"])
+	}
+
+	// The redefinition only affects the lines which follow it
+	if found[1].Arguments["password"] != "changed" {
+		t.Errorf("The redefined password wasn't expanded: '%s'", found[1].Arguments["password"])
+	}
+	if found[1].Arguments["port"] != "587" {
+		t.Errorf("The port wasn't parsed: '%s'", found[1].Arguments["port"])
+	}
+}
+
+// Test that referencing undefined variables is an error.
+func TestUndefinedVariables(t *testing.T) {
+
+	file, err := ioutil.TempFile(os.TempDir(), "prefix")
+	if err != nil {
+		t.Errorf("Error creating temporary-directory %s", err.Error())
+	}
+	defer os.Remove(file.Name())
+
+	// The variable is only defined after it is used.
+	lines := `# Comment
+localhost must run ssh
+
+localhost must run smtp with password '${PASS}'
+PASS = 'secret'
+`
+	err = ioutil.WriteFile(file.Name(), []byte(lines), 0644)
+	if err != nil {
+		t.Errorf("Error writing our test-case")
+	}
+
+	p := New()
+	err = p.ParseFile(file.Name(), nil)
+	if err == nil {
+		t.Fatalf("Expected an error, but found none")
+	}
+
+	if !strings.Contains(err.Error(), "line 4:") {
+		t.Errorf("The error didn't report the line number: %s", err.Error())
+	}
+	if !strings.Contains(err.Error(), "undefined variable 'PASS'") {
+		t.Errorf("The error we received was the wrong error: %s", err.Error())
+	}
+}
+
 // Test parsing an argument that fails validation
 func TestInvalidArgument(t *testing.T) {
 
@@ -510,6 +672,46 @@ func TestInvalidOptions(t *testing.T) {
 	}
 }
 
+// Test that unknown argument-names are rejected, and the valid ones listed.
+func TestUnknownArgument(t *testing.T) {
+
+	// Create a parser
+	p := New()
+
+	// A valid configuration is accepted
+	out, err := p.ParseLine("example.com must run ssh with port 2222", nil)
+	if err != nil {
+		t.Fatalf("We did not expect an error - got %s!", err)
+	}
+	if out.Arguments["port"] != "2222" {
+		t.Errorf("Failed to get the correct port-value")
+	}
+
+	// A typo is not
+	_, err = p.ParseLine("example.com must run ssh with porrt 2222", nil)
+	if err == nil {
+		t.Fatalf("We expected an error parsing an unknown argument, but found none!")
+	}
+
+	if !strings.Contains(err.Error(), "unsupported argument 'porrt'") {
+		t.Errorf("The error did not name the unknown argument: %s", err.Error())
+	}
+	if !strings.Contains(err.Error(), "fingerprint") {
+		t.Errorf("The error did not list the arguments of the test-type: %s", err.Error())
+	}
+	if !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("The error did not list the generic arguments: %s", err.Error())
+	}
+
+	// The generic arguments listed are all known
+	for _, arg := range genericArguments {
+		_, err = p.ParseLine(fmt.Sprintf("example.com must run ssh with %s x", arg), nil)
+		if err != nil && strings.Contains(err.Error(), "unsupported argument") {
+			t.Errorf("The generic argument '%s' is unknown: %s", arg, err.Error())
+		}
+	}
+}
+
 func TestMaxRetries(t *testing.T) {
 	tests := []string{
 		"http://example.com/ must run http with retries 0",
@@ -560,6 +762,493 @@ func TestMinDuration(t *testing.T) {
 	}
 }
 
+func TestTimeout(t *testing.T) {
+	tests := map[string]time.Duration{
+		"http://example.com/ must run http":                  0,
+		"http://example.com/ must run http with timeout 0s":  0,
+		"http://example.com/ must run http with timeout 10s": 10 * time.Second,
+		"mysql.example.com must run mysql with timeout 30s":  30 * time.Second,
+		"mysql.example.com must run ping with timeout 500ms": 500 * time.Millisecond,
+	}
+
+	// Create a parser
+	p := New()
+
+	// Parse each line
+	for input, expected := range tests {
+
+		tst, err := p.ParseLine(input, nil)
+		if err != nil {
+			t.Errorf("We did not expect an error parsing %s - got %s!", input, err)
+
+			continue
+		}
+
+		if expected == 0 {
+			if tst.Timeout != nil {
+				t.Errorf("Expected no timeout for %s, got %s", input, *tst.Timeout)
+			}
+			continue
+		}
+
+		if tst.Timeout == nil || *tst.Timeout != expected {
+			t.Errorf("Invalid timeout for %s, expected %s, got %v", input, expected, tst.Timeout)
+		}
+	}
+}
+
+func TestInvalidTimeout(t *testing.T) {
+	tests := []string{
+		"http://example.com/ must run http with timeout 10",
+		"http://example.com/ must run http with timeout soon",
+		"http://example.com/ must run http with timeout -5s",
+	}
+
+	// Create a parser
+	p := New()
+
+	// Parse each line
+	for _, input := range tests {
+
+		_, err := p.ParseLine(input, nil)
+		if err == nil {
+			t.Errorf("We expected an error parsing %s, but found none!", input)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), "'timeout'") {
+			t.Errorf("The error we received was the wrong error: %s", err.Error())
+		}
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	// Create a parser
+	p := New()
+
+	tst, err := p.ParseLine("http://example.com/ must run http with severity critical", nil)
+	if err != nil {
+		t.Fatalf("We did not expect an error - got %s!", err)
+	}
+	if tst.Severity != test.SeverityCritical {
+		t.Errorf("Invalid severity, got '%s'", tst.Severity)
+	}
+
+	_, err = p.ParseLine("http://example.com/ must run http with severity urgent", nil)
+	if err == nil {
+		t.Errorf("We expected an error parsing an invalid severity, but found none!")
+	}
+}
+
+func TestPriority(t *testing.T) {
+	// Create a parser
+	p := New()
+
+	tst, err := p.ParseLine("http://example.com/ must run http with priority high", nil)
+	if err != nil {
+		t.Fatalf("We did not expect an error - got %s!", err)
+	}
+	if tst.Priority != test.PriorityHigh {
+		t.Errorf("Invalid priority, got '%s'", tst.Priority)
+	}
+
+	tst, err = p.ParseLine("http://example.com/ must run http", nil)
+	if err != nil {
+		t.Fatalf("We did not expect an error - got %s!", err)
+	}
+	if tst.Priority != "" {
+		t.Errorf("Unexpected default priority, got '%s'", tst.Priority)
+	}
+
+	_, err = p.ParseLine("http://example.com/ must run http with priority urgent", nil)
+	if err == nil {
+		t.Errorf("We expected an error parsing an invalid priority, but found none!")
+	}
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	// Create a parser
+	p := New()
+
+	tst, err := p.ParseLine("http://example.com/ must run http with latency-percentile 95 with latency-margin 50%", nil)
+	if err != nil {
+		t.Fatalf("We did not expect an error - got %s!", err)
+	}
+	if tst.LatencyPercentile == nil || *tst.LatencyPercentile != 95 {
+		t.Errorf("Invalid latency percentile, got %v", tst.LatencyPercentile)
+	}
+	if tst.LatencyMargin == nil || *tst.LatencyMargin != 0.5 {
+		t.Errorf("Invalid latency margin, got %v", tst.LatencyMargin)
+	}
+	if _, ok := tst.Arguments["latency-percentile"]; ok {
+		t.Errorf("The latency percentile shouldn't be passed to the tester")
+	}
+
+	for _, input := range []string{
+		"http://example.com/ must run http with latency-percentile 100",
+		"http://example.com/ must run http with latency-percentile fast",
+		"http://example.com/ must run http with latency-margin 20",
+	} {
+		_, err = p.ParseLine(input, nil)
+		if err == nil {
+			t.Errorf("We expected an error parsing '%s', but found none!", input)
+		}
+	}
+}
+
+func TestConditionalArguments(t *testing.T) {
+	// Create a parser
+	p := New()
+
+	tst, err := p.ParseLine("http://example.com/ must run http with status 200 with status[sat,sun+01:00-03:00] 503 with status[env:MAINTENANCE] 503", nil)
+	if err != nil {
+		t.Fatalf("We did not expect an error - got %s!", err)
+	}
+	if tst.Arguments["status"] != "200" {
+		t.Errorf("Invalid status, got %s", tst.Arguments["status"])
+	}
+	if len(tst.ConditionalArguments) != 2 {
+		t.Fatalf("Expected two conditional arguments, got %v", tst.ConditionalArguments)
+	}
+
+	// Saturday night, and Monday night
+	saturday := time.Date(2020, 5, 16, 2, 0, 0, 0, time.Local)
+	monday := time.Date(2020, 5, 18, 2, 0, 0, 0, time.Local)
+
+	resolved, active := tst.Resolve(saturday)
+	if resolved.Arguments["status"] != "503" || len(active) != 1 || active[0] != "status[sat,sun+01:00-03:00]" {
+		t.Errorf("Expected the weekend status to apply, got %s with %v", resolved.Arguments["status"], active)
+	}
+	if tst.Arguments["status"] != "200" {
+		t.Errorf("Resolving the test changed its arguments")
+	}
+
+	resolved, active = tst.Resolve(monday)
+	if resolved.Arguments["status"] != "200" || len(active) != 0 {
+		t.Errorf("Expected the plain status to apply, got %s with %v", resolved.Arguments["status"], active)
+	}
+
+	os.Setenv("MAINTENANCE", "1")
+	defer os.Unsetenv("MAINTENANCE")
+
+	resolved, active = tst.Resolve(monday)
+	if resolved.Arguments["status"] != "503" || len(active) != 1 || active[0] != "status[env:MAINTENANCE]" {
+		t.Errorf("Expected the maintenance status to apply, got %s with %v", resolved.Arguments["status"], active)
+	}
+
+	for _, input := range []string{
+		"http://example.com/ must run http with status[someday] 503",
+		"http://example.com/ must run http with status[25:00-26:00] 503",
+		"http://example.com/ must run http with status[env:] 503",
+		"http://example.com/ must run http with status[sat] ok",
+		"http://example.com/ must run http with retries[sat] 3",
+		"http://example.com/ must run http with bogus[sat] 3",
+	} {
+		_, err = p.ParseLine(input, nil)
+		if err == nil {
+			t.Errorf("We expected an error parsing '%s', but found none!", input)
+		}
+	}
+}
+
+// Test that all the errors of a file can be collected.
+func TestOnError(t *testing.T) {
+	file, err := ioutil.TempFile(os.TempDir(), "errors")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary file: %s", err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString(`http://example.com/ must run http
+http://example.com/ must run htp
+example.com must run ssh with port 'ssh'
+
+example.com must run ssh
+`)
+	file.Close()
+
+	var errs []*LineError
+	count := 0
+
+	p := New()
+	p.OnError = func(err *LineError) {
+		errs = append(errs, err)
+	}
+	err = p.ParseFile(file.Name(), func(tst test.Test) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("We did not expect an error - got %s!", err)
+	}
+
+	if count != 2 {
+		t.Errorf("Expected two valid tests, got %d", count)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Expected two errors, got %d", len(errs))
+	}
+	if errs[0].Line != 2 || errs[1].Line != 3 || errs[0].File != file.Name() {
+		t.Errorf("The errors had the wrong location: %s:%d, %s:%d", errs[0].File, errs[0].Line, errs[1].File, errs[1].Line)
+	}
+}
+
+// Test that include-directives are parsed relative to the including file.
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "include")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "teams"), 0755)
+	if err != nil {
+		t.Fatalf("Error creating temporary directory")
+	}
+
+	files := map[string]string{
+		"main.cfg": `
+HOST = 'web.example.com'
+include teams/web.cfg
+localhost must run ssh
+`,
+		"teams/web.cfg": `
+http://${HOST}/ must run http
+include "db.cfg"
+`,
+		"teams/db.cfg": `
+db.example.com must run mysql
+`,
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Error writing to temporary file")
+		}
+	}
+
+	var found []string
+	p := New()
+	err = p.ParseFile(filepath.Join(dir, "main.cfg"), func(x test.Test) error {
+		found = append(found, x.Target)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("We did not expect an error - got %s!", err)
+	}
+
+	expected := []string{"http://web.example.com/", "db.example.com", "localhost"}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected targets %v, got %v", expected, found)
+	}
+}
+
+// Test that include-cycles are rejected.
+func TestIncludeCycle(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "include")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.cfg": "include b.cfg\n",
+		"b.cfg": "include a.cfg\n",
+		"c.cfg": "include c.cfg\n",
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Error writing to temporary file")
+		}
+	}
+
+	p := New()
+	err = p.ParseFile(filepath.Join(dir, "a.cfg"), nil)
+	if err == nil {
+		t.Fatalf("We expected an error parsing an include-cycle, but found none!")
+	}
+	if !strings.Contains(err.Error(), "a.cfg -> "+filepath.Join(dir, "b.cfg")+" -> "+filepath.Join(dir, "a.cfg")) {
+		t.Errorf("The error didn't name the files in the cycle: %s", err.Error())
+	}
+
+	err = p.ParseFile(filepath.Join(dir, "c.cfg"), nil)
+	if err == nil || !strings.Contains(err.Error(), "include cycle detected") {
+		t.Errorf("We expected an error parsing a self-include, got %v", err)
+	}
+}
+
+// Test that YAML configuration files produce the same tests as the
+// line-based format.
+func TestYAML(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "yaml")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"tests.yaml": `
+variables:
+  HOST: example.com
+  URL: https://${HOST}/
+macros:
+  REDIS: [127.0.0.1, "::1"]
+tests:
+  - target: ${URL}
+    type: http
+    arguments:
+      status: 200
+      content: "Steve's site"
+      severity: critical
+  - target: REDIS
+    type: redis
+`,
+		// No extension, so detected by content
+		"tests": `
+# Our tests
+---
+tests:
+  - target: mail.example.com
+    type: smtp
+    arguments:
+      port: 587
+`,
+		"tests.txt": `
+https://example.com/ must run http with content 'Steve's site' with severity 'critical' with status '200'
+127.0.0.1 must run redis
+::1 must run redis
+mail.example.com must run smtp with port 587
+`,
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Error writing to temporary file")
+		}
+	}
+
+	parse := func(names ...string) []test.Test {
+		var found []test.Test
+
+		p := New()
+		for _, name := range names {
+			err = p.ParseFile(filepath.Join(dir, name), func(x test.Test) error {
+				found = append(found, x)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("We did not expect an error parsing %s - got %s!", name, err)
+			}
+		}
+		return found
+	}
+
+	yamlTests := parse("tests.yaml", "tests")
+	dslTests := parse("tests.txt")
+
+	if len(yamlTests) != len(dslTests) {
+		t.Fatalf("Expected %d tests, got %d", len(dslTests), len(yamlTests))
+	}
+	for i := range dslTests {
+		if !reflect.DeepEqual(yamlTests[i].Arguments, dslTests[i].Arguments) ||
+			yamlTests[i].Target != dslTests[i].Target ||
+			yamlTests[i].Type != dslTests[i].Type ||
+			yamlTests[i].Severity != dslTests[i].Severity {
+			t.Errorf("Test %d differs: %+v vs %+v", i, yamlTests[i], dslTests[i])
+		}
+	}
+}
+
+// Test that bogus YAML configuration files are rejected.
+func TestYAMLErrors(t *testing.T) {
+	tests := []string{
+		"tests:\n  - target: example.com\n",
+		"tests:\n  - target: example.com\n    type: moi\n",
+		"tests:\n  - target: example.com\n    type: http\n    unknown: true\n",
+		"macros:\n  HOSTS: example.com\n",
+	}
+
+	for _, input := range tests {
+		file, err := ioutil.TempFile(os.TempDir(), "*.yml")
+		if err != nil {
+			t.Fatalf("Error creating temporary file")
+		}
+		defer os.Remove(file.Name())
+
+		err = ioutil.WriteFile(file.Name(), []byte(input), 0644)
+		if err != nil {
+			t.Fatalf("Error writing to temporary file")
+		}
+
+		p := New()
+		err = p.ParseFile(file.Name(), nil)
+		if err == nil {
+			t.Errorf("We expected an error parsing %s, but found none!", input)
+		}
+	}
+}
+
+// Test that CIDR blocks and ranges are expanded to one test per address.
+func TestCIDRExpansion(t *testing.T) {
+	type TestCase struct {
+		Input  string
+		Output []string
+	}
+
+	tests := []TestCase{
+		{"192.168.1.0/30 must run tcp with port 22",
+			[]string{"192.168.1.1", "192.168.1.2"}},
+		{"192.168.1.0/30 must run tcp with port 22 with include-network true",
+			[]string{"192.168.1.0", "192.168.1.1", "192.168.1.2", "192.168.1.3"}},
+		{"192.168.1.7/32 must run tcp with port 22",
+			[]string{"192.168.1.7"}},
+		{"10.0.0.1-10.0.0.5 must run ping",
+			[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"}},
+		{"10.0.0.255-10.0.1.0 must run ping",
+			[]string{"10.0.0.255", "10.0.1.0"}},
+	}
+
+	for _, tst := range tests {
+		p := New()
+
+		var targets []string
+		_, err := p.ParseLine(tst.Input, func(x test.Test) error {
+			if !strings.HasPrefix(x.Input, x.Target+" must run") {
+				t.Errorf("The target wasn't substituted into the input: %s", x.Input)
+			}
+			targets = append(targets, x.Target)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("We did not expect an error parsing %s - got %s!", tst.Input, err)
+		}
+
+		if !reflect.DeepEqual(targets, tst.Output) {
+			t.Errorf("Expected targets %v for %s, got %v", tst.Output, tst.Input, targets)
+		}
+	}
+}
+
+// Test that bogus ranges are rejected.
+func TestCIDRExpansionErrors(t *testing.T) {
+	tests := []string{
+		"10.0.0.5-10.0.0.1 must run ping",
+		"10.0.0.1-::1 must run ping",
+		"10.0.0.0/8 must run ping",
+		"10.0.0.0/30 must run ping with include-network maybe",
+	}
+
+	for _, input := range tests {
+		p := New()
+
+		_, err := p.ParseLine(input, nil)
+		if err == nil {
+			t.Errorf("We expected an error parsing %s, but found none!", input)
+		}
+	}
+}
+
 func TestTestLabel(t *testing.T) {
 	tests := []string{
 		"http://example.com/ must run http with min-duration 5m with test-label \"Hello 0\"",
@@ -713,3 +1402,65 @@ http://example.com/ must run http with username 'steve' with password 'ke'mp'
 		t.Errorf("We see no evidence of censorship")
 	}
 }
+
+func TestSanitizeBearer(t *testing.T) {
+	p := New()
+
+	tst, err := p.ParseLine("http://example.com/ must run http with bearer 's3cr3t-t0ken'", nil)
+	if err != nil {
+		t.Fatalf("We did not expect an error - got %s!", err)
+	}
+
+	safe := tst.Sanitize()
+	if strings.Contains(safe, "s3cr3t-t0ken") {
+		t.Errorf("Bearer token is still visible")
+	}
+	if !strings.Contains(safe, "with bearer 'CENSORED'") {
+		t.Errorf("We see no evidence of censorship")
+	}
+}
+
+// Test that executable files are refused, rather than executed, if asked.
+func TestNoExecute(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "noexec")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	marker := filepath.Join(dir, "executed")
+	script := filepath.Join(dir, "tests.sh")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\ntouch '"+marker+"'\necho 'localhost must run ssh'\n"), 0755)
+	if err != nil {
+		t.Fatalf("Error writing to temporary file")
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "main.cfg"), []byte("include tests.sh\n"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing to temporary file")
+	}
+
+	for _, file := range []string{script, filepath.Join(dir, "main.cfg")} {
+		p := New()
+		p.NoExecute = true
+		err = p.ParseFile(file, nil)
+		if err == nil || !strings.Contains(err.Error(), "refusing to execute") {
+			t.Errorf("Expected %s to be refused, got %v", file, err)
+		}
+	}
+	if _, err = os.Stat(marker); err == nil {
+		t.Fatalf("The executable file was executed")
+	}
+
+	// By default it is executed
+	found := 0
+	err = New().ParseFile(script, func(x test.Test) error {
+		found++
+		return nil
+	})
+	if err != nil || found != 1 {
+		t.Errorf("Expected the output of the executable file to be parsed, got %d tests, %v", found, err)
+	}
+	if _, err = os.Stat(marker); err != nil {
+		t.Errorf("The executable file wasn't executed")
+	}
+}
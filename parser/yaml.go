@@ -0,0 +1,147 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yamlConfig is the structure of a YAML configuration file, which is an
+// alternative to our line-based format:
+//
+//   variables:
+//     HOST: example.com
+//   macros:
+//     REDIS: [127.0.0.1, "::1"]
+//   tests:
+//     - target: https://${HOST}/
+//       type: http
+//       arguments:
+//         status: 200
+//         content: Hello
+//     - target: REDIS
+//       type: redis
+//
+// Variables and macros are ordered, and defined before the tests are
+// parsed, exactly as if they'd been written at the top of a file.
+type yamlConfig struct {
+	Variables yaml.MapSlice `yaml:"variables"`
+	Macros    yaml.MapSlice `yaml:"macros"`
+	Tests     []yamlTest    `yaml:"tests"`
+}
+
+// yamlTest is a single test within a YAML configuration file.
+type yamlTest struct {
+	Target    string                 `yaml:"target"`
+	Type      string                 `yaml:"type"`
+	Arguments map[string]interface{} `yaml:"arguments"`
+}
+
+// isYAML returns true if the given file contains YAML, either because of
+// its name or because it starts with a document-marker or one of our keys.
+func (s *Parser) isYAML(filename string, data []byte) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".yml" || ext == ".yaml" {
+		return true
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if line == "---" {
+			return true
+		}
+		for _, key := range []string{"variables:", "macros:", "tests:"} {
+			if strings.HasPrefix(line, key) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return false
+}
+
+// parseYAML processes a YAML configuration file.
+//
+// Each test is converted to the equivalent line of our own format, and
+// parsed via ParseLine, so that the results are identical to those of a
+// line-based file - not least because the worker re-parses the input of
+// each test it receives.
+func (s *Parser) parseYAML(data []byte, cb ParsedTest) error {
+	var config yamlConfig
+
+	err := yaml.UnmarshalStrict(data, &config)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range config.Variables {
+		name := fmt.Sprintf("%v", item.Key)
+
+		value, err := s.expandVariables(fmt.Sprintf("%v", item.Value))
+		if err != nil {
+			return fmt.Errorf("variable %s: %s", name, err.Error())
+		}
+		s.VARIABLES[name] = value
+	}
+
+	for _, item := range config.Macros {
+		hosts, ok := item.Value.([]interface{})
+		if !ok {
+			return fmt.Errorf("macro %v: the value must be a list of hosts", item.Key)
+		}
+
+		var values []string
+		for _, host := range hosts {
+			values = append(values, fmt.Sprintf("%v", host))
+		}
+
+		_, err = s.ParseLine(fmt.Sprintf("%v are %s", item.Key, strings.Join(values, ", ")), cb)
+		if err != nil {
+			return fmt.Errorf("macro %v: %s", item.Key, err.Error())
+		}
+	}
+
+	for i, tst := range config.Tests {
+		if tst.Target == "" || tst.Type == "" {
+			return fmt.Errorf("test %d: both a target and a type are required", i+1)
+		}
+
+		line := fmt.Sprintf("%s must run %s", tst.Target, tst.Type)
+
+		//
+		// Sort the arguments, so the input is stable.
+		//
+		var names []string
+		for name := range tst.Arguments {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value := fmt.Sprintf("%v", tst.Arguments[name])
+			if strings.Contains(value, "\n") {
+				return fmt.Errorf("test %d: the value of argument '%s' can't span multiple lines", i+1, name)
+			}
+
+			line += fmt.Sprintf(" with %s '%s'", name, value)
+		}
+
+		_, err = s.ParseLine(line, cb)
+		if err != nil {
+			return fmt.Errorf("test %d: %s", i+1, err.Error())
+		}
+	}
+
+	return nil
+}
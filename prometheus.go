@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// The upper bounds, in seconds, of the buckets of the duration histogram.
+var prometheusBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// prometheusLabels identify a series, as the protocol and target of the
+// tests counted.
+type prometheusLabels struct {
+	protocol string
+	target   string
+}
+
+// prometheusSeries are the counts of the tests of a single protocol and
+// target.
+type prometheusSeries struct {
+	runs     uint64
+	failures uint64
+
+	// The number of durations in each bucket, not cumulative, the last
+	// one being +Inf, along with their number and sum
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// prometheusMetrics is a sink which counts the results of tests, and
+// exports the counts to Prometheus, in its text format.
+type prometheusMetrics struct {
+	series map[prometheusLabels]*prometheusSeries
+	lock   sync.Mutex
+}
+
+// newPrometheusMetrics returns metrics with no tests counted.
+func newPrometheusMetrics() *prometheusMetrics {
+	return &prometheusMetrics{series: make(map[prometheusLabels]*prometheusSeries)}
+}
+
+// Write counts the result, and observes its duration if the test was run.
+func (m *prometheusMetrics) Write(result *test.Result) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	labels := prometheusLabels{protocol: result.Type, target: result.Target}
+	s, ok := m.series[labels]
+	if !ok {
+		s = &prometheusSeries{buckets: make([]uint64, len(prometheusBuckets)+1)}
+		m.series[labels] = s
+	}
+
+	s.runs++
+	if result.Error != nil {
+		s.failures++
+	}
+
+	if result.Duration != nil {
+		seconds := float64(*result.Duration) / 1000
+		i := sort.SearchFloat64s(prometheusBuckets, seconds)
+		s.buckets[i]++
+		s.count++
+		s.sum += seconds
+	}
+	return nil
+}
+
+// Close does nothing, as the metrics live as long as the worker.
+func (m *prometheusMetrics) Close() error {
+	return nil
+}
+
+// ServeHTTP writes the metrics.
+func (m *prometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// write writes the metrics, in the text format of Prometheus, with the
+// series sorted by their labels.
+func (m *prometheusMetrics) write(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	labels := make([]prometheusLabels, 0, len(m.series))
+	for l := range m.series {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].protocol != labels[j].protocol {
+			return labels[i].protocol < labels[j].protocol
+		}
+		return labels[i].target < labels[j].target
+	})
+
+	fmt.Fprintf(w, "# HELP overseer_test_runs_total The number of test results.\n")
+	fmt.Fprintf(w, "# TYPE overseer_test_runs_total counter\n")
+	for _, l := range labels {
+		fmt.Fprintf(w, "overseer_test_runs_total{%s} %d\n", m.labels(l), m.series[l].runs)
+	}
+
+	fmt.Fprintf(w, "# HELP overseer_test_failures_total The number of test results which failed.\n")
+	fmt.Fprintf(w, "# TYPE overseer_test_failures_total counter\n")
+	for _, l := range labels {
+		fmt.Fprintf(w, "overseer_test_failures_total{%s} %d\n", m.labels(l), m.series[l].failures)
+	}
+
+	fmt.Fprintf(w, "# HELP overseer_test_duration_seconds The time taken to run a test, including any retries.\n")
+	fmt.Fprintf(w, "# TYPE overseer_test_duration_seconds histogram\n")
+	for _, l := range labels {
+		s := m.series[l]
+
+		var cumulative uint64
+		for i, count := range s.buckets {
+			cumulative += count
+
+			le := "+Inf"
+			if i < len(prometheusBuckets) {
+				le = strconv.FormatFloat(prometheusBuckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "overseer_test_duration_seconds_bucket{%s,le=\"%s\"} %d\n", m.labels(l), le, cumulative)
+		}
+		fmt.Fprintf(w, "overseer_test_duration_seconds_sum{%s} %s\n", m.labels(l), strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "overseer_test_duration_seconds_count{%s} %d\n", m.labels(l), s.count)
+	}
+}
+
+// labels returns the labels of a series, as written within its braces.
+func (m *prometheusMetrics) labels(l prometheusLabels) string {
+	return fmt.Sprintf("protocol=\"%s\",target=\"%s\"", m.escape(l.protocol), m.escape(l.target))
+}
+
+// escape escapes the value of a label.
+func (m *prometheusMetrics) escape(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
@@ -0,0 +1,219 @@
+// AMQP Tester
+//
+// The AMQP tester connects to an AMQP 0-9-1 broker, such as RabbitMQ, logs
+// in, and ensures that a channel can be opened.
+//
+// This test is invoked via input like so:
+//
+//    rabbit.example.com must run amqp with username 'probe' with password 'secret' [with port 5672]
+//
+// If no credentials are given the default "guest" account is used.  The
+// virtual host, by default "/", may be changed via "vhost":
+//
+//    rabbit.example.com must run amqp with username 'probe' with password 'secret' with vhost 'jobs'
+//
+// To ensure that a queue exists, which is checked without changing it,
+// specify its name:
+//
+//    rabbit.example.com must run amqp with username 'probe' with password 'secret' with queue 'emails'
+//
+// Brokers can be connected to via TLS, on port 5671 by default, which may
+// also disable certificate validation:
+//
+//    rabbit.example.com must run amqp with tls true
+//    rabbit.example.com must run amqp with tls insecure
+//
+// Failures report whether the connection, the login, or the channel
+// failed, so it is clear whether the network or the broker is broken.
+//
+
+package protocols
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/streadway/amqp"
+)
+
+// AMQPTest is our object.
+type AMQPTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *AMQPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"password": ".*",
+		"port":     "^[0-9]+$",
+		"queue":    ".*",
+		"tls":      "^(true|insecure)$",
+		"username": ".*",
+		"vhost":    ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *AMQPTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *AMQPTest) Example() string {
+	str := `
+AMQP Tester
+-----------
+ The AMQP tester connects to an AMQP 0-9-1 broker, such as RabbitMQ, logs
+ in, and ensures that a channel can be opened.
+
+ This test is invoked via input like so:
+
+    rabbit.example.com must run amqp with username 'probe' with password 'secret' [with port 5672]
+
+ If no credentials are given the default "guest" account is used.  The
+ virtual host, by default "/", may be changed via "vhost":
+
+    rabbit.example.com must run amqp with username 'probe' with password 'secret' with vhost 'jobs'
+
+ To ensure that a queue exists, which is checked without changing it,
+ specify its name:
+
+    rabbit.example.com must run amqp with username 'probe' with password 'secret' with queue 'emails'
+
+ Brokers can be connected to via TLS, on port 5671 by default, which may
+ also disable certificate validation:
+
+    rabbit.example.com must run amqp with tls true
+    rabbit.example.com must run amqp with tls insecure
+
+ Failures report whether the connection, the login, or the channel
+ failed, so it is clear whether the network or the broker is broken.
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we connect, login, open a channel, and optionally check
+// the queue.
+func (s *AMQPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	port := 5672
+	if tst.Arguments["tls"] != "" {
+		port = 5671
+	}
+
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	username := tst.Arguments["username"]
+	password := tst.Arguments["password"]
+	if username == "" {
+		username = "guest"
+		password = "guest"
+	}
+
+	vhost := "/"
+	if tst.Arguments["vhost"] != "" {
+		vhost = tst.Arguments["vhost"]
+	}
+
+	//
+	// The address to connect to, with IPv6 addresses in brackets
+	//
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	d := net.Dialer{Timeout: opts.Timeout}
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		return fmt.Errorf("connecting to the broker failed: %w", err)
+	}
+	defer conn.Close()
+
+	//
+	// The deadline covers the handshake, after which the client
+	// manages it via heartbeats.
+	//
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	if tst.Arguments["tls"] != "" {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName:         tst.Target,
+			InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+		})
+		if err = tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("connecting to the broker failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	connection, err := amqp.Open(conn, amqp.Config{
+		SASL:  []amqp.Authentication{&amqp.PlainAuth{Username: username, Password: password}},
+		Vhost: vhost,
+		Properties: amqp.Table{
+			"product": "overseer",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("logging into the broker failed: %w", err)
+	}
+	defer connection.Close()
+
+	opts.Tracef("Logged into %s %s, on virtual host '%s'",
+		connection.Properties["product"], connection.Properties["version"], vhost)
+
+	channel, err := connection.Channel()
+	if err != nil {
+		return fmt.Errorf("opening a channel failed: %w", err)
+	}
+	defer channel.Close()
+
+	if tst.Arguments["queue"] == "" {
+		return nil
+	}
+
+	//
+	// A passive declaration fails if the queue doesn't exist, without
+	// creating it.
+	//
+	queue, err := channel.QueueDeclarePassive(tst.Arguments["queue"], false, false, false, false, nil)
+	if err != nil {
+		if e, ok := err.(*amqp.Error); ok && e.Code == amqp.NotFound {
+			return fmt.Errorf("the queue '%s' doesn't exist on virtual host '%s'", tst.Arguments["queue"], vhost)
+		}
+		return fmt.Errorf("checking the queue '%s' failed: %w", tst.Arguments["queue"], err)
+	}
+
+	opts.Tracef("The queue '%s' has %d messages, and %d consumers", queue.Name, queue.Messages, queue.Consumers)
+
+	return nil
+}
+
+func (s *AMQPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("amqp", func() ProtocolTest {
+		return &AMQPTest{}
+	})
+}
@@ -0,0 +1,172 @@
+// Banner Tester
+//
+// The banner tester connects to a line-based service, reads the first line
+// it sends, and ensures that it matches a regular expression.
+//
+// This test is invoked via input like so:
+//
+//    host.example.com must run banner with port 4000 with banner '^READY '
+//
+// Both the port and the banner are mandatory.
+//
+// Services which only reply once they've been spoken to can be sent a
+// line first, in which `\r`, `\n`, and `\t` are interpreted, and a
+// trailing CRLF is added if missing:
+//
+//    host.example.com must run banner with port 113 with send '22, 6191' with banner 'USERID|ERROR'
+//
+// If no newline arrives before the timeout whatever was received is
+// matched instead.
+//
+
+package protocols
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// BANNERTest is our object.
+type BANNERTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *BANNERTest) Arguments() map[string]string {
+	known := map[string]string{
+		"port":   "^[0-9]+$",
+		"banner": ".*",
+		"send":   ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *BANNERTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *BANNERTest) Example() string {
+	str := `
+Banner Tester
+-------------
+ The banner tester connects to a line-based service, reads the first line
+ it sends, and ensures that it matches a regular expression.
+
+ This test is invoked via input like so:
+
+    host.example.com must run banner with port 4000 with banner '^READY '
+
+ Both the port and the banner are mandatory.
+
+ Services which only reply once they've been spoken to can be sent a
+ line first, in which '\r', '\n', and '\t' are interpreted, and a
+ trailing CRLF is added if missing:
+
+    host.example.com must run banner with port 113 with send '22, 6191' with banner 'USERID|ERROR'
+
+ If no newline arrives before the timeout whatever was received is
+ matched instead.
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we read a line, and match it against the banner.
+func (s *BANNERTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	if tst.Arguments["port"] == "" {
+		return errors.New("you must specify the port when running a banner test")
+	}
+	if tst.Arguments["banner"] == "" {
+		return errors.New("you must specify the banner when running a banner test")
+	}
+
+	port, err := strconv.Atoi(tst.Arguments["port"])
+	if err != nil {
+		return err
+	}
+
+	re, err := regexp.Compile(tst.Arguments["banner"])
+	if err != nil {
+		return err
+	}
+
+	//
+	// The address to connect to, with IPv6 addresses in brackets
+	//
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	d := net.Dialer{Timeout: opts.Timeout}
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	//
+	// Speak first, if we were asked to.
+	//
+	if tst.Arguments["send"] != "" {
+		send := strings.NewReplacer(`\r`, "\r", `\n`, "\n", `\t`, "\t", `\\`, `\`).Replace(tst.Arguments["send"])
+		if !strings.HasSuffix(send, "\n") {
+			send += "\r\n"
+		}
+
+		if _, err = conn.Write([]byte(send)); err != nil {
+			return err
+		}
+	}
+
+	//
+	// Read a line, but settle for a partial one if that's all there is.
+	//
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return fmt.Errorf("no banner was received within %s", opts.Timeout)
+		}
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	if opts.Verbose {
+		fmt.Printf("\tReceived banner '%s'\n", line)
+	}
+
+	if !re.MatchString(line) {
+		return fmt.Errorf("remote banner '%s' didn't match the regular expression '%s'", line, tst.Arguments["banner"])
+	}
+
+	return nil
+}
+
+func (s *BANNERTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("banner", func() ProtocolTest {
+		return &BANNERTest{}
+	})
+}
@@ -0,0 +1,354 @@
+// CalDAV Tester
+//
+// The CalDAV tester logs into a CalDAV server, finds the calendars of the
+// user, and ensures that those you expect are present.
+//
+// This test is invoked via input like so:
+//
+//    https://dav.example.com/ must run caldav with username 'steve' with password 'secret' with collection 'Work,Holidays'
+//
+// The calendars are found via the principal of the user, so the target
+// can be any URL of the server, a principal, or a calendar home.  A
+// different path can be given too:
+//
+//    https://dav.example.com/ must run caldav with username 'steve' with password 'secret' with path '/calendars/steve/'
+//
+// Calendars are matched by their display name, or the last part of their
+// path.  If no collection is given the user must have at least one
+// calendar.
+//
+// Address books of CardDAV servers are tested in the same way, when this
+// test is invoked as "carddav":
+//
+//    https://dav.example.com/ must run carddav with username 'steve' with password 'secret' with collection 'Contacts'
+//
+// If you need to disable failures due to expired, broken, or otherwise
+// bogus TLS certificates you can do so via the tls setting:
+//
+//    https://dav.example.com/ must run caldav with username 'steve' with password 'secret' with tls insecure
+//
+
+package protocols
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// CALDAVTest is our object.
+type CALDAVTest struct {
+}
+
+// davHref is a property holding a single URL.
+type davHref struct {
+	Href string `xml:"DAV: href"`
+}
+
+// davMultiStatus is the body of a "207 Multi-Status" response to our
+// PROPFIND, with the properties we asked for.
+type davMultiStatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Calendar    *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+					AddressBook *struct{} `xml:"urn:ietf:params:xml:ns:carddav addressbook"`
+				} `xml:"DAV: resourcetype"`
+				DisplayName        string  `xml:"DAV: displayname"`
+				Principal          davHref `xml:"DAV: current-user-principal"`
+				CalendarHomeSet    davHref `xml:"urn:ietf:params:xml:ns:caldav calendar-home-set"`
+				AddressBookHomeSet davHref `xml:"urn:ietf:params:xml:ns:carddav addressbook-home-set"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// davPropfind is the body of our requests, asking for everything we need
+// to find the collections.
+const davPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:CR="urn:ietf:params:xml:ns:carddav"><D:prop><D:resourcetype/><D:displayname/><D:current-user-principal/><C:calendar-home-set/><CR:addressbook-home-set/></D:prop></D:propfind>`
+
+// davResource is a resource listed by a PROPFIND.
+type davResource struct {
+	url        *url.URL
+	name       string
+	collection bool
+	principal  string
+	home       string
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *CALDAVTest) Arguments() map[string]string {
+	known := map[string]string{
+		"collection": ".*",
+		"password":   ".*",
+		"path":       "^/.*$",
+		"tls":        "insecure",
+		"username":   ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *CALDAVTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *CALDAVTest) Example() string {
+	str := `
+CalDAV Tester
+-------------
+ The CalDAV tester logs into a CalDAV server, finds the calendars of the
+ user, and ensures that those you expect are present.
+
+ This test is invoked via input like so:
+
+    https://dav.example.com/ must run caldav with username 'steve' with password 'secret' with collection 'Work,Holidays'
+
+ The calendars are found via the principal of the user, so the target
+ can be any URL of the server, a principal, or a calendar home.  A
+ different path can be given too:
+
+    https://dav.example.com/ must run caldav with username 'steve' with password 'secret' with path '/calendars/steve/'
+
+ Calendars are matched by their display name, or the last part of their
+ path.  If no collection is given the user must have at least one
+ calendar.
+
+ Address books of CardDAV servers are tested in the same way, when this
+ test is invoked as "carddav":
+
+    https://dav.example.com/ must run carddav with username 'steve' with password 'secret' with collection 'Contacts'
+
+ If you need to disable failures due to expired, broken, or otherwise
+ bogus TLS certificates you can do so via the tls setting:
+
+    https://dav.example.com/ must run caldav with username 'steve' with password 'secret' with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we find the home of the collections, list them, and look
+// for the expected ones.
+func (s *CALDAVTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	u, err := url.Parse(tst.Target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("the target must be a http:// or https:// URL, got '%s'", tst.Target)
+	}
+
+	if tst.Arguments["path"] != "" {
+		u.Path = tst.Arguments["path"]
+		u.RawPath = ""
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	kind := "calendar"
+	if tst.Type == "carddav" {
+		kind = "address book"
+	}
+
+	client := newPinnedHTTPClient(target, tst.Arguments["tls"] == "insecure", opts.Timeout)
+
+	//
+	// The target may be the collection itself, otherwise we find
+	// the home of the collections, via the principal if need be.
+	//
+	resources, err := s.propfind(client, u, "0", tst)
+	if err != nil {
+		return err
+	}
+	if len(resources) == 0 {
+		return fmt.Errorf("PROPFIND %s listed no resources", u.Path)
+	}
+	self := resources[0]
+
+	var collections []davResource
+	if self.collection {
+		collections = []davResource{self}
+	} else {
+		home := self.home
+		if home == "" && self.principal != "" {
+			principal, err := u.Parse(self.principal)
+			if err != nil {
+				return err
+			}
+
+			resources, err = s.propfind(client, principal, "0", tst)
+			if err != nil {
+				return err
+			}
+			if len(resources) > 0 {
+				home = resources[0].home
+			}
+		}
+
+		homeURL := u
+		if home != "" {
+			homeURL, err = u.Parse(home)
+			if err != nil {
+				return err
+			}
+		}
+
+		resources, err = s.propfind(client, homeURL, "1", tst)
+		if err != nil {
+			return err
+		}
+		for _, resource := range resources {
+			if resource.collection {
+				collections = append(collections, resource)
+			}
+		}
+
+		u = homeURL
+	}
+
+	var names []string
+	for _, collection := range collections {
+		names = append(names, collection.name)
+	}
+
+	opts.Tracef("Found %d %s collections in %s: %s", len(collections), kind, u.Path, strings.Join(names, ", "))
+
+	if tst.Arguments["collection"] == "" {
+		if len(collections) == 0 {
+			return fmt.Errorf("no %s collections were found in %s", kind, u.Path)
+		}
+		return nil
+	}
+
+	for _, expected := range strings.Split(tst.Arguments["collection"], ",") {
+		expected = strings.TrimSpace(expected)
+		if expected == "" {
+			continue
+		}
+
+		found := false
+		for _, collection := range collections {
+			if collection.name == expected || path.Base(strings.TrimSuffix(collection.url.Path, "/")) == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("the %s '%s' wasn't found in %s, found: %s", kind, expected, u.Path, strings.Join(names, ", "))
+		}
+	}
+
+	return nil
+}
+
+// propfind lists the given resource, and with a depth of 1 its children,
+// with the properties we care about.
+func (s *CALDAVTest) propfind(client *http.Client, u *url.URL, depth string, tst test.Test) ([]davResource, error) {
+
+	req, err := http.NewRequest("PROPFIND", u.String(), strings.NewReader(davPropfind))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	if tst.Arguments["username"] != "" {
+		req.SetBasicAuth(tst.Arguments["username"], tst.Arguments["password"])
+	}
+
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s returned status code %d, not %d", u.Path, response.StatusCode, http.StatusMultiStatus)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxHTTPBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	var status davMultiStatus
+	err = xml.Unmarshal(body, &status)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROPFIND response: %w", err)
+	}
+
+	//
+	// Properties are split among a propstat for those which were
+	// found, and others for those which weren't, which are empty.
+	//
+	var resources []davResource
+	for _, r := range status.Responses {
+		href, err := u.Parse(strings.TrimSpace(r.Href))
+		if err != nil {
+			continue
+		}
+
+		resource := davResource{url: href}
+		for _, propstat := range r.Propstat {
+			prop := propstat.Prop
+
+			if tst.Type == "carddav" {
+				resource.collection = resource.collection || prop.ResourceType.AddressBook != nil
+				resource.home = s.pick(resource.home, prop.AddressBookHomeSet.Href)
+			} else {
+				resource.collection = resource.collection || prop.ResourceType.Calendar != nil
+				resource.home = s.pick(resource.home, prop.CalendarHomeSet.Href)
+			}
+			resource.name = s.pick(resource.name, prop.DisplayName)
+			resource.principal = s.pick(resource.principal, prop.Principal.Href)
+		}
+
+		if resource.name == "" {
+			resource.name = path.Base(strings.TrimSuffix(href.Path, "/"))
+		}
+		resources = append(resources, resource)
+	}
+
+	return resources, nil
+}
+
+// pick returns the current value, unless it is empty.
+func (s *CALDAVTest) pick(current string, value string) string {
+	if current != "" {
+		return current
+	}
+	return strings.TrimSpace(value)
+}
+
+func (s *CALDAVTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+// Register our protocol-tester.
+func init() {
+	Register("caldav", func() ProtocolTest {
+		return &CALDAVTest{}
+	})
+	Register("carddav", func() ProtocolTest {
+		return &CALDAVTest{}
+	})
+}
@@ -0,0 +1,251 @@
+// Cassandra Tester
+//
+// The Cassandra tester connects to a Cassandra, or ScyllaDB, cluster and
+// ensures that it answers a query.
+//
+// This test is invoked via input like so:
+//
+//    cassandra.example.com must run cassandra [with port 9042]
+//
+// Credentials may be specified, should the cluster require them:
+//
+//    cassandra.example.com must run cassandra with username 'monitor' with password 'secret'
+//
+// Once connected the query "SELECT now() FROM system.local" is executed,
+// which is answered by the node without involving the rest of the cluster.
+//
+// To ensure that the cluster isn't degraded the minimum number of nodes
+// which must be reachable may be specified.  The nodes are discovered via
+// the cluster metadata, and each is connected to:
+//
+//    cassandra.example.com must run cassandra with min-nodes 3
+//
+// Clusters can be connected to via TLS, which may also disable certificate
+// validation:
+//
+//    cassandra.example.com must run cassandra with tls true
+//    cassandra.example.com must run cassandra with tls insecure
+//
+
+package protocols
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/gocql/gocql"
+)
+
+// CASSANDRATest is our object.
+type CASSANDRATest struct {
+}
+
+// cassandraNodes records the nodes of the cluster which were discovered,
+// and those which couldn't be connected to.
+//
+// It wraps the host-selection policy, to be told of the nodes, and is the
+// conviction policy, to be told of the failures.
+type cassandraNodes struct {
+	gocql.HostSelectionPolicy
+
+	mutex  sync.Mutex
+	found  map[string]bool
+	failed map[string]bool
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *CASSANDRATest) Arguments() map[string]string {
+	known := map[string]string{
+		"min-nodes": "^[0-9]+$",
+		"password":  ".*",
+		"port":      "^[0-9]+$",
+		"tls":       "^(true|insecure)$",
+		"username":  ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *CASSANDRATest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *CASSANDRATest) Example() string {
+	str := `
+Cassandra Tester
+----------------
+ The Cassandra tester connects to a Cassandra, or ScyllaDB, cluster and
+ ensures that it answers a query.
+
+ This test is invoked via input like so:
+
+    cassandra.example.com must run cassandra [with port 9042]
+
+ Credentials may be specified, should the cluster require them:
+
+    cassandra.example.com must run cassandra with username 'monitor' with password 'secret'
+
+ Once connected the query "SELECT now() FROM system.local" is executed,
+ which is answered by the node without involving the rest of the cluster.
+
+ To ensure that the cluster isn't degraded the minimum number of nodes
+ which must be reachable may be specified.  The nodes are discovered via
+ the cluster metadata, and each is connected to:
+
+    cassandra.example.com must run cassandra with min-nodes 3
+
+ Clusters can be connected to via TLS, which may also disable certificate
+ validation:
+
+    cassandra.example.com must run cassandra with tls true
+    cassandra.example.com must run cassandra with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we connect to the cluster, run a query, and count the
+// nodes which could be connected to.
+func (s *CASSANDRATest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	port := 9042
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	minNodes := 0
+	if tst.Arguments["min-nodes"] != "" {
+		minNodes, err = strconv.Atoi(tst.Arguments["min-nodes"])
+		if err != nil {
+			return err
+		}
+	}
+
+	nodes := &cassandraNodes{
+		HostSelectionPolicy: gocql.RoundRobinHostPolicy(),
+		found:               make(map[string]bool),
+		failed:              make(map[string]bool),
+	}
+
+	cluster := gocql.NewCluster(target)
+	cluster.Port = port
+	cluster.Consistency = gocql.One
+	cluster.PoolConfig.HostSelectionPolicy = nodes
+	cluster.ConvictionPolicy = nodes
+	cluster.ReconnectInterval = 0
+
+	//
+	// The default timeouts are rather short, so only replace them
+	// when we have one.
+	//
+	if opts.Timeout > 0 {
+		cluster.Timeout = opts.Timeout
+		cluster.ConnectTimeout = opts.Timeout
+	}
+
+	if tst.Arguments["username"] != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: tst.Arguments["username"],
+			Password: tst.Arguments["password"],
+		}
+	}
+
+	//
+	// The server-name is left empty, as it is set to the address of
+	// each node we connect to.
+	//
+	if tst.Arguments["tls"] != "" {
+		cluster.SslOpts = &gocql.SslOptions{
+			Config: &tls.Config{
+				InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+			},
+			EnableHostVerification: tst.Arguments["tls"] != "insecure",
+		}
+	}
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return fmt.Errorf("connection failed: %w", err)
+	}
+	defer session.Close()
+
+	var now time.Time
+	err = session.Query("SELECT now() FROM system.local").Scan(&now)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+
+	found, reachable := nodes.count()
+
+	opts.Tracef("Server time is %s", now.UTC())
+	opts.Tracef("%d of %d nodes are reachable", reachable, found)
+
+	if reachable < minNodes {
+		return fmt.Errorf("only %d of %d nodes are reachable, fewer than the minimum of %d", reachable, found, minNodes)
+	}
+
+	return nil
+}
+
+// AddHost records a node which was discovered.
+func (n *cassandraNodes) AddHost(host *gocql.HostInfo) {
+	n.mutex.Lock()
+	n.found[host.ConnectAddress().String()] = true
+	n.mutex.Unlock()
+
+	n.HostSelectionPolicy.AddHost(host)
+}
+
+// AddFailure records a node which couldn't be connected to.
+func (n *cassandraNodes) AddFailure(err error, host *gocql.HostInfo) bool {
+	n.mutex.Lock()
+	n.failed[host.ConnectAddress().String()] = true
+	n.mutex.Unlock()
+
+	return true
+}
+
+// Reset is invoked when a node is connected to after failing.
+func (n *cassandraNodes) Reset(host *gocql.HostInfo) {
+}
+
+// count returns the number of nodes which were discovered, and the number
+// of those which could be connected to.
+func (n *cassandraNodes) count() (int, int) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	reachable := 0
+	for address := range n.found {
+		if !n.failed[address] {
+			reachable++
+		}
+	}
+	return len(n.found), reachable
+}
+
+func (s *CASSANDRATest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("cassandra", func() ProtocolTest {
+		return &CASSANDRATest{}
+	})
+}
@@ -0,0 +1,594 @@
+// ClickHouse Tester
+//
+// The ClickHouse tester connects to a ClickHouse server, and runs a query.
+//
+// This test is invoked via input like so:
+//
+//    host.example.com must run clickhouse [with username 'default' with password 'secret']
+//
+// By default the native interface, on port 9000, is used; the HTTP
+// interface, on port 8123, can be used instead:
+//
+//    host.example.com must run clickhouse with mode http
+//
+// The query defaults to `SELECT 1`, but you may specify your own, along
+// with the single value it must return:
+//
+//    host.example.com must run clickhouse with database 'analytics' with query 'SELECT count() > 0 FROM events' with expect '1'
+//
+// To connect via TLS, on ports 9440 and 8443 respectively, set the tls
+// setting, which may also disable certificate validation:
+//
+//    host.example.com must run clickhouse with tls true
+//    host.example.com must run clickhouse with mode http with tls insecure
+//
+
+package protocols
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// CLICKHOUSETest is our object.
+type CLICKHOUSETest struct {
+}
+
+// clickhouseRevision is the revision of the native protocol we speak; it
+// is old, but servers remain compatible with it, and it keeps the packets
+// simple.
+const clickhouseRevision = 54213
+
+// The packets of the native protocol which we send, or understand.
+const (
+	clickhouseClientHello = 0
+	clickhouseClientQuery = 1
+	clickhouseClientData  = 2
+
+	clickhouseServerHello       = 0
+	clickhouseServerData        = 1
+	clickhouseServerException   = 2
+	clickhouseServerProgress    = 3
+	clickhouseServerEndOfStream = 5
+	clickhouseServerProfileInfo = 6
+	clickhouseServerTotals      = 7
+	clickhouseServerExtremes    = 8
+)
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *CLICKHOUSETest) Arguments() map[string]string {
+	known := map[string]string{
+		"database": "^[^\\s]+$",
+		"expect":   ".*",
+		"mode":     "^(native|http)$",
+		"password": ".*",
+		"port":     "^[0-9]+$",
+		"query":    ".*",
+		"tls":      "^(true|insecure)$",
+		"username": ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *CLICKHOUSETest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *CLICKHOUSETest) Example() string {
+	str := `
+ClickHouse Tester
+-----------------
+ The ClickHouse tester connects to a ClickHouse server, and runs a query.
+
+ This test is invoked via input like so:
+
+    host.example.com must run clickhouse [with username 'default' with password 'secret']
+
+ By default the native interface, on port 9000, is used; the HTTP
+ interface, on port 8123, can be used instead:
+
+    host.example.com must run clickhouse with mode http
+
+ The query defaults to 'SELECT 1', but you may specify your own, along
+ with the single value it must return:
+
+    host.example.com must run clickhouse with database 'analytics' with query 'SELECT count() > 0 FROM events' with expect '1'
+
+ To connect via TLS, on ports 9440 and 8443 respectively, set the tls
+ setting, which may also disable certificate validation:
+
+    host.example.com must run clickhouse with tls true
+    host.example.com must run clickhouse with mode http with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we run the query, and compare the value it returned.
+func (s *CLICKHOUSETest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	useTLS := tst.Arguments["tls"] != ""
+	useHTTP := tst.Arguments["mode"] == "http"
+
+	port := 9000
+	switch {
+	case useHTTP && useTLS:
+		port = 8443
+	case useHTTP:
+		port = 8123
+	case useTLS:
+		port = 9440
+	}
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	query := "SELECT 1"
+	if tst.Arguments["query"] != "" {
+		query = tst.Arguments["query"]
+	}
+
+	var value string
+	if useHTTP {
+		value, err = s.queryHTTP(tst, target, port, useTLS, query, opts)
+	} else {
+		value, err = s.queryNative(tst, target, port, useTLS, query, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tQuery '%s' returned '%s'\n", query, value)
+	}
+
+	if tst.Arguments["expect"] != "" && !s.equal(value, tst.Arguments["expect"]) {
+		return fmt.Errorf("query '%s' returned '%s', expected '%s'", query, value, tst.Arguments["expect"])
+	}
+
+	return nil
+}
+
+// equal compares a value to the expected one, numerically if possible.
+func (s *CLICKHOUSETest) equal(value string, expected string) bool {
+	if value == expected {
+		return true
+	}
+
+	a, errA := strconv.ParseFloat(value, 64)
+	b, errB := strconv.ParseFloat(expected, 64)
+	return errA == nil && errB == nil && a == b
+}
+
+// queryHTTP runs the query via the HTTP interface, and returns the first
+// value of the result.
+func (s *CLICKHOUSETest) queryHTTP(tst test.Test, target string, port int, useTLS bool, query string, opts test.Options) (string, error) {
+	host := tst.Target
+	if strings.Contains(host, "://") {
+		if u, err := url.Parse(host); err == nil {
+			host = u.Hostname()
+		}
+	}
+
+	u := url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(host, strconv.Itoa(port)),
+		Path:   "/",
+	}
+	if useTLS {
+		u.Scheme = "https"
+	}
+
+	params := url.Values{}
+	params.Set("query", query)
+	if tst.Arguments["database"] != "" {
+		params.Set("database", tst.Arguments["database"])
+	}
+	u.RawQuery = params.Encode()
+
+	client := newPinnedHTTPClient(target, tst.Arguments["tls"] == "insecure", opts.Timeout)
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "overseer/probe")
+	if tst.Arguments["username"] != "" {
+		req.Header.Set("X-ClickHouse-User", tst.Arguments["username"])
+		req.Header.Set("X-ClickHouse-Key", tst.Arguments["password"])
+	}
+
+	response, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxHTTPBodySize))
+	if err != nil {
+		return "", err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("query '%s' failed, status code %d: %s", query, response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	//
+	// The result is tab-separated, we want the first column of the
+	// first row.
+	//
+	result := strings.TrimRight(string(body), "\n")
+	if result == "" {
+		return "", fmt.Errorf("query '%s' returned no rows", query)
+	}
+	row := strings.SplitN(result, "\n", 2)[0]
+	return strings.SplitN(row, "\t", 2)[0], nil
+}
+
+// queryNative runs the query via the native protocol, and returns the
+// first value of the result.
+func (s *CLICKHOUSETest) queryNative(tst test.Test, target string, port int, useTLS bool, query string, opts test.Options) (string, error) {
+
+	//
+	// The address to connect to, with IPv6 addresses in brackets
+	//
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+
+	var conn net.Conn
+	var err error
+	if useTLS {
+		config := &tls.Config{ServerName: strings.Fields(tst.Input)[0]}
+		if tst.Arguments["tls"] == "insecure" {
+			config = &tls.Config{InsecureSkipVerify: true}
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, config)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(opts.Timeout))
+	}
+
+	username := tst.Arguments["username"]
+	if username == "" {
+		username = "default"
+	}
+
+	w := &clickhouseWriter{}
+	r := &clickhouseReader{r: bufio.NewReader(conn)}
+
+	//
+	// Say hello, which authenticates us too.
+	//
+	w.uvarint(clickhouseClientHello)
+	w.string("overseer")
+	w.uvarint(1)
+	w.uvarint(0)
+	w.uvarint(clickhouseRevision)
+	w.string(tst.Arguments["database"])
+	w.string(username)
+	w.string(tst.Arguments["password"])
+	if _, err = conn.Write(w.buf); err != nil {
+		return "", err
+	}
+
+	packet := r.uvarint()
+	switch {
+	case r.err != nil:
+		return "", r.err
+	case packet == clickhouseServerException:
+		return "", s.exception(r)
+	case packet != clickhouseServerHello:
+		return "", fmt.Errorf("unexpected packet %d in response to hello", packet)
+	}
+
+	name := r.string()
+	major := r.uvarint()
+	minor := r.uvarint()
+	r.uvarint() // revision
+	r.string()  // timezone
+	if r.err != nil {
+		return "", r.err
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tConnected to %s %d.%d\n", name, major, minor)
+	}
+
+	//
+	// Send the query, followed by the empty block which says that
+	// we've no data of our own.
+	//
+	w = &clickhouseWriter{}
+	w.uvarint(clickhouseClientQuery)
+	w.string("") // query ID
+
+	// client information
+	w.byte(1) // initial query
+	w.string("")
+	w.string("")
+	w.string("0.0.0.0:0")
+	w.byte(1) // via TCP
+	w.string("")
+	w.string("")
+	w.string("overseer")
+	w.uvarint(1)
+	w.uvarint(0)
+	w.uvarint(clickhouseRevision)
+	w.string("") // quota key
+
+	w.string("") // no settings
+	w.uvarint(2) // run the query to completion
+	w.uvarint(0) // no compression
+	w.string(query)
+
+	w.uvarint(clickhouseClientData)
+	w.string("")
+	w.blockInfo()
+	w.uvarint(0) // columns
+	w.uvarint(0) // rows
+	if _, err = conn.Write(w.buf); err != nil {
+		return "", err
+	}
+
+	//
+	// Read until we find a block with some rows in it.
+	//
+	for {
+		packet := r.uvarint()
+		if r.err != nil {
+			return "", r.err
+		}
+
+		switch packet {
+		case clickhouseServerData, clickhouseServerTotals, clickhouseServerExtremes:
+			r.string() // table name
+			r.blockInfo()
+			columns := r.uvarint()
+			rows := r.uvarint()
+			if r.err != nil {
+				return "", r.err
+			}
+
+			if rows == 0 {
+				for i := uint64(0); i < columns; i++ {
+					r.string()
+					r.string()
+				}
+				continue
+			}
+			if packet != clickhouseServerData || columns == 0 {
+				return "", fmt.Errorf("unexpected packet %d in response to the query", packet)
+			}
+
+			r.string() // column name
+			kind := r.string()
+			if r.err != nil {
+				return "", r.err
+			}
+			return r.value(kind, rows)
+
+		case clickhouseServerException:
+			return "", fmt.Errorf("query '%s' failed: %s", query, s.exception(r).Error())
+
+		case clickhouseServerProgress:
+			r.uvarint()
+			r.uvarint()
+			r.uvarint()
+
+		case clickhouseServerProfileInfo:
+			r.uvarint()
+			r.uvarint()
+			r.uvarint()
+			r.byte()
+			r.uvarint()
+			r.byte()
+
+		case clickhouseServerEndOfStream:
+			return "", fmt.Errorf("query '%s' returned no rows", query)
+
+		default:
+			return "", fmt.Errorf("unexpected packet %d in response to the query", packet)
+		}
+	}
+}
+
+// exception reads an exception from the server, and returns it as an error.
+func (s *CLICKHOUSETest) exception(r *clickhouseReader) error {
+	code := r.int32()
+	r.string() // name
+	message := r.string()
+	if r.err != nil {
+		return r.err
+	}
+	return fmt.Errorf("code %d: %s", code, message)
+}
+
+// clickhouseWriter builds packets of the native protocol.
+type clickhouseWriter struct {
+	buf []byte
+}
+
+func (w *clickhouseWriter) byte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *clickhouseWriter) uvarint(v uint64) {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	w.buf = append(w.buf, tmp[:binary.PutUvarint(tmp, v)]...)
+}
+
+func (w *clickhouseWriter) string(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// blockInfo writes the default block information.
+func (w *clickhouseWriter) blockInfo() {
+	w.uvarint(1)
+	w.byte(0) // is_overflows
+	w.uvarint(2)
+	w.buf = append(w.buf, 0xff, 0xff, 0xff, 0xff) // bucket_num -1
+	w.uvarint(0)
+}
+
+// clickhouseReader reads packets of the native protocol, remembering the
+// first error so that fields can be read without checking each one.
+type clickhouseReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (r *clickhouseReader) read(n int) []byte {
+	buf := make([]byte, n)
+	if r.err == nil {
+		_, r.err = io.ReadFull(r.r, buf)
+	}
+	return buf
+}
+
+func (r *clickhouseReader) byte() byte {
+	return r.read(1)[0]
+}
+
+func (r *clickhouseReader) int32() int32 {
+	return int32(binary.LittleEndian.Uint32(r.read(4)))
+}
+
+func (r *clickhouseReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var v uint64
+	v, r.err = binary.ReadUvarint(r.r)
+	return v
+}
+
+func (r *clickhouseReader) string() string {
+	n := r.uvarint()
+	if r.err == nil && n > 1024*1024 {
+		r.err = errors.New("string too long")
+	}
+	if r.err != nil {
+		return ""
+	}
+	return string(r.read(int(n)))
+}
+
+// blockInfo skips the block information.
+func (r *clickhouseReader) blockInfo() {
+	for r.err == nil {
+		switch r.uvarint() {
+		case 0:
+			return
+		case 1:
+			r.byte()
+		case 2:
+			r.int32()
+		default:
+			r.err = errors.New("unknown block information")
+		}
+	}
+}
+
+// value reads the first value of a column of the given type, with the
+// given number of rows.
+func (r *clickhouseReader) value(kind string, rows uint64) (string, error) {
+
+	//
+	// Nullable columns are preceded by a map of which rows are NULL.
+	//
+	null := false
+	if strings.HasPrefix(kind, "Nullable(") {
+		kind = strings.TrimSuffix(strings.TrimPrefix(kind, "Nullable("), ")")
+		null = r.read(int(rows))[0] == 1
+	}
+
+	var value string
+	switch kind {
+	case "UInt8", "Bool":
+		value = strconv.FormatUint(uint64(r.byte()), 10)
+	case "UInt16":
+		value = strconv.FormatUint(uint64(binary.LittleEndian.Uint16(r.read(2))), 10)
+	case "UInt32":
+		value = strconv.FormatUint(uint64(binary.LittleEndian.Uint32(r.read(4))), 10)
+	case "UInt64":
+		value = strconv.FormatUint(binary.LittleEndian.Uint64(r.read(8)), 10)
+	case "Int8":
+		value = strconv.FormatInt(int64(int8(r.byte())), 10)
+	case "Int16":
+		value = strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(r.read(2)))), 10)
+	case "Int32":
+		value = strconv.FormatInt(int64(r.int32()), 10)
+	case "Int64":
+		value = strconv.FormatInt(int64(binary.LittleEndian.Uint64(r.read(8))), 10)
+	case "Float32":
+		value = strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(r.read(4)))), 'g', -1, 32)
+	case "Float64":
+		value = strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(r.read(8))), 'g', -1, 64)
+	case "String":
+		value = r.string()
+	default:
+		if strings.HasPrefix(kind, "FixedString(") {
+			size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(kind, "FixedString("), ")"))
+			if err != nil {
+				return "", err
+			}
+			value = strings.TrimRight(string(r.read(size)), "\x00")
+			break
+		}
+		return "", fmt.Errorf("the query returned a %s, which isn't supported by the native mode; try the http mode", kind)
+	}
+
+	if r.err != nil {
+		return "", r.err
+	}
+	if null {
+		return "NULL", nil
+	}
+	return value, nil
+}
+
+func (s *CLICKHOUSETest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("clickhouse", func() ProtocolTest {
+		return &CLICKHOUSETest{}
+	})
+}
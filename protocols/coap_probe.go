@@ -0,0 +1,412 @@
+// CoAP Tester
+//
+// The CoAP tester sends a GET request to a resource of a CoAP server, and
+// ensures that a "2.05 Content" response is received.
+//
+// This test is invoked via input like so:
+//
+//    coap://sensor.example.com/temperature must run coap
+//
+// If no path is present in the target the `/.well-known/core` resource is
+// requested, which all servers should offer.
+//
+// DTLS is used when the target has the `coaps://` scheme, in which case the
+// default port is 5684 rather than 5683.  Servers using a pre-shared key
+// can be tested with:
+//
+//    coaps://sensor.example.com/temperature must run coap with psk-identity 'client' with psk 'secret'
+//
+// To disable certificate validation use `with tls insecure`.
+//
+// Optionally a regular expression can be matched against the payload:
+//
+//    coap://sensor.example.com/temperature must run coap with pattern '^[0-9.]+$'
+//
+
+package protocols
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/pion/dtls/v2"
+)
+
+// COAPTest is our object
+type COAPTest struct {
+}
+
+// CoAP message types, codes and options we care about.
+const (
+	coapTypeConfirmable    = 0
+	coapTypeAcknowledgment = 2
+	coapCodeEmpty          = 0x00
+	coapCodeGET            = 0x01
+	coapCodeContent        = 0x45
+	coapOptionURIPath      = 11
+)
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *COAPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"port":         "^[0-9]+$",
+		"pattern":      ".*",
+		"tls":          "insecure",
+		"psk":          ".*",
+		"psk-identity": ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *COAPTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *COAPTest) Example() string {
+	str := `
+CoAP Tester
+-----------
+ The CoAP tester sends a GET request to a resource of a CoAP server, and
+ ensures that a "2.05 Content" response is received.
+
+ This test is invoked via input like so:
+
+    coap://sensor.example.com/temperature must run coap
+
+ If no path is present in the target the '/.well-known/core' resource is
+ requested, which all servers should offer.
+
+ DTLS is used when the target has the 'coaps://' scheme, in which case the
+ default port is 5684 rather than 5683.  Servers using a pre-shared key
+ can be tested with:
+
+    coaps://sensor.example.com/temperature must run coap with psk-identity 'client' with psk 'secret'
+
+ To disable certificate validation use "with tls insecure".
+
+ Optionally a regular expression can be matched against the payload:
+
+    coap://sensor.example.com/temperature must run coap with pattern '^[0-9.]+$'
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we send a confirmable GET request, and wait for the
+// response to arrive, either piggybacked on the acknowledgement or
+// separately.
+func (s *COAPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	//
+	// The target may be a plain hostname, or a coap:// URI.
+	//
+	hostname := tst.Target
+	path := "/.well-known/core"
+	secure := false
+
+	if strings.Contains(tst.Target, "://") {
+		u, errParse := url.Parse(tst.Target)
+		if errParse != nil {
+			return errParse
+		}
+
+		switch u.Scheme {
+		case "coap":
+		case "coaps":
+			secure = true
+		default:
+			return fmt.Errorf("unsupported scheme '%s', expected coap:// or coaps://", u.Scheme)
+		}
+
+		hostname = u.Hostname()
+		if u.Path != "" && u.Path != "/" {
+			path = u.Path
+		}
+	}
+
+	//
+	// The default port to connect to.
+	//
+	port := 5683
+	if secure {
+		port = 5684
+	}
+
+	//
+	// If the user specified a different port update to use it.
+	//
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// Default to connecting to an IPv4-address
+	//
+	address := fmt.Sprintf("%s:%d", target, port)
+
+	//
+	// If we find a ":" we know it is an IPv6 address though
+	//
+	if strings.Contains(target, ":") {
+		address = fmt.Sprintf("[%s]:%d", target, port)
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+
+	var conn net.Conn
+	if secure {
+		conn, err = s.dialDTLS(raddr, hostname, tst, opts)
+	} else {
+		conn, err = net.DialUDP("udp", nil, raddr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	//
+	// Build and send our request.
+	//
+	token := make([]byte, 4)
+	if _, err = rand.Read(token); err != nil {
+		return err
+	}
+	messageID := binary.BigEndian.Uint16(token[:2])
+
+	if _, err = conn.Write(s.buildGET(messageID, token, path)); err != nil {
+		return err
+	}
+
+	//
+	// Wait for the response with our token.
+	//
+	buf := make([]byte, 65535)
+	for {
+		n, errRead := conn.Read(buf)
+		if errRead != nil {
+			if errNet, ok := errRead.(net.Error); ok && errNet.Timeout() {
+				return fmt.Errorf("no CoAP response received within %s", opts.Timeout)
+			}
+			return errRead
+		}
+
+		msgType, code, msgID, msgToken, payload, errParse := s.parse(buf[:n])
+		if errParse != nil {
+			return errParse
+		}
+
+		//
+		// An empty acknowledgement means the response will follow
+		// separately.
+		//
+		if code == coapCodeEmpty {
+			continue
+		}
+
+		if !bytes.Equal(msgToken, token) {
+			continue
+		}
+
+		//
+		// Acknowledge separate responses, as a polite client would.
+		//
+		if msgType == coapTypeConfirmable {
+			ack := []byte{coapTypeAcknowledgment<<4 | 1<<6, coapCodeEmpty, byte(msgID >> 8), byte(msgID)}
+			conn.Write(ack)
+		}
+
+		if code != coapCodeContent {
+			return fmt.Errorf("expected response 2.05, got %d.%02d", code>>5, code&0x1f)
+		}
+
+		if tst.Arguments["pattern"] != "" {
+			re, errCompile := regexp.Compile("(?ms)" + tst.Arguments["pattern"])
+			if errCompile != nil {
+				return errCompile
+			}
+			if !re.Match(payload) {
+				return fmt.Errorf("payload '%s' didn't match the regular expression '%s'", payload, tst.Arguments["pattern"])
+			}
+		}
+
+		return nil
+	}
+}
+
+// dialDTLS sets up a DTLS session with the server.
+func (s *COAPTest) dialDTLS(raddr *net.UDPAddr, hostname string, tst test.Test, opts test.Options) (net.Conn, error) {
+	config := &dtls.Config{
+		ServerName:         hostname,
+		InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+	}
+
+	if tst.Arguments["psk"] != "" {
+		psk := []byte(tst.Arguments["psk"])
+		config.PSK = func(hint []byte) ([]byte, error) {
+			return psk, nil
+		}
+		config.PSKIdentityHint = []byte(tst.Arguments["psk-identity"])
+		config.CipherSuites = []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8}
+	}
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	return dtls.DialWithContext(ctx, "udp", raddr, config)
+}
+
+// buildGET creates a confirmable GET request for the given path.
+func (s *COAPTest) buildGET(messageID uint16, token []byte, path string) []byte {
+	msg := []byte{
+		1<<6 | coapTypeConfirmable<<4 | byte(len(token)),
+		coapCodeGET,
+		byte(messageID >> 8),
+		byte(messageID),
+	}
+	msg = append(msg, token...)
+
+	//
+	// Each path segment is its own Uri-Path option, options are delta
+	// encoded so only the first one carries the option number.
+	//
+	delta := coapOptionURIPath
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		msg = append(msg, s.encodeOption(delta, []byte(segment))...)
+		delta = 0
+	}
+
+	return msg
+}
+
+// encodeOption encodes a single option with the given delta.
+func (s *COAPTest) encodeOption(delta int, value []byte) []byte {
+	nibble := func(v int) (byte, []byte) {
+		switch {
+		case v < 13:
+			return byte(v), nil
+		case v < 269:
+			return 13, []byte{byte(v - 13)}
+		default:
+			return 14, []byte{byte((v - 269) >> 8), byte(v - 269)}
+		}
+	}
+
+	d, dExt := nibble(delta)
+	l, lExt := nibble(len(value))
+
+	out := []byte{d<<4 | l}
+	out = append(out, dExt...)
+	out = append(out, lExt...)
+	return append(out, value...)
+}
+
+// parse decodes a CoAP message, skipping over any options.
+func (s *COAPTest) parse(msg []byte) (byte, byte, uint16, []byte, []byte, error) {
+	if len(msg) < 4 {
+		return 0, 0, 0, nil, nil, errors.New("CoAP response too short")
+	}
+	if msg[0]>>6 != 1 {
+		return 0, 0, 0, nil, nil, fmt.Errorf("unsupported CoAP version %d", msg[0]>>6)
+	}
+
+	msgType := (msg[0] >> 4) & 0x03
+	tkl := int(msg[0] & 0x0f)
+	code := msg[1]
+	messageID := binary.BigEndian.Uint16(msg[2:4])
+
+	if len(msg) < 4+tkl {
+		return 0, 0, 0, nil, nil, errors.New("CoAP response truncated")
+	}
+	token := msg[4 : 4+tkl]
+	rest := msg[4+tkl:]
+
+	// Returns the size of an extended delta/length field
+	extended := func(v byte) (int, int) {
+		switch v {
+		case 13:
+			return 1, 13
+		case 14:
+			return 2, 269
+		}
+		return 0, 0
+	}
+
+	for len(rest) > 0 {
+		if rest[0] == 0xff {
+			return msgType, code, messageID, token, rest[1:], nil
+		}
+
+		deltaSize, _ := extended(rest[0] >> 4)
+		lengthSize, lengthBase := extended(rest[0] & 0x0f)
+		length := int(rest[0] & 0x0f)
+
+		header := 1 + deltaSize + lengthSize
+		if len(rest) < header {
+			return 0, 0, 0, nil, nil, errors.New("CoAP option truncated")
+		}
+
+		switch lengthSize {
+		case 1:
+			length = lengthBase + int(rest[1+deltaSize])
+		case 2:
+			length = lengthBase + int(binary.BigEndian.Uint16(rest[1+deltaSize:]))
+		}
+
+		if len(rest) < header+length {
+			return 0, 0, 0, nil, nil, errors.New("CoAP option truncated")
+		}
+		rest = rest[header+length:]
+	}
+
+	return msgType, code, messageID, token, nil, nil
+}
+
+func (s *COAPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("coap", func() ProtocolTest {
+		return &COAPTest{}
+	})
+}
@@ -0,0 +1,242 @@
+// Consul Tester
+//
+// The Consul tester queries the health of a service registered with
+// Consul, and ensures that enough of its instances are passing their
+// health checks.
+//
+// This catches instances which are running, but which have been
+// deregistered, or whose checks fail, which a test of the service itself
+// can't detect.
+//
+// This test is invoked via input like so, against the HTTP API of a
+// Consul agent:
+//
+//    http://consul.example.com:8500/ must run consul with service 'web' [with min-passing 2]
+//
+// The service may be looked up in a specific datacenter, and an ACL
+// token may be given if the API requires one:
+//
+//    http://consul.example.com:8500/ must run consul with service 'web' with dc 'eu-west' with token 's3cr3t'
+//
+// If you need to disable failures due to expired, broken, or otherwise
+// bogus TLS certificates you can do so via the tls setting:
+//
+//    https://consul.example.com:8501/ must run consul with service 'web' with tls insecure
+//
+
+package protocols
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// CONSULTest is our object.
+type CONSULTest struct {
+}
+
+// consulEntry is a single instance of a service, as returned by the
+// health endpoint of the API.
+type consulEntry struct {
+	Node struct {
+		Node string
+	}
+	Service struct {
+		ID string
+	}
+	Checks []struct {
+		Name   string
+		Status string
+		Output string
+	}
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *CONSULTest) Arguments() map[string]string {
+	known := map[string]string{
+		"dc":          `^[A-Za-z0-9_.-]+$`,
+		"min-passing": "^[0-9]+$",
+		"service":     `^[A-Za-z0-9_.-]+$`,
+		"tls":         "insecure",
+		"token":       ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *CONSULTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *CONSULTest) Example() string {
+	str := `
+Consul Tester
+-------------
+ The Consul tester queries the health of a service registered with
+ Consul, and ensures that enough of its instances are passing their
+ health checks.
+
+ This catches instances which are running, but which have been
+ deregistered, or whose checks fail, which a test of the service itself
+ can't detect.
+
+ This test is invoked via input like so, against the HTTP API of a
+ Consul agent:
+
+    http://consul.example.com:8500/ must run consul with service 'web' [with min-passing 2]
+
+ The service may be looked up in a specific datacenter, and an ACL
+ token may be given if the API requires one:
+
+    http://consul.example.com:8500/ must run consul with service 'web' with dc 'eu-west' with token 's3cr3t'
+
+ If you need to disable failures due to expired, broken, or otherwise
+ bogus TLS certificates you can do so via the tls setting:
+
+    https://consul.example.com:8501/ must run consul with service 'web' with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we fetch the health of the service, and count the
+// instances which are passing.
+func (s *CONSULTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	u, err := url.Parse(tst.Target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("the target must be a http:// or https:// URL, got '%s'", tst.Target)
+	}
+
+	if tst.Arguments["service"] == "" {
+		return errors.New("you must specify the service when running a consul test")
+	}
+
+	minPassing := 1
+	if tst.Arguments["min-passing"] != "" {
+		minPassing, err = strconv.Atoi(tst.Arguments["min-passing"])
+		if err != nil {
+			return err
+		}
+	}
+
+	endpoint := u.ResolveReference(&url.URL{Path: "/v1/health/service/" + tst.Arguments["service"]})
+	if tst.Arguments["dc"] != "" {
+		endpoint.RawQuery = url.Values{"dc": {tst.Arguments["dc"]}}.Encode()
+	}
+
+	req, err := http.NewRequest("GET", endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	if tst.Arguments["token"] != "" {
+		req.Header.Set("X-Consul-Token", tst.Arguments["token"])
+	}
+
+	client := newPinnedHTTPClient(target, tst.Arguments["tls"] == "insecure", opts.Timeout)
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxHTTPBodySize))
+	if err != nil {
+		return err
+	}
+
+	//
+	// Errors, such as a missing ACL token, are described in plain text.
+	//
+	if response.StatusCode != http.StatusOK {
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			return fmt.Errorf("status code was %d not %d: %s", response.StatusCode, http.StatusOK, msg)
+		}
+		return fmt.Errorf("status code was %d not %d", response.StatusCode, http.StatusOK)
+	}
+
+	var entries []consulEntry
+	if err = json.Unmarshal(body, &entries); err != nil {
+		return fmt.Errorf("invalid response from the health endpoint: %w", err)
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("no instances of the service '%s' are registered", tst.Arguments["service"])
+	}
+
+	//
+	// An instance is only as healthy as its worst check, including those
+	// of the node it runs upon.
+	//
+	passing := 0
+	var unhealthy []string
+	for _, entry := range entries {
+		status := "passing"
+		var failing []string
+		for _, check := range entry.Checks {
+			if check.Status == "passing" {
+				continue
+			}
+			if status != "critical" {
+				status = check.Status
+			}
+			failing = append(failing, fmt.Sprintf("%s is %s", check.Name, check.Status))
+		}
+
+		if status == "passing" {
+			passing++
+			continue
+		}
+		unhealthy = append(unhealthy, fmt.Sprintf("%s on %s (%s)", entry.Service.ID, entry.Node.Node, strings.Join(failing, ", ")))
+	}
+	sort.Strings(unhealthy)
+
+	opts.Tracef("%d of %d instances are passing", passing, len(entries))
+	for _, instance := range unhealthy {
+		opts.Tracef("Unhealthy: %s", instance)
+	}
+
+	if passing < minPassing {
+		msg := fmt.Sprintf("%d of %d instances of '%s' are passing, fewer than %d", passing, len(entries), tst.Arguments["service"], minPassing)
+		if len(unhealthy) > 0 {
+			msg += ", unhealthy: " + strings.Join(unhealthy, "; ")
+		}
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+func (s *CONSULTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("consul", func() ProtocolTest {
+		return &CONSULTest{}
+	})
+}
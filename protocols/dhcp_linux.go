@@ -0,0 +1,32 @@
+package protocols
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// dhcpListen opens a UDP socket on the DHCP client port, bound to the
+// given interface, which is able to send broadcasts.
+func dhcpListen(iface string) (net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			errControl := c.Control(func(fd uintptr) {
+				if err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+					return
+				}
+				if err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1); err != nil {
+					return
+				}
+				err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+			})
+			if errControl != nil {
+				return errControl
+			}
+			return err
+		},
+	}
+
+	return lc.ListenPacket(context.Background(), "udp4", "0.0.0.0:68")
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package protocols
+
+import (
+	"errors"
+	"net"
+)
+
+// dhcpListen is only implemented on Linux, where we can bind a socket to
+// a specific interface.
+func dhcpListen(iface string) (net.PacketConn, error) {
+	return nil, errors.New("the DHCP test is only supported on Linux")
+}
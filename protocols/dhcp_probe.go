@@ -0,0 +1,290 @@
+// DHCP Tester
+//
+// The DHCP tester broadcasts a DHCPDISCOVER message on a local network
+// interface, and ensures that a DHCPOFFER is received in reply.
+//
+// This test is invoked via input like so:
+//
+//    eth0 must run dhcp
+//
+// The target is the name of the interface to broadcast on, rather than a
+// host.  To only accept offers from a specific server, and to ensure the
+// address offered is within the expected subnet, use:
+//
+//    eth0 must run dhcp with server 192.168.1.1 with subnet 192.168.1.0/24
+//
+// NOTE: This test is only supported on Linux, and requires elevated
+// privileges: it binds to the DHCP client port (68) and to a specific
+// interface, which needs root or the CAP_NET_BIND_SERVICE and CAP_NET_RAW
+// capabilities.  A DHCP client running on the same host may also compete
+// for the replies.
+//
+// No address is actually requested, so the offer is never accepted.
+//
+
+package protocols
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// DHCPTest is our object.
+type DHCPTest struct {
+}
+
+// DHCP message types and options we care about.
+const (
+	dhcpDiscover       = 1
+	dhcpOffer          = 2
+	dhcpOptionPad      = 0
+	dhcpOptionSubnet   = 1
+	dhcpOptionRouter   = 3
+	dhcpOptionDNS      = 6
+	dhcpOptionLease    = 51
+	dhcpOptionMsgType  = 53
+	dhcpOptionServerID = 54
+	dhcpOptionParams   = 55
+	dhcpOptionEnd      = 255
+)
+
+// dhcpMagicCookie marks the start of the options in a DHCP message.
+var dhcpMagicCookie = []byte{99, 130, 83, 99}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *DHCPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"server": `^[0-9.]+$`,
+		"subnet": `^[0-9.]+/[0-9]+$`,
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *DHCPTest) ShouldResolveHostname() bool {
+	return false
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *DHCPTest) Example() string {
+	str := `
+DHCP Tester
+-----------
+ The DHCP tester broadcasts a DHCPDISCOVER message on a local network
+ interface, and ensures that a DHCPOFFER is received in reply.
+
+ This test is invoked via input like so:
+
+    eth0 must run dhcp
+
+ The target is the name of the interface to broadcast on, rather than a
+ host.  To only accept offers from a specific server, and to ensure the
+ address offered is within the expected subnet, use:
+
+    eth0 must run dhcp with server 192.168.1.1 with subnet 192.168.1.0/24
+
+ NOTE: This test is only supported on Linux, and requires elevated
+ privileges: it binds to the DHCP client port (68) and to a specific
+ interface, which needs root or the CAP_NET_BIND_SERVICE and CAP_NET_RAW
+ capabilities.  A DHCP client running on the same host may also compete
+ for the replies.
+
+ No address is actually requested, so the offer is never accepted.
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we broadcast a DHCPDISCOVER on the interface, and wait
+// for a matching DHCPOFFER.
+func (s *DHCPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	iface, err := net.InterfaceByName(target)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s: %s", target, err)
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return fmt.Errorf("interface %s has no ethernet address", target)
+	}
+
+	var server net.IP
+	if tst.Arguments["server"] != "" {
+		server = net.ParseIP(tst.Arguments["server"])
+		if server == nil {
+			return fmt.Errorf("invalid server address '%s'", tst.Arguments["server"])
+		}
+	}
+
+	var subnet *net.IPNet
+	if tst.Arguments["subnet"] != "" {
+		_, subnet, err = net.ParseCIDR(tst.Arguments["subnet"])
+		if err != nil {
+			return err
+		}
+	}
+
+	conn, err := dhcpListen(iface.Name)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %s", iface.Name, err)
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	//
+	// Send our discover message, using a random transaction ID to match
+	// the replies against.
+	//
+	xid := make([]byte, 4)
+	if _, err = rand.Read(xid); err != nil {
+		return err
+	}
+
+	broadcast := &net.UDPAddr{IP: net.IPv4bcast, Port: 67}
+	if _, err = conn.WriteTo(s.buildDiscover(xid, iface.HardwareAddr), broadcast); err != nil {
+		return err
+	}
+
+	//
+	// Wait for an offer, ignoring replies to other clients.
+	//
+	buf := make([]byte, 1500)
+	for {
+		n, _, errRead := conn.ReadFrom(buf)
+		if errRead != nil {
+			if errNet, ok := errRead.(net.Error); ok && errNet.Timeout() {
+				return fmt.Errorf("no DHCP offer received on %s within %s", iface.Name, opts.Timeout)
+			}
+			return errRead
+		}
+
+		offered, serverID, errParse := s.parseOffer(buf[:n], xid)
+		if errParse != nil {
+			continue
+		}
+
+		if server != nil && !server.Equal(serverID) {
+			if opts.Verbose {
+				fmt.Printf("\tIgnoring offer of %s from server %s\n", offered, serverID)
+			}
+			continue
+		}
+
+		if opts.Verbose {
+			fmt.Printf("\tServer %s offered %s\n", serverID, offered)
+		}
+
+		if subnet != nil && !subnet.Contains(offered) {
+			return fmt.Errorf("server %s offered %s, which is outside of %s", serverID, offered, subnet)
+		}
+
+		return nil
+	}
+}
+
+// buildDiscover creates a DHCPDISCOVER message.
+func (s *DHCPTest) buildDiscover(xid []byte, mac net.HardwareAddr) []byte {
+	msg := make([]byte, 240)
+
+	msg[0] = 1 // op: request
+	msg[1] = 1 // htype: ethernet
+	msg[2] = 6 // hlen
+	copy(msg[4:8], xid)
+
+	// Ask for the reply to be broadcast, as we have no address yet
+	binary.BigEndian.PutUint16(msg[10:12], 0x8000)
+
+	copy(msg[28:34], mac)
+	copy(msg[236:240], dhcpMagicCookie)
+
+	msg = append(msg, dhcpOptionMsgType, 1, dhcpDiscover)
+	msg = append(msg, dhcpOptionParams, 4, dhcpOptionSubnet, dhcpOptionRouter, dhcpOptionDNS, dhcpOptionLease)
+	msg = append(msg, dhcpOptionEnd)
+
+	return msg
+}
+
+// parseOffer returns the offered address, and the server identifier, of
+// a DHCPOFFER matching our transaction ID.
+func (s *DHCPTest) parseOffer(msg []byte, xid []byte) (net.IP, net.IP, error) {
+	if len(msg) < 240 {
+		return nil, nil, errors.New("message too short")
+	}
+	if msg[0] != 2 {
+		return nil, nil, errors.New("not a reply")
+	}
+	if string(msg[4:8]) != string(xid) {
+		return nil, nil, errors.New("reply to another transaction")
+	}
+	if string(msg[236:240]) != string(dhcpMagicCookie) {
+		return nil, nil, errors.New("missing magic cookie")
+	}
+
+	offered := net.IP(msg[16:20])
+
+	// Fallback to the address of the next server, if there is no identifier
+	serverID := net.IP(msg[20:24])
+	msgType := 0
+
+	options := msg[240:]
+	for len(options) > 0 {
+		code := options[0]
+		if code == dhcpOptionEnd {
+			break
+		}
+		if code == dhcpOptionPad {
+			options = options[1:]
+			continue
+		}
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			return nil, nil, errors.New("option truncated")
+		}
+
+		value := options[2 : 2+int(options[1])]
+		switch code {
+		case dhcpOptionMsgType:
+			if len(value) == 1 {
+				msgType = int(value[0])
+			}
+		case dhcpOptionServerID:
+			if len(value) == 4 {
+				serverID = net.IP(value)
+			}
+		}
+
+		options = options[2+int(options[1]):]
+	}
+
+	if msgType != dhcpOffer {
+		return nil, nil, fmt.Errorf("unexpected message type %d", msgType)
+	}
+
+	return offered, serverID, nil
+}
+
+func (s *DHCPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("dhcp", func() ProtocolTest {
+		return &DHCPTest{}
+	})
+}
@@ -8,17 +8,50 @@
 // This test ensures that the DNS lookup of an A record for `test.example.com`
 // returns the single value 1.2.3.4
 //
-// Lookups are supported for A, AAAA, MX, NS, and TXT records.
+// Multiple values are separated by commas, and may be given in any order:
+//
+//    ns.example.com must run dns with lookup test.example.com with type A with result '1.2.3.4,1.2.3.5'
+//
+// The server is queried on port 53, unless another port is given.
+//
+// Lookups are supported for A, AAAA, MX, NS, PTR, SOA, and TXT records.
+//
+// Reverse lookups, via PTR records, may be given the address to lookup,
+// which is converted to its in-addr.arpa, or ip6.arpa, form:
+//
+//    8.8.8.8 must run dns with lookup 8.8.8.8 with type PTR with result 'dns.google.'
+//
+// SOA records are shown in the form of a zone-file, e.g.
+// "ns1.example.com. hostmaster.example.com. 2020010101 7200 3600 1209600 3600".
+// As the serial changes with the zone, you can instead require that it is
+// at least a given value, to catch secondary servers which are stale:
+//
+//    ns2.example.com must run dns with lookup example.com with type SOA with min-serial 2020010101
+//
+// The result isn't compared when min-serial is given, unless it is given
+// too.
+//
+// If only the number of records matters, for example for a round-robin
+// pool whose members rotate, that can be tested instead of the result:
+//
+//    ns.example.com must run dns with lookup pool.example.com with type A with count 4
+//
+// Other record types may be looked up by their number, in which case the
+// results are in the generic form of RFC 3597, e.g. for an HTTPS record:
+//
+//    ns.example.com must run dns with lookup example.com with type 65 with result '\# 3 000100'
 //
 
 package protocols
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
 	"sort"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/cmaster11/overseer/test"
 	"github.com/miekg/dns"
@@ -28,65 +61,71 @@ import (
 type DNSTest struct {
 }
 
-var (
-	localm *dns.Msg
-	localc *dns.Client
-)
-
 // lookup will perform a DNS query, using the servername-specified.
 // It returns an array of maps of the response.
-func (s *DNSTest) lookup(server string, name string, ltype string, timeout time.Duration) ([]string, error) {
+func (s *DNSTest) lookup(server string, port int, name string, ltype string, opts test.Options) ([]string, error) {
 
 	var results []string
 
-	var err error
-	localm = &dns.Msg{
-		MsgHdr: dns.MsgHdr{
-			RecursionDesired: true,
-		},
-		Question: make([]dns.Question, 1),
+	qtype, raw, err := dnsQueryType(ltype)
+	if err != nil {
+		return nil, err
 	}
-	localc = &dns.Client{
-		ReadTimeout: timeout,
+
+	//
+	// Addresses are looked up in reverse via their arpa names.
+	//
+	if qtype == dns.TypePTR && net.ParseIP(name) != nil {
+		name, err = dns.ReverseAddr(name)
+		if err != nil {
+			return nil, err
+		}
 	}
-	r, err := s.localQuery(server, dns.Fqdn(name), ltype)
+
+	r, err := s.localQuery(server, port, dns.Fqdn(name), qtype, opts)
 	if err != nil || r == nil {
 		return nil, err
 	}
+
+	opts.Tracef("The server replied %s, with %d answers", dns.RcodeToString[r.Rcode], len(r.Answer))
+	for _, entry := range r.Answer {
+		opts.Tracef("Answer: %s", entry.String())
+	}
 	if r.Rcode == dns.RcodeNameError {
 		return nil, fmt.Errorf("no such domain %s", dns.Fqdn(name))
 	}
 
 	for _, entry := range r.Answer {
 
+		//
+		// Types given by number are shown in their generic form,
+		// ignoring any other records, such as CNAMEs leading to them.
+		//
+		if raw {
+			if entry.Header().Rrtype != qtype {
+				continue
+			}
+			generic := new(dns.RFC3597)
+			if err = generic.ToRFC3597(entry); err != nil {
+				return nil, err
+			}
+			results = append(results, fmt.Sprintf("\\# %d %s", len(generic.Rdata)/2, generic.Rdata))
+			continue
+		}
+
 		//
 		// Lookup the value
 		//
-		switch ent := entry.(type) {
-		case *dns.A:
-			a := ent.A
-			results = append(results, a.String())
-		case *dns.AAAA:
-			aaaa := ent.AAAA
-			results = append(results, aaaa.String())
-		case *dns.MX:
-			mxName := ent.Mx
-			mxPrio := ent.Preference
-			results = append(results, fmt.Sprintf("%d %s", mxPrio, mxName))
-		case *dns.NS:
-			nameserver := ent.Ns
-			results = append(results, nameserver)
-		case *dns.TXT:
-			txt := ent.Txt
-			results = append(results, txt[0])
+		if value, ok := dnsRecordValue(entry); ok {
+			results = append(results, value)
 		}
 	}
 	return results, nil
 }
 
-// Given a name & type to lookup perform the request against the named
-// DNS-server.
-func (s *DNSTest) localQuery(server string, qname string, lookupType string) (*dns.Msg, error) {
+// dnsQueryType returns the type of record to lookup, given either its
+// name, or its number.  Types given by number are flagged as raw.
+func dnsQueryType(lookupType string) (uint16, bool, error) {
 
 	// Here we have a map of DNS type-names.
 	var StringToType = map[string]uint16{
@@ -94,38 +133,110 @@ func (s *DNSTest) localQuery(server string, qname string, lookupType string) (*d
 		"AAAA": dns.TypeAAAA,
 		"MX":   dns.TypeMX,
 		"NS":   dns.TypeNS,
+		"PTR":  dns.TypePTR,
+		"SOA":  dns.TypeSOA,
 		"TXT":  dns.TypeTXT,
 	}
 
-	qtype := StringToType[lookupType]
-	if qtype == 0 {
-		return nil, fmt.Errorf("unsupported record to lookup '%s'", lookupType)
+	if qtype := StringToType[lookupType]; qtype != 0 {
+		return qtype, false, nil
+	}
+
+	qtype, err := strconv.ParseUint(lookupType, 10, 16)
+	if err != nil || qtype == 0 {
+		return 0, false, fmt.Errorf("unsupported record to lookup '%s'", lookupType)
+	}
+	return uint16(qtype), true, nil
+}
+
+// dnsRecordValue returns the value of the given record, in the form the
+// user specifies it in the `result` argument.  Records of types we don't
+// support are ignored.
+func dnsRecordValue(entry dns.RR) (string, bool) {
+	switch ent := entry.(type) {
+	case *dns.A:
+		a := ent.A
+		return a.String(), true
+	case *dns.AAAA:
+		aaaa := ent.AAAA
+		return aaaa.String(), true
+	case *dns.MX:
+		mxName := ent.Mx
+		mxPrio := ent.Preference
+		return fmt.Sprintf("%d %s", mxPrio, mxName), true
+	case *dns.NS:
+		nameserver := ent.Ns
+		return nameserver, true
+	case *dns.PTR:
+		return ent.Ptr, true
+	case *dns.SOA:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", ent.Ns, ent.Mbox, ent.Serial, ent.Refresh, ent.Retry, ent.Expire, ent.Minttl), true
+	case *dns.TXT:
+		// Long values are split into several strings, which are
+		// concatenated, and a record may have no strings at all
+		return strings.Join(ent.Txt, ""), true
+	}
+	return "", false
+}
+
+// Given a name & type to lookup perform the request against the named
+// DNS-server.
+//
+// The message and client are created for each query, as tests may run
+// concurrently.
+func (s *DNSTest) localQuery(server string, port int, qname string, qtype uint16, opts test.Options) (*dns.Msg, error) {
+
+	m := new(dns.Msg)
+	m.RecursionDesired = true
+	m.SetQuestion(qname, qtype)
+
+	c := &dns.Client{
+		ReadTimeout: opts.Timeout,
+	}
+
+	//
+	// The server is usually resolved already, but if it's a name which
+	// doesn't resolve the error from the exchange wouldn't say so.
+	//
+	if net.ParseIP(server) == nil {
+		ctx := context.Background()
+		if opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+		}
+
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, server)
+		if err != nil || len(addrs) == 0 {
+			return nil, fmt.Errorf("DNS server '%s' could not be resolved", server)
+		}
+		server = addrs[0].IP.String()
 	}
-	localm.SetQuestion(qname, qtype)
 
 	//
 	// Default to connecting to an IPv4-address
 	//
-	address := fmt.Sprintf("%s:%d", server, 53)
+	address := fmt.Sprintf("%s:%d", server, port)
 
 	//
 	// If we find a ":" we know it is an IPv6 address though
 	//
 	if strings.Contains(server, ":") {
-		address = fmt.Sprintf("[%s]:%d", server, 53)
+		address = fmt.Sprintf("[%s]:%d", server, port)
 	}
 
 	//
 	// Run the lookup
 	//
-	r, _, err := localc.Exchange(localm, address)
+	opts.Tracef("Querying %s for the %s record of %s", address, dns.Type(qtype).String(), qname)
+	r, _, err := c.Exchange(m, address)
 	if err != nil {
 		return nil, err
 	}
-	if r == nil || r.Rcode == dns.RcodeNameError || r.Rcode == dns.RcodeSuccess {
-		return r, err
+	if r.Rcode != dns.RcodeNameError && r.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("the server replied %s", dns.RcodeToString[r.Rcode])
 	}
-	return nil, nil
+	return r, nil
 }
 
 // Arguments returns the names of arguments which this protocol-test
@@ -134,9 +245,12 @@ func (s *DNSTest) localQuery(server string, qname string, lookupType string) (*d
 func (s *DNSTest) Arguments() map[string]string {
 
 	known := map[string]string{
-		"type":   "A|AAAA|MX|NS|TXT",
-		"lookup": ".*",
-		"result": ".*",
+		"type":       "^(A|AAAA|MX|NS|PTR|SOA|TXT|[0-9]+)$",
+		"count":      "^[0-9]+$",
+		"lookup":     ".*",
+		"min-serial": "^[0-9]+$",
+		"port":       "^[0-9]+$",
+		"result":     ".*",
 	}
 	return known
 }
@@ -159,11 +273,42 @@ DNS Tester
  This test ensures that the DNS lookup of an A record for 'test.example.com'
  returns the single value 1.2.3.4
 
- Lookups are supported for A, AAAA, MX, NS, and TXT records.  If you expect
+ Multiple values are separated by commas, and may be given in any order:
+
+    ns.example.com must run dns with lookup test.example.com with type A with result '1.2.3.4,1.2.3.5'
+
+ The server is queried on port 53, unless another port is given.
+
+ Lookups are supported for A, AAAA, MX, NS, PTR, SOA, and TXT records.  If you expect
  there to be zero returning records, perhaps because you're ensuring that a
  service is IPv4-only you can specify that you require an empty result:
 
     rache.ns.cloudflare.com must run dns with lookup alert.steve.fi with type AAAA with result ''
+
+ Reverse lookups, via PTR records, may be given the address to lookup,
+ which is converted to its in-addr.arpa, or ip6.arpa, form:
+
+    8.8.8.8 must run dns with lookup 8.8.8.8 with type PTR with result 'dns.google.'
+
+ SOA records are shown in the form of a zone-file, e.g.
+ "ns1.example.com. hostmaster.example.com. 2020010101 7200 3600 1209600 3600".
+ As the serial changes with the zone, you can instead require that it is
+ at least a given value, to catch secondary servers which are stale:
+
+    ns2.example.com must run dns with lookup example.com with type SOA with min-serial 2020010101
+
+ The result isn't compared when min-serial is given, unless it is given
+ too.
+
+ If only the number of records matters, for example for a round-robin
+ pool whose members rotate, that can be tested instead of the result:
+
+    ns.example.com must run dns with lookup pool.example.com with type A with count 4
+
+ Other record types may be looked up by their number, in which case the
+ results are in the generic form of RFC 3597, e.g. for an HTTPS record:
+
+    ns.example.com must run dns with lookup example.com with type 65 with result '\# 3 000100'
 `
 	return str
 }
@@ -186,13 +331,40 @@ func (s *DNSTest) RunTest(tst test.Test, target string, opts test.Options) error
 	//
 	// NOTE:
 	// "result" must also be specified, but it is valid to set that
-	// to be empty.
+	// to be empty.  Unless the serial of a SOA record, or the number of
+	// records, is tested instead.
 	//
+	if tst.Arguments["min-serial"] != "" && tst.Arguments["type"] != "SOA" {
+		return errors.New("min-serial can only be tested for SOA records")
+	}
+
+	_, compare := tst.Arguments["result"]
+	count := -1
+	if tst.Arguments["count"] != "" {
+		if compare {
+			return errors.New("only one of count and result may be given")
+		}
+
+		var err error
+		count, err = strconv.Atoi(tst.Arguments["count"])
+		if err != nil {
+			return err
+		}
+	}
+
+	port := 53
+	if tst.Arguments["port"] != "" {
+		var err error
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
 
 	//
 	// Run the lookup
 	//
-	res, err := s.lookup(target, tst.Arguments["lookup"], tst.Arguments["type"], opts.Timeout)
+	res, err := s.lookup(target, port, tst.Arguments["lookup"], tst.Arguments["type"], opts)
 	if err != nil {
 		return err
 	}
@@ -205,7 +377,19 @@ func (s *DNSTest) RunTest(tst test.Test, target string, opts test.Options) error
 	sort.Strings(res)
 	found := strings.Join(res, ",")
 
-	if found != tst.Arguments["result"] {
+	if tst.Arguments["min-serial"] != "" {
+		if err = s.checkSerial(res, tst.Arguments["min-serial"]); err != nil {
+			return err
+		}
+	} else if count < 0 {
+		compare = true
+	}
+
+	if count >= 0 && len(res) != count {
+		return fmt.Errorf("expected %d DNS records, but found %d: '%s'", count, len(res), found)
+	}
+
+	if compare && dnsSortedValues(found) != dnsSortedValues(tst.Arguments["result"]) {
 		return fmt.Errorf("expected DNS result to be '%s', but found '%s'", tst.Arguments["result"], found)
 	}
 
@@ -213,6 +397,47 @@ func (s *DNSTest) RunTest(tst test.Test, target string, opts test.Options) error
 
 }
 
+// checkSerial ensures the serial of the SOA record found is at least the
+// given one.
+func (s *DNSTest) checkSerial(res []string, minSerial string) error {
+	min, err := strconv.ParseUint(minSerial, 10, 32)
+	if err != nil {
+		return err
+	}
+
+	if len(res) == 0 {
+		return errors.New("no SOA record was found")
+	}
+
+	// The serial is the third field of the record
+	fields := strings.Fields(res[0])
+	serial, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return err
+	}
+
+	if serial < min {
+		return fmt.Errorf("the SOA serial is %d, lower than %d", serial, min)
+	}
+	return nil
+}
+
+// dnsSortedValues splits the given comma-separated values, and joins
+// them again in sorted order, so that results may be compared regardless
+// of their order.
+func dnsSortedValues(values string) string {
+	if values == "" {
+		return ""
+	}
+
+	split := strings.Split(values, ",")
+	for i := range split {
+		split[i] = strings.TrimSpace(split[i])
+	}
+	sort.Strings(split)
+	return strings.Join(split, ",")
+}
+
 func (s *DNSTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
 	return nil
 }
@@ -0,0 +1,336 @@
+package protocols
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/miekg/dns"
+)
+
+// startDNSServer runs a DNS server on a random local port, which answers
+// queries with the records the given zone holds for the name, and type,
+// queried.  Names beneath "servfail." fail to be resolved, as though the
+// server was broken.  It returns the port, and a function to stop the
+// server.
+func startDNSServer(t *testing.T, zone []string) (string, func()) {
+	records := make(map[dns.Question][]dns.RR)
+	names := make(map[string]bool)
+	for _, line := range zone {
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			t.Fatalf("Invalid record '%s': %s", line, err)
+		}
+		q := dns.Question{Name: rr.Header().Name, Qtype: rr.Header().Rrtype, Qclass: dns.ClassINET}
+		records[q] = append(records[q], rr)
+		names[q.Name] = true
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	server := &dns.Server{
+		PacketConn: conn,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = records[r.Question[0]]
+			switch {
+			case dns.IsSubDomain("servfail.", r.Question[0].Name):
+				m.Rcode = dns.RcodeServerFailure
+			case !names[r.Question[0].Name]:
+				m.Rcode = dns.RcodeNameError
+			}
+			w.WriteMsg(m)
+		}),
+	}
+
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	return port, func() { server.Shutdown() }
+}
+
+// runDNSTest runs a dns test against our local server.
+func runDNSTest(port string, args map[string]string) error {
+	args["port"] = port
+
+	tst := test.Test{
+		Target:    "127.0.0.1",
+		Type:      "dns",
+		Arguments: args,
+	}
+
+	return (&DNSTest{}).RunTest(tst, "127.0.0.1", test.Options{Timeout: 5 * time.Second})
+}
+
+// Test that results are compared regardless of their order.
+func TestDNSResultOrder(t *testing.T) {
+	port, stop := startDNSServer(t, []string{
+		"multi.example.com. 60 IN A 10.0.0.3",
+		"multi.example.com. 60 IN A 10.0.0.1",
+		"multi.example.com. 60 IN A 10.0.0.2",
+	})
+	defer stop()
+
+	tests := []struct {
+		Result string
+		Valid  bool
+	}{
+		{"10.0.0.1,10.0.0.2,10.0.0.3", true},
+		{"10.0.0.3,10.0.0.1,10.0.0.2", true},
+		{"10.0.0.2, 10.0.0.3, 10.0.0.1", true},
+		{"10.0.0.1,10.0.0.2", false},
+		{"10.0.0.1,10.0.0.2,10.0.0.3,10.0.0.4", false},
+		{"", false},
+	}
+
+	for _, tst := range tests {
+		err := runDNSTest(port, map[string]string{
+			"lookup": "multi.example.com",
+			"type":   "A",
+			"result": tst.Result,
+		})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected result '%s' to match, got %s", tst.Result, err)
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected result '%s' not to match", tst.Result)
+		}
+	}
+}
+
+// Test that an empty result may be expected.
+func TestDNSEmptyResult(t *testing.T) {
+	port, stop := startDNSServer(t, []string{
+		"v4.example.com. 60 IN A 10.0.0.1",
+	})
+	defer stop()
+
+	err := runDNSTest(port, map[string]string{
+		"lookup": "v4.example.com",
+		"type":   "AAAA",
+		"result": "",
+	})
+	if err != nil {
+		t.Errorf("Expected no AAAA records, got %s", err)
+	}
+
+	err = runDNSTest(port, map[string]string{
+		"lookup": "v4.example.com",
+		"type":   "A",
+		"result": "",
+	})
+	if err == nil {
+		t.Errorf("Expected the A record to fail an empty result")
+	}
+}
+
+// Test that addresses are looked up in reverse.
+func TestDNSReverse(t *testing.T) {
+	port, stop := startDNSServer(t, []string{
+		"8.8.8.8.in-addr.arpa. 60 IN PTR dns.google.",
+		"8.8.8.8.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.6.8.4.0.6.8.4.1.0.0.2.ip6.arpa. 60 IN PTR dns.google.",
+	})
+	defer stop()
+
+	for _, lookup := range []string{"8.8.8.8", "8.8.8.8.in-addr.arpa", "2001:4860:4860::8888"} {
+		err := runDNSTest(port, map[string]string{
+			"lookup": lookup,
+			"type":   "PTR",
+			"result": "dns.google.",
+		})
+		if err != nil {
+			t.Errorf("Expected the reverse lookup of %s to succeed, got %s", lookup, err)
+		}
+	}
+}
+
+// Test that the serial of a SOA record can be tested.
+func TestDNSSerial(t *testing.T) {
+	port, stop := startDNSServer(t, []string{
+		"example.com. 60 IN SOA ns1.example.com. hostmaster.example.com. 2020010102 7200 3600 1209600 3600",
+	})
+	defer stop()
+
+	tests := []struct {
+		Args  map[string]string
+		Valid bool
+	}{
+		{map[string]string{"result": "ns1.example.com. hostmaster.example.com. 2020010102 7200 3600 1209600 3600"}, true},
+		{map[string]string{"min-serial": "2020010101"}, true},
+		{map[string]string{"min-serial": "2020010102"}, true},
+		{map[string]string{"min-serial": "2020010103"}, false},
+		{map[string]string{"min-serial": "2020010101", "result": "ns2.example.com. hostmaster.example.com. 2020010102 7200 3600 1209600 3600"}, false},
+	}
+
+	for _, tst := range tests {
+		tst.Args["lookup"] = "example.com"
+		tst.Args["type"] = "SOA"
+
+		err := runDNSTest(port, tst.Args)
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %v to pass, got %s", tst.Args, err)
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %v to fail", tst.Args)
+		}
+	}
+}
+
+func TestDNSCount(t *testing.T) {
+	port, stop := startDNSServer(t, []string{
+		"pool.example.com. 60 IN A 10.0.0.1",
+		"pool.example.com. 60 IN A 10.0.0.2",
+		"pool.example.com. 60 IN A 10.0.0.3",
+		"pool.example.com. 60 IN A 10.0.0.4",
+	})
+	defer stop()
+
+	tests := []struct {
+		Args  map[string]string
+		Valid bool
+	}{
+		{map[string]string{"count": "4"}, true},
+		{map[string]string{"count": "3"}, false},
+		{map[string]string{"count": "5"}, false},
+		{map[string]string{"count": "0"}, false},
+		{map[string]string{"count": "4", "result": "10.0.0.1,10.0.0.2,10.0.0.3,10.0.0.4"}, false},
+	}
+
+	for _, tst := range tests {
+		tst.Args["lookup"] = "pool.example.com"
+		tst.Args["type"] = "A"
+
+		err := runDNSTest(port, tst.Args)
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %v to pass, got %s", tst.Args, err)
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %v to fail", tst.Args)
+		}
+	}
+
+	err := runDNSTest(port, map[string]string{
+		"lookup": "pool.example.com",
+		"type":   "AAAA",
+		"count":  "0",
+	})
+	if err != nil {
+		t.Errorf("Expected no AAAA records, got %s", err)
+	}
+}
+
+func TestDNSEmptyTXT(t *testing.T) {
+	// A TXT record without any strings used to crash the probe
+	port, stop := startDNSServer(t, []string{
+		"empty.example.com. 60 IN TXT",
+	})
+	defer stop()
+
+	err := runDNSTest(port, map[string]string{
+		"lookup": "empty.example.com",
+		"type":   "TXT",
+		"count":  "1",
+	})
+	if err != nil {
+		t.Errorf("Expected the empty TXT record to be found, got %s", err)
+	}
+
+	err = runDNSTest(port, map[string]string{
+		"lookup": "empty.example.com",
+		"type":   "TXT",
+		"result": "v=spf1 -all",
+	})
+	if err == nil {
+		t.Errorf("Expected the empty TXT record not to match")
+	}
+}
+
+func TestDNSSplitTXT(t *testing.T) {
+	// Long values are split into several strings, and must be joined
+	port, stop := startDNSServer(t, []string{
+		`split.example.com. 60 IN TXT "v=DKIM1; k=rsa; " "p=MIGfMA0GCSqGSIb3" "DQEBAQUAA4GNADCBiQKBgQ"`,
+	})
+	defer stop()
+
+	err := runDNSTest(port, map[string]string{
+		"lookup": "split.example.com",
+		"type":   "TXT",
+		"result": "v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQ",
+	})
+	if err != nil {
+		t.Errorf("Expected the TXT record to be joined, got %s", err)
+	}
+
+	err = runDNSTest(port, map[string]string{
+		"lookup": "split.example.com",
+		"type":   "TXT",
+		"result": "v=DKIM1; k=rsa; ",
+	})
+	if err == nil {
+		t.Errorf("Expected the first string alone not to match")
+	}
+}
+
+func TestDNSServerFailure(t *testing.T) {
+	// A broken server used to pass tests expecting no records
+	port, stop := startDNSServer(t, []string{})
+	defer stop()
+
+	err := runDNSTest(port, map[string]string{
+		"lookup": "example.servfail",
+		"type":   "A",
+		"result": "",
+	})
+	if err == nil {
+		t.Fatalf("Expected SERVFAIL to fail the test")
+	}
+	if err.Error() != "the server replied SERVFAIL" {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestDNSConcurrent(t *testing.T) {
+	// Lookups running at the same time used to share their query
+	var zone []string
+	for i := 0; i < 50; i++ {
+		zone = append(zone, fmt.Sprintf("host%d.example.com. 60 IN A 10.0.0.%d", i, i))
+	}
+	port, stop := startDNSServer(t, zone)
+	defer stop()
+
+	for round := 0; round < 5; round++ {
+		var wg sync.WaitGroup
+		errs := make(chan error, 50)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				err := runDNSTest(port, map[string]string{
+					"lookup": fmt.Sprintf("host%d.example.com", i),
+					"type":   "A",
+					"result": fmt.Sprintf("10.0.0.%d", i),
+				})
+				if err != nil {
+					errs <- fmt.Errorf("host%d: %s", i, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Errorf("Expected each lookup to get its own result, got %s", err)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+// DNS Trace Tester
+//
+// The DNS trace tester resolves a name iteratively, starting at the root
+// servers and following each delegation down to the authoritative
+// servers, rather than asking a recursive resolver.
+//
+// This catches delegation and glue problems which a caching resolver
+// would mask, because it already has the answer cached.
+//
+// This test is invoked via input like so:
+//
+//    test.example.com must run dns-trace with type A
+//
+// The test fails if any step of the chain is broken, reporting the zone
+// and server where it broke.  Optionally the final answer can be compared
+// with the expected one, in the same way as the DNS tester:
+//
+//    test.example.com must run dns-trace with type A with result '1.2.3.4'
+//
+// To start the resolution from a specific root server specify its address:
+//
+//    test.example.com must run dns-trace with type A with root 193.0.14.129
+//
+// Lookups are supported for A, AAAA, CNAME, MX, NS, and TXT records.
+//
+
+package protocols
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/miekg/dns"
+)
+
+// DNSTraceTest is our object.
+type DNSTraceTest struct {
+}
+
+// dnsRootServers are the IPv4 addresses of the root servers, which
+// resolution starts from by default.
+var dnsRootServers = []string{
+	"198.41.0.4",     // a.root-servers.net
+	"192.33.4.12",    // c.root-servers.net
+	"199.7.91.13",    // d.root-servers.net
+	"192.203.230.10", // e.root-servers.net
+	"192.5.5.241",    // f.root-servers.net
+	"192.112.36.4",   // g.root-servers.net
+	"198.97.190.53",  // h.root-servers.net
+	"192.36.148.17",  // i.root-servers.net
+	"192.58.128.30",  // j.root-servers.net
+	"193.0.14.129",   // k.root-servers.net
+	"199.7.83.42",    // l.root-servers.net
+	"202.12.27.33",   // m.root-servers.net
+}
+
+// dnsTraceMaxDepth is the maximum number of CNAMEs, or glueless
+// nameserver lookups, we'll follow while resolving a single name.
+const dnsTraceMaxDepth = 8
+
+// dnsTraceMaxSteps is the maximum number of delegations we'll follow.
+const dnsTraceMaxSteps = 32
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *DNSTraceTest) Arguments() map[string]string {
+	known := map[string]string{
+		"type":   "^(A|AAAA|CNAME|MX|NS|TXT)$",
+		"result": ".*",
+		"root":   "^[0-9a-fA-F.:]+$",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *DNSTraceTest) ShouldResolveHostname() bool {
+	return false
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *DNSTraceTest) Example() string {
+	str := `
+DNS Trace Tester
+----------------
+ The DNS trace tester resolves a name iteratively, starting at the root
+ servers and following each delegation down to the authoritative
+ servers, rather than asking a recursive resolver.
+
+ This catches delegation and glue problems which a caching resolver
+ would mask, because it already has the answer cached.
+
+ This test is invoked via input like so:
+
+    test.example.com must run dns-trace with type A
+
+ The test fails if any step of the chain is broken, reporting the zone
+ and server where it broke.  Optionally the final answer can be compared
+ with the expected one, in the same way as the DNS tester:
+
+    test.example.com must run dns-trace with type A with result '1.2.3.4'
+
+ To start the resolution from a specific root server specify its address:
+
+    test.example.com must run dns-trace with type A with root 193.0.14.129
+
+ Lookups are supported for A, AAAA, CNAME, MX, NS, and TXT records.
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we resolve the target from the root servers down, and
+// compare the final answer with what the user specified.
+func (s *DNSTraceTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	if tst.Arguments["type"] == "" {
+		return errors.New("no record-type to lookup")
+	}
+	qtype := dns.StringToType[tst.Arguments["type"]]
+
+	roots := dnsRootServers
+	if tst.Arguments["root"] != "" {
+		roots = []string{tst.Arguments["root"]}
+	}
+
+	res, err := s.resolve(dns.Fqdn(target), qtype, roots, opts, 0)
+	if err != nil {
+		return err
+	}
+
+	//
+	// If no result was specified then a working chain is enough.
+	//
+	expected, ok := tst.Arguments["result"]
+	if !ok {
+		return nil
+	}
+
+	//
+	// Sort both sides for comparison, as the order of records isn't
+	// meaningful.
+	//
+	found := dnsSortedValues(strings.Join(res, ","))
+
+	if found != dnsSortedValues(expected) {
+		return fmt.Errorf("expected DNS result to be '%s', but found '%s'", expected, found)
+	}
+
+	return nil
+}
+
+// resolve performs an iterative lookup of the given name, starting at the
+// given root servers, and returns the values of the records found.
+func (s *DNSTraceTest) resolve(name string, qtype uint16, roots []string, opts test.Options, depth int) ([]string, error) {
+	if depth > dnsTraceMaxDepth {
+		return nil, fmt.Errorf("too many CNAMEs or nameserver lookups while resolving %s", name)
+	}
+
+	zone := "."
+	servers := roots
+
+	for step := 0; step < dnsTraceMaxSteps; step++ {
+		r, server, err := s.query(servers, name, qtype, opts.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("resolution of %s broke at zone %s: %s", name, zone, err)
+		}
+
+		switch r.Rcode {
+		case dns.RcodeSuccess:
+		case dns.RcodeNameError:
+			return nil, fmt.Errorf("%s (zone %s) reports no such domain %s", server, zone, name)
+		default:
+			return nil, fmt.Errorf("%s (zone %s) returned %s for %s", server, zone, dns.RcodeToString[r.Rcode], name)
+		}
+
+		//
+		// If we got an answer then we're done, unless it is an alias
+		// in which case we start again for the name it points to.
+		//
+		if len(r.Answer) > 0 {
+			var results []string
+			cname := ""
+
+			for _, entry := range r.Answer {
+				if entry.Header().Rrtype == qtype {
+					if value, ok := dnsRecordValue(entry); ok {
+						results = append(results, value)
+					} else if alias, ok := entry.(*dns.CNAME); ok {
+						results = append(results, alias.Target)
+					}
+				} else if alias, ok := entry.(*dns.CNAME); ok {
+					cname = alias.Target
+				}
+			}
+
+			if len(results) == 0 && cname != "" {
+				if opts.Verbose {
+					fmt.Printf("\t%s is an alias for %s\n", name, cname)
+				}
+				return s.resolve(cname, qtype, roots, opts, depth+1)
+			}
+
+			if opts.Verbose {
+				fmt.Printf("\t%s answered by %s (zone %s)\n", name, server, zone)
+			}
+			return results, nil
+		}
+
+		//
+		// An authoritative reply without answers means the name
+		// exists, but has no records of the requested type.
+		//
+		if r.Authoritative {
+			return nil, nil
+		}
+
+		//
+		// Otherwise we expect to be referred to the servers of a
+		// child zone.
+		//
+		child := ""
+		var nameservers []string
+		for _, entry := range r.Ns {
+			if ns, ok := entry.(*dns.NS); ok {
+				child = ns.Hdr.Name
+				nameservers = append(nameservers, ns.Ns)
+			}
+		}
+
+		if len(nameservers) == 0 {
+			return nil, fmt.Errorf("%s (zone %s) returned neither an answer nor a referral for %s", server, zone, name)
+		}
+
+		//
+		// The referral must be to a zone below the current one, which
+		// contains the name we're looking for.
+		//
+		if dns.CountLabel(child) <= dns.CountLabel(zone) || !dns.IsSubDomain(zone, child) || !dns.IsSubDomain(child, name) {
+			return nil, fmt.Errorf("%s (zone %s) returned a bogus referral to %s for %s", server, zone, child, name)
+		}
+
+		addresses, err := s.nameserverAddresses(r, child, nameservers, roots, opts, depth)
+		if err != nil {
+			return nil, fmt.Errorf("delegation of %s by %s (zone %s) is broken: %s", child, server, zone, err)
+		}
+
+		if opts.Verbose {
+			fmt.Printf("\t%s delegated %s to %s\n", server, child, strings.Join(nameservers, ", "))
+		}
+
+		zone = child
+		servers = addresses
+	}
+
+	return nil, fmt.Errorf("too many delegations while resolving %s", name)
+}
+
+// nameserverAddresses returns the addresses of the nameservers a zone was
+// delegated to, using the glue records of the referral if present, and
+// resolving the nameservers otherwise.
+func (s *DNSTraceTest) nameserverAddresses(r *dns.Msg, child string, nameservers []string, roots []string, opts test.Options, depth int) ([]string, error) {
+	wanted := make(map[string]bool)
+	for _, ns := range nameservers {
+		wanted[strings.ToLower(ns)] = true
+	}
+
+	//
+	// Prefer IPv4 glue, but fall back to IPv6 if that is all we have.
+	//
+	var addresses []string
+	var addresses6 []string
+	for _, entry := range r.Extra {
+		if !wanted[strings.ToLower(entry.Header().Name)] {
+			continue
+		}
+		switch ent := entry.(type) {
+		case *dns.A:
+			addresses = append(addresses, ent.A.String())
+		case *dns.AAAA:
+			addresses6 = append(addresses6, ent.AAAA.String())
+		}
+	}
+	addresses = append(addresses, addresses6...)
+
+	if len(addresses) > 0 {
+		return addresses, nil
+	}
+
+	//
+	// Without glue we have to resolve the nameservers ourselves, which
+	// is only possible for those outside of the delegated zone.
+	//
+	var lastErr error
+	for _, ns := range nameservers {
+		if dns.IsSubDomain(child, ns) {
+			lastErr = fmt.Errorf("no glue for in-zone nameserver %s", ns)
+			continue
+		}
+
+		found, err := s.resolve(dns.Fqdn(ns), dns.TypeA, roots, opts, depth+1)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to resolve nameserver %s: %s", ns, err)
+			continue
+		}
+		if len(found) > 0 {
+			return found, nil
+		}
+		lastErr = fmt.Errorf("nameserver %s has no addresses", ns)
+	}
+
+	return nil, lastErr
+}
+
+// query sends a non-recursive query to each of the given servers in turn,
+// until one of them replies.
+func (s *DNSTraceTest) query(servers []string, name string, qtype uint16, timeout time.Duration) (*dns.Msg, string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.RecursionDesired = false
+
+	var lastErr error
+	for _, server := range servers {
+
+		//
+		// Default to connecting to an IPv4-address
+		//
+		address := fmt.Sprintf("%s:%d", server, 53)
+
+		//
+		// If we find a ":" we know it is an IPv6 address though
+		//
+		if strings.Contains(server, ":") {
+			address = fmt.Sprintf("[%s]:%d", server, 53)
+		}
+
+		c := &dns.Client{ReadTimeout: timeout}
+		r, _, err := c.Exchange(m, address)
+
+		//
+		// Retry truncated replies over TCP.
+		//
+		if err == nil && r.Truncated {
+			c.Net = "tcp"
+			r, _, err = c.Exchange(m, address)
+		}
+
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %s", server, err)
+			continue
+		}
+		return r, server, nil
+	}
+
+	return nil, "", fmt.Errorf("no nameserver replied, last error %s", lastErr)
+}
+
+func (s *DNSTraceTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+// Register our protocol-tester.
+func init() {
+	Register("dns-trace", func() ProtocolTest {
+		return &DNSTraceTest{}
+	})
+}
@@ -0,0 +1,402 @@
+// gRPC Tester
+//
+// The gRPC tester connects to a gRPC server, and by default ensures that
+// it reports itself as serving via the standard health-checking service.
+//
+// This test is invoked via input like so:
+//
+//    host.example.com must run grpc with port 50051
+//
+// The health of a single service may be checked instead of the server
+// as a whole:
+//
+//    host.example.com must run grpc with port 50051 with service 'shop.Cart'
+//
+// A unary method may be invoked instead, in which case the server must
+// support reflection, which is used to discover the method.  The request
+// is given as JSON, and defaults to an empty message:
+//
+//    host.example.com must run grpc with port 50051 with method 'shop.Cart/GetCart' with request '{"userId": "probe"}'
+//
+// A JSONPath within the response, encoded as JSON, may then be required
+// to be present, or to have a particular value:
+//
+//    host.example.com must run grpc with port 50051 with method 'shop.Cart/GetCart' with path '$.items[0].sku' with expect 'PROBE-1'
+//
+// Fields use their JSON names, and 64-bit integers are encoded as strings,
+// as per the protobuf JSON mapping.
+//
+// To connect via TLS set the tls setting, which may also disable
+// certificate validation.  The certificate may be required to remain
+// valid for a period of time via the expiry setting:
+//
+//    host.example.com must run grpc with port 443 with tls true with expiry 336h
+//    host.example.com must run grpc with port 443 with tls insecure
+//
+
+package protocols
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// GRPCTest is our object.
+type GRPCTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *GRPCTest) Arguments() map[string]string {
+	known := map[string]string{
+		"expect":  ".*",
+		"expiry":  expiryArgument,
+		"method":  `^[A-Za-z0-9_.]+/[A-Za-z0-9_]+$`,
+		"path":    jsonPathArgument,
+		"port":    "^[0-9]+$",
+		"request": ".*",
+		"service": `^[A-Za-z0-9_.]*$`,
+		"tls":     "^(true|insecure)$",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *GRPCTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *GRPCTest) Example() string {
+	str := `
+gRPC Tester
+-----------
+ The gRPC tester connects to a gRPC server, and by default ensures that
+ it reports itself as serving via the standard health-checking service.
+
+ This test is invoked via input like so:
+
+    host.example.com must run grpc with port 50051
+
+ The health of a single service may be checked instead of the server
+ as a whole:
+
+    host.example.com must run grpc with port 50051 with service 'shop.Cart'
+
+ A unary method may be invoked instead, in which case the server must
+ support reflection, which is used to discover the method.  The request
+ is given as JSON, and defaults to an empty message:
+
+    host.example.com must run grpc with port 50051 with method 'shop.Cart/GetCart' with request '{"userId": "probe"}'
+
+ A JSONPath within the response, encoded as JSON, may then be required
+ to be present, or to have a particular value:
+
+    host.example.com must run grpc with port 50051 with method 'shop.Cart/GetCart' with path '$.items[0].sku' with expect 'PROBE-1'
+
+ Fields use their JSON names, and 64-bit integers are encoded as strings,
+ as per the protobuf JSON mapping.
+
+ To connect via TLS set the tls setting, which may also disable
+ certificate validation.  The certificate may be required to remain
+ valid for a period of time via the expiry setting:
+
+    host.example.com must run grpc with port 443 with tls true with expiry 336h
+    host.example.com must run grpc with port 443 with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we either check the health of the server, or invoke the
+// configured method and inspect its response.
+func (s *GRPCTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	if tst.Arguments["expect"] != "" && tst.Arguments["path"] == "" {
+		return errors.New("the expect setting requires a path")
+	}
+	if tst.Arguments["method"] == "" && (tst.Arguments["path"] != "" || tst.Arguments["request"] != "") {
+		return errors.New("the path and request settings require a method")
+	}
+
+	port := 50051
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	window, err := certificateExpiryWindow(tst)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Default to connecting to an IPv4-address
+	//
+	address := fmt.Sprintf("%s:%d", target, port)
+
+	//
+	// If we find a ":" we know it is an IPv6 address though
+	//
+	if strings.Contains(target, ":") {
+		address = fmt.Sprintf("[%s]:%d", target, port)
+	}
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	dialOpts := []grpc.DialOption{grpc.WithBlock(), grpc.WithUserAgent("overseer/probe")}
+	if tst.Arguments["tls"] != "" {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			ServerName:         tst.Target,
+			InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+		})))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	}
+
+	conn, err := grpc.DialContext(ctx, address, dialOpts...)
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			return fmt.Errorf("failed to connect within %s", opts.Timeout)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	//
+	// The peer tells us about the certificate the server presented.
+	//
+	var remote peer.Peer
+	if tst.Arguments["method"] != "" {
+		err = s.invoke(ctx, conn, tst, &remote, opts)
+	} else {
+		err = s.checkHealth(ctx, conn, tst, &remote, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if window > 0 {
+		info, ok := remote.AuthInfo.(credentials.TLSInfo)
+		if !ok {
+			return errors.New("the server didn't present a certificate")
+		}
+		return checkCertificateExpiry(info.State, window, opts.Verbose)
+	}
+
+	return nil
+}
+
+// checkHealth asks the health-checking service whether the server, or the
+// configured service, is serving.
+func (s *GRPCTest) checkHealth(ctx context.Context, conn *grpc.ClientConn, tst test.Test, remote *peer.Peer, opts test.Options) error {
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: tst.Arguments["service"]}, grpc.Peer(remote))
+	if err != nil {
+		return s.statusError(err)
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tHealth status '%s'\n", resp.Status)
+	}
+
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		if tst.Arguments["service"] != "" {
+			return fmt.Errorf("service '%s' is %s", tst.Arguments["service"], resp.Status)
+		}
+		return fmt.Errorf("server is %s", resp.Status)
+	}
+
+	return nil
+}
+
+// invoke discovers the configured method via reflection, invokes it, and
+// inspects the response.
+func (s *GRPCTest) invoke(ctx context.Context, conn *grpc.ClientConn, tst test.Test, remote *peer.Peer, opts test.Options) error {
+	name := tst.Arguments["method"]
+	slash := strings.LastIndex(name, "/")
+	serviceName := name[:slash]
+	methodName := name[slash+1:]
+
+	files, err := s.reflect(ctx, conn, serviceName)
+	if err != nil {
+		return err
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(serviceName))
+	if err != nil {
+		return fmt.Errorf("the server doesn't offer the service '%s'", serviceName)
+	}
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("'%s' isn't a service", serviceName)
+	}
+
+	method := service.Methods().ByName(protoreflect.Name(methodName))
+	if method == nil {
+		return fmt.Errorf("the service '%s' has no method '%s'", serviceName, methodName)
+	}
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return fmt.Errorf("the method '%s' is streaming, only unary methods are supported", name)
+	}
+
+	body := tst.Arguments["request"]
+	if body == "" {
+		body = "{}"
+	}
+
+	req := dynamicpb.NewMessage(method.Input())
+	if err = protojson.Unmarshal([]byte(body), req); err != nil {
+		return fmt.Errorf("invalid request for the method '%s': %w", name, err)
+	}
+
+	resp := dynamicpb.NewMessage(method.Output())
+	err = conn.Invoke(ctx, "/"+name, proto.MessageV1(req), proto.MessageV1(resp), grpc.Peer(remote))
+	if err != nil {
+		return s.statusError(err)
+	}
+
+	out, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tResponse %s\n", out)
+	}
+
+	if tst.Arguments["path"] == "" {
+		return nil
+	}
+
+	var doc interface{}
+	if err = json.Unmarshal(out, &doc); err != nil {
+		return err
+	}
+
+	value, err := jsonPathLookup(doc, tst.Arguments["path"])
+	if err != nil {
+		return err
+	}
+
+	if tst.Arguments["expect"] != "" && jsonValueString(value) != tst.Arguments["expect"] {
+		return fmt.Errorf("the value at '%s' was '%s', not '%s'", tst.Arguments["path"], jsonValueString(value), tst.Arguments["expect"])
+	}
+
+	return nil
+}
+
+// reflect fetches the descriptors of the file defining the given symbol,
+// and of everything it depends upon, from the reflection service.
+func (s *GRPCTest) reflect(ctx context.Context, conn *grpc.ClientConn, symbol string) (*protoregistry.Files, error) {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	defer stream.CloseSend()
+
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+
+	request := &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	}
+	var pending []string
+
+	for request != nil {
+		if err = stream.Send(request); err != nil {
+			return nil, s.statusError(err)
+		}
+
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, s.statusError(err)
+		}
+		if e := resp.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("reflection failed: %s", e.ErrorMessage)
+		}
+
+		for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			file := &descriptorpb.FileDescriptorProto{}
+			if err = proto.Unmarshal(raw, file); err != nil {
+				return nil, err
+			}
+			if seen[file.GetName()] {
+				continue
+			}
+			seen[file.GetName()] = true
+			set.File = append(set.File, file)
+			pending = append(pending, file.Dependency...)
+		}
+
+		//
+		// Ask for the next dependency we're missing, if any.
+		//
+		request = nil
+		for len(pending) > 0 && request == nil {
+			dep := pending[0]
+			pending = pending[1:]
+			if !seen[dep] {
+				request = &rpb.ServerReflectionRequest{
+					MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+				}
+			}
+		}
+	}
+
+	return protodesc.NewFiles(set)
+}
+
+// statusError describes a failed call by its gRPC status.
+func (s *GRPCTest) statusError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	return fmt.Errorf("call failed with %s: %s", st.Code(), st.Message())
+}
+
+func (s *GRPCTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("grpc", func() ProtocolTest {
+		return &GRPCTest{}
+	})
+}
@@ -9,7 +9,7 @@
 //    http://example.com/ must run http
 //
 // By default a remote HTTP-server is considered working if it responds
-// with a HTTP status-code of 200, but you can change this via:
+// with any 2xx HTTP status-code, but you can change this via:
 //
 //    with status 301
 //
@@ -17,6 +17,10 @@
 //
 //    with status 200,429
 //
+// A whole class of statuses can be allowed too:
+//
+//    with status 2xx,3xx
+//
 // Or if you do not care about the specific status-code at all, but you
 // wish to see an alert when a connection-refused/failed/timeout condition
 // occurs you could say:
@@ -49,12 +53,45 @@
 // (The regular expression will be assumed to be multi-line, and
 // will also allow newlines to be matched with ".".)
 //
+// For JSON APIs you can require that the response contains the keys, and
+// values, of a JSON snippet, ignoring any others.  Each element of an
+// array in the snippet must be matched by one of the response's elements:
+//
+//    https://api.example.com/health must run http with json-contains '{"status": "up", "checks": [{"name": "db"}]}'
+//
+// You can require that the response carries particular headers, and
+// that it does NOT carry others, such as those which leak the versions
+// of the software in use.  Both take a comma-separated list:
+//
+//    https://example.com/ must run http with header 'Strict-Transport-Security,X-Frame-Options' with not-header 'Server,X-Powered-By'
+//
+// To check the Strict-Transport-Security header in detail, its max-age
+// must be at least one year, or the number of seconds given by hsts-max-age.
+// For inclusion in the HSTS preload list the includeSubDomains, and
+// preload, directives may be required too:
+//
+//    https://example.com/ must run http with hsts true with hsts-max-age 15768000
+//    https://example.com/ must run http with hsts preload
+//
+// Streaming endpoints should send their responses with chunked framing,
+// rather than being buffered by a proxy and sent with a Content-Length.
+// You can test for either:
+//
+//    https://example.com/events must run http with framing chunked
+//    https://example.com/ must run http with framing length
+//
 // If your URL requires the use of HTTP basic authentication this is
 // supported by adding a username and password parameter to your test,
 // for example:
 //
 //    https://jigsaw.w3.org/HTTP/Basic/ must run http with username 'guest' with password 'guest' with content "Your browser made it"
 //
+// Alternatively a bearer token may be sent, via an Authorization header:
+//
+//    https://api.example.com/health must run http with bearer 'eyJhbGciOi...'
+//
+// The credentials, and token, are censored when tests are logged.
+//
 // If you need to disable failures due to expired, broken, or
 // otherwise bogus SSL certificates you can do so via the tls setting:
 //
@@ -89,6 +126,13 @@
 //    https://steve.fi/Security/XSS/Tutorial/filter.cgi must run http with method PUT with data "text=test%20me" with content "test me"
 //
 //
+// To ensure the server honours range requests, as used by resumable
+// downloads, you can request a byte-range.  The test will then fail unless
+// the server replies with a "206 Partial Content" status, a matching
+// Content-Range header, and a body of the requested size:
+//
+//    https://example.com/file.iso must run http with range 0-99
+//
 // NOTE: This test deliberately does not follow redirections, to allow
 // enhanced testing.
 //
@@ -98,6 +142,17 @@
 //
 //    with follow-redirect 20 <- max 20 follows
 //
+// Or, equivalently, "with redirect follow", while "with redirect none"
+// ensures redirects are never followed.
+//
+// To check both forms of a site at once, the http:// form can be required
+// to permanently redirect (301 or 308) to the https:// form, which is then
+// tested with the remaining arguments.  Failures say which form failed:
+//
+//    https://example.com/ must run http with https-redirect true with content 'Welcome'
+//
+// Only the first 16MB of the response body is read, and tested.
+//
 
 package protocols
 
@@ -105,7 +160,10 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
@@ -123,12 +181,17 @@ import (
 type HTTPTest struct {
 }
 
+// maxHTTPBodySize is the most of a response body we'll read, so that a
+// huge response can't exhaust our memory.
+const maxHTTPBodySize = 16 * 1024 * 1024
+
 // Arguments returns the names of arguments which this protocol-test
 // understands, along with corresponding regular-expressions to validate
 // their values.
 func (s *HTTPTest) Arguments() map[string]string {
 	known := map[string]string{
 		"user-agent":          ".*",
+		"bearer":              ".*",
 		"content":             ".*",
 		"not-content":         ".*",
 		"data":                ".*",
@@ -137,7 +200,7 @@ func (s *HTTPTest) Arguments() map[string]string {
 		"password":            ".*",
 		"pattern":             ".*",
 		"not-pattern":         ".*",
-		"status":              "^(any|[0-9]{3}(?:,[0-9]{3})*)$",
+		"status":              "^(any|(?:[0-9]{3}|[1-5]xx)(?:,(?:[0-9]{3}|[1-5]xx))*)$",
 		"tls":                 "insecure",
 		"username":            ".*",
 		"connect-timeout":     `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
@@ -145,6 +208,15 @@ func (s *HTTPTest) Arguments() map[string]string {
 		"tls-timeout":         `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
 		"resp-header-timeout": `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
 		"follow-redirect":     `^true|false|(\d+)$`,
+		"redirect":            "^(none|follow)$",
+		"header":              `^[A-Za-z0-9-]+(\s*,\s*[A-Za-z0-9-]+)*$`,
+		"hsts":                "^(true|preload)$",
+		"hsts-max-age":        `^[0-9]+$`,
+		"not-header":          `^[A-Za-z0-9-]+(\s*,\s*[A-Za-z0-9-]+)*$`,
+		"framing":             "^(chunked|length)$",
+		"range":               `^[0-9]+-[0-9]+$`,
+		"json-contains":       `^\s*[\[{].*$`,
+		"https-redirect":      "^true$",
 	}
 	return known
 }
@@ -168,7 +240,7 @@ HTTP Tester
    http://example.com/ must run http
 
  By default a remote HTTP-server is considered working if it responds
- with a HTTP status-code of 200, but you can change this via:
+ with any 2xx HTTP status-code, but you can change this via:
 
    with status 301
 
@@ -176,6 +248,10 @@ HTTP Tester
 
    with status 200,429
 
+ A whole class of statuses can be allowed too:
+
+   with status 2xx,3xx
+
  Or if you do not care about the specific status-code at all, but you
  wish to see an alert when a connection-refused/failed/timeout condition
  occurs you could say:
@@ -208,12 +284,45 @@ HTTP Tester
  (The regular expression will be assumed to be multi-line, and
  will also allow newlines to be matched with ".".)
 
+ For JSON APIs you can require that the response contains the keys, and
+ values, of a JSON snippet, ignoring any others.  Each element of an
+ array in the snippet must be matched by one of the response's elements:
+
+   https://api.example.com/health must run http with json-contains '{"status": "up", "checks": [{"name": "db"}]}'
+
+ You can require that the response carries particular headers, and
+ that it does NOT carry others, such as those which leak the versions
+ of the software in use.  Both take a comma-separated list:
+
+   https://example.com/ must run http with header 'Strict-Transport-Security,X-Frame-Options' with not-header 'Server,X-Powered-By'
+
+ To check the Strict-Transport-Security header in detail, its max-age
+ must be at least one year, or the number of seconds given by hsts-max-age.
+ For inclusion in the HSTS preload list the includeSubDomains, and
+ preload, directives may be required too:
+
+   https://example.com/ must run http with hsts true with hsts-max-age 15768000
+   https://example.com/ must run http with hsts preload
+
+ Streaming endpoints should send their responses with chunked framing,
+ rather than being buffered by a proxy and sent with a Content-Length.
+ You can test for either:
+
+   https://example.com/events must run http with framing chunked
+   https://example.com/ must run http with framing length
+
  If your URL requires the use of HTTP basic authentication this is
  supported by adding a username and password parameter to your test,
  for example:
 
    https://jigsaw.w3.org/HTTP/Basic/ must run http with username 'guest' with password 'guest' with content "Your browser made it"
 
+ Alternatively a bearer token may be sent, via an Authorization header:
+
+   https://api.example.com/health must run http with bearer 'eyJhbGciOi...'
+
+ The credentials, and token, are censored when tests are logged.
+
  If you need to disable failures due to expired, broken, or
  otherwise bogus SSL certificates you can do so via the tls setting:
 
@@ -247,6 +356,13 @@ HTTP Tester
 
     https://steve.fi/Security/XSS/Tutorial/filter.cgi must run http with method PUT with data "text=test%20me" with content "test me"
 
+ To ensure the server honours range requests, as used by resumable
+ downloads, you can request a byte-range.  The test will then fail unless
+ the server replies with a "206 Partial Content" status, a matching
+ Content-Range header, and a body of the requested size:
+
+    https://example.com/file.iso must run http with range 0-99
+
  Do note that the HTTP-probe never follow redirections, to allow enhanced
  testing.
 
@@ -255,6 +371,17 @@ HTTP Tester
     with follow-redirect true <- max 10 follows (default)
 
     with follow-redirect 20 <- max 20 follows
+
+ Or, equivalently, "with redirect follow", while "with redirect none"
+ ensures redirects are never followed.
+
+ To check both forms of a site at once, the http:// form can be required
+ to permanently redirect (301 or 308) to the https:// form, which is then
+ tested with the remaining arguments.  Failures say which form failed:
+
+    https://example.com/ must run http with https-redirect true with content 'Welcome'
+
+ Only the first 16MB of the response body is read, and tested.
 `
 	return str
 }
@@ -310,139 +437,16 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	target = strings.Replace(target, "__pt-time-ms__", strconv.FormatInt(opts.PeriodTestStartTime, 10), -1)
 
 	//
-	// Setup a dialer which will be dual-stack
-	//
-	dialer := &net.Dialer{}
-
-	if connectTimeoutString := tst.Arguments["connect-timeout"]; connectTimeoutString != "" {
-		connectTimeout, errParse := time.ParseDuration(connectTimeoutString)
-		if errParse != nil {
-			return errParse
-		}
-		dialer.Timeout = connectTimeout
-	}
-
-	maxConnectRetries := 0
-	if retriesString := tst.Arguments["connect-retries"]; retriesString != "" {
-		_maxDialerRetries, errParse := strconv.ParseInt(retriesString, 10, 0)
-		if errParse != nil {
-			return errParse
-		}
-		maxConnectRetries = int(_maxDialerRetries)
-	}
-
-	//
-	// This is where some magic happens, we want to connect and do
-	// a http check on http://example.com/, but we want to do that
-	// via the IP address.
-	//
-	// We could do that manually by connecting to http://1.2.3.4,
-	// and sending the appropriate HTTP Host: header but that risks
-	// a bit of complexity with SSL in particular.
-	//
-	// So instead we fake the address in the dialer object, so that
-	// we don't rewrite anything, don't do anything manually, and
-	// instead just connect to the right IP by magic.
-	//
-	dial := func(ctx context.Context, network, _ string) (net.Conn, error) {
-		//
-		// Assume an IPv4 address by default.
-		//
-		addr := fmt.Sprintf("%s:%s", address, port)
-
-		//
-		// If we find a ":" we know it is an IPv6 address though
-		//
-		if strings.Contains(address, ":") {
-			addr = fmt.Sprintf("[%s]:%s", address, port)
-		}
-
-		var conn net.Conn
-		var errDial error
-		for retryCount := 0; retryCount <= maxConnectRetries; retryCount++ {
-			//
-			// Use the replaced/updated address in our connection.
-			//
-			conn, errDial = dialer.DialContext(ctx, network, addr)
-			// On error, if we experience a connect timeout, give it another chance if there are more retries available
-			if errDial != nil {
-				errNet, ok := errDial.(net.Error)
-				if ok && errNet.Timeout() {
-					continue
-				}
-
-				// On any other error, no retries
-				break
-			}
-			break
-		}
-
-		return conn, errDial
-	}
-
-	//
-	// Create a context which uses the dial-context
-	//
-	// The dial-context is where the magic happens.
+	// In the composite mode the plaintext site must redirect to the
+	// secure one, which is then tested as usual.
 	//
-	tr := &http.Transport{
-		DialContext: dial,
+	if tst.Arguments["https-redirect"] == "true" {
+		return s.runHTTPSRedirect(tst, address, target, opts)
 	}
 
-	if tlsTimeoutString := tst.Arguments["tls-timeout"]; tlsTimeoutString != "" {
-		tlsTimeout, errParse := time.ParseDuration(tlsTimeoutString)
-		if errParse != nil {
-			return errParse
-		}
-		tr.TLSHandshakeTimeout = tlsTimeout
-	}
-
-	if headerTimeoutString := tst.Arguments["resp-header-timeout"]; headerTimeoutString != "" {
-		headerTimeout, errParse := time.ParseDuration(headerTimeoutString)
-		if errParse != nil {
-			return errParse
-		}
-		tr.ResponseHeaderTimeout = headerTimeout
-	}
-
-	//
-	// If we're running insecurely then ignore SSL errors
-	//
-	if tst.Arguments["tls"] == "insecure" {
-		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	}
-
-	// Total request timeout
-	timeout := opts.Timeout
-	if tst.Timeout != nil {
-		timeout = *tst.Timeout
-	}
-
-	maxFollowRedirects := 0
-
-	argFollowRedirect := tst.Arguments["follow-redirect"]
-	if parsed, errParse := strconv.ParseInt(argFollowRedirect, 10, 32); errParse == nil {
-		maxFollowRedirects = int(parsed)
-	} else if argFollowRedirect == "true" {
-		maxFollowRedirects = 10
-	}
-
-	//
-	// Create a client with a timeout, disabled redirection, and
-	// the magical transport we've just created.
-	//
-	var netClient = &http.Client{
-		Timeout:   timeout,
-		Transport: tr,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if maxFollowRedirects > 0 {
-				maxFollowRedirects--
-				lastRequest := via[len(via)-1]
-				log.Printf("following redirect from %s to %s", lastRequest.URL.String(), req.URL.String())
-				return nil
-			}
-			return http.ErrUseLastResponse
-		},
+	netClient, err := s.newClient(tst, address, port, opts)
+	if err != nil {
+		return err
 	}
 
 	//
@@ -481,8 +485,14 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	}
 
 	//
-	// Are we using basic-auth?
+	// Are we using basic-auth, or a bearer token?
 	//
+	if tst.Arguments["bearer"] != "" {
+		if tst.Arguments["username"] != "" || tst.Arguments["password"] != "" {
+			return fmt.Errorf("the 'bearer' argument can't be combined with 'username' or 'password'")
+		}
+		req.Header.Set("Authorization", "Bearer "+tst.Arguments["bearer"])
+	}
 	if tst.Arguments["username"] != "" {
 		req.SetBasicAuth(tst.Arguments["username"],
 			tst.Arguments["password"])
@@ -497,6 +507,13 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 		req.Header.Set("User-Agent", "overseer/probe")
 	}
 
+	//
+	// Are we testing range requests?
+	//
+	if tst.Arguments["range"] != "" {
+		req.Header.Set("Range", "bytes="+tst.Arguments["range"])
+	}
+
 	//
 	// Perform the request
 	//
@@ -509,35 +526,41 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	// Get the body and status-code.
 	//
 	defer response.Body.Close()
-	body, err := ioutil.ReadAll(response.Body)
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxHTTPBodySize))
 	if err != nil {
 		return err
 	}
 	status := response.StatusCode
 
+	//
+	// If we requested a range, then the server must have honoured it.
+	//
+	if tst.Arguments["range"] != "" {
+		err = s.checkRange(tst.Arguments["range"], response, body)
+		if err != nil {
+			return err
+		}
+	}
+
 	//
 	// The default status-code we accept as OK
 	//
-	var allowedStatuses []int
+	var allowedStatuses []string
 
 	//
 	// Did the user want to look for a specific status-code?
 	//
 	if tst.Arguments["status"] != "" && tst.Arguments["status"] != "any" {
 
-		split := strings.Split(tst.Arguments["status"], ",")
-		for _, statusString := range split {
-			allowedStatus, errConv := strconv.Atoi(statusString)
-			if errConv != nil {
-				return errConv
-			}
+		allowedStatuses = strings.Split(tst.Arguments["status"], ",")
 
-			allowedStatuses = append(allowedStatuses, allowedStatus)
-		}
+	} else if tst.Arguments["range"] != "" {
+
+		allowedStatuses = append(allowedStatuses, strconv.Itoa(http.StatusPartialContent))
 
 	} else {
 
-		allowedStatuses = append(allowedStatuses, http.StatusOK)
+		allowedStatuses = append(allowedStatuses, "2xx")
 
 	}
 
@@ -551,7 +574,17 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 
 		found := false
 		for _, allowedStatus := range allowedStatuses {
-			if status == allowedStatus {
+
+			// A class of statuses, such as "2xx"?
+			if strings.HasSuffix(allowedStatus, "xx") {
+				if strconv.Itoa(status/100) == allowedStatus[:1] {
+					found = true
+					break
+				}
+				continue
+			}
+
+			if strconv.Itoa(status) == allowedStatus {
 				found = true
 				break
 			}
@@ -559,7 +592,7 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 
 		if !found {
 			if len(allowedStatuses) == 1 {
-				return fmt.Errorf("status code was %d not %d", status, allowedStatuses[0])
+				return fmt.Errorf("status code was %d not %s", status, allowedStatuses[0])
 			}
 
 			return fmt.Errorf("status code was %d not one of %v", status, allowedStatuses)
@@ -567,6 +600,34 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 
 	}
 
+	//
+	// Are there headers which must, or must not, be present?
+	//
+	err = s.checkHeaders(tst, response)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Is HSTS configured as required?
+	//
+	if tst.Arguments["hsts"] != "" || tst.Arguments["hsts-max-age"] != "" {
+		err = s.checkHSTS(tst, response)
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// Was the response framed as expected?
+	//
+	if tst.Arguments["framing"] != "" {
+		err = s.checkFraming(tst.Arguments["framing"], response)
+		if err != nil {
+			return err
+		}
+	}
+
 	//
 	// Is the user looking for a literal body-match?
 	//
@@ -619,6 +680,25 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 		}
 	}
 
+	//
+	// Is the user expecting the response to contain some JSON?
+	//
+	if tst.Arguments["json-contains"] != "" {
+		var expected interface{}
+		if err = json.Unmarshal([]byte(tst.Arguments["json-contains"]), &expected); err != nil {
+			return fmt.Errorf("invalid JSON given via json-contains: %w", err)
+		}
+
+		var actual interface{}
+		if err = json.Unmarshal(body, &actual); err != nil {
+			return fmt.Errorf("body isn't valid JSON: %w", err)
+		}
+
+		if err = jsonContains(actual, expected, "$"); err != nil {
+			return err
+		}
+	}
+
 	//
 	// If we reached here then our actual test was fine.
 	//
@@ -701,6 +781,343 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	return nil
 }
 
+// runHTTPSRedirect ensures that the http:// form of the URL permanently
+// redirects to the https:// form, and then tests the https:// form with
+// the remaining arguments.  Failures report which of the two failed.
+func (s *HTTPTest) runHTTPSRedirect(tst test.Test, address string, target string, opts test.Options) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Each form uses the default port of its scheme, unless the URL
+	// has a port for it.
+	//
+	host := u.Hostname()
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	plain := *u
+	plain.Scheme = "http"
+	secure := *u
+	secure.Scheme = "https"
+
+	if u.Scheme == "https" {
+		plain.Host = host
+	} else {
+		secure.Host = host
+	}
+
+	err = s.checkHTTPSRedirect(tst, address, &plain, &secure, opts)
+	if err != nil {
+		return fmt.Errorf("the http leg failed: %w", err)
+	}
+
+	secureTest := tst
+	secureTest.Target = secure.String()
+	secureTest.Arguments = make(map[string]string)
+	for k, v := range tst.Arguments {
+		if k != "https-redirect" {
+			secureTest.Arguments[k] = v
+		}
+	}
+
+	err = s.RunTest(secureTest, address, opts)
+	if err != nil {
+		return fmt.Errorf("the https leg failed: %w", err)
+	}
+
+	return nil
+}
+
+// checkHTTPSRedirect ensures that fetching the plain URL results in a
+// permanent redirect to the secure one.
+func (s *HTTPTest) checkHTTPSRedirect(tst test.Test, address string, plain *url.URL, secure *url.URL, opts test.Options) error {
+	port := plain.Port()
+	if port == "" {
+		port = "80"
+	}
+
+	netClient, err := s.newClient(tst, address, port, opts)
+	if err != nil {
+		return err
+	}
+
+	//
+	// We want to see the redirect, not where it leads.
+	//
+	netClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	req, err := http.NewRequest("GET", plain.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	if tst.Arguments["user-agent"] != "" {
+		req.Header.Set("User-Agent", tst.Arguments["user-agent"])
+	} else {
+		req.Header.Set("User-Agent", "overseer/probe")
+	}
+
+	response, err := netClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusMovedPermanently && response.StatusCode != http.StatusPermanentRedirect {
+		return fmt.Errorf("status code was %d not 301 or 308", response.StatusCode)
+	}
+
+	location, err := response.Location()
+	if err != nil {
+		return fmt.Errorf("the redirect has no valid Location header: %w", err)
+	}
+
+	opts.Tracef("%s redirects to %s", plain.String(), location.String())
+
+	if !sameHTTPURL(location, secure) {
+		return fmt.Errorf("redirected to '%s' not '%s'", location.String(), secure.String())
+	}
+
+	return nil
+}
+
+// sameHTTPURL reports whether two URLs refer to the same resource,
+// ignoring the case of the hostname, and default ports.
+func sameHTTPURL(a *url.URL, b *url.URL) bool {
+	port := func(u *url.URL) string {
+		if u.Port() != "" {
+			return u.Port()
+		}
+		if u.Scheme == "https" {
+			return "443"
+		}
+		return "80"
+	}
+	path := func(u *url.URL) string {
+		if u.EscapedPath() == "" {
+			return "/"
+		}
+		return u.EscapedPath()
+	}
+
+	return a.Scheme == b.Scheme &&
+		strings.EqualFold(a.Hostname(), b.Hostname()) &&
+		port(a) == port(b) &&
+		path(a) == path(b) &&
+		a.RawQuery == b.RawQuery
+}
+
+// newClient creates a client which connects to the given address, rather
+// than to the address the hostname of a request resolves to, and which is
+// configured by the arguments of the test.
+func (s *HTTPTest) newClient(tst test.Test, address string, port string, opts test.Options) (*http.Client, error) {
+	//
+	// Setup a dialer which will be dual-stack
+	//
+	dialer := &net.Dialer{}
+
+	if connectTimeoutString := tst.Arguments["connect-timeout"]; connectTimeoutString != "" {
+		connectTimeout, errParse := time.ParseDuration(connectTimeoutString)
+		if errParse != nil {
+			return nil, errParse
+		}
+		dialer.Timeout = connectTimeout
+	}
+
+	maxConnectRetries := 0
+	if retriesString := tst.Arguments["connect-retries"]; retriesString != "" {
+		_maxDialerRetries, errParse := strconv.ParseInt(retriesString, 10, 0)
+		if errParse != nil {
+			return nil, errParse
+		}
+		maxConnectRetries = int(_maxDialerRetries)
+	}
+
+	//
+	// This is where some magic happens, we want to connect and do
+	// a http check on http://example.com/, but we want to do that
+	// via the IP address.
+	//
+	// We could do that manually by connecting to http://1.2.3.4,
+	// and sending the appropriate HTTP Host: header but that risks
+	// a bit of complexity with SSL in particular.
+	//
+	// So instead we fake the address in the dialer object, so that
+	// we don't rewrite anything, don't do anything manually, and
+	// instead just connect to the right IP by magic.
+	//
+	dial := func(ctx context.Context, network, _ string) (net.Conn, error) {
+		//
+		// Assume an IPv4 address by default.
+		//
+		addr := fmt.Sprintf("%s:%s", address, port)
+
+		//
+		// If we find a ":" we know it is an IPv6 address though
+		//
+		if strings.Contains(address, ":") {
+			addr = fmt.Sprintf("[%s]:%s", address, port)
+		}
+
+		var conn net.Conn
+		var errDial error
+		for retryCount := 0; retryCount <= maxConnectRetries; retryCount++ {
+			//
+			// Use the replaced/updated address in our connection.
+			//
+			conn, errDial = dialer.DialContext(ctx, network, addr)
+			// On error, if we experience a connect timeout, give it another chance if there are more retries available
+			if errDial != nil {
+				errNet, ok := errDial.(net.Error)
+				if ok && errNet.Timeout() {
+					continue
+				}
+
+				// On any other error, no retries
+				break
+			}
+			break
+		}
+
+		return conn, errDial
+	}
+
+	//
+	// Create a context which uses the dial-context
+	//
+	// The dial-context is where the magic happens.
+	//
+	tr := &http.Transport{
+		DialContext: dial,
+	}
+
+	if tlsTimeoutString := tst.Arguments["tls-timeout"]; tlsTimeoutString != "" {
+		tlsTimeout, errParse := time.ParseDuration(tlsTimeoutString)
+		if errParse != nil {
+			return nil, errParse
+		}
+		tr.TLSHandshakeTimeout = tlsTimeout
+	}
+
+	if headerTimeoutString := tst.Arguments["resp-header-timeout"]; headerTimeoutString != "" {
+		headerTimeout, errParse := time.ParseDuration(headerTimeoutString)
+		if errParse != nil {
+			return nil, errParse
+		}
+		tr.ResponseHeaderTimeout = headerTimeout
+	}
+
+	//
+	// Transparent decompression hides the Content-Length, so
+	// disable it if we're testing the framing.
+	//
+	if tst.Arguments["framing"] != "" {
+		tr.DisableCompression = true
+	}
+
+	//
+	// If we're running insecurely then ignore SSL errors
+	//
+	if tst.Arguments["tls"] == "insecure" {
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	// Total request timeout
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
+	if tst.Arguments["redirect"] != "" && tst.Arguments["follow-redirect"] != "" {
+		return nil, &test.ConfigError{Err: errors.New("the 'redirect' argument can't be combined with 'follow-redirect'")}
+	}
+
+	maxFollowRedirects := 0
+
+	argFollowRedirect := tst.Arguments["follow-redirect"]
+	if parsed, errParse := strconv.ParseInt(argFollowRedirect, 10, 32); errParse == nil {
+		maxFollowRedirects = int(parsed)
+	} else if argFollowRedirect == "true" {
+		maxFollowRedirects = 10
+	}
+
+	switch tst.Arguments["redirect"] {
+	case "follow":
+		maxFollowRedirects = 10
+	case "none":
+		maxFollowRedirects = 0
+	}
+
+	//
+	// Create a client with a timeout, disabled redirection, and
+	// the magical transport we've just created.
+	//
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: tr,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if maxFollowRedirects > 0 {
+				maxFollowRedirects--
+				lastRequest := via[len(via)-1]
+				log.Printf("following redirect from %s to %s", lastRequest.URL.String(), req.URL.String())
+				return nil
+			}
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+// checkRange ensures that the response honours the requested byte-range,
+// which is given in the form "first-last".
+func (s *HTTPTest) checkRange(byteRange string, response *http.Response, body []byte) error {
+
+	var first, last int64
+	_, err := fmt.Sscanf(byteRange, "%d-%d", &first, &last)
+	if err != nil {
+		return err
+	}
+	if last < first {
+		return fmt.Errorf("invalid range '%s'", byteRange)
+	}
+
+	contentRange := response.Header.Get("Content-Range")
+
+	if response.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range request ignored, status code was %d not %d (content-range '%s', %d bytes received)",
+			response.StatusCode, http.StatusPartialContent, contentRange, len(body))
+	}
+
+	//
+	// The header looks like "bytes 0-99/1234", where the total size may
+	// also be "*" if unknown.
+	//
+	var rangeFirst, rangeLast int64
+	_, err = fmt.Sscanf(contentRange, "bytes %d-%d/", &rangeFirst, &rangeLast)
+	if err != nil {
+		return fmt.Errorf("invalid content-range '%s' in response", contentRange)
+	}
+
+	if rangeFirst != first || rangeLast > last {
+		return fmt.Errorf("content-range '%s' doesn't match the requested range %s", contentRange, byteRange)
+	}
+
+	//
+	// The server may legitimately return less than requested if the
+	// resource is shorter, but the body must match the content-range.
+	//
+	if int64(len(body)) != rangeLast-rangeFirst+1 {
+		return fmt.Errorf("body was %d bytes, but content-range was '%s'", len(body), contentRange)
+	}
+
+	return nil
+}
+
 // SSLExpiration returns the number of hours remaining for a given
 // SSL certificate chain.
 func (s *HTTPTest) SSLExpiration(host string, verbose bool) (int64, string, error) {
@@ -775,6 +1192,146 @@ func (s *HTTPTest) SSLExpiration(host string, verbose bool) (int64, string, erro
 	return hours, cn, nil
 }
 
+// checkHeaders ensures the response has all the headers listed in the
+// "header" argument, and none of those listed in "not-header".
+func (s *HTTPTest) checkHeaders(tst test.Test, response *http.Response) error {
+	var missing []string
+	var present []string
+
+	if tst.Arguments["header"] != "" {
+		for _, name := range strings.Split(tst.Arguments["header"], ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := response.Header[http.CanonicalHeaderKey(name)]; !ok {
+				missing = append(missing, name)
+			}
+		}
+	}
+
+	if tst.Arguments["not-header"] != "" {
+		for _, name := range strings.Split(tst.Arguments["not-header"], ",") {
+			name = strings.TrimSpace(name)
+			if value, ok := response.Header[http.CanonicalHeaderKey(name)]; ok {
+				present = append(present, fmt.Sprintf("%s: %s", name, strings.Join(value, ", ")))
+			}
+		}
+	}
+
+	switch {
+	case len(missing) > 0 && len(present) > 0:
+		return fmt.Errorf("response is missing headers %s, and has disallowed headers %s", strings.Join(missing, ", "), strings.Join(present, "; "))
+	case len(missing) > 0:
+		return fmt.Errorf("response is missing headers %s", strings.Join(missing, ", "))
+	case len(present) > 0:
+		return fmt.Errorf("response has disallowed headers %s", strings.Join(present, "; "))
+	}
+
+	return nil
+}
+
+// hstsMinMaxAge is the least max-age, in seconds, the HSTS preload list
+// accepts, and which we require by default.
+const hstsMinMaxAge = 31536000
+
+// checkHSTS ensures the response carries a Strict-Transport-Security
+// header with a long enough max-age, and when the "hsts" argument is
+// "preload" the includeSubDomains and preload directives too.
+func (s *HTTPTest) checkHSTS(tst test.Test, response *http.Response) error {
+	header := response.Header.Get("Strict-Transport-Security")
+	if header == "" {
+		return fmt.Errorf("response is missing the Strict-Transport-Security header")
+	}
+
+	minAge := int64(hstsMinMaxAge)
+	if tst.Arguments["hsts-max-age"] != "" {
+		var err error
+		minAge, err = strconv.ParseInt(tst.Arguments["hsts-max-age"], 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// The directives are case-insensitive, and the max-age may be quoted,
+	// e.g. `max-age="31536000"; includeSubDomains; preload`.
+	//
+	maxAge := int64(-1)
+	directives := make(map[string]bool)
+	for _, directive := range strings.Split(header, ";") {
+		name := strings.ToLower(strings.TrimSpace(directive))
+		value := ""
+		if i := strings.Index(name, "="); i >= 0 {
+			value = strings.Trim(strings.TrimSpace(name[i+1:]), `"`)
+			name = strings.TrimSpace(name[:i])
+		}
+		directives[name] = true
+
+		if name == "max-age" {
+			age, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Strict-Transport-Security header '%s' has an invalid max-age", header)
+			}
+			maxAge = age
+		}
+	}
+
+	if maxAge < 0 {
+		return fmt.Errorf("Strict-Transport-Security header '%s' has no max-age", header)
+	}
+	if maxAge < minAge {
+		return fmt.Errorf("Strict-Transport-Security header '%s' has a max-age of %d, less than %d", header, maxAge, minAge)
+	}
+
+	if tst.Arguments["hsts"] == "preload" {
+		var missing []string
+		for _, name := range []string{"includeSubDomains", "preload"} {
+			if !directives[strings.ToLower(name)] {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("Strict-Transport-Security header '%s' is missing %s, which preloading requires", header, strings.Join(missing, " and "))
+		}
+	}
+
+	return nil
+}
+
+// checkFraming ensures the response body was framed as expected, either
+// via chunked transfer-encoding, or with a Content-Length.
+func (s *HTTPTest) checkFraming(expected string, response *http.Response) error {
+	chunked := false
+	for _, encoding := range response.TransferEncoding {
+		if encoding == "chunked" {
+			chunked = true
+		}
+	}
+
+	var observed string
+	switch {
+	case response.ProtoMajor >= 2:
+		observed = response.Proto + " frames"
+	case chunked:
+		observed = "chunked transfer-encoding"
+	case response.ContentLength >= 0:
+		observed = fmt.Sprintf("Content-Length %d", response.ContentLength)
+	default:
+		observed = "neither chunked transfer-encoding nor a Content-Length"
+	}
+
+	switch expected {
+	case "chunked":
+		if !chunked {
+			return fmt.Errorf("response wasn't chunked, it used %s", observed)
+		}
+	case "length":
+		if chunked || response.ContentLength < 0 {
+			return fmt.Errorf("response had no Content-Length, it used %s", observed)
+		}
+	}
+
+	return nil
+}
+
 func (s *HTTPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
 	return nil
 }
@@ -0,0 +1,53 @@
+package protocols
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// newPinnedHTTPClient returns a HTTP client which always connects to the
+// given IP address, whatever the hostname of the requested URL is.
+//
+// The port is still taken from the URL, and the hostname is still used
+// for the Host: header and TLS verification, the same way the HTTP tester
+// works.
+func newPinnedHTTPClient(address string, insecure bool, timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{}
+
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		//
+		// If we find a ":" we know it is an IPv6 address
+		//
+		host := address
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		return dialer.DialContext(ctx, network, host+":"+port)
+	}
+
+	tr := &http.Transport{
+		DialContext: dial,
+	}
+
+	if insecure {
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: tr,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
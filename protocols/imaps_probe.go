@@ -11,18 +11,49 @@
 // Because IMAPS uses TLS it will test the validity of the certificate as
 // part of the test, if you wish to disable this add `with tls insecure`.
 //
+// To alert before the certificate expires specify the period it must
+// still be valid for:
+//
+//    host.example.com must run imaps with expiry 168h
+//
+// Hosts which only offer IMAP on port 143 can be tested by upgrading the
+// plaintext connection via STARTTLS, with the same certificate checks:
+//
+//    host.example.com must run imaps with starttls true
+//
+// To ensure the server advertises the capabilities your clients depend
+// upon, list them; all of them must be present:
+//
+//    host.example.com must run imaps with capability 'IDLE,MOVE'
+//
+// Servers which only accept OAuth2 can be logged into via XOAUTH2, with
+// an access token in place of the password:
+//
+//    host.example.com must run imaps with username 'steve@example.com' with oauth-token 'ya29.a0Af...'
+//
+// Connections can be tunnelled through a HTTP proxy, which supports the
+// CONNECT method, optionally authenticating to it:
+//
+//    host.example.com must run imaps with proxy 'http://proxy.example.com:3128'
+//    host.example.com must run imaps with proxy 'http://proxy.example.com:3128' with proxy-username 'probe' with proxy-password 'secret'
+//
 
 package protocols
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
 	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-sasl"
 )
 
 // IMAPSTest is our object
@@ -34,10 +65,17 @@ type IMAPSTest struct {
 // their values.
 func (s *IMAPSTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port":     "^[0-9]+$",
-		"tls":      "insecure",
-		"username": ".*",
-		"password": ".*",
+		"port":           "^[0-9]+$",
+		"tls":            "insecure",
+		"username":       ".*",
+		"password":       ".*",
+		"expiry":         expiryArgument,
+		"starttls":       "^(true|false)$",
+		"capability":     `^[^\s,]+(\s*,\s*[^\s,]+)*$`,
+		"oauth-token":    ".*",
+		"proxy":          proxyArgument,
+		"proxy-username": ".*",
+		"proxy-password": ".*",
 	}
 	return known
 }
@@ -63,6 +101,32 @@ IMAPS Tester
 
  Because IMAPS uses TLS this test will ensure the validity of the certificate as
  part of the test, if you wish to disable this add "with tls insecure".
+
+ To alert before the certificate expires specify the period it must
+ still be valid for:
+
+    host.example.com must run imaps with expiry 168h
+
+ Hosts which only offer IMAP on port 143 can be tested by upgrading the
+ plaintext connection via STARTTLS, with the same certificate checks:
+
+    host.example.com must run imaps with starttls true
+
+ To ensure the server advertises the capabilities your clients depend
+ upon, list them; all of them must be present:
+
+    host.example.com must run imaps with capability 'IDLE,MOVE'
+
+ Servers which only accept OAuth2 can be logged into via XOAUTH2, with
+ an access token in place of the password:
+
+    host.example.com must run imaps with username 'steve@example.com' with oauth-token 'ya29.a0Af...'
+
+ Connections can be tunnelled through a HTTP proxy, which supports the
+ CONNECT method, optionally authenticating to it:
+
+    host.example.com must run imaps with proxy 'http://proxy.example.com:3128'
+    host.example.com must run imaps with proxy 'http://proxy.example.com:3128' with proxy-username 'probe' with proxy-password 'secret'
 `
 
 	return str
@@ -77,10 +141,15 @@ IMAPS Tester
 func (s *IMAPSTest) RunTest(tst test.Test, target string, opts test.Options) error {
 	var err error
 
+	starttls := tst.Arguments["starttls"] == "true"
+
 	//
 	// The default port to connect to.
 	//
 	port := 993
+	if starttls {
+		port = 143
+	}
 
 	//
 	// If the user specified a different port update to use it.
@@ -100,6 +169,18 @@ func (s *IMAPSTest) RunTest(tst test.Test, target string, opts test.Options) err
 		insecure = true
 	}
 
+	window, err := certificateExpiryWindow(tst)
+	if err != nil {
+		return err
+	}
+
+	//
+	// We can't tell which way the user wants to login if given both.
+	//
+	if tst.Arguments["password"] != "" && tst.Arguments["oauth-token"] != "" {
+		return errors.New("specify either a password or an oauth-token, not both")
+	}
+
 	//
 	// Default to connecting to an IPv4-address
 	//
@@ -137,26 +218,43 @@ func (s *IMAPSTest) RunTest(tst test.Test, target string, opts test.Options) err
 		}
 	}
 
-	//
-	// Connect.
-	//
-	con, err := client.DialWithDialerTLS(dial, address, tlsSetup)
+	var con *client.Client
+	if starttls {
+		con, err = s.dialStartTLS(tst, dial, address, tlsSetup, window, opts)
+	} else {
+		con, err = s.dialTLS(tst, dial, address, tlsSetup, window, opts)
+	}
 	if err != nil {
 		return err
-
 	}
 	defer con.Close()
 
 	//
-	// If we got username/password then use them
+	// Capabilities are available before we login.
+	//
+	if tst.Arguments["capability"] != "" {
+		if err = s.checkCapabilities(con, tst.Arguments["capability"], opts); err != nil {
+			return err
+		}
+	}
+
+	//
+	// If we got username/password, or an OAuth2 token, then use them
 	//
-	if (tst.Arguments["username"] != "") && (tst.Arguments["password"] != "") {
-		err = con.Login(tst.Arguments["username"], tst.Arguments["password"])
+	login := tst.Arguments["password"] != "" || tst.Arguments["oauth-token"] != ""
+	if tst.Arguments["username"] != "" && login {
+		opts.Tracef("Logging in as %s", tst.Arguments["username"])
+		if tst.Arguments["oauth-token"] != "" {
+			err = s.loginOAuth2(con, tst.Arguments["username"], tst.Arguments["oauth-token"])
+		} else {
+			err = con.Login(tst.Arguments["username"], tst.Arguments["password"])
+		}
 		if err != nil {
 			return err
 		}
 
 		// Logout so that we don't keep the handle open.
+		opts.Tracef("Logged in, logging out")
 		err = con.Logout()
 		if err != nil {
 			return err
@@ -166,6 +264,166 @@ func (s *IMAPSTest) RunTest(tst test.Test, target string, opts test.Options) err
 	return nil
 }
 
+// loginOAuth2 logs into the server via XOAUTH2.
+func (s *IMAPSTest) loginOAuth2(con *client.Client, username string, token string) error {
+	supported, err := con.SupportAuth(sasl.Xoauth2)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return errors.New("XOAUTH2 authentication was not advertised by the server")
+	}
+
+	return con.Authenticate(sasl.NewXoauth2Client(username, token))
+}
+
+// checkCapabilities ensures that the server advertises each of the given,
+// comma-separated, capabilities.
+func (s *IMAPSTest) checkCapabilities(con *client.Client, wanted string, opts test.Options) error {
+	caps, err := con.Capability()
+	if err != nil {
+		return err
+	}
+
+	// Capabilities are case-insensitive
+	advertised := make(map[string]bool)
+	for name := range caps {
+		advertised[strings.ToUpper(name)] = true
+	}
+
+	if opts.Verbose {
+		var names []string
+		for name := range caps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		opts.Tracef("Capabilities: %s", strings.Join(names, " "))
+	}
+
+	var missing []string
+	for _, name := range strings.Split(wanted, ",") {
+		name = strings.TrimSpace(name)
+		if !advertised[strings.ToUpper(name)] {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("the server doesn't advertise the capabilities: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// dialTLS connects to the server via implicit TLS.
+func (s *IMAPSTest) dialTLS(tst test.Test, dial *net.Dialer, address string, tlsSetup *tls.Config, window time.Duration, opts test.Options) (*client.Client, error) {
+
+	//
+	// We make the TLS connection ourselves, so that we can inspect
+	// the certificate the server presented.
+	//
+	opts.Tracef("Connecting to %s via TLS", address)
+	raw, err := dialTCP(tst, dial, address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := tls.Client(raw, tlsSetup)
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if err = conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	opts.Tracef("Completed the TLS handshake")
+
+	con, err := client.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	opts.Tracef("Received the greeting of the server")
+
+	if window > 0 {
+		if err = checkCertificateExpiry(conn.ConnectionState(), window, opts.Verbose); err != nil {
+			con.Close()
+			return nil, err
+		}
+	}
+
+	return con, nil
+}
+
+// dialStartTLS connects to the server in plaintext, and then upgrades the
+// connection via STARTTLS.
+func (s *IMAPSTest) dialStartTLS(tst test.Test, dial *net.Dialer, address string, tlsSetup *tls.Config, window time.Duration, opts test.Options) (*client.Client, error) {
+	opts.Tracef("Connecting to %s", address)
+	conn, err := dialTCP(tst, dial, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	con, err := client.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	opts.Tracef("Received the greeting of the server")
+
+	supported, err := con.SupportStartTLS()
+	if err != nil {
+		con.Close()
+		return nil, err
+	}
+	if !supported {
+		con.Close()
+		return nil, errors.New("STARTTLS was not advertised by the server")
+	}
+
+	//
+	// The client doesn't expose the upgraded connection, so we record
+	// the certificate the server presented as it is verified.
+	//
+	var leaf *x509.Certificate
+	config := tlsSetup.Clone()
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) > 0 {
+			leaf, _ = x509.ParseCertificate(rawCerts[0])
+		}
+		return nil
+	}
+
+	opts.Tracef("Upgrading the connection via STARTTLS")
+	if err = con.StartTLS(config); err != nil {
+		con.Close()
+		return nil, fmt.Errorf("STARTTLS failed: %w", err)
+	}
+
+	if window > 0 {
+		var state tls.ConnectionState
+		if leaf != nil {
+			state.PeerCertificates = []*x509.Certificate{leaf}
+		}
+		if err = checkCertificateExpiry(state, window, opts.Verbose); err != nil {
+			con.Close()
+			return nil, err
+		}
+	}
+
+	return con, nil
+}
+
 func (s *IMAPSTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
 	return nil
 }
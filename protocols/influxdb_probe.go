@@ -0,0 +1,239 @@
+// InfluxDB Tester
+//
+// The InfluxDB tester queries the health-endpoint of an InfluxDB server
+// and ensures that the server reports itself as healthy.
+//
+// This test is invoked via input like so:
+//
+//    http://influx.example.com:8086/ must run influxdb
+//
+// By default the `/health` endpoint is used, older servers which don't
+// offer it can be tested via the `/ping` endpoint instead:
+//
+//    http://influx.example.com:8086/ must run influxdb with endpoint ping
+//
+// If the server requires authentication a token may be specified:
+//
+//    https://influx.example.com/ must run influxdb with token 'secret'
+//
+// Optionally a query can be executed, and the test will fail if the query
+// returns no results:
+//
+//    http://influx.example.com:8086/ must run influxdb with database 'telegraf' with query 'SELECT * FROM cpu LIMIT 1'
+//
+
+package protocols
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// INFLUXDBTest is our object.
+type INFLUXDBTest struct {
+}
+
+// influxHealth is the response of the `/health` endpoint.
+type influxHealth struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// influxQueryResponse is the response of the `/query` endpoint.
+type influxQueryResponse struct {
+	Error   string `json:"error"`
+	Results []struct {
+		Error  string `json:"error"`
+		Series []struct {
+			Values [][]interface{} `json:"values"`
+		} `json:"series"`
+	} `json:"results"`
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *INFLUXDBTest) Arguments() map[string]string {
+	known := map[string]string{
+		"endpoint": "^(health|ping)$",
+		"token":    ".*",
+		"database": ".*",
+		"query":    ".*",
+		"tls":      "insecure",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *INFLUXDBTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *INFLUXDBTest) Example() string {
+	str := `
+InfluxDB Tester
+---------------
+ The InfluxDB tester queries the health-endpoint of an InfluxDB server
+ and ensures that the server reports itself as healthy.
+
+ This test is invoked via input like so:
+
+    http://influx.example.com:8086/ must run influxdb
+
+ By default the '/health' endpoint is used, older servers which don't
+ offer it can be tested via the '/ping' endpoint instead:
+
+    http://influx.example.com:8086/ must run influxdb with endpoint ping
+
+ If the server requires authentication a token may be specified:
+
+    https://influx.example.com/ must run influxdb with token 'secret'
+
+ Optionally a query can be executed, and the test will fail if the query
+ returns no results:
+
+    http://influx.example.com:8086/ must run influxdb with database 'telegraf' with query 'SELECT * FROM cpu LIMIT 1'
+
+ If you need to disable failures due to expired, broken, or
+ otherwise bogus SSL certificates you can do so via the tls setting:
+
+    https://influx.example.com/ must run influxdb with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we query the health-endpoint of the server, and then
+// optionally run the query the user specified.
+func (s *INFLUXDBTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	u, err := url.Parse(tst.Target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("the target must be a http:// or https:// URL, got '%s'", tst.Target)
+	}
+
+	client := newPinnedHTTPClient(target, tst.Arguments["tls"] == "insecure", opts.Timeout)
+	base := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+
+	//
+	// Query the health-endpoint
+	//
+	if tst.Arguments["endpoint"] == "ping" {
+		status, _, errPing := s.get(client, base+"/ping", tst.Arguments["token"])
+		if errPing != nil {
+			return errPing
+		}
+		if status != http.StatusNoContent && status != http.StatusOK {
+			return fmt.Errorf("ping returned status code %d", status)
+		}
+	} else {
+		status, body, errHealth := s.get(client, base+"/health", tst.Arguments["token"])
+		if errHealth != nil {
+			return errHealth
+		}
+
+		var health influxHealth
+		if errJSON := json.Unmarshal(body, &health); errJSON != nil {
+			return fmt.Errorf("failed to parse health response (status code %d): %s", status, errJSON)
+		}
+		if health.Status != "pass" {
+			return fmt.Errorf("server reported status '%s': %s", health.Status, health.Message)
+		}
+	}
+
+	//
+	// If there is no query to run we're done.
+	//
+	if tst.Arguments["query"] == "" {
+		return nil
+	}
+
+	params := url.Values{}
+	params.Set("q", tst.Arguments["query"])
+	if tst.Arguments["database"] != "" {
+		params.Set("db", tst.Arguments["database"])
+	}
+
+	status, body, err := s.get(client, base+"/query?"+params.Encode(), tst.Arguments["token"])
+	if err != nil {
+		return err
+	}
+
+	var response influxQueryResponse
+	if err = json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("failed to parse query response (status code %d): %s", status, err)
+	}
+	if response.Error != "" {
+		return fmt.Errorf("query failed: %s", response.Error)
+	}
+
+	rows := 0
+	for _, result := range response.Results {
+		if result.Error != "" {
+			return fmt.Errorf("query failed: %s", result.Error)
+		}
+		for _, series := range result.Series {
+			rows += len(series.Values)
+		}
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("query '%s' returned no results", tst.Arguments["query"])
+	}
+
+	return nil
+}
+
+// get performs a GET request, returning the status-code and the body of
+// the response.
+func (s *INFLUXDBTest) get(client *http.Client, address string, token string) (int, []byte, error) {
+	req, err := http.NewRequest("GET", address, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req.Header.Set("User-Agent", "overseer/probe")
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+
+	response, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+
+	// Responses are expected to be small, so don't read silly amounts
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, 1024*1024))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return response.StatusCode, []byte(strings.TrimSpace(string(body))), nil
+}
+
+func (s *INFLUXDBTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("influxdb", func() ProtocolTest {
+		return &INFLUXDBTest{}
+	})
+}
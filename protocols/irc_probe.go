@@ -0,0 +1,324 @@
+// IRC Tester
+//
+// The IRC tester connects to an IRC server, registers a connection, and
+// ensures that the server welcomes us.
+//
+// This test is invoked via input like so:
+//
+//    irc.example.com must run irc [with port 6667]
+//
+// A random nickname is used, unless one is given:
+//
+//    irc.example.com must run irc with nick 'overseer'
+//
+// To ensure that a channel can be joined specify its name:
+//
+//    irc.example.com must run irc with channel '#support'
+//
+// Servers can be connected to via TLS, on port 6697 by default, which may
+// also disable certificate validation:
+//
+//    irc.example.com must run irc with tls true
+//    irc.example.com must run irc with tls insecure
+//
+
+package protocols
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// IRCTest is our object.
+type IRCTest struct {
+}
+
+// ircMessage is a single line sent by the server.
+type ircMessage struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *IRCTest) Arguments() map[string]string {
+	known := map[string]string{
+		"channel": `^[#&+!][^\s,]+$`,
+		"nick":    `^[^\s:#&!@,]+$`,
+		"port":    "^[0-9]+$",
+		"tls":     "^(true|insecure)$",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *IRCTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *IRCTest) Example() string {
+	str := `
+IRC Tester
+----------
+ The IRC tester connects to an IRC server, registers a connection, and
+ ensures that the server welcomes us.
+
+ This test is invoked via input like so:
+
+    irc.example.com must run irc [with port 6667]
+
+ A random nickname is used, unless one is given:
+
+    irc.example.com must run irc with nick 'overseer'
+
+ To ensure that a channel can be joined specify its name:
+
+    irc.example.com must run irc with channel '#support'
+
+ Servers can be connected to via TLS, on port 6697 by default, which may
+ also disable certificate validation:
+
+    irc.example.com must run irc with tls true
+    irc.example.com must run irc with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we register, wait to be welcomed, and optionally join the
+// channel.
+func (s *IRCTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	port := 6667
+	if tst.Arguments["tls"] != "" {
+		port = 6697
+	}
+
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	nick := tst.Arguments["nick"]
+	if nick == "" {
+		//
+		// Nicknames may be as short as nine characters, and must be
+		// unique, so we use a random one.
+		//
+		tag := make([]byte, 3)
+		if _, err = rand.Read(tag); err != nil {
+			return err
+		}
+		nick = "ovs" + hex.EncodeToString(tag)
+	}
+
+	//
+	// The address to connect to, with IPv6 addresses in brackets
+	//
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	d := net.Dialer{Timeout: opts.Timeout}
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	if tst.Arguments["tls"] != "" {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName:         tst.Target,
+			InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+		})
+		if err = tlsConn.Handshake(); err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+
+	reader := bufio.NewReader(conn)
+
+	_, err = fmt.Fprintf(conn, "NICK %s\r\nUSER overseer 0 * :overseer\r\n", nick)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Wait for the welcome, failing on the replies which mean we
+	// won't be registered.
+	//
+	msg, err := s.await(conn, reader, opts, func(msg ircMessage) (bool, error) {
+		switch msg.Command {
+		case "001":
+			return true, nil
+		case "431", "432", "433", "436", "437", "464", "465":
+			return false, fmt.Errorf("registration was refused: %s", msg.Trailing())
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	opts.Tracef("Welcomed as '%s': %s", nick, msg.Trailing())
+
+	//
+	// Be polite, and leave once we're done.
+	//
+	defer fmt.Fprintf(conn, "QUIT :overseer\r\n")
+
+	channel := tst.Arguments["channel"]
+	if channel == "" {
+		return nil
+	}
+
+	_, err = fmt.Fprintf(conn, "JOIN %s\r\n", channel)
+	if err != nil {
+		return err
+	}
+
+	//
+	// The server echoes our JOIN back on success, while failures are
+	// numerics naming the channel.
+	//
+	_, err = s.await(conn, reader, opts, func(msg ircMessage) (bool, error) {
+		if msg.Command == "JOIN" && len(msg.Params) > 0 &&
+			strings.EqualFold(msg.Params[0], channel) &&
+			strings.EqualFold(strings.SplitN(msg.Prefix, "!", 2)[0], nick) {
+			return true, nil
+		}
+		if len(msg.Command) == 3 && (msg.Command[0] == '4' || msg.Command[0] == '5') &&
+			len(msg.Params) > 1 && strings.EqualFold(msg.Params[1], channel) {
+			return false, fmt.Errorf("joining '%s' failed: %s", channel, msg.Trailing())
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	opts.Tracef("Joined '%s'", channel)
+
+	return nil
+}
+
+// await reads messages until the given function accepts one, or returns
+// an error.  Pings from the server are answered meanwhile.
+func (s *IRCTest) await(conn net.Conn, reader *bufio.Reader, opts test.Options, accept func(ircMessage) (bool, error)) (ircMessage, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return ircMessage{}, fmt.Errorf("no reply was received within %s", opts.Timeout)
+			}
+			return ircMessage{}, err
+		}
+
+		msg := parseIRCMessage(strings.TrimRight(line, "\r\n"))
+
+		switch msg.Command {
+		case "PING":
+			if _, err = fmt.Fprintf(conn, "PONG :%s\r\n", msg.Trailing()); err != nil {
+				return ircMessage{}, err
+			}
+			continue
+		case "ERROR":
+			return ircMessage{}, fmt.Errorf("the server closed the connection: %s", msg.Trailing())
+		}
+
+		ok, err := accept(msg)
+		if err != nil {
+			return ircMessage{}, err
+		}
+		if ok {
+			return msg, nil
+		}
+	}
+}
+
+// Trailing returns the last parameter of the message, which is usually
+// the human-readable text.
+func (m ircMessage) Trailing() string {
+	if len(m.Params) == 0 {
+		return ""
+	}
+	return m.Params[len(m.Params)-1]
+}
+
+// parseIRCMessage splits a line into its prefix, command, and parameters.
+func parseIRCMessage(line string) ircMessage {
+	var msg ircMessage
+
+	//
+	// Skip any IRCv3 message-tags.
+	//
+	if strings.HasPrefix(line, "@") {
+		if i := strings.Index(line, " "); i >= 0 {
+			line = strings.TrimLeft(line[i:], " ")
+		}
+	}
+
+	if strings.HasPrefix(line, ":") {
+		i := strings.Index(line, " ")
+		if i < 0 {
+			msg.Prefix = line[1:]
+			return msg
+		}
+		msg.Prefix = line[1:i]
+		line = strings.TrimLeft(line[i:], " ")
+	}
+
+	trailing := ""
+	hasTrailing := false
+	if i := strings.Index(line, " :"); i >= 0 {
+		trailing = line[i+2:]
+		hasTrailing = true
+		line = line[:i]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) > 0 {
+		msg.Command = strings.ToUpper(fields[0])
+		msg.Params = fields[1:]
+	}
+	if hasTrailing {
+		msg.Params = append(msg.Params, trailing)
+	}
+
+	return msg
+}
+
+func (s *IRCTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("irc", func() ProtocolTest {
+		return &IRCTest{}
+	})
+}
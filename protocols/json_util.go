@@ -0,0 +1,207 @@
+package protocols
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPathArgument is the regular expression used to validate the JSONPath
+// arguments of the testers.
+const jsonPathArgument = `^\$.*$`
+
+// jsonPathLookup returns the value found at the given JSONPath within a
+// decoded JSON document.
+//
+// Only the child and index operators are supported, which covers the
+// common case of addressing a single value, e.g. `$.items[0].name` or
+// `$['content-type']`.
+func jsonPathLookup(doc interface{}, path string) (interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("the JSONPath '%s' must start with '$'", path)
+	}
+
+	current := doc
+	rest := path[1:]
+	for rest != "" {
+		var key string
+		index := -1
+
+		switch {
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated key in the JSONPath '%s'", path)
+			}
+			key = rest[2:end]
+			rest = rest[end+2:]
+
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in the JSONPath '%s'", path)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("invalid index '%s' in the JSONPath '%s'", rest[1:end], path)
+			}
+			index = i
+			rest = rest[end+1:]
+
+		case strings.HasPrefix(rest, "."):
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key = rest[1 : end+1]
+			rest = rest[end+1:]
+			if key == "" {
+				return nil, fmt.Errorf("empty key in the JSONPath '%s'", path)
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected '%s' in the JSONPath '%s'", rest, path)
+		}
+
+		if index >= 0 {
+			list, ok := current.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("the JSONPath '%s' indexes a value which isn't a list", path)
+			}
+			if index >= len(list) {
+				return nil, fmt.Errorf("the JSONPath '%s' indexes %d of a list of %d values", path, index, len(list))
+			}
+			current = list[index]
+			continue
+		}
+
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("the JSONPath '%s' looks up '%s' in a value which isn't an object", path, key)
+		}
+		value, ok := object[key]
+		if !ok {
+			return nil, fmt.Errorf("the JSONPath '%s' didn't match, there is no '%s'", path, key)
+		}
+		current = value
+	}
+
+	return current, nil
+}
+
+// jsonValueString returns the textual form of a decoded JSON value, for
+// comparing against an expected value.
+//
+// Strings are returned as-is, rather than quoted.
+func jsonValueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	}
+
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(out)
+}
+
+// jsonContains ensures the decoded JSON value contains the expected one.
+//
+// Objects must contain each of the expected keys, with matching values,
+// but may have others.  Each expected element of an array must be matched
+// by one of the actual elements, in any order.  Other values must be
+// equal.  The path of the value is used to describe any mismatch.
+func jsonContains(actual interface{}, expected interface{}, path string) error {
+	switch want := expected.(type) {
+	case map[string]interface{}:
+		object, ok := actual.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s was %s, not an object", path, jsonTypeName(actual))
+		}
+
+		// Check the keys in order, so that we report the same mismatch
+		keys := make([]string, 0, len(want))
+		for key := range want {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			child := jsonChildPath(path, key)
+			found, ok := object[key]
+			if !ok {
+				return fmt.Errorf("%s is missing", child)
+			}
+			if err := jsonContains(found, want[key], child); err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		list, ok := actual.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s was %s, not an array", path, jsonTypeName(actual))
+		}
+
+		for i, value := range want {
+			matched := false
+			for j := range list {
+				if jsonContains(list[j], value, path) == nil {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return fmt.Errorf("%s has no element matching '%s', element %d of the expected array", path, jsonValueString(value), i)
+			}
+		}
+
+	default:
+		if !reflect.DeepEqual(actual, expected) {
+			return fmt.Errorf("%s was '%s', not '%s'", path, jsonValueString(actual), jsonValueString(expected))
+		}
+	}
+
+	return nil
+}
+
+// jsonTypeName describes the type of a decoded JSON value.
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
+
+// jsonIdentifier matches keys which can be used in the dot-notation of
+// a JSONPath.
+var jsonIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// jsonChildPath returns the JSONPath of the given key within the object
+// at the given path.
+func jsonChildPath(path string, key string) string {
+	if jsonIdentifier.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "['" + key + "']"
+}
@@ -0,0 +1,379 @@
+// Load-Balancer Status Tester
+//
+// The load-balancer status tester fetches the statistics of a load
+// balancer, and ensures that enough of its backend servers are up.
+//
+// This catches a load balancer which still serves traffic, but with fewer
+// servers than it should have.
+//
+// HAProxy statistics, in CSV format, are supported:
+//
+//    http://lb.example.com:8404/stats;csv must run lb-status with min-up 3
+//
+// As are the nginx upstream-check module's JSON status, and the nginx
+// Plus API:
+//
+//    http://lb.example.com/status?format=json must run lb-status with min-up 3
+//    http://lb.example.com/api/6/http/upstreams must run lb-status with min-up 3
+//
+// By default the format is detected, and every backend is considered,
+// but you can be specific about both:
+//
+//    http://lb.example.com:8404/stats;csv must run lb-status with format haproxy with backend 'web' with min-up 2
+//
+// The statistics may be protected by HTTP basic-authentication:
+//
+//    http://lb.example.com:8404/stats;csv must run lb-status with username 'admin' with password 'secret'
+//
+// If you need to disable failures due to expired, broken, or otherwise
+// bogus TLS certificates you can do so via the tls setting:
+//
+//    https://lb.example.com/stats;csv must run lb-status with tls insecure
+//
+
+package protocols
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// LBSTATUSTest is our object.
+type LBSTATUSTest struct {
+}
+
+// lbServer is a single backend server of a load balancer.
+type lbServer struct {
+	// The backend, or upstream, the server belongs to
+	Backend string
+
+	// The name, or address, of the server
+	Name string
+
+	// The state reported for the server
+	Status string
+
+	// Is the server up?
+	Up bool
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *LBSTATUSTest) Arguments() map[string]string {
+	known := map[string]string{
+		"backend":  ".*",
+		"format":   "^(haproxy|nginx)$",
+		"min-up":   "^[0-9]+$",
+		"password": ".*",
+		"tls":      "insecure",
+		"username": ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *LBSTATUSTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *LBSTATUSTest) Example() string {
+	str := `
+Load-Balancer Status Tester
+---------------------------
+ The load-balancer status tester fetches the statistics of a load
+ balancer, and ensures that enough of its backend servers are up.
+
+ This catches a load balancer which still serves traffic, but with fewer
+ servers than it should have.
+
+ HAProxy statistics, in CSV format, are supported:
+
+    http://lb.example.com:8404/stats;csv must run lb-status with min-up 3
+
+ As are the nginx upstream-check module's JSON status, and the nginx
+ Plus API:
+
+    http://lb.example.com/status?format=json must run lb-status with min-up 3
+    http://lb.example.com/api/6/http/upstreams must run lb-status with min-up 3
+
+ By default the format is detected, and every backend is considered,
+ but you can be specific about both:
+
+    http://lb.example.com:8404/stats;csv must run lb-status with format haproxy with backend 'web' with min-up 2
+
+ The statistics may be protected by HTTP basic-authentication:
+
+    http://lb.example.com:8404/stats;csv must run lb-status with username 'admin' with password 'secret'
+
+ If you need to disable failures due to expired, broken, or otherwise
+ bogus TLS certificates you can do so via the tls setting:
+
+    https://lb.example.com/stats;csv must run lb-status with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we fetch the statistics, and count the servers which are up.
+func (s *LBSTATUSTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	u, err := url.Parse(tst.Target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("the target must be a http:// or https:// URL, got '%s'", tst.Target)
+	}
+
+	minUp := 1
+	if tst.Arguments["min-up"] != "" {
+		minUp, err = strconv.Atoi(tst.Arguments["min-up"])
+		if err != nil {
+			return err
+		}
+	}
+
+	client := newPinnedHTTPClient(target, tst.Arguments["tls"] == "insecure", opts.Timeout)
+
+	req, err := http.NewRequest("GET", tst.Target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	if tst.Arguments["username"] != "" {
+		req.SetBasicAuth(tst.Arguments["username"], tst.Arguments["password"])
+	}
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code was %d not %d", response.StatusCode, http.StatusOK)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxHTTPBodySize))
+	if err != nil {
+		return err
+	}
+
+	//
+	// Detect the format, unless we were told.
+	//
+	format := tst.Arguments["format"]
+	if format == "" {
+		trimmed := bytes.TrimSpace(body)
+		switch {
+		case bytes.HasPrefix(trimmed, []byte("# pxname")):
+			format = "haproxy"
+		case bytes.HasPrefix(trimmed, []byte("{")):
+			format = "nginx"
+		default:
+			return errors.New("unrecognized statistics, expected HAProxy CSV or nginx JSON")
+		}
+	}
+
+	var servers []lbServer
+	if format == "haproxy" {
+		servers, err = s.parseHAProxy(body)
+	} else {
+		servers, err = s.parseNginx(body)
+	}
+	if err != nil {
+		return err
+	}
+
+	//
+	// Count the servers of the backend(s) we care about.
+	//
+	total := 0
+	up := 0
+	var down []string
+	for _, server := range servers {
+		if tst.Arguments["backend"] != "" && server.Backend != tst.Arguments["backend"] {
+			continue
+		}
+
+		total++
+		if server.Up {
+			up++
+		} else {
+			down = append(down, fmt.Sprintf("%s/%s (%s)", server.Backend, server.Name, server.Status))
+		}
+	}
+	sort.Strings(down)
+
+	if total == 0 {
+		if tst.Arguments["backend"] != "" {
+			return fmt.Errorf("no servers were found for the backend '%s'", tst.Arguments["backend"])
+		}
+		return errors.New("no servers were found")
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\t%d of %d servers are up\n", up, total)
+		for _, server := range down {
+			fmt.Printf("\tDown: %s\n", server)
+		}
+	}
+
+	if up < minUp {
+		msg := fmt.Sprintf("%d of %d servers are up, fewer than %d", up, total, minUp)
+		if len(down) > 0 {
+			msg += ", down: " + strings.Join(down, ", ")
+		}
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+// parseHAProxy parses the CSV statistics of HAProxy.
+//
+// The rows for frontends, and backends as a whole, are ignored in favour
+// of the individual servers.
+func (s *LBSTATUSTest) parseHAProxy(body []byte) ([]lbServer, error) {
+	body = bytes.TrimPrefix(bytes.TrimSpace(body), []byte("# "))
+
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid HAProxy statistics: %w", err)
+	}
+	if len(records) < 1 {
+		return nil, errors.New("invalid HAProxy statistics: there is no header")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	for _, name := range []string{"pxname", "svname", "status"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("invalid HAProxy statistics: there is no '%s' column", name)
+		}
+	}
+
+	var servers []lbServer
+	for _, record := range records[1:] {
+		if len(record) <= columns["status"] {
+			continue
+		}
+
+		name := record[columns["svname"]]
+		if name == "FRONTEND" || name == "BACKEND" {
+			continue
+		}
+
+		// e.g. "UP", "UP 1/3" while going down, or "no check"
+		status := record[columns["status"]]
+		servers = append(servers, lbServer{
+			Backend: record[columns["pxname"]],
+			Name:    name,
+			Status:  status,
+			Up:      strings.HasPrefix(status, "UP") || status == "no check",
+		})
+	}
+
+	return servers, nil
+}
+
+// parseNginx parses the JSON status of the nginx upstream-check module, or
+// the upstreams of the nginx Plus API.
+func (s *LBSTATUSTest) parseNginx(body []byte) ([]lbServer, error) {
+	var servers []lbServer
+
+	//
+	// The upstream-check module reports a flat list of servers.
+	//
+	var check struct {
+		Servers *struct {
+			Server []struct {
+				Upstream string `json:"upstream"`
+				Name     string `json:"name"`
+				Status   string `json:"status"`
+			} `json:"server"`
+		} `json:"servers"`
+	}
+	if err := json.Unmarshal(body, &check); err != nil {
+		return nil, fmt.Errorf("invalid nginx status: %w", err)
+	}
+
+	if check.Servers != nil {
+		for _, server := range check.Servers.Server {
+			servers = append(servers, lbServer{
+				Backend: server.Upstream,
+				Name:    server.Name,
+				Status:  server.Status,
+				Up:      server.Status == "up",
+			})
+		}
+		return servers, nil
+	}
+
+	//
+	// The Plus API reports the peers of each upstream.
+	//
+	var plus map[string]struct {
+		Peers []struct {
+			Server string `json:"server"`
+			Name   string `json:"name"`
+			State  string `json:"state"`
+		} `json:"peers"`
+	}
+	if err := json.Unmarshal(body, &plus); err != nil {
+		return nil, fmt.Errorf("invalid nginx status: %w", err)
+	}
+
+	for upstream, status := range plus {
+		for _, peer := range status.Peers {
+			name := peer.Name
+			if name == "" {
+				name = peer.Server
+			}
+
+			servers = append(servers, lbServer{
+				Backend: upstream,
+				Name:    name,
+				Status:  peer.State,
+				Up:      peer.State == "up",
+			})
+		}
+	}
+
+	return servers, nil
+}
+
+func (s *LBSTATUSTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("lb-status", func() ProtocolTest {
+		return &LBSTATUSTest{}
+	})
+}
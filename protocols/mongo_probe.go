@@ -0,0 +1,233 @@
+// MongoDB Tester
+//
+// The MongoDB tester connects to a MongoDB server, and ensures that it
+// answers a "ping" command.
+//
+// This test is invoked via input like so:
+//
+//    mongo.example.com must run mongodb [with port 27017]
+//
+// Credentials may be given if the server requires them, which are checked
+// against the given database, or "admin" if there is none:
+//
+//    mongo.example.com must run mongodb with username 'probe' with password 'secret' with database 'shop'
+//
+// To ensure that a collection holds documents specify its name, along with
+// the database it is in:
+//
+//    mongo.example.com must run mongodb with username 'probe' with password 'secret' with database 'shop' with collection 'orders'
+//
+// Servers can be connected to via TLS, which may also disable certificate
+// validation:
+//
+//    mongo.example.com must run mongodb with tls true
+//    mongo.example.com must run mongodb with tls insecure
+//
+
+package protocols
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cmaster11/overseer/test"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MONGODBTest is our object
+type MONGODBTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *MONGODBTest) Arguments() map[string]string {
+	known := map[string]string{
+		"collection": ".*",
+		"database":   ".*",
+		"password":   ".*",
+		"port":       "^[0-9]+$",
+		"tls":        "^(true|insecure)$",
+		"username":   ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *MONGODBTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *MONGODBTest) Example() string {
+	str := `
+MongoDB Tester
+--------------
+ The MongoDB tester connects to a MongoDB server, and ensures that it
+ answers a "ping" command.
+
+ This test is invoked via input like so:
+
+    mongo.example.com must run mongodb [with port 27017]
+
+ Credentials may be given if the server requires them, which are checked
+ against the given database, or "admin" if there is none:
+
+    mongo.example.com must run mongodb with username 'probe' with password 'secret' with database 'shop'
+
+ To ensure that a collection holds documents specify its name, along with
+ the database it is in:
+
+    mongo.example.com must run mongodb with username 'probe' with password 'secret' with database 'shop' with collection 'orders'
+
+ Servers can be connected to via TLS, which may also disable certificate
+ validation:
+
+    mongo.example.com must run mongodb with tls true
+    mongo.example.com must run mongodb with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we connect, ping the server, and optionally count the
+// documents of the collection.
+func (s *MONGODBTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	if tst.Arguments["collection"] != "" && tst.Arguments["database"] == "" {
+		return errors.New("a collection can only be checked along with its database")
+	}
+
+	//
+	// The default port to connect to.
+	//
+	port := 27017
+
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// Default to connecting to an IPv4-address
+	//
+	address := fmt.Sprintf("%s:%d", target, port)
+
+	//
+	// If we find a ":" we know it is an IPv6 address though
+	//
+	if strings.Contains(target, ":") {
+		address = fmt.Sprintf("[%s]:%d", target, port)
+	}
+
+	database := "admin"
+	if tst.Arguments["database"] != "" {
+		database = tst.Arguments["database"]
+	}
+
+	//
+	// We connect to this server alone, rather than to the replica-set
+	// it may be a member of.
+	//
+	clientOptions := options.Client().
+		SetHosts([]string{address}).
+		SetDirect(true).
+		SetAppName("overseer")
+
+	if opts.Timeout > 0 {
+		clientOptions.SetConnectTimeout(opts.Timeout).SetServerSelectionTimeout(opts.Timeout)
+	}
+
+	if tst.Arguments["username"] != "" {
+		clientOptions.SetAuth(options.Credential{
+			Username:   tst.Arguments["username"],
+			Password:   tst.Arguments["password"],
+			AuthSource: database,
+		})
+	}
+
+	if tst.Arguments["tls"] != "" {
+		clientOptions.SetTLSConfig(&tls.Config{
+			ServerName:         tst.Target,
+			InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+		})
+	}
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return err
+	}
+
+	//
+	// The client must be disconnected even if we've run out of time,
+	// so it is given a context of its own.
+	//
+	defer func() {
+		disconnectCtx := context.Background()
+		if opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			disconnectCtx, cancel = context.WithTimeout(disconnectCtx, opts.Timeout)
+			defer cancel()
+		}
+		client.Disconnect(disconnectCtx)
+	}()
+
+	err = client.Database(database).RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Err()
+	if err != nil {
+		return fmt.Errorf("ping failed: %w", err)
+	}
+
+	opts.Tracef("MongoDB server %s answered our ping", address)
+
+	if tst.Arguments["collection"] == "" {
+		return nil
+	}
+
+	//
+	// The estimate comes from the metadata of the collection, so is
+	// cheap even for huge ones.
+	//
+	count, err := client.Database(database).Collection(tst.Arguments["collection"]).EstimatedDocumentCount(ctx)
+	if err != nil {
+		return fmt.Errorf("counting the documents of '%s' failed: %w", tst.Arguments["collection"], err)
+	}
+
+	opts.Tracef("The collection '%s' has %d documents", tst.Arguments["collection"], count)
+
+	if count == 0 {
+		return fmt.Errorf("the collection '%s' of database '%s' is empty", tst.Arguments["collection"], database)
+	}
+
+	return nil
+}
+
+func (s *MONGODBTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("mongodb", func() ProtocolTest {
+		return &MONGODBTest{}
+	})
+}
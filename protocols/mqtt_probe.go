@@ -0,0 +1,238 @@
+// MQTT Tester
+//
+// The MQTT tester connects to an MQTT broker, and ensures that it accepts
+// our connection.
+//
+// This test is invoked via input like so:
+//
+//    mqtt.example.com must run mqtt [with port 1883]
+//
+// Credentials may be given if the broker requires them:
+//
+//    mqtt.example.com must run mqtt with username 'probe' with password 'secret'
+//
+// To check that messages are actually delivered a topic may be given,
+// which a message is published to, and must be received from:
+//
+//    mqtt.example.com must run mqtt with topic 'overseer/probe'
+//
+// Brokers can be connected to via TLS, on port 8883 by default, which may
+// also disable certificate validation:
+//
+//    mqtt.example.com must run mqtt with tls true
+//    mqtt.example.com must run mqtt with tls insecure
+//
+
+package protocols
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTTTest is our object.
+type MQTTTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *MQTTTest) Arguments() map[string]string {
+	known := map[string]string{
+		"password": ".*",
+		"port":     "^[0-9]+$",
+		"tls":      "^(true|insecure)$",
+		"topic":    `^[^#+]+$`,
+		"username": ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *MQTTTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *MQTTTest) Example() string {
+	str := `
+MQTT Tester
+-----------
+ The MQTT tester connects to an MQTT broker, and ensures that it accepts
+ our connection.
+
+ This test is invoked via input like so:
+
+    mqtt.example.com must run mqtt [with port 1883]
+
+ Credentials may be given if the broker requires them:
+
+    mqtt.example.com must run mqtt with username 'probe' with password 'secret'
+
+ To check that messages are actually delivered a topic may be given,
+ which a message is published to, and must be received from:
+
+    mqtt.example.com must run mqtt with topic 'overseer/probe'
+
+ Brokers can be connected to via TLS, on port 8883 by default, which may
+ also disable certificate validation:
+
+    mqtt.example.com must run mqtt with tls true
+    mqtt.example.com must run mqtt with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we connect, and optionally exchange a message.
+func (s *MQTTTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	scheme := "tcp"
+	port := 1883
+	if tst.Arguments["tls"] != "" {
+		scheme = "ssl"
+		port = 8883
+	}
+
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// Default to connecting to an IPv4-address
+	//
+	address := fmt.Sprintf("%s://%s:%d", scheme, target, port)
+
+	//
+	// If we find a ":" we know it is an IPv6 address though
+	//
+	if strings.Contains(target, ":") {
+		address = fmt.Sprintf("%s://[%s]:%d", scheme, target, port)
+	}
+
+	//
+	// Each connection needs a unique client ID, or the broker will
+	// disconnect one of the clients using it.
+	//
+	tag := make([]byte, 8)
+	if _, err = rand.Read(tag); err != nil {
+		return err
+	}
+	id := "overseer-" + hex.EncodeToString(tag)
+
+	options := mqtt.NewClientOptions().
+		AddBroker(address).
+		SetClientID(id).
+		SetProtocolVersion(4).
+		SetCleanSession(true).
+		SetAutoReconnect(false).
+		SetConnectTimeout(opts.Timeout).
+		SetWriteTimeout(opts.Timeout).
+		SetUsername(tst.Arguments["username"]).
+		SetPassword(tst.Arguments["password"])
+
+	if tst.Arguments["tls"] != "" {
+		options.SetTLSConfig(&tls.Config{
+			ServerName:         tst.Target,
+			InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+		})
+	}
+
+	client := mqtt.NewClient(options)
+
+	token := client.Connect()
+	if !s.wait(token, opts.Timeout) {
+		return fmt.Errorf("the broker didn't accept our connection within %s", opts.Timeout)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("connecting to the broker failed: %s", token.Error())
+	}
+	defer client.Disconnect(250)
+
+	opts.Tracef("Connected to MQTT broker %s", address)
+
+	if tst.Arguments["topic"] == "" {
+		return nil
+	}
+
+	return s.roundTrip(client, tst.Arguments["topic"], id, opts)
+}
+
+// roundTrip subscribes to the topic, publishes a message to it, and waits
+// for the message to be delivered back to us.
+func (s *MQTTTest) roundTrip(client mqtt.Client, topic string, payload string, opts test.Options) error {
+
+	received := make(chan struct{}, 1)
+	token := client.Subscribe(topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		// Others may publish to the topic too
+		if string(msg.Payload()) == payload {
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if !s.wait(token, opts.Timeout) {
+		return fmt.Errorf("the subscription to '%s' wasn't acknowledged within %s", topic, opts.Timeout)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("subscribing to '%s' failed: %s", topic, token.Error())
+	}
+
+	token = client.Publish(topic, 1, false, payload)
+	if !s.wait(token, opts.Timeout) {
+		return fmt.Errorf("the message published to '%s' wasn't acknowledged within %s", topic, opts.Timeout)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("publishing to '%s' failed: %s", topic, token.Error())
+	}
+
+	var timeout <-chan time.Time
+	if opts.Timeout > 0 {
+		timeout = time.After(opts.Timeout)
+	}
+
+	select {
+	case <-received:
+		opts.Tracef("Received the message published to '%s'", topic)
+		return nil
+	case <-timeout:
+		return fmt.Errorf("the message published to '%s' wasn't received within %s", topic, opts.Timeout)
+	}
+}
+
+// wait waits for the token to complete, returning false if it didn't
+// within the timeout.
+func (s *MQTTTest) wait(token mqtt.Token, timeout time.Duration) bool {
+	if timeout <= 0 {
+		return token.Wait()
+	}
+	return token.WaitTimeout(timeout)
+}
+
+func (s *MQTTTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("mqtt", func() ProtocolTest {
+		return &MQTTTest{}
+	})
+}
@@ -8,15 +8,32 @@
 // Specifying a username and password is mandatory, because otherwise we
 // cannot connect to the database.
 //
+// Once connected a query is executed, by default "SELECT 1", to ensure
+// the server is really serving queries.  You may specify the database
+// to use, and the query to run:
+//
+//    host.example.com must run mysql with username 'root' with password 'test' with database 'shop' with query 'SELECT COUNT(*) FROM orders'
+//
+// The test fails if the query fails, or returns no rows.
+//
+// To ensure that a replica isn't falling behind its primary, the
+// "Seconds_Behind_Master" it reports may be limited.  The test fails if
+// the server isn't a replica, replication isn't running, or the lag is
+// larger:
+//
+//    replica.example.com must run mysql with username 'root' with password 'test' with max-lag 30s
+//
 
 package protocols
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
 	"github.com/go-sql-driver/mysql"
@@ -34,6 +51,9 @@ func (s *MYSQLTest) Arguments() map[string]string {
 		"port":     "^[0-9]+$",
 		"username": ".*",
 		"password": ".*",
+		"database": ".*",
+		"query":    ".*",
+		"max-lag":  `^([0-9]+(\.[0-9]*)?(ms|s|m|h))+$`,
 	}
 	return known
 }
@@ -55,6 +75,21 @@ MySQL Tester
 
  Specifying a username and password is mandatory, because otherwise we
  cannot connect to the database.
+
+ Once connected a query is executed, by default "SELECT 1", to ensure
+ the server is really serving queries.  You may specify the database
+ to use, and the query to run:
+
+    host.example.com must run mysql with username 'root' with password 'test' with database 'shop' with query 'SELECT COUNT(*) FROM orders'
+
+ The test fails if the query fails, or returns no rows.
+
+ To ensure that a replica isn't falling behind its primary, the
+ "Seconds_Behind_Master" it reports may be limited.  The test fails if
+ the server isn't a replica, replication isn't running, or the lag is
+ larger:
+
+    replica.example.com must run mysql with username 'root' with password 'test' with max-lag 30s
 `
 	return str
 }
@@ -62,8 +97,8 @@ MySQL Tester
 // RunTest is the part of our API which is invoked to actually execute a
 // test against the given target.
 //
-// In this case we make a TCP connection to the host and attempt to login
-// with the specified username & password.
+// In this case we make a TCP connection to the host, attempt to login
+// with the specified username & password, and run a query.
 func (s *MYSQLTest) RunTest(tst test.Test, target string, opts test.Options) error {
 	var err error
 
@@ -74,6 +109,14 @@ func (s *MYSQLTest) RunTest(tst test.Test, target string, opts test.Options) err
 		return errors.New("no username specified")
 	}
 
+	var maxLag time.Duration
+	if tst.Arguments["max-lag"] != "" {
+		maxLag, err = time.ParseDuration(tst.Arguments["max-lag"])
+		if err != nil {
+			return err
+		}
+	}
+
 	//
 	// The default port to connect to.
 	//
@@ -101,6 +144,7 @@ func (s *MYSQLTest) RunTest(tst test.Test, target string, opts test.Options) err
 	//
 	config.User = tst.Arguments["username"]
 	config.Passwd = tst.Arguments["password"]
+	config.DBName = tst.Arguments["database"]
 
 	//
 	// Default to connecting to an IPv4-address
@@ -143,10 +187,120 @@ func (s *MYSQLTest) RunTest(tst test.Test, target string, opts test.Options) err
 	defer db.Close()
 
 	//
-	// And test that the connection actually worked.
+	// We only ever want the single connection, which is closed
+	// along with the database.
+	//
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(0)
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	//
+	// Test that the connection actually worked.
+	//
+	err = db.PingContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Now run the query, which must return at least one row.
+	//
+	query := "SELECT 1"
+	if tst.Arguments["query"] != "" {
+		query = tst.Arguments["query"]
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("query '%s' returned no rows", query)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	//
+	// The single connection is needed for the lag, so release it.
+	//
+	rows.Close()
+
+	if tst.Arguments["max-lag"] != "" {
+		return s.checkLag(ctx, db, maxLag, opts)
+	}
+	return nil
+}
+
+// checkLag ensures the server is a replica, which isn't lagging behind
+// its primary by more than the given duration.
+func (s *MYSQLTest) checkLag(ctx context.Context, db *sql.DB, maxLag time.Duration, opts test.Options) error {
+	rows, err := db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		return fmt.Errorf("querying the replication lag failed: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("querying the replication lag failed: %w", err)
+		}
+		return errors.New("the server isn't a replica, so has no replication lag")
+	}
+
+	//
+	// The status has dozens of columns, which vary between versions,
+	// so we pick those we want by name.
 	//
-	err = db.Ping()
-	return err
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	values := make([]sql.NullString, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err = rows.Scan(pointers...); err != nil {
+		return err
+	}
+
+	status := make(map[string]sql.NullString)
+	for i, column := range columns {
+		status[column] = values[i]
+	}
+
+	behind := status["Seconds_Behind_Master"]
+	if !behind.Valid {
+		return fmt.Errorf("replication isn't running (Slave_IO_Running: %s, Slave_SQL_Running: %s)",
+			status["Slave_IO_Running"].String, status["Slave_SQL_Running"].String)
+	}
+
+	seconds, err := strconv.Atoi(behind.String)
+	if err != nil {
+		return fmt.Errorf("invalid Seconds_Behind_Master '%s'", behind.String)
+	}
+	lag := time.Duration(seconds) * time.Second
+
+	opts.Tracef("Replication lag is %s", lag)
+
+	if lag > maxLag {
+		return fmt.Errorf("replication lag is %s, more than the maximum of %s", lag, maxLag)
+	}
+	return nil
 }
 
 func (s *MYSQLTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
@@ -0,0 +1,322 @@
+// NATS Tester
+//
+// The NATS tester connects to a NATS server, and ensures that it accepts
+// our connection.
+//
+// This test is invoked via input like so:
+//
+//    host.example.com must run nats [with port 4222]
+//
+// Credentials, or a token, may be given if the server requires them:
+//
+//    host.example.com must run nats with username 'probe' with password 'secret'
+//    host.example.com must run nats with token 's3cr3t'
+//
+// To check that messages are actually delivered a subject may be given,
+// which a message is published to, and must be received from:
+//
+//    host.example.com must run nats with subject 'overseer.probe'
+//
+// Servers which require TLS are connected to via TLS automatically, and
+// it can be required via the tls setting, which may also disable
+// certificate validation:
+//
+//    host.example.com must run nats with tls true
+//    host.example.com must run nats with tls insecure
+//
+
+package protocols
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// NATSTest is our object.
+type NATSTest struct {
+}
+
+// natsInfo is the part of the INFO the server greets us with which we
+// care about.
+type natsInfo struct {
+	ServerID    string `json:"server_id"`
+	Version     string `json:"version"`
+	TLSRequired bool   `json:"tls_required"`
+}
+
+// natsConnect is the CONNECT we send to the server.
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	Protocol int    `json:"protocol"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *NATSTest) Arguments() map[string]string {
+	known := map[string]string{
+		"password": ".*",
+		"port":     "^[0-9]+$",
+		"subject":  `^[^\s*>]+$`,
+		"tls":      "^(true|insecure)$",
+		"token":    ".*",
+		"username": ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *NATSTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *NATSTest) Example() string {
+	str := `
+NATS Tester
+-----------
+ The NATS tester connects to a NATS server, and ensures that it accepts
+ our connection.
+
+ This test is invoked via input like so:
+
+    host.example.com must run nats [with port 4222]
+
+ Credentials, or a token, may be given if the server requires them:
+
+    host.example.com must run nats with username 'probe' with password 'secret'
+    host.example.com must run nats with token 's3cr3t'
+
+ To check that messages are actually delivered a subject may be given,
+ which a message is published to, and must be received from:
+
+    host.example.com must run nats with subject 'overseer.probe'
+
+ Servers which require TLS are connected to via TLS automatically, and
+ it can be required via the tls setting, which may also disable
+ certificate validation:
+
+    host.example.com must run nats with tls true
+    host.example.com must run nats with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we connect, and optionally exchange a message.
+func (s *NATSTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	port := 4222
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// The address to connect to, with IPv6 addresses in brackets
+	//
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	d := net.Dialer{Timeout: opts.Timeout}
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	//
+	// The server greets us with its INFO.
+	//
+	reader := bufio.NewReader(conn)
+	line, err := s.readLine(reader)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting '%s', this doesn't look like a NATS server", line)
+	}
+
+	var info natsInfo
+	if err = json.Unmarshal([]byte(line[5:]), &info); err != nil {
+		return fmt.Errorf("invalid INFO from the server: %w", err)
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tConnected to NATS server %s, version %s\n", info.ServerID, info.Version)
+	}
+
+	//
+	// Upgrade to TLS, if either side wants it.
+	//
+	var rw io.ReadWriter = conn
+	if tst.Arguments["tls"] != "" || info.TLSRequired {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName:         tst.Target,
+			InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+		})
+		if err = tlsConn.Handshake(); err != nil {
+			return err
+		}
+		rw = tlsConn
+		reader = bufio.NewReader(tlsConn)
+	}
+
+	connect, err := json.Marshal(natsConnect{
+		Name:     "overseer",
+		Lang:     "go",
+		Version:  "1.0.0",
+		Protocol: 1,
+		User:     tst.Arguments["username"],
+		Pass:     tst.Arguments["password"],
+		Token:    tst.Arguments["token"],
+	})
+	if err != nil {
+		return err
+	}
+
+	//
+	// A PING after our CONNECT is answered with a PONG only once we've
+	// been accepted, otherwise we'll get an error.
+	//
+	if _, err = fmt.Fprintf(rw, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		return err
+	}
+	if err = s.awaitPong(rw, reader); err != nil {
+		return err
+	}
+
+	if tst.Arguments["subject"] == "" {
+		return nil
+	}
+
+	return s.roundTrip(rw, reader, tst.Arguments["subject"], opts)
+}
+
+// roundTrip subscribes to the subject, publishes a message to it, and
+// waits for the message to be delivered back to us.
+func (s *NATSTest) roundTrip(rw io.ReadWriter, reader *bufio.Reader, subject string, opts test.Options) error {
+	tag := make([]byte, 8)
+	if _, err := rand.Read(tag); err != nil {
+		return err
+	}
+	payload := "overseer-" + hex.EncodeToString(tag)
+
+	_, err := fmt.Fprintf(rw, "SUB %s 1\r\nPUB %s %d\r\n%s\r\n", subject, subject, len(payload), payload)
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, err := s.readLine(reader)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return fmt.Errorf("the message published to '%s' wasn't received within %s", subject, opts.Timeout)
+			}
+			return err
+		}
+
+		switch {
+		case line == "PING":
+			if _, err = io.WriteString(rw, "PONG\r\n"); err != nil {
+				return err
+			}
+
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("the server refused the message: %s", strings.TrimSpace(line[4:]))
+
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return fmt.Errorf("invalid message header '%s'", line)
+			}
+
+			body := make([]byte, size+2)
+			if _, err = io.ReadFull(reader, body); err != nil {
+				return err
+			}
+
+			// Others may publish to the subject too
+			if string(body[:size]) == payload {
+				if opts.Verbose {
+					fmt.Printf("\tReceived the message published to '%s'\n", subject)
+				}
+				return nil
+			}
+		}
+	}
+}
+
+// awaitPong waits for the server to answer our PING.
+func (s *NATSTest) awaitPong(rw io.ReadWriter, reader *bufio.Reader) error {
+	for {
+		line, err := s.readLine(reader)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err = io.WriteString(rw, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			// e.g. -ERR 'Authorization Violation'
+			return fmt.Errorf("the server refused the connection: %s", strings.Trim(strings.TrimSpace(line[4:]), "'"))
+		}
+	}
+}
+
+// readLine reads a single line of the protocol, without its CRLF.
+func (s *NATSTest) readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (s *NATSTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("nats", func() ProtocolTest {
+		return &NATSTest{}
+	})
+}
@@ -0,0 +1,205 @@
+// NTP Tester
+//
+// The NTP tester queries a remote NTP server, and ensures that a valid
+// response is received.
+//
+// This test is invoked via input like so:
+//
+//    ntp.example.com must run ntp
+//
+// To also alert when the time reported by the server differs from the
+// local clock by too much, specify the maximum allowed offset:
+//
+//    ntp.example.com must run ntp with max-offset 500ms
+//
+
+package protocols
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// NTPTest is our object
+type NTPTest struct {
+}
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900)
+// and the unix one (1970).
+const ntpEpochOffset = 2208988800
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *NTPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"port":       "^[0-9]+$",
+		"max-offset": `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *NTPTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *NTPTest) Example() string {
+	str := `
+NTP Tester
+----------
+ The NTP tester queries a remote NTP server, and ensures that a valid
+ response is received.
+
+ This test is invoked via input like so:
+
+    ntp.example.com must run ntp
+
+ To also alert when the time reported by the server differs from the
+ local clock by too much, specify the maximum allowed offset:
+
+    ntp.example.com must run ntp with max-offset 500ms
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we send a SNTP client request, and compare the timestamps
+// of the reply against our local clock.
+func (s *NTPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	//
+	// The default port to connect to.
+	//
+	port := 123
+
+	//
+	// If the user specified a different port update to use it.
+	//
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	var maxOffset time.Duration
+	if tst.Arguments["max-offset"] != "" {
+		maxOffset, err = time.ParseDuration(tst.Arguments["max-offset"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// The address to connect to, with IPv6 addresses in brackets
+	//
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	//
+	// A client request: leap indicator 0, version 4, mode 3 (client).
+	//
+	request := make([]byte, 48)
+	request[0] = 0<<6 | 4<<3 | 3
+
+	sent := time.Now()
+	if _, err = conn.Write(request); err != nil {
+		return err
+	}
+
+	response := make([]byte, 48)
+	n, err := conn.Read(response)
+	if err != nil {
+		if errNet, ok := err.(net.Error); ok && errNet.Timeout() {
+			return fmt.Errorf("no NTP response received within %s", opts.Timeout)
+		}
+		return err
+	}
+	received := time.Now()
+
+	if n < 48 {
+		return fmt.Errorf("NTP response too short (%d bytes)", n)
+	}
+
+	//
+	// Mode 4 is a server-reply, and stratum 0 is a kiss-of-death packet.
+	//
+	if response[0]&0x07 != 4 {
+		return fmt.Errorf("unexpected NTP mode %d in response", response[0]&0x07)
+	}
+	if response[1] == 0 {
+		return fmt.Errorf("server sent a kiss-of-death packet (%s)", strings.TrimRight(string(response[12:16]), "\x00"))
+	}
+	if response[0]>>6 == 3 {
+		return errors.New("server reports its clock is unsynchronized")
+	}
+
+	//
+	// Compute the clock offset from the server receive and transmit
+	// timestamps, as per RFC 4330.
+	//
+	serverReceive := s.timestamp(response[32:40])
+	serverTransmit := s.timestamp(response[40:48])
+	offset := (serverReceive.Sub(sent) + serverTransmit.Sub(received)) / 2
+
+	if opts.Verbose {
+		fmt.Printf("\tNTP offset is %s\n", offset)
+	}
+
+	if maxOffset > 0 {
+		absOffset := offset
+		if absOffset < 0 {
+			absOffset = -absOffset
+		}
+
+		if absOffset > maxOffset {
+			return fmt.Errorf("clock offset %s exceeds the maximum of %s", offset, maxOffset)
+		}
+	}
+
+	return nil
+}
+
+// timestamp converts a 64-bit NTP timestamp to a time.
+func (s *NTPTest) timestamp(data []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint32(data[0:4])) - ntpEpochOffset
+	fraction := int64(binary.BigEndian.Uint32(data[4:8]))
+	nanoseconds := (fraction * int64(time.Second)) >> 32
+	return time.Unix(seconds, nanoseconds)
+}
+
+func (s *NTPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("ntp", func() ProtocolTest {
+		return &NTPTest{}
+	})
+}
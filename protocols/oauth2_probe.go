@@ -0,0 +1,240 @@
+// OAuth2 Tester
+//
+// The OAuth2 tester performs a client-credentials grant against a token
+// endpoint, and ensures that an access token is issued.
+//
+// This test is invoked via input like so:
+//
+//    https://auth.example.com/oauth/token must run oauth2 with client-id 'probe' with client-secret 'secret'
+//
+// The client credentials are sent via HTTP basic-authentication, as
+// RFC 6749 recommends, but some servers only accept them in the body of
+// the request:
+//
+//    https://auth.example.com/oauth/token must run oauth2 with client-id 'probe' with client-secret 'secret' with auth post
+//
+// A scope, and an audience, may be requested too:
+//
+//    https://auth.example.com/oauth/token must run oauth2 with client-id 'probe' with client-secret 'secret' with scope 'read:status' with audience 'https://api.example.com'
+//
+// To catch misconfigured token lifetimes the token can be required to
+// remain valid for at least a period of time:
+//
+//    https://auth.example.com/oauth/token must run oauth2 with client-id 'probe' with client-secret 'secret' with min-expiry 5m
+//
+// If you need to disable failures due to expired, broken, or otherwise
+// bogus TLS certificates you can do so via the tls setting:
+//
+//    https://auth.example.com/oauth/token must run oauth2 with client-id 'probe' with client-secret 'secret' with tls insecure
+//
+
+package protocols
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// OAUTH2Test is our object.
+type OAUTH2Test struct {
+}
+
+// oauth2Response is the response of a token endpoint, successful or not.
+type oauth2Response struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   *int64 `json:"expires_in"`
+
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *OAUTH2Test) Arguments() map[string]string {
+	known := map[string]string{
+		"audience":      ".*",
+		"auth":          "^(basic|post)$",
+		"client-id":     ".*",
+		"client-secret": ".*",
+		"min-expiry":    expiryArgument,
+		"scope":         ".*",
+		"tls":           "insecure",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *OAUTH2Test) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *OAUTH2Test) Example() string {
+	str := `
+OAuth2 Tester
+-------------
+ The OAuth2 tester performs a client-credentials grant against a token
+ endpoint, and ensures that an access token is issued.
+
+ This test is invoked via input like so:
+
+    https://auth.example.com/oauth/token must run oauth2 with client-id 'probe' with client-secret 'secret'
+
+ The client credentials are sent via HTTP basic-authentication, as
+ RFC 6749 recommends, but some servers only accept them in the body of
+ the request:
+
+    https://auth.example.com/oauth/token must run oauth2 with client-id 'probe' with client-secret 'secret' with auth post
+
+ A scope, and an audience, may be requested too:
+
+    https://auth.example.com/oauth/token must run oauth2 with client-id 'probe' with client-secret 'secret' with scope 'read:status' with audience 'https://api.example.com'
+
+ To catch misconfigured token lifetimes the token can be required to
+ remain valid for at least a period of time:
+
+    https://auth.example.com/oauth/token must run oauth2 with client-id 'probe' with client-secret 'secret' with min-expiry 5m
+
+ If you need to disable failures due to expired, broken, or otherwise
+ bogus TLS certificates you can do so via the tls setting:
+
+    https://auth.example.com/oauth/token must run oauth2 with client-id 'probe' with client-secret 'secret' with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we request a token, and inspect the response.
+func (s *OAUTH2Test) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	u, err := url.Parse(tst.Target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("the target must be a http:// or https:// URL, got '%s'", tst.Target)
+	}
+
+	if tst.Arguments["client-id"] == "" {
+		return errors.New("you must specify the client-id when running an oauth2 test")
+	}
+
+	var minExpiry time.Duration
+	if tst.Arguments["min-expiry"] != "" {
+		minExpiry, err = time.ParseDuration(tst.Arguments["min-expiry"])
+		if err != nil {
+			return err
+		}
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if tst.Arguments["scope"] != "" {
+		form.Set("scope", tst.Arguments["scope"])
+	}
+	if tst.Arguments["audience"] != "" {
+		form.Set("audience", tst.Arguments["audience"])
+	}
+	if tst.Arguments["auth"] == "post" {
+		form.Set("client_id", tst.Arguments["client-id"])
+		form.Set("client_secret", tst.Arguments["client-secret"])
+	}
+
+	req, err := http.NewRequest("POST", tst.Target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	if tst.Arguments["auth"] != "post" {
+		// The credentials are form-encoded before being joined, as per RFC 6749
+		req.SetBasicAuth(url.QueryEscape(tst.Arguments["client-id"]), url.QueryEscape(tst.Arguments["client-secret"]))
+	}
+
+	client := newPinnedHTTPClient(target, tst.Arguments["tls"] == "insecure", opts.Timeout)
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxHTTPBodySize))
+	if err != nil {
+		return err
+	}
+
+	var token oauth2Response
+	jsonErr := json.Unmarshal(body, &token)
+
+	//
+	// Errors are described by the error code, e.g. "invalid_client".
+	//
+	if response.StatusCode != http.StatusOK {
+		if jsonErr == nil && token.Error != "" {
+			if token.ErrorDescription != "" {
+				return fmt.Errorf("token request failed with %s: %s (status code %d)", token.Error, token.ErrorDescription, response.StatusCode)
+			}
+			return fmt.Errorf("token request failed with %s (status code %d)", token.Error, response.StatusCode)
+		}
+		return fmt.Errorf("status code was %d not %d", response.StatusCode, http.StatusOK)
+	}
+
+	if jsonErr != nil {
+		return fmt.Errorf("invalid token response: %s", jsonErr.Error())
+	}
+	if token.Error != "" {
+		return fmt.Errorf("token request failed with %s", token.Error)
+	}
+	if token.AccessToken == "" {
+		return errors.New("the response contained no access_token")
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tReceived a '%s' token\n", token.TokenType)
+		if token.ExpiresIn != nil {
+			fmt.Printf("\tThe token expires in %s\n", time.Duration(*token.ExpiresIn)*time.Second)
+		}
+	}
+
+	if minExpiry > 0 {
+		if token.ExpiresIn == nil {
+			return errors.New("the response contained no expires_in")
+		}
+
+		expiresIn := time.Duration(*token.ExpiresIn) * time.Second
+		if expiresIn < minExpiry {
+			return fmt.Errorf("the token expires in %s, which is less than %s", expiresIn, minExpiry)
+		}
+	}
+
+	return nil
+}
+
+func (s *OAUTH2Test) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("oauth2", func() ProtocolTest {
+		return &OAUTH2Test{}
+	})
+}
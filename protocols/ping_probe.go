@@ -1,86 +1,62 @@
 // Ping Tester
 //
-// The ping tester invokes the system 'ping' and 'ping6' binaries to carry
-// out a ping-test against a remote host.
-//
-// If the system-binary reports a failure, via its exit-code, then this test
-// fails.
+// The ping tester sends ICMP echo requests to a remote host, and fails if
+// none of them are answered before the timeout.
 //
 // This test is invoked via input like so:
 //
 //    host.example.com must run ping
+//
+// By default three echo requests are sent, but you can change that, and
+// also fail the test if too many of them were lost:
+//
+//    host.example.com must run ping with count 10 with max-loss 20%
+//
+// Sending ICMP requires either an unprivileged ICMP socket, which on
+// Linux must be allowed via the sysctl "net.ipv4.ping_group_range", or
+// the privilege to open raw sockets (i.e. running as root, or with the
+// CAP_NET_RAW capability).  The former is tried first.
+//
 
 package protocols
 
 import (
 	"bytes"
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"net"
-	"os/exec"
-	"syscall"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
+	"github.com/cmaster11/overseer/utils"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // PINGTest is our object.
 type PINGTest struct {
 }
 
+// pingInterval is the longest we wait between sending echo requests.
+const pingInterval = time.Second
+
 // ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
 func (s *PINGTest) ShouldResolveHostname() bool {
 	return true
 }
 
-// RunCommand invokes an external binary and returns stdout/stderr/exit-code
-func (s *PINGTest) RunCommand(name string, args ...string) (stdout string, stderr string, exitCode int) {
-	var outbuf, errbuf bytes.Buffer
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = &outbuf
-	cmd.Stderr = &errbuf
-
-	err := cmd.Run()
-	stdout = outbuf.String()
-	stderr = errbuf.String()
-
-	if err != nil {
-		// try to get the exit code
-		if exitError, ok := err.(*exec.ExitError); ok {
-			ws := exitError.Sys().(syscall.WaitStatus)
-			exitCode = ws.ExitStatus()
-		} else {
-			exitCode = 1
-			if stderr == "" {
-				stderr = err.Error()
-			}
-		}
-	} else {
-		// success, exitCode should be 0 if go is ok
-		ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
-		exitCode = ws.ExitStatus()
-	}
-	return
-}
-
-// Ping4 runs a ping test against an IPv4 address, returning true
-// if the ping succeeded.
-func (s *PINGTest) Ping4(target string) bool {
-
-	_, _, ret := s.RunCommand("ping4", "-c", "1", "-w", "4", "-W", "4", target)
-	return (ret == 0)
-}
-
-// Ping6 runs a ping test against an IPv6 address, returning true
-// if the ping succeeded.
-func (s *PINGTest) Ping6(target string) bool {
-	_, _, ret := s.RunCommand("ping6", "-c", "1", "-w", "4", "-W", "4", target)
-	return (ret == 0)
-}
-
 // Arguments returns the names of arguments which this protocol-test
 // understands, along with corresponding regular-expressions to validate
 // their values.
 func (s *PINGTest) Arguments() map[string]string {
-	known := map[string]string{}
+	known := map[string]string{
+		"count":    "^[1-9][0-9]*$",
+		"max-loss": `^\d+(\.\d+)?%$`,
+	}
 	return known
 }
 
@@ -89,51 +65,219 @@ func (s *PINGTest) Example() string {
 	str := `
 Ping Tester
 -----------
- The ping tester invokes the system 'ping' and 'ping6' binaries to carry
- out a ping-test against a remote host.
-
- If the system-binary reports a failure, via its exit-code, then this test
- fails.
+ The ping tester sends ICMP echo requests to a remote host, and fails if
+ none of them are answered before the timeout.
 
  This test is invoked via input like so:
 
     host.example.com must run ping
+
+ By default three echo requests are sent, but you can change that, and
+ also fail the test if too many of them were lost:
+
+    host.example.com must run ping with count 10 with max-loss 20%
+
+ Sending ICMP requires either an unprivileged ICMP socket, which on
+ Linux must be allowed via the sysctl "net.ipv4.ping_group_range", or
+ the privilege to open raw sockets (i.e. running as root, or with the
+ CAP_NET_RAW capability).  The former is tried first.
 `
 	return str
 }
 
+// listen opens an ICMP socket for the given address-family, preferring an
+// unprivileged one.
+//
+// It returns the connection, and whether it is a raw socket.
+func (s *PINGTest) listen(ip net.IP) (*icmp.PacketConn, bool, error) {
+	unprivileged, privileged, address := "udp4", "ip4:icmp", "0.0.0.0"
+	if ip.To4() == nil {
+		unprivileged, privileged, address = "udp6", "ip6:ipv6-icmp", "::"
+	}
+
+	conn, err := icmp.ListenPacket(unprivileged, address)
+	if err == nil {
+		return conn, false, nil
+	}
+
+	conn, rawErr := icmp.ListenPacket(privileged, address)
+	if rawErr == nil {
+		return conn, true, nil
+	}
+
+	return nil, false, fmt.Errorf("failed to open an ICMP socket, unprivileged: %s, raw: %s", err.Error(), rawErr.Error())
+}
+
 // RunTest is the part of our API which is invoked to actually execute a
 // test against the given target.
 //
-// In this case we run a ping-command with the appropriate binary depending
-// on the address-family of the target host.
+// In this case we send the echo requests, and count the replies.
 func (s *PINGTest) RunTest(tst test.Test, target string, opts test.Options) error {
 	ip := net.ParseIP(target)
+	if ip == nil {
+		return errors.New("neither IPv4 nor IPv6 address")
+	}
 
-	//
-	// If the address is an IPv4 address.
-	//
-	if ip.To4() != nil {
-		if s.Ping4(target) {
-			return nil
+	count := 3
+	if tst.Arguments["count"] != "" {
+		var err error
+		count, err = strconv.Atoi(tst.Arguments["count"])
+		if err != nil {
+			return err
+		}
+	}
+
+	maxLoss := float32(-1)
+	if tst.Arguments["max-loss"] != "" {
+		var err error
+		maxLoss, err = utils.ParsePercentage(tst.Arguments["max-loss"])
+		if err != nil {
+			return fmt.Errorf("invalid max-loss: %w", err)
 		}
-		return errors.New("failed to ping binary")
 	}
 
+	conn, raw, err := s.listen(ip)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
 	//
-	// If the address is an IPv6 address.
+	// Unprivileged sockets are addressed via UDP, and the kernel takes
+	// care of the identifier.  Either way we tag our payload, so that
+	// we never count replies to anybody else's requests.
 	//
-	if ip.To16() != nil && ip.To4() == nil {
-		if s.Ping6(target) {
-			return nil
-		}
-		return errors.New("failed to ping target")
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	if raw {
+		dst = &net.IPAddr{IP: ip}
 	}
 
+	var requestType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	protocol := 1
+	if ip.To4() == nil {
+		requestType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+		protocol = 58
+	}
+
+	tag := make([]byte, 16)
+	if _, err = rand.Read(tag); err != nil {
+		return err
+	}
+	id := os.Getpid() & 0xffff
+
 	//
-	// Unknown family, or otherwise bogus name.
+	// Spread the requests over the timeout, so that there's time for the
+	// last of them to be answered.
 	//
-	return errors.New("neither IPv4 nor IPv6 address")
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+
+	interval := timeout / time.Duration(count+1)
+	if interval > pingInterval {
+		interval = pingInterval
+	}
+
+	received := 0
+	replied := make(map[int]bool)
+	var rtt time.Duration
+	sent := make(map[int]time.Time)
+
+	buf := make([]byte, 1500)
+	for seq := 1; seq <= count; seq++ {
+		msg := icmp.Message{
+			Type: requestType,
+			Body: &icmp.Echo{ID: id, Seq: seq, Data: tag},
+		}
+		packet, err := msg.Marshal(nil)
+		if err != nil {
+			return err
+		}
+
+		sent[seq] = time.Now()
+		if _, err = conn.WriteTo(packet, dst); err != nil {
+			return err
+		}
+
+		//
+		// Collect replies until it's time for the next request, or
+		// until the deadline if this was the last one.
+		//
+		wait := sent[seq].Add(interval)
+		if seq == count || wait.After(deadline) {
+			wait = deadline
+		}
+
+		for received < count {
+			conn.SetReadDeadline(wait)
+
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return err
+			}
+
+			if !s.fromTarget(peer, ip) {
+				continue
+			}
+
+			reply, err := icmp.ParseMessage(protocol, buf[:n])
+			if err != nil || reply.Type != replyType {
+				continue
+			}
+			echo, ok := reply.Body.(*icmp.Echo)
+			if !ok || !bytes.Equal(echo.Data, tag) {
+				continue
+			}
+			if replied[echo.Seq] || sent[echo.Seq].IsZero() {
+				continue
+			}
+
+			replied[echo.Seq] = true
+			received++
+			rtt += time.Since(sent[echo.Seq])
+		}
+
+		if time.Now().After(deadline) {
+			break
+		}
+	}
+
+	transmitted := len(sent)
+	loss := float32(count-received) / float32(count)
+
+	if opts.Verbose {
+		fmt.Printf("\t%d packets transmitted, %d received, %.0f%% packet loss", transmitted, received, loss*100)
+		if received > 0 {
+			fmt.Printf(", average rtt %s", rtt/time.Duration(received))
+		}
+		fmt.Printf("\n")
+	}
+
+	if received == 0 {
+		return fmt.Errorf("no reply to %d echo requests within %s", count, timeout)
+	}
+
+	if maxLoss >= 0 && loss > maxLoss {
+		return fmt.Errorf("packet loss was %.0f%% (%d of %d), more than the allowed %.0f%%", loss*100, count-received, count, maxLoss*100)
+	}
+
+	return nil
+}
+
+// fromTarget returns true if the given peer is the target we're pinging.
+func (s *PINGTest) fromTarget(peer net.Addr, ip net.IP) bool {
+	switch addr := peer.(type) {
+	case *net.UDPAddr:
+		return addr.IP.Equal(ip)
+	case *net.IPAddr:
+		return addr.IP.Equal(ip)
+	}
+	return false
 }
 
 func (s *PINGTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
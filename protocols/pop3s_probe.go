@@ -11,12 +11,18 @@
 // Because POP3S uses TLS it will test the validity of the certificate as
 // part of the test, if you wish to disable this add `with tls insecure`.
 //
+// To alert before the certificate expires specify the period it must
+// still be valid for:
+//
+//    host.example.com must run pop3s with expiry 168h
+//
 
 package protocols
 
 import (
 	"crypto/tls"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 
@@ -37,6 +43,7 @@ func (s *POP3STest) Arguments() map[string]string {
 		"tls":      "insecure",
 		"username": ".*",
 		"password": ".*",
+		"expiry":   expiryArgument,
 	}
 	return known
 }
@@ -61,6 +68,11 @@ POP3S Tester
 
  Because POP3S uses TLS it will test the validity of the certificate as
  part of the test, if you wish to disable this add 'with tls insecure'.
+
+ To alert before the certificate expires specify the period it must
+ still be valid for:
+
+    host.example.com must run pop3s with expiry 168h
 `
 	return str
 }
@@ -97,6 +109,11 @@ func (s *POP3STest) RunTest(tst test.Test, target string, opts test.Options) err
 		insecure = true
 	}
 
+	window, err := certificateExpiryWindow(tst)
+	if err != nil {
+		return err
+	}
+
 	//
 	// Default to connecting to an IPv4-address
 	//
@@ -130,8 +147,25 @@ func (s *POP3STest) RunTest(tst test.Test, target string, opts test.Options) err
 	//
 	// Connect
 	//
-	c, err := pop3.Dial(address, pop3.UseTLS(tlsSetup), pop3.UseTimeout(opts.Timeout))
+	// We make the TLS connection ourselves, so that we can inspect
+	// the certificate the server presented.
+	//
+	dial := &net.Dialer{Timeout: opts.Timeout}
+	conn, err := tls.DialWithDialer(dial, "tcp", address, tlsSetup)
+	if err != nil {
+		return err
+	}
+
+	if window > 0 {
+		if err = checkCertificateExpiry(conn.ConnectionState(), window, opts.Verbose); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	c, err := pop3.NewClient(conn, pop3.UseTimeout(opts.Timeout))
 	if err != nil {
+		conn.Close()
 		return err
 	}
 
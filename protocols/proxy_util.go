@@ -0,0 +1,117 @@
+package protocols
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// proxyArgument validates the HTTP proxy a connection is tunnelled
+// through, e.g. `http://proxy.example.com:3128`.  Credentials for the
+// proxy are given via the "proxy-username" and "proxy-password" arguments.
+const proxyArgument = `^https?://[^\s/@]+/?$`
+
+// dialTCP makes a TCP connection to the given address, tunnelled through
+// the HTTP CONNECT proxy given via the "proxy" argument, if any.
+func dialTCP(tst test.Test, dial *net.Dialer, address string) (net.Conn, error) {
+	if tst.Arguments["proxy"] == "" {
+		return dial.Dial("tcp", address)
+	}
+
+	proxy, err := url.Parse(tst.Arguments["proxy"])
+	if err != nil {
+		return nil, err
+	}
+
+	proxyAddress := proxy.Host
+	if proxy.Port() == "" {
+		if proxy.Scheme == "https" {
+			proxyAddress = net.JoinHostPort(proxy.Hostname(), "443")
+		} else {
+			proxyAddress = net.JoinHostPort(proxy.Hostname(), "80")
+		}
+	}
+
+	conn, err := dial.Dial("tcp", proxyAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the proxy %s: %w", proxyAddress, err)
+	}
+
+	if proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		conn = tlsConn
+	}
+
+	//
+	// Don't wait forever for the proxy to answer.
+	//
+	if dial.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(dial.Timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	req.Header.Set("User-Agent", "overseer/probe")
+	if tst.Arguments["proxy-username"] != "" {
+		credentials := tst.Arguments["proxy-username"] + ":" + tst.Arguments["proxy-password"]
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT to the proxy %s: %w", proxyAddress, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("invalid response to CONNECT from the proxy %s: %w", proxyAddress, err)
+	}
+	response.Body.Close()
+
+	//
+	// The proxy will refuse us if our credentials are wrong, or it can't
+	// reach the target, e.g. with "502 Bad Gateway".
+	//
+	if response.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("the proxy %s refused to connect to %s: %s", proxyAddress, address, response.Status)
+	}
+
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	//
+	// The target may have already sent us something, such as a banner,
+	// which was read along with the response of the proxy.
+	//
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn is a connection whose reads are buffered.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads from the buffer, and the connection once that is empty.
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -7,20 +7,43 @@
 //
 //    host.example.com must run psql with username 'postgres' with password 'mysecretpassword' [with port 5432] [with tls disable]
 //
-// The `tls` setting may be used to configure how TLS is used, valid values
-// are "disable", "require", "verify-ca", or "verify-full".
+// The `sslmode` setting, or its older name `tls`, may be used to configure
+// how TLS is used, valid values are "disable", "require", "verify-ca", or
+// "verify-full".
 //
 // Specifying a username and password is required, because otherwise we
 // cannot connect to the database.
 //
+// Once connected a query is executed, by default "SELECT 1", to ensure
+// the server is really serving queries.  You may specify the database
+// to use, and the query to run:
+//
+//    host.example.com must run postgres with username 'app' with password 'secret' with database 'shop' with query 'SELECT COUNT(*) FROM orders'
+//
+// The test fails if the query fails, or returns no rows.  Failing to
+// connect and failing to query are reported differently, so it is clear
+// whether the network or the database is broken.
+//
+// To ensure that a replica isn't falling behind its primary, the time
+// since it last replayed a transaction it has received may be limited.
+// The test fails if the server isn't a replica, or the lag is larger:
+//
+//    replica.example.com must run postgres with username 'app' with password 'secret' with max-lag 30s
+//
+// This test may be invoked as either "psql" or "postgres".
+//
 
 package protocols
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
 	_ "github.com/lib/pq" // Don't need to import this
@@ -39,6 +62,10 @@ func (s *PSQLTest) Arguments() map[string]string {
 		"username": ".*",
 		"password": ".*",
 		"tls":      "^(disable|require|verify-ca|verify-full)$",
+		"sslmode":  "^(disable|require|verify-ca|verify-full)$",
+		"database": ".*",
+		"query":    ".*",
+		"max-lag":  `^([0-9]+(\.[0-9]*)?(ms|s|m|h))+$`,
 	}
 	return known
 }
@@ -59,11 +86,30 @@ PSQL Tester
 
     host.example.com must run psql with username 'postgres' with password 'mysecretpassword'
 
- The 'tls' setting may be used to configure how TLS is used, valid values
- are "disable", "require", "verify-ca", or "verify-full".
+ The 'sslmode' setting, or its older name 'tls', may be used to configure
+ how TLS is used, valid values are "disable", "require", "verify-ca", or
+ "verify-full".
 
  Specifying a username and password is required, because otherwise we
  cannot connect to the database.
+
+ Once connected a query is executed, by default "SELECT 1", to ensure
+ the server is really serving queries.  You may specify the database
+ to use, and the query to run:
+
+    host.example.com must run postgres with username 'app' with password 'secret' with database 'shop' with query 'SELECT COUNT(*) FROM orders'
+
+ The test fails if the query fails, or returns no rows.  Failing to
+ connect and failing to query are reported differently, so it is clear
+ whether the network or the database is broken.
+
+ To ensure that a replica isn't falling behind its primary, the time
+ since it last replayed a transaction it has received may be limited.
+ The test fails if the server isn't a replica, or the lag is larger:
+
+    replica.example.com must run postgres with username 'app' with password 'secret' with max-lag 30s
+
+ This test may be invoked as either "psql" or "postgres".
 `
 	return str
 }
@@ -71,8 +117,8 @@ PSQL Tester
 // RunTest is the part of our API which is invoked to actually execute a
 // test against the given target.
 //
-// In this case we make a TCP connection to the database host and attempt
-// to login with the specified username & password.
+// In this case we make a TCP connection to the database host, attempt
+// to login with the specified username & password, and run a query.
 func (s *PSQLTest) RunTest(tst test.Test, target string, opts test.Options) error {
 	var err error
 
@@ -83,6 +129,14 @@ func (s *PSQLTest) RunTest(tst test.Test, target string, opts test.Options) erro
 		return errors.New("no username specified")
 	}
 
+	var maxLag time.Duration
+	if tst.Arguments["max-lag"] != "" {
+		maxLag, err = time.ParseDuration(tst.Arguments["max-lag"])
+		if err != nil {
+			return err
+		}
+	}
+
 	//
 	// The default port to connect to.
 	//
@@ -98,14 +152,28 @@ func (s *PSQLTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	// The default SSL mode
 	//
 	ssl := "disable"
-	if tst.Arguments["tsl"] != "" {
-		ssl = tst.Arguments["tsl"]
+	if tst.Arguments["tls"] != "" {
+		ssl = tst.Arguments["tls"]
+	}
+	if tst.Arguments["sslmode"] != "" {
+		ssl = tst.Arguments["sslmode"]
+	}
+
+	//
+	// The connection-timeout is in whole seconds, zero meaning forever.
+	//
+	connectTimeout := 0
+	if opts.Timeout > 0 {
+		connectTimeout = int(math.Ceil(opts.Timeout.Seconds()))
 	}
 
 	//
 	// This is the string we'll use for the database connection.
 	//
-	connect := fmt.Sprintf("host=%s port='%d' user='%s' password='%s' connect_timeout='%d' sslmode='%s'", target, port, tst.Arguments["username"], tst.Arguments["password"], opts.Timeout, ssl)
+	connect := fmt.Sprintf("host=%s port='%d' user='%s' password='%s' connect_timeout='%d' sslmode='%s'", target, port, s.quote(tst.Arguments["username"]), s.quote(tst.Arguments["password"]), connectTimeout, ssl)
+	if tst.Arguments["database"] != "" {
+		connect += fmt.Sprintf(" dbname='%s'", s.quote(tst.Arguments["database"]))
+	}
 
 	//
 	// Show the config, if appropriate.
@@ -124,10 +192,105 @@ func (s *PSQLTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	defer db.Close()
 
 	//
-	// And test that the connection actually worked.
+	// We only ever want the single connection, which is closed
+	// along with the database.
+	//
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(0)
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	//
+	// Test that the connection actually worked.
+	//
+	err = db.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("connection failed: %w", err)
+	}
+
+	//
+	// Now run the query, which must return at least one row.
+	//
+	query := "SELECT 1"
+	if tst.Arguments["query"] != "" {
+		query = tst.Arguments["query"]
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("query '%s' failed: %w", query, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("query '%s' failed: %w", query, err)
+		}
+		return fmt.Errorf("query '%s' returned no rows", query)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("query '%s' failed: %w", query, err)
+	}
+
+	//
+	// The single connection is needed for the lag, so release it.
+	//
+	rows.Close()
+
+	if tst.Arguments["max-lag"] != "" {
+		return s.checkLag(ctx, db, maxLag, opts)
+	}
+	return nil
+}
+
+// checkLag ensures the server is a replica, which isn't lagging behind
+// its primary by more than the given duration.
+func (s *PSQLTest) checkLag(ctx context.Context, db *sql.DB, maxLag time.Duration, opts test.Options) error {
+
+	//
+	// The time since the last replayed transaction grows while the
+	// primary is idle too, so a replica which has replayed all it
+	// received isn't lagging.
 	//
-	err = db.Ping()
-	return err
+	query := `SELECT pg_is_in_recovery(),
+		CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) END`
+
+	var replica bool
+	var seconds sql.NullFloat64
+	err := db.QueryRowContext(ctx, query).Scan(&replica, &seconds)
+	if err != nil {
+		return fmt.Errorf("querying the replication lag failed: %w", err)
+	}
+
+	if !replica {
+		return errors.New("the server isn't a replica, so has no replication lag")
+	}
+	if !seconds.Valid {
+		return errors.New("the replication lag is unknown, as no transaction has been replayed yet")
+	}
+
+	lag := time.Duration(seconds.Float64 * float64(time.Second)).Round(time.Millisecond)
+
+	opts.Tracef("Replication lag is %s", lag)
+
+	if lag > maxLag {
+		return fmt.Errorf("replication lag is %s, more than the maximum of %s", lag, maxLag)
+	}
+	return nil
+}
+
+// quote escapes a value for use within a single-quoted connection-string
+// parameter.
+func (s *PSQLTest) quote(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	return strings.Replace(value, `'`, `\'`, -1)
 }
 
 func (s *PSQLTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
@@ -141,4 +304,7 @@ func init() {
 	Register("psql", func() ProtocolTest {
 		return &PSQLTest{}
 	})
+	Register("postgres", func() ProtocolTest {
+		return &PSQLTest{}
+	})
 }
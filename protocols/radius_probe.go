@@ -0,0 +1,337 @@
+// RADIUS Tester
+//
+// The RADIUS tester sends an Access-Request to a remote RADIUS server,
+// and ensures that the credentials are accepted.
+//
+// This test is invoked via input like so:
+//
+//    radius.example.com must run radius with username 'steve' with password 'secret' with secret 'shared-secret'
+//
+// By default port 1812 is used, but that can be changed via "port".
+//
+// To ensure that bad credentials are rejected, rather than accepted,
+// specify the expected response:
+//
+//    radius.example.com must run radius with username 'steve' with password 'wrong' with secret 'shared-secret' with expect reject
+//
+
+package protocols
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// RADIUSTest is our object
+type RADIUSTest struct {
+}
+
+// RADIUS packet codes, and attributes, from RFC 2865 and RFC 3579.
+const (
+	radiusAccessRequest   = 1
+	radiusAccessAccept    = 2
+	radiusAccessReject    = 3
+	radiusAccessChallenge = 11
+
+	radiusUserName             = 1
+	radiusUserPassword         = 2
+	radiusReplyMessage         = 18
+	radiusNASIdentifier        = 32
+	radiusMessageAuthenticator = 80
+)
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *RADIUSTest) Arguments() map[string]string {
+	known := map[string]string{
+		"port":     "^[0-9]+$",
+		"username": ".*",
+		"password": ".*",
+		"secret":   ".*",
+		"expect":   "^(accept|reject)$",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *RADIUSTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *RADIUSTest) Example() string {
+	str := `
+RADIUS Tester
+-------------
+ The RADIUS tester sends an Access-Request to a remote RADIUS server,
+ and ensures that the credentials are accepted.
+
+ This test is invoked via input like so:
+
+    radius.example.com must run radius with username 'steve' with password 'secret' with secret 'shared-secret'
+
+ By default port 1812 is used, but that can be changed via "port".
+
+ To ensure that bad credentials are rejected, rather than accepted,
+ specify the expected response:
+
+    radius.example.com must run radius with username 'steve' with password 'wrong' with secret 'shared-secret' with expect reject
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we send an Access-Request, using PAP, and check the
+// response we receive is both authentic and the one we expected.
+func (s *RADIUSTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	if tst.Arguments["username"] == "" {
+		return errors.New("no username specified")
+	}
+	if tst.Arguments["secret"] == "" {
+		return errors.New("no shared secret specified")
+	}
+
+	//
+	// The default port to connect to.
+	//
+	port := 1812
+
+	//
+	// If the user specified a different port update to use it.
+	//
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	expected := byte(radiusAccessAccept)
+	if tst.Arguments["expect"] == "reject" {
+		expected = radiusAccessReject
+	}
+
+	//
+	// The address to connect to, with IPv6 addresses in brackets
+	//
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	secret := []byte(tst.Arguments["secret"])
+
+	request, err := s.buildRequest(tst.Arguments["username"], tst.Arguments["password"], secret)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	if _, err = conn.Write(request); err != nil {
+		return err
+	}
+
+	//
+	// Read responses until we find the one matching our request, as
+	// a stale reply to an earlier request might still arrive.
+	//
+	response := make([]byte, 4096)
+	for {
+		n, err := conn.Read(response)
+		if err != nil {
+			if errNet, ok := err.(net.Error); ok && errNet.Timeout() {
+				return fmt.Errorf("no RADIUS response received within %s", opts.Timeout)
+			}
+			return err
+		}
+
+		if n < 20 || response[1] != request[1] {
+			continue
+		}
+
+		return s.checkResponse(response[:n], request, secret, expected, opts.Verbose)
+	}
+}
+
+// buildRequest returns an Access-Request packet for the given credentials.
+func (s *RADIUSTest) buildRequest(username string, password string, secret []byte) ([]byte, error) {
+
+	//
+	// A random identifier and request-authenticator.
+	//
+	header := make([]byte, 20)
+	header[0] = radiusAccessRequest
+	if _, err := rand.Read(header[1:2]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(header[4:20]); err != nil {
+		return nil, err
+	}
+	authenticator := header[4:20]
+
+	var packet bytes.Buffer
+	packet.Write(header)
+
+	attributes := [][]byte{
+		s.attribute(radiusUserName, []byte(username)),
+		s.attribute(radiusUserPassword, s.hidePassword(password, secret, authenticator)),
+		s.attribute(radiusNASIdentifier, []byte("overseer")),
+	}
+	for _, attr := range attributes {
+		if len(attr) > 255 {
+			return nil, errors.New("the username, or password, is too long")
+		}
+		packet.Write(attr)
+	}
+
+	//
+	// Servers increasingly require a Message-Authenticator, which is
+	// a HMAC of the whole packet with the attribute itself zeroed.
+	//
+	offset := packet.Len() + 2
+	packet.Write(s.attribute(radiusMessageAuthenticator, make([]byte, 16)))
+
+	result := packet.Bytes()
+	binary.BigEndian.PutUint16(result[2:4], uint16(len(result)))
+
+	mac := hmac.New(md5.New, secret)
+	mac.Write(result)
+	copy(result[offset:], mac.Sum(nil))
+
+	return result, nil
+}
+
+// checkResponse validates the response to our request.
+func (s *RADIUSTest) checkResponse(response []byte, request []byte, secret []byte, expected byte, verbose bool) error {
+
+	length := int(binary.BigEndian.Uint16(response[2:4]))
+	if length < 20 || length > len(response) {
+		return fmt.Errorf("invalid RADIUS response length %d", length)
+	}
+	response = response[:length]
+
+	//
+	// The response-authenticator is the MD5 of the response, with our
+	// request-authenticator in its place, followed by the secret.
+	//
+	hash := md5.New()
+	hash.Write(response[0:4])
+	hash.Write(request[4:20])
+	hash.Write(response[20:])
+	hash.Write(secret)
+	if !hmac.Equal(hash.Sum(nil), response[4:20]) {
+		return errors.New("invalid response authenticator, is the shared secret correct?")
+	}
+
+	//
+	// Look for a message, to make failures more useful.
+	//
+	message := ""
+	attributes := response[20:]
+	for len(attributes) >= 2 {
+		size := int(attributes[1])
+		if size < 2 || size > len(attributes) {
+			return errors.New("malformed attribute in RADIUS response")
+		}
+		if attributes[0] == radiusReplyMessage {
+			message = string(attributes[2:size])
+		}
+		attributes = attributes[size:]
+	}
+
+	var name string
+	switch response[0] {
+	case radiusAccessAccept:
+		name = "Access-Accept"
+	case radiusAccessReject:
+		name = "Access-Reject"
+	case radiusAccessChallenge:
+		name = "Access-Challenge"
+	default:
+		return fmt.Errorf("unexpected RADIUS response code %d", response[0])
+	}
+
+	if verbose {
+		fmt.Printf("\tRADIUS server replied with %s\n", name)
+	}
+
+	if response[0] != expected {
+		if message != "" {
+			return fmt.Errorf("RADIUS server replied with %s: %s", name, message)
+		}
+		return fmt.Errorf("RADIUS server replied with %s", name)
+	}
+
+	return nil
+}
+
+// attribute encodes a single attribute.
+func (s *RADIUSTest) attribute(kind byte, value []byte) []byte {
+	return append([]byte{kind, byte(len(value) + 2)}, value...)
+}
+
+// hidePassword obfuscates the password, as described in RFC 2865
+// section 5.2.
+func (s *RADIUSTest) hidePassword(password string, secret []byte, authenticator []byte) []byte {
+
+	//
+	// The password is padded with NULs to a multiple of 16 bytes.
+	//
+	size := (len(password) + 15) / 16 * 16
+	if size == 0 {
+		size = 16
+	}
+	result := make([]byte, size)
+	copy(result, password)
+
+	previous := authenticator
+	for i := 0; i < size; i += 16 {
+		hash := md5.New()
+		hash.Write(secret)
+		hash.Write(previous)
+		b := hash.Sum(nil)
+
+		for j := 0; j < 16; j++ {
+			result[i+j] ^= b[j]
+		}
+		previous = result[i : i+16]
+	}
+
+	return result
+}
+
+func (s *RADIUSTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("radius", func() ProtocolTest {
+		return &RADIUSTest{}
+	})
+}
@@ -0,0 +1,178 @@
+// RDP Tester
+//
+// The RDP tester connects to a remote host and ensures that it confirms
+// the connection, as the first step of the handshake of the Remote
+// Desktop Protocol.
+//
+// This test is invoked via input like so:
+//
+//    host.example.com must run rdp [with port 3389]
+//
+// The connection is requested with TLS, or Network Level Authentication,
+// as the security protocol.  Servers which only allow the older RDP
+// security reply with a negotiation failure, which also confirms that
+// they're alive.
+//
+
+package protocols
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// RDPTest is our object.
+type RDPTest struct {
+}
+
+// rdpConnectionRequest is an X.224 Connection Request, within a TPKT
+// header, which contains an RDP Negotiation Request for TLS or CredSSP.
+var rdpConnectionRequest = []byte{
+	// TPKT: version, reserved, and the length of the packet
+	0x03, 0x00, 0x00, 0x13,
+	// X.224: length, Connection Request, destination and source
+	// references, and class
+	0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00,
+	// RDP Negotiation Request: type, flags, length, and the protocols
+	// requested (TLS | CredSSP)
+	0x01, 0x00, 0x08, 0x00, 0x03, 0x00, 0x00, 0x00,
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *RDPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"port": "^[0-9]+$",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *RDPTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *RDPTest) Example() string {
+	str := `
+RDP Tester
+----------
+ The RDP tester connects to a remote host and ensures that it confirms
+ the connection, as the first step of the handshake of the Remote
+ Desktop Protocol.
+
+ This test is invoked via input like so:
+
+    host.example.com must run rdp [with port 3389]
+
+ The connection is requested with TLS, or Network Level Authentication,
+ as the security protocol.  Servers which only allow the older RDP
+ security reply with a negotiation failure, which also confirms that
+ they're alive.
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we make a TCP connection, defaulting to port 3389, send a
+// connection request, and validate the connection confirm we receive.
+func (s *RDPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	port := 3389
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// The address to connect to, with IPv6 addresses in brackets
+	//
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	d := net.Dialer{Timeout: opts.Timeout}
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	if _, err = conn.Write(rdpConnectionRequest); err != nil {
+		return err
+	}
+
+	//
+	// Read the TPKT header, which gives the length of the reply.
+	//
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("no connection confirm was received: %w", err)
+	}
+	if header[0] != 0x03 {
+		return errors.New("reply doesn't look like RDP")
+	}
+
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < 11 || length > 1024 {
+		return fmt.Errorf("reply has an invalid length of %d bytes", length)
+	}
+
+	body := make([]byte, length-4)
+	if _, err = io.ReadFull(conn, body); err != nil {
+		return fmt.Errorf("no connection confirm was received: %w", err)
+	}
+
+	//
+	// The X.224 code is in the upper four bits.
+	//
+	if body[1]&0xf0 != 0xd0 {
+		return fmt.Errorf("reply isn't a connection confirm, but has the X.224 code 0x%02x", body[1])
+	}
+
+	//
+	// The connection confirm might contain the negotiation response,
+	// which is informational.
+	//
+	if len(body) >= 15 {
+		value := binary.LittleEndian.Uint32(body[11:15])
+		switch body[7] {
+		case 0x02:
+			opts.Tracef("Server selected the security protocol %d", value)
+		case 0x03:
+			opts.Tracef("Server refused the security protocols, with the failure-code %d", value)
+		}
+	}
+
+	return nil
+}
+
+func (s *RDPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("rdp", func() ProtocolTest {
+		return &RDPTest{}
+	})
+}
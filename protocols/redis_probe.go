@@ -7,10 +7,18 @@
 //
 //    host.example.com must run redis [with port 6379] [with password 'password']
 //
+// A database other than the default may be selected via "db".
+//
+// To ensure that a key exists, and optionally that it has the value you
+// expect, specify it:
+//
+//    host.example.com must run redis with key 'deploy:version' with expect 'v1.2.3'
+//
 
 package protocols
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -30,6 +38,9 @@ func (s *REDISTest) Arguments() map[string]string {
 	known := map[string]string{
 		"port":     "^[0-9]+$",
 		"password": ".*",
+		"db":       "^[0-9]+$",
+		"key":      ".*",
+		"expect":   ".*",
 	}
 	return known
 }
@@ -50,6 +61,13 @@ Redis Tester
  This test is invoked via input like so:
 
     host.example.com must run redis
+
+ A database other than the default may be selected via "db".
+
+ To ensure that a key exists, and optionally that it has the value you
+ expect, specify it:
+
+    host.example.com must run redis with key 'deploy:version' with expect 'v1.2.3'
 `
 	return str
 }
@@ -91,6 +109,21 @@ func (s *REDISTest) RunTest(tst test.Test, target string, opts test.Options) err
 	//
 	password = tst.Arguments["password"]
 
+	//
+	// The database to select.
+	//
+	db := 0
+	if tst.Arguments["db"] != "" {
+		db, err = strconv.Atoi(tst.Arguments["db"])
+		if err != nil {
+			return err
+		}
+	}
+
+	if tst.Arguments["expect"] != "" && tst.Arguments["key"] == "" {
+		return errors.New("an expected value was given without a key")
+	}
+
 	//
 	// Default to connecting to an IPv4-address
 	//
@@ -107,10 +140,15 @@ func (s *REDISTest) RunTest(tst test.Test, target string, opts test.Options) err
 	// Attempt to connect to the host with the optional password
 	//
 	client := redis.NewClient(&redis.Options{
-		Addr:     address,
-		Password: password,
-		DB:       0, // use default DB
+		Addr:         address,
+		Password:     password,
+		DB:           db,
+		DialTimeout:  opts.Timeout,
+		ReadTimeout:  opts.Timeout,
+		WriteTimeout: opts.Timeout,
+		MaxRetries:   0,
 	})
+	defer client.Close()
 
 	//
 	// And run a ping
@@ -118,10 +156,34 @@ func (s *REDISTest) RunTest(tst test.Test, target string, opts test.Options) err
 	// If the connection is refused, or the auth-details don't match
 	// then we'll see that here.
 	//
-	_, err = client.Ping().Result()
+	pong, err := client.Ping().Result()
 	if err != nil {
 		return err
 	}
+	if pong != "PONG" {
+		return fmt.Errorf("unexpected reply to PING: '%s'", pong)
+	}
+
+	//
+	// Look for the key, if we were asked to.
+	//
+	if tst.Arguments["key"] != "" {
+		value, errGet := client.Get(tst.Arguments["key"]).Result()
+		if errGet == redis.Nil {
+			return fmt.Errorf("key '%s' doesn't exist", tst.Arguments["key"])
+		}
+		if errGet != nil {
+			return errGet
+		}
+
+		if opts.Verbose {
+			fmt.Printf("\tKey '%s' has value '%s'\n", tst.Arguments["key"], value)
+		}
+
+		if tst.Arguments["expect"] != "" && value != tst.Arguments["expect"] {
+			return fmt.Errorf("key '%s' has value '%s', not '%s'", tst.Arguments["key"], value, tst.Arguments["expect"])
+		}
+	}
 
 	//
 	// If we reached here all is OK
@@ -0,0 +1,206 @@
+// security.txt Tester
+//
+// The security.txt tester fetches the `/.well-known/security.txt` file of
+// a site, as described in RFC 9116, and ensures that it is valid.
+//
+// This test is invoked via input like so:
+//
+//    https://example.com/ must run security-txt
+//
+// The test fails if the file is missing, if it doesn't contain both the
+// required `Contact` and `Expires` fields, or if it has expired.
+//
+// To alert before the file expires specify the period it must still be
+// valid for:
+//
+//    https://example.com/ must run security-txt with expiry 720h
+//
+
+package protocols
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// SECURITYTXTTest is our object.
+type SECURITYTXTTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *SECURITYTXTTest) Arguments() map[string]string {
+	known := map[string]string{
+		"expiry": expiryArgument,
+		"tls":    "insecure",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *SECURITYTXTTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *SECURITYTXTTest) Example() string {
+	str := `
+security.txt Tester
+-------------------
+ The security.txt tester fetches the '/.well-known/security.txt' file of
+ a site, as described in RFC 9116, and ensures that it is valid.
+
+ This test is invoked via input like so:
+
+    https://example.com/ must run security-txt
+
+ The test fails if the file is missing, if it doesn't contain both the
+ required 'Contact' and 'Expires' fields, or if it has expired.
+
+ To alert before the file expires specify the period it must still be
+ valid for:
+
+    https://example.com/ must run security-txt with expiry 720h
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we fetch the file, and check the fields it contains.
+func (s *SECURITYTXTTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	//
+	// Allow the target to be a plain hostname.
+	//
+	site := tst.Target
+	if !strings.Contains(site, "://") {
+		site = "https://" + site
+	}
+
+	u, err := url.Parse(site)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("the target must be a http:// or https:// URL, got '%s'", tst.Target)
+	}
+
+	var window time.Duration
+	if tst.Arguments["expiry"] != "" {
+		window, err = time.ParseDuration(tst.Arguments["expiry"])
+		if err != nil {
+			return err
+		}
+	}
+
+	client := newPinnedHTTPClient(target, tst.Arguments["tls"] == "insecure", opts.Timeout)
+	address := fmt.Sprintf("%s://%s/.well-known/security.txt", u.Scheme, u.Host)
+
+	req, err := http.NewRequest("GET", address, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s, status code %d", address, response.StatusCode)
+	}
+
+	// The file is expected to be small, so don't read silly amounts
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+
+	fields := s.parse(string(body))
+
+	if len(fields["contact"]) == 0 {
+		return errors.New("security.txt has no Contact field")
+	}
+
+	switch len(fields["expires"]) {
+	case 0:
+		return errors.New("security.txt has no Expires field")
+	case 1:
+	default:
+		return errors.New("security.txt has more than one Expires field")
+	}
+
+	expires, err := time.Parse(time.RFC3339, fields["expires"][0])
+	if err != nil {
+		return fmt.Errorf("security.txt has an invalid Expires field '%s'", fields["expires"][0])
+	}
+
+	remaining := time.Until(expires)
+	if opts.Verbose {
+		fmt.Printf("\tsecurity.txt expires in %s\n", remaining.Truncate(time.Second))
+	}
+
+	if remaining <= 0 {
+		return fmt.Errorf("security.txt expired on %s", expires.Format(time.RFC3339))
+	}
+	if remaining < window {
+		return fmt.Errorf("security.txt expires on %s, which is within %s", expires.Format(time.RFC3339), window)
+	}
+
+	return nil
+}
+
+// parse returns the values of the fields in the given file, keyed by
+// their lower-cased names.
+//
+// Comments, and any OpenPGP signature wrapping the file, are ignored.
+func (s *SECURITYTXTTest) parse(body string) map[string][]string {
+	fields := make(map[string][]string)
+
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "-----BEGIN PGP SIGNATURE") {
+			break
+		}
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "-----") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		fields[name] = append(fields[name], strings.TrimSpace(parts[1]))
+	}
+
+	return fields
+}
+
+func (s *SECURITYTXTTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("security-txt", func() ProtocolTest {
+		return &SECURITYTXTTest{}
+	})
+}
@@ -0,0 +1,477 @@
+// SIP Tester
+//
+// The SIP tester sends an OPTIONS request to a remote SIP server, and
+// ensures that it replies with a "200 OK" response.
+//
+// This test is invoked via input like so:
+//
+//    sip.example.com must run sip
+//
+// By default the request is sent via UDP to port 5060, but TCP and TLS
+// are supported too, the latter defaulting to port 5061:
+//
+//    sip.example.com must run sip with transport tcp
+//    sip.example.com must run sip with transport tls with port 5061
+//
+// If you need to disable failures due to expired, broken, or otherwise
+// bogus TLS certificates you can do so via the tls setting:
+//
+//    sip.example.com must run sip with transport tls with tls insecure
+//
+// To test that a user can register send a REGISTER request, along with
+// the credentials.  If the server challenges the request it is repeated
+// with them, and the registration is removed straight away, by using an
+// expiry of zero:
+//
+//    sip.example.com must run sip with method REGISTER with username '1000' with password 'secret'
+//
+// The SIP-domain defaults to the name of the target, but may be changed
+// via "domain".  Finally the expected response-codes can be changed, for
+// example to just check that a server challenges unauthenticated users:
+//
+//    sip.example.com must run sip with method REGISTER with status 401,407
+//
+
+package protocols
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// SIPTest is our object
+type SIPTest struct {
+}
+
+// sipResponse is a parsed response from a SIP server.
+type sipResponse struct {
+	status int
+	reason string
+	header textproto.MIMEHeader
+}
+
+// sipRequest holds the details shared by the requests we send.
+type sipRequest struct {
+	method    string
+	uri       string
+	user      string
+	domain    string
+	transport string
+	local     string
+	callID    string
+	tag       string
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *SIPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"port":      "^[0-9]+$",
+		"transport": "^(udp|tcp|tls)$",
+		"method":    "^(OPTIONS|REGISTER)$",
+		"username":  ".*",
+		"password":  ".*",
+		"domain":    `^[a-zA-Z0-9.-]+$`,
+		"status":    "^[0-9]{3}(,[0-9]{3})*$",
+		"tls":       "insecure",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *SIPTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *SIPTest) Example() string {
+	str := `
+SIP Tester
+----------
+ The SIP tester sends an OPTIONS request to a remote SIP server, and
+ ensures that it replies with a "200 OK" response.
+
+ This test is invoked via input like so:
+
+    sip.example.com must run sip
+
+ By default the request is sent via UDP to port 5060, but TCP and TLS
+ are supported too, the latter defaulting to port 5061:
+
+    sip.example.com must run sip with transport tcp
+    sip.example.com must run sip with transport tls with port 5061
+
+ If you need to disable failures due to expired, broken, or otherwise
+ bogus TLS certificates you can do so via the tls setting:
+
+    sip.example.com must run sip with transport tls with tls insecure
+
+ To test that a user can register send a REGISTER request, along with
+ the credentials.  If the server challenges the request it is repeated
+ with them, and the registration is removed straight away, by using an
+ expiry of zero:
+
+    sip.example.com must run sip with method REGISTER with username '1000' with password 'secret'
+
+ The SIP-domain defaults to the name of the target, but may be changed
+ via "domain".  Finally the expected response-codes can be changed, for
+ example to just check that a server challenges unauthenticated users:
+
+    sip.example.com must run sip with method REGISTER with status 401,407
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we send our request, answering any authentication
+// challenge, and compare the final response-code to those expected.
+func (s *SIPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	transport := "udp"
+	if tst.Arguments["transport"] != "" {
+		transport = tst.Arguments["transport"]
+	}
+
+	//
+	// The default port to connect to.
+	//
+	port := 5060
+	if transport == "tls" {
+		port = 5061
+	}
+
+	//
+	// If the user specified a different port update to use it.
+	//
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	expected := []string{"200"}
+	if tst.Arguments["status"] != "" {
+		expected = strings.Split(tst.Arguments["status"], ",")
+	}
+
+	//
+	// The address to connect to, with IPv6 addresses in brackets
+	//
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+
+	var conn net.Conn
+	switch transport {
+	case "tls":
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+			ServerName:         tst.Target,
+			InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+		})
+	default:
+		conn, err = dialer.Dial(transport, address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	domain := tst.Target
+	if tst.Arguments["domain"] != "" {
+		domain = tst.Arguments["domain"]
+	}
+
+	user := "overseer"
+	if tst.Arguments["username"] != "" {
+		user = tst.Arguments["username"]
+	}
+
+	method := "OPTIONS"
+	if tst.Arguments["method"] != "" {
+		method = tst.Arguments["method"]
+	}
+
+	req := sipRequest{
+		method:    method,
+		uri:       "sip:" + domain,
+		user:      user,
+		domain:    domain,
+		transport: strings.ToUpper(transport),
+		local:     conn.LocalAddr().String(),
+		callID:    s.random() + "@overseer",
+		tag:       s.random(),
+	}
+
+	var reader *bufio.Reader
+	if transport != "udp" {
+		reader = bufio.NewReader(conn)
+	}
+
+	response, err := s.exchange(conn, reader, req, 1, "", opts.Verbose)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Answer an authentication-challenge, if we have credentials and
+	// the challenge wasn't the response the user expected.
+	//
+	challenged := response.status == 401 || response.status == 407
+	if challenged && tst.Arguments["password"] != "" && !s.isExpected(response.status, expected) {
+		authorization, errAuth := s.authorization(response, req, tst.Arguments["password"])
+		if errAuth != nil {
+			return errAuth
+		}
+
+		response, err = s.exchange(conn, reader, req, 2, authorization, opts.Verbose)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !s.isExpected(response.status, expected) {
+		return fmt.Errorf("SIP server replied with '%d %s', not %s", response.status, response.reason, strings.Join(expected, " or "))
+	}
+
+	return nil
+}
+
+// exchange sends a single request, and returns the final response to it,
+// skipping any provisional ones.
+func (s *SIPTest) exchange(conn net.Conn, reader *bufio.Reader, req sipRequest, seq int, authorization string, verbose bool) (*sipResponse, error) {
+	branch := "z9hG4bK" + s.random()
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "%s %s SIP/2.0\r\n", req.method, req.uri)
+	fmt.Fprintf(&msg, "Via: SIP/2.0/%s %s;branch=%s;rport\r\n", req.transport, req.local, branch)
+	fmt.Fprintf(&msg, "Max-Forwards: 70\r\n")
+	fmt.Fprintf(&msg, "From: <sip:%s@%s>;tag=%s\r\n", req.user, req.domain, req.tag)
+	fmt.Fprintf(&msg, "To: <sip:%s@%s>\r\n", req.user, req.domain)
+	fmt.Fprintf(&msg, "Call-ID: %s\r\n", req.callID)
+	fmt.Fprintf(&msg, "CSeq: %d %s\r\n", seq, req.method)
+	if req.method == "REGISTER" {
+		fmt.Fprintf(&msg, "Contact: <sip:%s@%s>\r\n", req.user, req.local)
+		fmt.Fprintf(&msg, "Expires: 0\r\n")
+	}
+	if authorization != "" {
+		fmt.Fprintf(&msg, "%s\r\n", authorization)
+	}
+	fmt.Fprintf(&msg, "User-Agent: overseer/probe\r\n")
+	fmt.Fprintf(&msg, "Content-Length: 0\r\n\r\n")
+
+	if _, err := conn.Write(msg.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for {
+		response, err := s.readResponse(conn, reader)
+		if err != nil {
+			if errNet, ok := err.(net.Error); ok && errNet.Timeout() {
+				return nil, errors.New("no SIP response received before the timeout")
+			}
+			return nil, err
+		}
+
+		if verbose {
+			fmt.Printf("\tSIP server replied with '%d %s'\n", response.status, response.reason)
+		}
+
+		//
+		// Ignore responses to other requests, and provisional ones.
+		//
+		if !strings.Contains(response.header.Get("Via"), "branch="+branch) {
+			continue
+		}
+		if response.status < 200 {
+			continue
+		}
+
+		return response, nil
+	}
+}
+
+// readResponse reads a single response, either from a datagram or from
+// the stream-reader.
+func (s *SIPTest) readResponse(conn net.Conn, reader *bufio.Reader) (*sipResponse, error) {
+	if reader == nil {
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return s.parseResponse(bufio.NewReader(bytes.NewReader(buf[:n])))
+	}
+
+	response, err := s.parseResponse(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	//
+	// Skip any body, so the next response can be read.
+	//
+	length, _ := strconv.Atoi(response.header.Get("Content-Length"))
+	if length > 0 {
+		if _, err = io.CopyN(ioutil.Discard, reader, int64(length)); err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// parseResponse parses the status-line and headers of a response.
+func (s *SIPTest) parseResponse(reader *bufio.Reader) (*sipResponse, error) {
+	tp := textproto.NewReader(reader)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || parts[0] != "SIP/2.0" {
+		return nil, fmt.Errorf("invalid SIP status-line '%s'", line)
+	}
+
+	status, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid SIP status-line '%s'", line)
+	}
+
+	header, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	//
+	// Expand the compact forms of the headers we use.
+	//
+	compact := map[string]string{"V": "Via", "L": "Content-Length"}
+	for short, long := range compact {
+		for _, value := range header[short] {
+			header.Add(long, value)
+		}
+	}
+
+	result := &sipResponse{status: status, header: header}
+	if len(parts) == 3 {
+		result.reason = parts[2]
+	}
+	return result, nil
+}
+
+// authorization returns the header which answers the digest-challenge
+// in the given response, as described in RFC 3261 and RFC 2617.
+func (s *SIPTest) authorization(response *sipResponse, req sipRequest, password string) (string, error) {
+	name := "Authorization"
+	challenge := response.header.Get("Www-Authenticate")
+	if response.status == 407 {
+		name = "Proxy-Authorization"
+		challenge = response.header.Get("Proxy-Authenticate")
+	}
+
+	if !strings.HasPrefix(strings.ToLower(challenge), "digest ") {
+		return "", fmt.Errorf("unsupported SIP authentication challenge '%s'", challenge)
+	}
+
+	params := make(map[string]string)
+	re := regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^\s,]+))`)
+	for _, match := range re.FindAllStringSubmatch(challenge[len("digest "):], -1) {
+		params[strings.ToLower(match[1])] = match[2] + match[3]
+	}
+
+	if params["algorithm"] != "" && !strings.EqualFold(params["algorithm"], "MD5") {
+		return "", fmt.Errorf("unsupported SIP digest algorithm '%s'", params["algorithm"])
+	}
+
+	ha1 := s.md5(req.user + ":" + params["realm"] + ":" + password)
+	ha2 := s.md5(req.method + ":" + req.uri)
+
+	header := fmt.Sprintf(`%s: Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=MD5`,
+		name, req.user, params["realm"], params["nonce"], req.uri)
+
+	qop := false
+	for _, value := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(value) == "auth" {
+			qop = true
+		}
+	}
+
+	if qop {
+		cnonce := s.random()
+		header += fmt.Sprintf(`, qop=auth, nc=00000001, cnonce="%s", response="%s"`,
+			cnonce, s.md5(ha1+":"+params["nonce"]+":00000001:"+cnonce+":auth:"+ha2))
+	} else {
+		header += fmt.Sprintf(`, response="%s"`, s.md5(ha1+":"+params["nonce"]+":"+ha2))
+	}
+
+	if params["opaque"] != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, params["opaque"])
+	}
+
+	return header, nil
+}
+
+// isExpected returns true if the status is one of those expected.
+func (s *SIPTest) isExpected(status int, expected []string) bool {
+	for _, ent := range expected {
+		if strconv.Itoa(status) == ent {
+			return true
+		}
+	}
+	return false
+}
+
+// md5 returns the hex-encoded MD5 hash of the given string.
+func (s *SIPTest) md5(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// random returns a random token, for use in tags, branches, and similar.
+func (s *SIPTest) random() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (s *SIPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("sip", func() ProtocolTest {
+		return &SIPTest{}
+	})
+}
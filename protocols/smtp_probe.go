@@ -19,6 +19,16 @@
 //
 //    host.example.com must run smtp [with port 587] with username 'steve@example.com' with password 'secret'  [with tls insecure]
 //
+// To alert before the certificate expires specify the period it must
+// still be valid for, this also requires `STARTTLS`:
+//
+//    host.example.com must run smtp with port 587 with expiry 168h
+//
+// Connections can be tunnelled through a HTTP proxy, which supports the
+// CONNECT method, optionally authenticating to it:
+//
+//    host.example.com must run smtp with proxy 'http://proxy.example.com:3128'
+//    host.example.com must run smtp with proxy 'http://proxy.example.com:3128' with proxy-username 'probe' with proxy-password 'secret'
 //
 
 package protocols
@@ -44,10 +54,14 @@ type SMTPTest struct {
 // their values.
 func (s *SMTPTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port":     "^[0-9]+$",
-		"username": ".*",
-		"password": ".*",
-		"tls":      "insecure",
+		"port":           "^[0-9]+$",
+		"username":       ".*",
+		"password":       ".*",
+		"tls":            "insecure",
+		"expiry":         expiryArgument,
+		"proxy":          proxyArgument,
+		"proxy-username": ".*",
+		"proxy-password": ".*",
 	}
 	return known
 }
@@ -80,6 +94,17 @@ SMTP Tester
  A complete example, testing a login, will look like this:
 
     host.example.com must run smtp [with port 587] with username 'steve@example.com' with password 's3cr3t'  [with tls insecure]
+
+ To alert before the certificate expires specify the period it must
+ still be valid for, this also requires STARTTLS:
+
+    host.example.com must run smtp with port 587 with expiry 168h
+
+ Connections can be tunnelled through a HTTP proxy, which supports the
+ CONNECT method, optionally authenticating to it:
+
+    host.example.com must run smtp with proxy 'http://proxy.example.com:3128'
+    host.example.com must run smtp with proxy 'http://proxy.example.com:3128' with proxy-username 'probe' with proxy-password 'secret'
 `
 	return str
 }
@@ -107,6 +132,11 @@ func (s *SMTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 		}
 	}
 
+	window, err := certificateExpiryWindow(tst)
+	if err != nil {
+		return err
+	}
+
 	//
 	// Set an explicit timeout
 	//
@@ -127,7 +157,7 @@ func (s *SMTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	//
 	// Make the TCP connection.
 	//
-	conn, err := d.Dial("tcp", address)
+	conn, err := dialTCP(tst, &d, address)
 	if err != nil {
 		return err
 	}
@@ -159,23 +189,36 @@ func (s *SMTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 		return err
 	}
 
+	login := tst.Arguments["username"] != "" &&
+		tst.Arguments["password"] != ""
+
 	//
-	// If we have a username & password then we have to
-	// try them - but this will require TLS so we'll start
-	// that first.
+	// Testing the certificate, or logging in, requires that
+	// we start TLS first.
 	//
-	if tst.Arguments["username"] != "" &&
-		tst.Arguments["password"] != "" {
-
+	if login || window > 0 {
 		hasStartTLS, _ := client.Extension("STARTTLS")
 		if !hasStartTLS {
-			return errors.New("we cannot login without STARTTLS, and that was not advertised")
+			return errors.New("we cannot use TLS without STARTTLS, and that was not advertised")
 		}
 
 		if err = client.StartTLS(tlsconfig); err != nil {
 			return err
 		}
+	}
 
+	if window > 0 {
+		state, _ := client.TLSConnectionState()
+		if err = checkCertificateExpiry(state, window, opts.Verbose); err != nil {
+			return err
+		}
+	}
+
+	//
+	// If we have a username & password then we have to
+	// try them.
+	//
+	if login {
 		//
 		// In the future we might try more options
 		//
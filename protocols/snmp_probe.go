@@ -0,0 +1,1093 @@
+// SNMP Tester
+//
+// The SNMP tester retrieves the value of an OID from a device, via a GET
+// request, and ensures that it is the value you expect.
+//
+// This test is invoked via input like so:
+//
+//    switch.example.com must run snmp with oid 1.3.6.1.2.1.1.5.0 with pattern '^switch'
+//
+// SNMP v2c is used by default, with the community "public", which may be
+// changed via "community".  By default port 161 is used, but that can be
+// changed via "port".
+//
+// Numeric values, such as counters or gauges, can be required to be within
+// a range instead:
+//
+//    ups.example.com must run snmp with oid 1.3.6.1.2.1.33.1.2.4.0 with min 80
+//    switch.example.com must run snmp with oid 1.3.6.1.4.1.9.9.13.1.3.1.3.1 with max 60
+//
+// If neither a pattern, nor a range, is given the OID only has to exist.
+//
+// SNMP v3 is used when a username is given.  Requests are authenticated,
+// via HMAC-SHA or HMAC-MD5, if an auth-password is given, and encrypted,
+// via AES or DES, if a priv-password is given too:
+//
+//    switch.example.com must run snmp with oid 1.3.6.1.2.1.1.3.0 with username 'probe' with auth-password 'authsecret' with priv-password 'privsecret'
+//    switch.example.com must run snmp with oid 1.3.6.1.2.1.1.3.0 with username 'probe' with auth-password 'authsecret' with auth-protocol md5 with priv-password 'privsecret' with priv-protocol des
+//
+
+package protocols
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// SNMPTest is our object
+type SNMPTest struct {
+}
+
+// BER, and SNMP, tags from RFC 2578 and RFC 3416.
+const (
+	snmpInteger     = 0x02
+	snmpOctetString = 0x04
+	snmpNull        = 0x05
+	snmpOID         = 0x06
+	snmpSequence    = 0x30
+
+	snmpIPAddress = 0x40
+	snmpCounter32 = 0x41
+	snmpGauge32   = 0x42
+	snmpTimeTicks = 0x43
+	snmpOpaque    = 0x44
+	snmpCounter64 = 0x46
+
+	snmpNoSuchObject   = 0x80
+	snmpNoSuchInstance = 0x81
+	snmpEndOfMibView   = 0x82
+
+	snmpGetRequest = 0xa0
+	snmpResponse   = 0xa2
+	snmpReport     = 0xa8
+)
+
+// SNMP v3 message flags, from RFC 3412.
+const (
+	snmpFlagAuth       = 0x01
+	snmpFlagPriv       = 0x02
+	snmpFlagReportable = 0x04
+)
+
+// snmpNotInTimeWindow is the report sent when the engine time of a v3
+// request is wrong.
+const snmpNotInTimeWindow = "1.3.6.1.6.3.15.1.1.2.0"
+
+// snmpErrors are the names of the error-status values of a response.
+var snmpErrors = []string{"noError", "tooBig", "noSuchName", "badValue",
+	"readOnly", "genErr", "noAccess", "wrongType", "wrongLength",
+	"wrongEncoding", "wrongValue", "noCreation", "inconsistentValue",
+	"resourceUnavailable", "commitFailed", "undoFailed",
+	"authorizationError", "notWritable", "inconsistentName"}
+
+// snmpReports describe the reports an agent sends when it refuses a v3
+// request, from RFC 3414.
+var snmpReports = map[string]string{
+	"1.3.6.1.6.3.15.1.1.1.0": "the security level isn't supported for this user",
+	snmpNotInTimeWindow:      "the request wasn't within the time window of the agent",
+	"1.3.6.1.6.3.15.1.1.3.0": "unknown username",
+	"1.3.6.1.6.3.15.1.1.4.0": "unknown engine ID",
+	"1.3.6.1.6.3.15.1.1.5.0": "wrong digest, are the auth-password and auth-protocol correct?",
+	"1.3.6.1.6.3.15.1.1.6.0": "decryption failed, are the priv-password and priv-protocol correct?",
+}
+
+// snmpBinding is a single variable-binding, an OID and its value.
+type snmpBinding struct {
+	oid   string
+	tag   byte
+	value []byte
+}
+
+// snmpPDU is the part of a PDU which we care about.
+type snmpPDU struct {
+	kind      byte
+	requestID int64
+	errStatus int64
+	errIndex  int64
+	bindings  []snmpBinding
+}
+
+// snmpMessage is the part of a v3 message which we care about.
+type snmpMessage struct {
+	msgID      int64
+	flags      byte
+	engineID   []byte
+	boots      int64
+	time       int64
+	authParams []byte
+	privParams []byte
+	data       berElement
+}
+
+// snmpUser holds the credentials of a v3 user, with the keys localized to
+// the engine of the agent.
+type snmpUser struct {
+	name     string
+	authHash func() hash.Hash
+	authKey  []byte
+	privDES  bool
+	privKey  []byte
+	engineID []byte
+	boots    int64
+	time     int64
+}
+
+// berElement is a single decoded TLV.
+type berElement struct {
+	tag   byte
+	value []byte
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *SNMPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"auth-password": ".*",
+		"auth-protocol": "^(md5|sha)$",
+		"community":     ".*",
+		"max":           `^-?[0-9]+(\.[0-9]+)?$`,
+		"min":           `^-?[0-9]+(\.[0-9]+)?$`,
+		"oid":           `^\.?[0-9]+(\.[0-9]+)+$`,
+		"pattern":       ".*",
+		"port":          "^[0-9]+$",
+		"priv-password": ".*",
+		"priv-protocol": "^(aes|des)$",
+		"username":      ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *SNMPTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *SNMPTest) Example() string {
+	str := `
+SNMP Tester
+-----------
+ The SNMP tester retrieves the value of an OID from a device, via a GET
+ request, and ensures that it is the value you expect.
+
+ This test is invoked via input like so:
+
+    switch.example.com must run snmp with oid 1.3.6.1.2.1.1.5.0 with pattern '^switch'
+
+ SNMP v2c is used by default, with the community "public", which may be
+ changed via "community".  By default port 161 is used, but that can be
+ changed via "port".
+
+ Numeric values, such as counters or gauges, can be required to be within
+ a range instead:
+
+    ups.example.com must run snmp with oid 1.3.6.1.2.1.33.1.2.4.0 with min 80
+    switch.example.com must run snmp with oid 1.3.6.1.4.1.9.9.13.1.3.1.3.1 with max 60
+
+ If neither a pattern, nor a range, is given the OID only has to exist.
+
+ SNMP v3 is used when a username is given.  Requests are authenticated,
+ via HMAC-SHA or HMAC-MD5, if an auth-password is given, and encrypted,
+ via AES or DES, if a priv-password is given too:
+
+    switch.example.com must run snmp with oid 1.3.6.1.2.1.1.3.0 with username 'probe' with auth-password 'authsecret' with priv-password 'privsecret'
+    switch.example.com must run snmp with oid 1.3.6.1.2.1.1.3.0 with username 'probe' with auth-password 'authsecret' with auth-protocol md5 with priv-password 'privsecret' with priv-protocol des
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we send a GET request for the OID, and compare the value
+// of the response with what the user specified.
+func (s *SNMPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	oid := strings.TrimPrefix(tst.Arguments["oid"], ".")
+	if oid == "" {
+		return errors.New("no OID specified")
+	}
+
+	if tst.Arguments["priv-password"] != "" && tst.Arguments["auth-password"] == "" {
+		return errors.New("a priv-password can only be used with an auth-password")
+	}
+
+	var re *regexp.Regexp
+	if tst.Arguments["pattern"] != "" {
+		re, err = regexp.Compile(tst.Arguments["pattern"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// The default port to connect to.
+	//
+	port := 161
+
+	//
+	// If the user specified a different port update to use it.
+	//
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// The address to connect to, with IPv6 addresses in brackets
+	//
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	var binding *snmpBinding
+	if tst.Arguments["username"] != "" {
+		binding, err = s.getV3(conn, tst, oid, opts)
+	} else {
+		community := "public"
+		if tst.Arguments["community"] != "" {
+			community = tst.Arguments["community"]
+		}
+		binding, err = s.getV2c(conn, community, oid, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	value, err := s.formatValue(binding)
+	if err != nil {
+		return err
+	}
+
+	opts.Tracef("%s = '%s'", binding.oid, value)
+
+	if re != nil && !re.MatchString(value) {
+		return fmt.Errorf("the value of %s, '%s', doesn't match '%s'", oid, value, tst.Arguments["pattern"])
+	}
+
+	if tst.Arguments["min"] == "" && tst.Arguments["max"] == "" {
+		return nil
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("the value of %s, '%s', isn't numeric", oid, value)
+	}
+
+	if tst.Arguments["min"] != "" {
+		min, err := strconv.ParseFloat(tst.Arguments["min"], 64)
+		if err != nil {
+			return err
+		}
+		if number < min {
+			return fmt.Errorf("the value of %s is %s, which is less than %s", oid, value, tst.Arguments["min"])
+		}
+	}
+
+	if tst.Arguments["max"] != "" {
+		max, err := strconv.ParseFloat(tst.Arguments["max"], 64)
+		if err != nil {
+			return err
+		}
+		if number > max {
+			return fmt.Errorf("the value of %s is %s, which is more than %s", oid, value, tst.Arguments["max"])
+		}
+	}
+
+	return nil
+}
+
+// getV2c retrieves the OID via SNMP v2c.
+func (s *SNMPTest) getV2c(conn net.Conn, community string, oid string, opts test.Options) (*snmpBinding, error) {
+
+	requestID, err := s.randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	pdu, err := s.getRequest(requestID, oid)
+	if err != nil {
+		return nil, err
+	}
+
+	request := berTLV(snmpSequence, berInteger(1), berTLV(snmpOctetString, []byte(community)), pdu)
+
+	var result *snmpPDU
+	err = s.exchange(conn, request, opts, func(response []byte) (bool, error) {
+		message, err := berExpect(response, snmpSequence)
+		if err != nil {
+			return false, err
+		}
+		items, err := berExpect(message[0].value, snmpInteger, snmpOctetString)
+		if err != nil {
+			return false, err
+		}
+		if len(items) != 3 {
+			return false, errors.New("malformed SNMP response")
+		}
+
+		result, err = s.parsePDU(items[2])
+		if err != nil {
+			return false, err
+		}
+		return result.requestID == requestID, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.checkResponse(result, oid)
+}
+
+// getV3 retrieves the OID via SNMP v3, after discovering the engine of the
+// agent, which the keys of the user are localized to.
+func (s *SNMPTest) getV3(conn net.Conn, tst test.Test, oid string, opts test.Options) (*snmpBinding, error) {
+
+	user := &snmpUser{name: tst.Arguments["username"]}
+
+	//
+	// An empty request, which is unauthenticated, is answered with a
+	// report which contains the details of the engine.
+	//
+	discovery, err := s.sendV3(conn, user, nil, snmpFlagReportable, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(discovery.engineID) == 0 {
+		return nil, errors.New("the agent didn't report its engine ID")
+	}
+	user.engineID = discovery.engineID
+	user.boots = discovery.boots
+	user.time = discovery.time
+
+	opts.Tracef("Discovered SNMP engine %x", user.engineID)
+
+	flags := byte(snmpFlagReportable)
+	if tst.Arguments["auth-password"] != "" {
+		flags |= snmpFlagAuth
+
+		if len(tst.Arguments["auth-password"]) < 8 {
+			return nil, errors.New("the auth-password must be at least 8 characters")
+		}
+
+		user.authHash = sha1.New
+		if tst.Arguments["auth-protocol"] == "md5" {
+			user.authHash = md5.New
+		}
+		user.authKey = s.localizeKey(user.authHash, tst.Arguments["auth-password"], user.engineID)
+	}
+	if tst.Arguments["priv-password"] != "" {
+		flags |= snmpFlagPriv
+
+		if len(tst.Arguments["priv-password"]) < 8 {
+			return nil, errors.New("the priv-password must be at least 8 characters")
+		}
+
+		user.privDES = tst.Arguments["priv-protocol"] == "des"
+		user.privKey = s.localizeKey(user.authHash, tst.Arguments["priv-password"], user.engineID)
+	}
+
+	//
+	// If our idea of the engine time was wrong, which it will be if the
+	// agent doesn't report it to unauthenticated requests, we're told the
+	// right one and can try again.
+	//
+	for attempt := 0; ; attempt++ {
+		requestID, err := s.randomID()
+		if err != nil {
+			return nil, err
+		}
+
+		pdu, err := s.getRequest(requestID, oid)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := s.sendV3(conn, user, pdu, flags, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := s.parseScopedPDU(user, response)
+		if err != nil {
+			return nil, err
+		}
+
+		if result.kind == snmpReport && len(result.bindings) > 0 {
+			report := result.bindings[0].oid
+			if report == snmpNotInTimeWindow && attempt == 0 {
+				user.boots = response.boots
+				user.time = response.time
+				continue
+			}
+
+			if description, ok := snmpReports[report]; ok {
+				return nil, fmt.Errorf("the agent refused the request: %s", description)
+			}
+			return nil, fmt.Errorf("the agent refused the request with report %s", report)
+		}
+
+		if flags&snmpFlagAuth != 0 && response.flags&snmpFlagAuth == 0 {
+			return nil, errors.New("the SNMP response wasn't authenticated")
+		}
+
+		if result.requestID != requestID {
+			return nil, errors.New("the response doesn't match our request")
+		}
+
+		return s.checkResponse(result, oid)
+	}
+}
+
+// sendV3 sends a v3 message containing the given PDU, or an empty one, and
+// returns the response to it.
+func (s *SNMPTest) sendV3(conn net.Conn, user *snmpUser, pdu []byte, flags byte, opts test.Options) (*snmpMessage, error) {
+
+	msgID, err := s.randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	if pdu == nil {
+		requestID, err := s.randomID()
+		if err != nil {
+			return nil, err
+		}
+		pdu = berTLV(snmpGetRequest, berInteger(requestID), berInteger(0), berInteger(0), berTLV(snmpSequence))
+	}
+
+	data := berTLV(snmpSequence, berTLV(snmpOctetString, user.engineID), berTLV(snmpOctetString, nil), pdu)
+
+	var privParams []byte
+	if flags&snmpFlagPriv != 0 {
+		var encrypted []byte
+		encrypted, privParams, err = s.encrypt(user, data)
+		if err != nil {
+			return nil, err
+		}
+		data = berTLV(snmpOctetString, encrypted)
+	}
+
+	var authParams []byte
+	if flags&snmpFlagAuth != 0 {
+		authParams = make([]byte, 12)
+	}
+
+	userName := []byte(user.name)
+	if flags&snmpFlagAuth == 0 && user.engineID == nil {
+		userName = nil
+	}
+
+	privTLV := berTLV(snmpOctetString, privParams)
+	security := berTLV(snmpSequence,
+		berTLV(snmpOctetString, user.engineID),
+		berInteger(user.boots),
+		berInteger(user.time),
+		berTLV(snmpOctetString, userName),
+		berTLV(snmpOctetString, authParams),
+		privTLV)
+
+	request := berTLV(snmpSequence,
+		berInteger(3),
+		berTLV(snmpSequence, berInteger(msgID), berInteger(65507), berTLV(snmpOctetString, []byte{flags}), berInteger(3)),
+		berTLV(snmpOctetString, security),
+		data)
+
+	//
+	// The digest is the HMAC of the whole message, while its own place
+	// in it is zeroed.
+	//
+	if flags&snmpFlagAuth != 0 {
+		offset := bytes.Index(request, security) + len(security) - len(privTLV) - len(authParams)
+		copy(request[offset:], s.digest(user, request))
+	}
+
+	var result *snmpMessage
+	err = s.exchange(conn, request, opts, func(response []byte) (bool, error) {
+		result, err = s.parseV3(response)
+		if err != nil {
+			return false, err
+		}
+		if result.msgID != msgID {
+			return false, nil
+		}
+
+		if result.flags&snmpFlagAuth != 0 && user.authKey != nil {
+			digest := append([]byte(nil), result.authParams...)
+			offset := bytes.Index(response, digest)
+			if len(digest) != 12 || offset < 0 {
+				return false, errors.New("malformed digest in the SNMP response")
+			}
+			copy(response[offset:], make([]byte, len(digest)))
+			if !hmac.Equal(digest, s.digest(user, response)) {
+				return false, errors.New("invalid digest in the SNMP response, is the auth-password correct?")
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// parseV3 parses a v3 message.
+func (s *SNMPTest) parseV3(response []byte) (*snmpMessage, error) {
+
+	message, err := berExpect(response, snmpSequence)
+	if err != nil {
+		return nil, err
+	}
+	items, err := berExpect(message[0].value, snmpInteger, snmpSequence, snmpOctetString)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) != 4 || berParseInt(items[0].value) != 3 {
+		return nil, errors.New("malformed SNMP v3 response")
+	}
+
+	header, err := berExpect(items[1].value, snmpInteger, snmpInteger, snmpOctetString, snmpInteger)
+	if err != nil {
+		return nil, err
+	}
+	if len(header[2].value) != 1 {
+		return nil, errors.New("malformed SNMP v3 response flags")
+	}
+
+	security, err := berExpect(items[2].value, snmpSequence)
+	if err != nil {
+		return nil, err
+	}
+	params, err := berExpect(security[0].value, snmpOctetString, snmpInteger, snmpInteger, snmpOctetString, snmpOctetString, snmpOctetString)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snmpMessage{
+		msgID:      berParseInt(header[0].value),
+		flags:      header[2].value[0],
+		engineID:   params[0].value,
+		boots:      berParseInt(params[1].value),
+		time:       berParseInt(params[2].value),
+		authParams: params[4].value,
+		privParams: params[5].value,
+		data:       items[3],
+	}, nil
+}
+
+// parseScopedPDU returns the PDU of a v3 response, decrypting it if need
+// be.
+func (s *SNMPTest) parseScopedPDU(user *snmpUser, response *snmpMessage) (*snmpPDU, error) {
+
+	data := response.data
+	if response.flags&snmpFlagPriv != 0 {
+		if data.tag != snmpOctetString || user.privKey == nil {
+			return nil, errors.New("malformed encrypted SNMP response")
+		}
+
+		plain, err := s.decrypt(user, response, data.value)
+		if err != nil {
+			return nil, err
+		}
+
+		//
+		// DES pads the plaintext, so anything after it is ignored.
+		//
+		data, _, err = berSplit(plain)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if data.tag != snmpSequence {
+		return nil, errors.New("malformed SNMP v3 response")
+	}
+
+	scoped, err := berExpect(data.value, snmpOctetString, snmpOctetString)
+	if err != nil {
+		return nil, err
+	}
+	if len(scoped) != 3 {
+		return nil, errors.New("malformed SNMP v3 response")
+	}
+
+	return s.parsePDU(scoped[2])
+}
+
+// localizeKey derives the key of the user for the given engine, as
+// described in RFC 3414 section A.2.
+func (s *SNMPTest) localizeKey(hashFunc func() hash.Hash, password string, engineID []byte) []byte {
+
+	//
+	// The password is repeated to fill a megabyte.
+	//
+	h := hashFunc()
+	chunk := make([]byte, 64)
+	for i := 0; i < 1048576; i += len(chunk) {
+		for j := range chunk {
+			chunk[j] = password[(i+j)%len(password)]
+		}
+		h.Write(chunk)
+	}
+	key := h.Sum(nil)
+
+	h = hashFunc()
+	h.Write(key)
+	h.Write(engineID)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// digest returns the HMAC-96 of a message.
+func (s *SNMPTest) digest(user *snmpUser, message []byte) []byte {
+	mac := hmac.New(user.authHash, user.authKey)
+	mac.Write(message)
+	return mac.Sum(nil)[:12]
+}
+
+// encrypt encrypts the scoped PDU of a request, returning it along with
+// the salt which is sent as the privacy parameters.
+func (s *SNMPTest) encrypt(user *snmpUser, data []byte) ([]byte, []byte, error) {
+
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+
+	if user.privDES {
+		//
+		// DES-CBC, as described in RFC 3414 section 8.1.1.
+		//
+		binary.BigEndian.PutUint32(salt, uint32(user.boots))
+
+		block, err := des.NewCipher(user.privKey[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = user.privKey[8+i] ^ salt[i]
+		}
+
+		padded := make([]byte, (len(data)+7)/8*8)
+		copy(padded, data)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+		return padded, salt, nil
+	}
+
+	//
+	// AES-CFB, as described in RFC 3826.
+	//
+	block, err := aes.NewCipher(user.privKey[:16])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encrypted := make([]byte, len(data))
+	cipher.NewCFBEncrypter(block, s.aesIV(user.boots, user.time, salt)).XORKeyStream(encrypted, data)
+	return encrypted, salt, nil
+}
+
+// decrypt decrypts the scoped PDU of a response.
+func (s *SNMPTest) decrypt(user *snmpUser, response *snmpMessage, data []byte) ([]byte, error) {
+
+	salt := response.privParams
+	if len(salt) != 8 {
+		return nil, errors.New("malformed privacy parameters in the SNMP response")
+	}
+
+	if user.privDES {
+		if len(data)%8 != 0 {
+			return nil, errors.New("malformed encrypted SNMP response")
+		}
+
+		block, err := des.NewCipher(user.privKey[:8])
+		if err != nil {
+			return nil, err
+		}
+
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = user.privKey[8+i] ^ salt[i]
+		}
+
+		plain := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+		return plain, nil
+	}
+
+	block, err := aes.NewCipher(user.privKey[:16])
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCFBDecrypter(block, s.aesIV(response.boots, response.time, salt)).XORKeyStream(plain, data)
+	return plain, nil
+}
+
+// aesIV returns the IV for AES, which is made of the engine boots, the
+// engine time, and the salt.
+func (s *SNMPTest) aesIV(boots int64, engineTime int64, salt []byte) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv[0:4], uint32(boots))
+	binary.BigEndian.PutUint32(iv[4:8], uint32(engineTime))
+	copy(iv[8:], salt)
+	return iv
+}
+
+// exchange sends the request, and reads responses until one is accepted,
+// as a stale reply to an earlier request might still arrive.
+func (s *SNMPTest) exchange(conn net.Conn, request []byte, opts test.Options, accept func([]byte) (bool, error)) error {
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	for {
+		response := make([]byte, 65535)
+		n, err := conn.Read(response)
+		if err != nil {
+			if errNet, ok := err.(net.Error); ok && errNet.Timeout() {
+				return fmt.Errorf("no SNMP response received within %s", opts.Timeout)
+			}
+			return err
+		}
+
+		ok, err := accept(response[:n])
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+// getRequest returns a GetRequest PDU for the OID.
+func (s *SNMPTest) getRequest(requestID int64, oid string) ([]byte, error) {
+	encoded, err := berEncodeOID(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	binding := berTLV(snmpSequence, encoded, berTLV(snmpNull))
+	return berTLV(snmpGetRequest, berInteger(requestID), berInteger(0), berInteger(0), berTLV(snmpSequence, binding)), nil
+}
+
+// parsePDU parses a response, or report, PDU.
+func (s *SNMPTest) parsePDU(element berElement) (*snmpPDU, error) {
+
+	if element.tag != snmpResponse && element.tag != snmpReport {
+		return nil, fmt.Errorf("unexpected SNMP PDU type 0x%02x", element.tag)
+	}
+
+	items, err := berExpect(element.value, snmpInteger, snmpInteger, snmpInteger, snmpSequence)
+	if err != nil {
+		return nil, err
+	}
+
+	pdu := &snmpPDU{
+		kind:      element.tag,
+		requestID: berParseInt(items[0].value),
+		errStatus: berParseInt(items[1].value),
+		errIndex:  berParseInt(items[2].value),
+	}
+
+	bindings, err := berDecode(items[3].value)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bindings {
+		if b.tag != snmpSequence {
+			return nil, errors.New("malformed SNMP variable-binding")
+		}
+		parts, err := berExpect(b.value, snmpOID)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) != 2 {
+			return nil, errors.New("malformed SNMP variable-binding")
+		}
+		pdu.bindings = append(pdu.bindings, snmpBinding{
+			oid:   berParseOID(parts[0].value),
+			tag:   parts[1].tag,
+			value: parts[1].value,
+		})
+	}
+
+	return pdu, nil
+}
+
+// checkResponse returns the binding of the OID in the response, unless it
+// reported an error.
+func (s *SNMPTest) checkResponse(pdu *snmpPDU, oid string) (*snmpBinding, error) {
+
+	if pdu.kind != snmpResponse {
+		return nil, fmt.Errorf("unexpected SNMP PDU type 0x%02x", pdu.kind)
+	}
+
+	if pdu.errStatus != 0 {
+		name := fmt.Sprintf("error-status %d", pdu.errStatus)
+		if pdu.errStatus > 0 && pdu.errStatus < int64(len(snmpErrors)) {
+			name = snmpErrors[pdu.errStatus]
+		}
+		return nil, fmt.Errorf("the agent replied with %s for %s", name, oid)
+	}
+
+	if len(pdu.bindings) != 1 {
+		return nil, fmt.Errorf("expected a single value, but the agent replied with %d", len(pdu.bindings))
+	}
+
+	return &pdu.bindings[0], nil
+}
+
+// formatValue returns the value of a binding as a string.
+func (s *SNMPTest) formatValue(binding *snmpBinding) (string, error) {
+
+	switch binding.tag {
+	case snmpInteger:
+		return strconv.FormatInt(berParseInt(binding.value), 10), nil
+	case snmpCounter32, snmpGauge32, snmpTimeTicks, snmpCounter64:
+		return strconv.FormatUint(berParseUint(binding.value), 10), nil
+	case snmpOctetString, snmpOpaque:
+		return string(binding.value), nil
+	case snmpOID:
+		return berParseOID(binding.value), nil
+	case snmpIPAddress:
+		if len(binding.value) == 4 {
+			return net.IP(binding.value).String(), nil
+		}
+	case snmpNull:
+		return "", nil
+	case snmpNoSuchObject, snmpEndOfMibView:
+		return "", fmt.Errorf("the agent has no object %s", binding.oid)
+	case snmpNoSuchInstance:
+		return "", fmt.Errorf("the agent has no instance %s", binding.oid)
+	}
+
+	return "", fmt.Errorf("unsupported value type 0x%02x for %s", binding.tag, binding.oid)
+}
+
+// randomID returns a random, positive, 31-bit identifier.
+func (s *SNMPTest) randomID() (int64, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint32(b) & 0x7fffffff), nil
+}
+
+// berTLV encodes the given parts as the value of a TLV.
+func berTLV(tag byte, parts ...[]byte) []byte {
+	var value []byte
+	for _, part := range parts {
+		value = append(value, part...)
+	}
+
+	result := []byte{tag}
+	switch size := len(value); {
+	case size < 0x80:
+		result = append(result, byte(size))
+	case size <= 0xff:
+		result = append(result, 0x81, byte(size))
+	default:
+		result = append(result, 0x82, byte(size>>8), byte(size))
+	}
+	return append(result, value...)
+}
+
+// berInteger encodes an INTEGER, in as few bytes as possible.
+func berInteger(n int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n))
+	for len(b) > 1 && ((b[0] == 0x00 && b[1]&0x80 == 0) || (b[0] == 0xff && b[1]&0x80 != 0)) {
+		b = b[1:]
+	}
+	return berTLV(snmpInteger, b)
+}
+
+// berEncodeOID encodes a dotted OID.
+func berEncodeOID(oid string) ([]byte, error) {
+	var arcs []uint64
+	for _, part := range strings.Split(oid, ".") {
+		arc, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID '%s'", oid)
+		}
+		arcs = append(arcs, arc)
+	}
+	if len(arcs) < 2 || arcs[0] > 2 || (arcs[0] < 2 && arcs[1] >= 40) {
+		return nil, fmt.Errorf("invalid OID '%s'", oid)
+	}
+
+	//
+	// The first two arcs are combined, and each is encoded in base 128.
+	//
+	arcs = append([]uint64{arcs[0]*40 + arcs[1]}, arcs[2:]...)
+
+	var value []byte
+	for _, arc := range arcs {
+		encoded := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			encoded = append([]byte{byte(arc&0x7f) | 0x80}, encoded...)
+		}
+		value = append(value, encoded...)
+	}
+	return berTLV(snmpOID, value), nil
+}
+
+// berSplit decodes the first TLV of the data, returning it along with the
+// remaining data.
+func berSplit(data []byte) (berElement, []byte, error) {
+	malformed := errors.New("malformed SNMP message")
+
+	if len(data) < 2 {
+		return berElement{}, nil, malformed
+	}
+
+	tag := data[0]
+	size := int(data[1])
+	data = data[2:]
+
+	if size&0x80 != 0 {
+		count := size & 0x7f
+		if count == 0 || count > 3 || len(data) < count {
+			return berElement{}, nil, malformed
+		}
+		size = 0
+		for _, b := range data[:count] {
+			size = size<<8 | int(b)
+		}
+		data = data[count:]
+	}
+
+	if size > len(data) {
+		return berElement{}, nil, malformed
+	}
+
+	return berElement{tag: tag, value: data[:size]}, data[size:], nil
+}
+
+// berDecode decodes all the TLVs of the data.
+func berDecode(data []byte) ([]berElement, error) {
+	var elements []berElement
+	for len(data) > 0 {
+		element, rest, err := berSplit(data)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		data = rest
+	}
+	return elements, nil
+}
+
+// berExpect decodes all the TLVs of the data, ensuring the first of them
+// have the given tags.
+func berExpect(data []byte, tags ...byte) ([]berElement, error) {
+	elements, err := berDecode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) < len(tags) {
+		return nil, errors.New("malformed SNMP message")
+	}
+	for i, tag := range tags {
+		if elements[i].tag != tag {
+			return nil, fmt.Errorf("malformed SNMP message, expected type 0x%02x but found 0x%02x", tag, elements[i].tag)
+		}
+	}
+	return elements, nil
+}
+
+// berParseInt decodes the value of an INTEGER.
+func berParseInt(value []byte) int64 {
+	var n int64
+	if len(value) > 0 && value[0]&0x80 != 0 {
+		n = -1
+	}
+	for _, b := range value {
+		n = n<<8 | int64(b)
+	}
+	return n
+}
+
+// berParseUint decodes the value of an unsigned type, such as a Counter32.
+func berParseUint(value []byte) uint64 {
+	var n uint64
+	for _, b := range value {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+// berParseOID decodes the value of an OBJECT IDENTIFIER.
+func berParseOID(value []byte) string {
+	var arcs []string
+	var arc uint64
+	for _, b := range value {
+		arc = arc<<7 | uint64(b&0x7f)
+		if b&0x80 != 0 {
+			continue
+		}
+
+		if arcs == nil {
+			first := arc / 40
+			if first > 2 {
+				first = 2
+			}
+			arcs = append(arcs, strconv.FormatUint(first, 10), strconv.FormatUint(arc-first*40, 10))
+		} else {
+			arcs = append(arcs, strconv.FormatUint(arc, 10))
+		}
+		arc = 0
+	}
+	return strings.Join(arcs, ".")
+}
+
+func (s *SNMPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("snmp", func() ProtocolTest {
+		return &SNMPTest{}
+	})
+}
@@ -0,0 +1,247 @@
+// SSE Tester
+//
+// The SSE tester opens a Server-Sent Events stream, and ensures that an
+// event is received before the timeout.
+//
+// This test is invoked via input like so:
+//
+//    https://example.com/events must run sse
+//
+// The test fails if the stream can't be opened, if it closes before an
+// event is received, or if no event arrives in time.
+//
+// To wait for a particular type of event, or for one whose data matches
+// a regular expression, specify them:
+//
+//    https://example.com/events must run sse with event 'price' with pattern '"symbol":\s*"ACME"'
+//
+// If you need to disable failures due to expired, broken, or otherwise
+// bogus TLS certificates you can do so via the tls setting:
+//
+//    https://example.com/events must run sse with tls insecure
+//
+
+package protocols
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// SSETest is our object.
+type SSETest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *SSETest) Arguments() map[string]string {
+	known := map[string]string{
+		"event":   `^[^\s]+$`,
+		"pattern": ".*",
+		"tls":     "insecure",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *SSETest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *SSETest) Example() string {
+	str := `
+SSE Tester
+----------
+ The SSE tester opens a Server-Sent Events stream, and ensures that an
+ event is received before the timeout.
+
+ This test is invoked via input like so:
+
+    https://example.com/events must run sse
+
+ The test fails if the stream can't be opened, if it closes before an
+ event is received, or if no event arrives in time.
+
+ To wait for a particular type of event, or for one whose data matches
+ a regular expression, specify them:
+
+    https://example.com/events must run sse with event 'price' with pattern '"symbol":\s*"ACME"'
+
+ If you need to disable failures due to expired, broken, or otherwise
+ bogus TLS certificates you can do so via the tls setting:
+
+    https://example.com/events must run sse with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we read the stream until we find an event we're happy
+// with, or the timeout expires.
+func (s *SSETest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	u, err := url.Parse(tst.Target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("the target must be a http:// or https:// URL, got '%s'", tst.Target)
+	}
+
+	var pattern *regexp.Regexp
+	if tst.Arguments["pattern"] != "" {
+		pattern, err = regexp.Compile("(?ms)" + tst.Arguments["pattern"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// The stream is long-lived, so rather than a client-timeout we
+	// bound the whole request via its context.
+	//
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	client := newPinnedHTTPClient(target, tst.Arguments["tls"] == "insecure", 0)
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code was %d not %d", response.StatusCode, http.StatusOK)
+	}
+
+	contentType := response.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "text/event-stream") {
+		return fmt.Errorf("content type was '%s', not 'text/event-stream'", contentType)
+	}
+
+	//
+	// Read the stream, line by line, dispatching an event on each
+	// blank line as described in the HTML specification.
+	//
+	events := 0
+	eventType := ""
+	var data []string
+
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxHTTPBodySize)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			if len(data) > 0 {
+				events++
+				value := strings.Join(data, "\n")
+
+				if opts.Verbose {
+					fmt.Printf("\tReceived SSE event '%s': %s\n", eventType, value)
+				}
+
+				if s.matches(tst, pattern, eventType, value) {
+					return nil
+				}
+			}
+
+			eventType = ""
+			data = nil
+			continue
+		}
+
+		// Comments are used as keep-alives
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field := line
+		value := ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field = line[:i]
+			value = strings.TrimPrefix(line[i+1:], " ")
+		}
+
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		if events == 0 {
+			return fmt.Errorf("no event was received within %s", opts.Timeout)
+		}
+		return fmt.Errorf("no matching event was received within %s, out of %d events", opts.Timeout, events)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	if events == 0 {
+		return errors.New("the stream closed before an event was received")
+	}
+	return fmt.Errorf("the stream closed before a matching event was received, out of %d events", events)
+}
+
+// matches returns true if the given event is the one we're waiting for.
+func (s *SSETest) matches(tst test.Test, pattern *regexp.Regexp, eventType string, data string) bool {
+
+	// Events without a type are "message" events
+	if eventType == "" {
+		eventType = "message"
+	}
+
+	if tst.Arguments["event"] != "" && tst.Arguments["event"] != eventType {
+		return false
+	}
+
+	if pattern != nil && !pattern.MatchString(data) {
+		return false
+	}
+
+	return true
+}
+
+func (s *SSETest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("sse", func() ProtocolTest {
+		return &SSETest{}
+	})
+}
@@ -7,6 +7,18 @@
 //
 //    host.example.com must run ssh [with port 22]
 //
+// To ensure the host key hasn't changed specify its fingerprint, in the
+// format shown by `ssh-keygen -l`, either SHA256 or MD5:
+//
+//    host.example.com must run ssh with fingerprint 'SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8'
+//
+// If the server has several host keys you can choose which is checked
+// via "key-type", for example "ssh-ed25519" or "rsa-sha2-512".
+//
+// To also ensure that a login succeeds specify the credentials:
+//
+//    host.example.com must run ssh with username 'steve' with password 'secret'
+//
 
 package protocols
 
@@ -17,8 +29,10 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
+	"golang.org/x/crypto/ssh"
 )
 
 // SSHTest is our object.
@@ -30,7 +44,11 @@ type SSHTest struct {
 // their values.
 func (s *SSHTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port": "^[0-9]+$",
+		"port":        "^[0-9]+$",
+		"fingerprint": `^(SHA256:[A-Za-z0-9+/]{43}=?|(MD5:)?[0-9a-fA-F]{2}(:[0-9a-fA-F]{2}){15})$`,
+		"key-type":    `^[a-z0-9.@-]+$`,
+		"username":    ".*",
+		"password":    ".*",
 	}
 	return known
 }
@@ -51,6 +69,18 @@ SSH Tester
  This test is invoked via input like so:
 
     host.example.com must run ssh
+
+ To ensure the host key hasn't changed specify its fingerprint, in the
+ format shown by 'ssh-keygen -l', either SHA256 or MD5:
+
+    host.example.com must run ssh with fingerprint 'SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8'
+
+ If the server has several host keys you can choose which is checked
+ via "key-type", for example "ssh-ed25519" or "rsa-sha2-512".
+
+ To also ensure that a login succeeds specify the credentials:
+
+    host.example.com must run ssh with username 'steve' with password 'secret'
 `
 	return str
 }
@@ -59,7 +89,8 @@ SSH Tester
 // test against the given target.
 //
 // In this case we make a TCP connection, defaulting to port 22, and
-// look for a response which appears to be an SSH-server.
+// look for a response which appears to be an SSH-server.  If we need
+// to check the host key, or to login, we perform the full handshake.
 func (s *SSHTest) RunTest(tst test.Test, target string, opts test.Options) error {
 	var err error
 
@@ -103,6 +134,11 @@ func (s *SSHTest) RunTest(tst test.Test, target string, opts test.Options) error
 		return err
 	}
 
+	if tst.Arguments["fingerprint"] != "" || tst.Arguments["username"] != "" {
+		defer conn.Close()
+		return s.handshake(tst, conn, address, opts)
+	}
+
 	//
 	// Read the banner.
 	//
@@ -119,6 +155,83 @@ func (s *SSHTest) RunTest(tst test.Test, target string, opts test.Options) error
 	return nil
 }
 
+// handshake performs the SSH handshake over the given connection, checking
+// the host key and logging in, as requested.
+func (s *SSHTest) handshake(tst test.Test, conn net.Conn, address string, opts test.Options) error {
+	if opts.Timeout > 0 {
+		err := conn.SetDeadline(time.Now().Add(opts.Timeout))
+		if err != nil {
+			return err
+		}
+	}
+
+	expected := strings.TrimPrefix(tst.Arguments["fingerprint"], "MD5:")
+	verified := false
+
+	config := &ssh.ClientConfig{
+		User: tst.Arguments["username"],
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			fingerprint := ssh.FingerprintSHA256(key)
+			if expected != "" && !strings.HasPrefix(expected, "SHA256:") {
+				fingerprint = ssh.FingerprintLegacyMD5(key)
+			}
+
+			if opts.Verbose {
+				fmt.Printf("\tSSH host key is %s %s\n", key.Type(), fingerprint)
+			}
+
+			if expected != "" && !strings.EqualFold(fingerprint, expected) {
+				return fmt.Errorf("host key fingerprint %s %s doesn't match the expected %s", key.Type(), fingerprint, tst.Arguments["fingerprint"])
+			}
+
+			verified = true
+			return nil
+		},
+		Timeout: opts.Timeout,
+	}
+
+	if config.User == "" {
+		config.User = "overseer"
+	}
+
+	if tst.Arguments["key-type"] != "" {
+		config.HostKeyAlgorithms = []string{tst.Arguments["key-type"]}
+	}
+
+	//
+	// Servers tend to accept passwords via either method.
+	//
+	if tst.Arguments["username"] != "" {
+		password := tst.Arguments["password"]
+		config.Auth = []ssh.AuthMethod{
+			ssh.Password(password),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = password
+				}
+				return answers, nil
+			}),
+		}
+	}
+
+	client, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+
+		//
+		// If we were only checking the host key then failing to
+		// login is expected.
+		//
+		if verified && tst.Arguments["username"] == "" {
+			return nil
+		}
+		return err
+	}
+
+	ssh.NewClient(client, chans, reqs).Close()
+	return nil
+}
+
 func (s *SSHTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
 	return nil
 }
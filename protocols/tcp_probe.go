@@ -16,6 +16,12 @@
 //
 //    host.example.com must run tcp with port 655 with banner '0 \S+ 17'
 //
+// Connections can be tunnelled through a HTTP proxy, which supports the
+// CONNECT method, optionally authenticating to it:
+//
+//    host.example.com must run tcp with port 123 with proxy 'http://proxy.example.com:3128'
+//    host.example.com must run tcp with port 123 with proxy 'http://proxy.example.com:3128' with proxy-username 'probe' with proxy-password 'secret'
+//
 
 package protocols
 
@@ -27,6 +33,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
 )
@@ -40,8 +47,11 @@ type TCPTest struct {
 // their values.
 func (s *TCPTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port":   "^[0-9]+$",
-		"banner": ".*",
+		"port":           "^[0-9]+$",
+		"banner":         ".*",
+		"proxy":          proxyArgument,
+		"proxy-username": ".*",
+		"proxy-password": ".*",
 	}
 	return known
 }
@@ -71,6 +81,12 @@ TCP Tester
  banner the remote host sends on connection:
 
     host.example.com must run tcp with port 655 with banner '0 \S+ 17'
+
+ Connections can be tunnelled through a HTTP proxy, which supports the
+ CONNECT method, optionally authenticating to it:
+
+    host.example.com must run tcp with port 123 with proxy 'http://proxy.example.com:3128'
+    host.example.com must run tcp with port 123 with proxy 'http://proxy.example.com:3128' with proxy-username 'probe' with proxy-password 'secret'
 `
 	return str
 }
@@ -125,7 +141,7 @@ func (s *TCPTest) RunTest(tst test.Test, target string, opts test.Options) error
 	//
 	// Make the TCP connection.
 	//
-	conn, err := d.Dial("tcp", address)
+	conn, err := dialTCP(tst, &d, address)
 	if err != nil {
 		return err
 	}
@@ -138,6 +154,15 @@ func (s *TCPTest) RunTest(tst test.Test, target string, opts test.Options) error
 	//
 	if tst.Arguments["banner"] != "" {
 
+		//
+		// Don't wait forever for a banner which might never arrive.
+		//
+		if opts.Timeout > 0 {
+			if errDeadline := conn.SetReadDeadline(time.Now().Add(opts.Timeout)); errDeadline != nil {
+				return errDeadline
+			}
+		}
+
 		// Compile the regular expression
 		re, errCompile := regexp.Compile("(?ms)" + tst.Arguments["banner"])
 		if errCompile != nil {
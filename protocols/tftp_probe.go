@@ -0,0 +1,256 @@
+// TFTP Tester
+//
+// The TFTP tester downloads a file from a TFTP server, such as those used
+// to network-boot hosts via PXE, and ensures that the transfer succeeds.
+//
+// This test is invoked via input like so:
+//
+//    pxe.example.com must run tftp with file 'pxelinux.0' [with port 69]
+//
+// The size of the file, or its SHA256 hash, may be checked too:
+//
+//    pxe.example.com must run tftp with file 'pxelinux.0' with size 42790
+//    pxe.example.com must run tftp with file 'pxelinux.0' with sha256 'e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855'
+//
+
+package protocols
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// TFTPTest is our object.
+type TFTPTest struct {
+}
+
+// The TFTP opcodes we use, from RFC 1350.
+const (
+	tftpOpRRQ   = 1
+	tftpOpData  = 3
+	tftpOpAck   = 4
+	tftpOpError = 5
+)
+
+// tftpBlockSize is the size of every data packet but the last.
+const tftpBlockSize = 512
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *TFTPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"file":   ".+",
+		"port":   "^[0-9]+$",
+		"sha256": "^[0-9a-fA-F]{64}$",
+		"size":   "^[0-9]+$",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *TFTPTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *TFTPTest) Example() string {
+	str := `
+TFTP Tester
+-----------
+ The TFTP tester downloads a file from a TFTP server, such as those used
+ to network-boot hosts via PXE, and ensures that the transfer succeeds.
+
+ This test is invoked via input like so:
+
+    pxe.example.com must run tftp with file 'pxelinux.0' [with port 69]
+
+ The size of the file, or its SHA256 hash, may be checked too:
+
+    pxe.example.com must run tftp with file 'pxelinux.0' with size 42790
+    pxe.example.com must run tftp with file 'pxelinux.0' with sha256 'e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855'
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we download the file, and inspect it.
+func (s *TFTPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	if tst.Arguments["file"] == "" {
+		return errors.New("you must specify the file when running a tftp test")
+	}
+
+	port := 69
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// Default to connecting to an IPv4-address
+	//
+	address := fmt.Sprintf("%s:%d", target, port)
+
+	//
+	// If we find a ":" we know it is an IPv6 address though
+	//
+	if strings.Contains(target, ":") {
+		address = fmt.Sprintf("[%s]:%d", target, port)
+	}
+
+	server, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+
+	//
+	// The server replies from a port of its own choosing, so we can't
+	// use a connected socket.
+	//
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	// RRQ | filename | 0 | mode | 0
+	var request bytes.Buffer
+	binary.Write(&request, binary.BigEndian, uint16(tftpOpRRQ))
+	request.WriteString(tst.Arguments["file"])
+	request.WriteByte(0)
+	request.WriteString("octet")
+	request.WriteByte(0)
+
+	if _, err = conn.WriteTo(request.Bytes(), server); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	size := 0
+	expected := uint16(1)
+	var peer net.Addr
+
+	packet := make([]byte, 4+tftpBlockSize)
+	for {
+		n, from, err := conn.ReadFrom(packet)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return fmt.Errorf("the transfer of '%s' didn't complete within %s", tst.Arguments["file"], opts.Timeout)
+			}
+			return err
+		}
+
+		// Ignore anything which isn't from the port the transfer began on
+		if peer != nil && from.String() != peer.String() {
+			continue
+		}
+		if n < 4 {
+			return errors.New("received a truncated packet")
+		}
+
+		opcode := binary.BigEndian.Uint16(packet[0:2])
+		switch opcode {
+		case tftpOpError:
+			return s.serverError(tst.Arguments["file"], packet[2:n])
+		case tftpOpData:
+		default:
+			return fmt.Errorf("received an unexpected packet with opcode %d", opcode)
+		}
+		peer = from
+
+		block := binary.BigEndian.Uint16(packet[2:4])
+		ack := []byte{0, tftpOpAck, packet[2], packet[3]}
+		if _, err = conn.WriteTo(ack, peer); err != nil {
+			return err
+		}
+
+		// A retransmission of a block we already have
+		if block != expected {
+			continue
+		}
+
+		hash.Write(packet[4:n])
+		size += n - 4
+		expected++
+
+		// The last block is short
+		if n-4 < tftpBlockSize {
+			break
+		}
+	}
+
+	opts.Tracef("Downloaded %d bytes of '%s'", size, tst.Arguments["file"])
+
+	if tst.Arguments["size"] != "" {
+		want, err := strconv.Atoi(tst.Arguments["size"])
+		if err != nil {
+			return err
+		}
+		if size != want {
+			return fmt.Errorf("the file '%s' was %d bytes, not %d", tst.Arguments["file"], size, want)
+		}
+	}
+
+	if tst.Arguments["sha256"] != "" {
+		sum := hex.EncodeToString(hash.Sum(nil))
+		if !strings.EqualFold(sum, tst.Arguments["sha256"]) {
+			return fmt.Errorf("the file '%s' had the SHA256 hash %s, not %s", tst.Arguments["file"], sum, strings.ToLower(tst.Arguments["sha256"]))
+		}
+	}
+
+	return nil
+}
+
+// serverError describes the error the server sent us, which consists of
+// a code, and a message.
+func (s *TFTPTest) serverError(file string, payload []byte) error {
+	code := binary.BigEndian.Uint16(payload[0:2])
+	msg := string(bytes.TrimRight(payload[2:], "\x00"))
+
+	switch code {
+	case 1:
+		return fmt.Errorf("the file '%s' was not found on the server", file)
+	case 2:
+		return fmt.Errorf("access to the file '%s' was denied by the server", file)
+	}
+
+	if msg == "" {
+		return fmt.Errorf("the server refused the transfer with error %d", code)
+	}
+	return fmt.Errorf("the server refused the transfer with error %d: %s", code, msg)
+}
+
+func (s *TFTPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("tftp", func() ProtocolTest {
+		return &TFTPTest{}
+	})
+}
@@ -0,0 +1,52 @@
+package protocols
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// expiryArgument is the regular expression used to validate the `expiry`
+// argument of the TLS-based testers.
+const expiryArgument = `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`
+
+// certificateExpiryWindow returns the period the certificate presented by
+// the server must still be valid for, or zero if no check should be made.
+//
+// The check is skipped when certificate validation has been disabled via
+// `with tls insecure`, since the certificate is not trusted anyway.
+func certificateExpiryWindow(tst test.Test) (time.Duration, error) {
+	if tst.Arguments["expiry"] == "" || tst.Arguments["tls"] == "insecure" {
+		return 0, nil
+	}
+
+	window, err := time.ParseDuration(tst.Arguments["expiry"])
+	if err != nil {
+		return 0, err
+	}
+	return window, nil
+}
+
+// checkCertificateExpiry fails if the leaf certificate of the given
+// connection expires within the specified window.
+func checkCertificateExpiry(state tls.ConnectionState, window time.Duration, verbose bool) error {
+	if len(state.PeerCertificates) < 1 {
+		return errors.New("the server didn't present a certificate")
+	}
+
+	cert := state.PeerCertificates[0]
+	remaining := time.Until(cert.NotAfter)
+
+	if verbose {
+		fmt.Printf("\tCertificate '%s' expires in %s\n", cert.Subject.CommonName, remaining.Truncate(time.Second))
+	}
+
+	if remaining < window {
+		return fmt.Errorf("certificate '%s' expires in %s, which is within %s", cert.Subject.CommonName, remaining.Truncate(time.Second), window)
+	}
+
+	return nil
+}
@@ -0,0 +1,433 @@
+// Tracker Tester
+//
+// The tracker tester sends an announce request to a BitTorrent tracker,
+// for a given info-hash, and ensures that it replies with a list of peers.
+//
+// This test is invoked via input like so:
+//
+//    http://tracker.example.com/announce must run tracker with info-hash 'c12fe1c06bba254a9dc9f519b335aa7c1367a88a'
+//
+// Both HTTP(S) and UDP trackers are supported:
+//
+//    udp://tracker.example.com:6969/announce must run tracker with info-hash 'c12fe1c06bba254a9dc9f519b335aa7c1367a88a'
+//
+// By default at least one peer must be returned, to only check that the
+// tracker replies specify a different minimum:
+//
+//    udp://tracker.example.com:6969/announce must run tracker with info-hash 'c12fe1c06bba254a9dc9f519b335aa7c1367a88a' with min-peers 0
+//
+// If you need to disable failures due to expired, broken, or otherwise
+// bogus TLS certificates you can do so via the tls setting:
+//
+//    https://tracker.example.com/announce must run tracker with info-hash 'c12fe1c06bba254a9dc9f519b335aa7c1367a88a' with tls insecure
+//
+
+package protocols
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// TRACKERTest is our object.
+type TRACKERTest struct {
+}
+
+// trackerUDPProtocolID is the magic constant which starts a connection to
+// a UDP tracker, as described in BEP 15.
+const trackerUDPProtocolID = 0x41727101980
+
+// The actions of the UDP tracker protocol.
+const (
+	trackerActionConnect  = 0
+	trackerActionAnnounce = 1
+	trackerActionError    = 3
+)
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *TRACKERTest) Arguments() map[string]string {
+	known := map[string]string{
+		"info-hash": "^[0-9a-fA-F]{40}$",
+		"min-peers": "^[0-9]+$",
+		"tls":       "insecure",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *TRACKERTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *TRACKERTest) Example() string {
+	str := `
+Tracker Tester
+--------------
+ The tracker tester sends an announce request to a BitTorrent tracker,
+ for a given info-hash, and ensures that it replies with a list of peers.
+
+ This test is invoked via input like so:
+
+    http://tracker.example.com/announce must run tracker with info-hash 'c12fe1c06bba254a9dc9f519b335aa7c1367a88a'
+
+ Both HTTP(S) and UDP trackers are supported:
+
+    udp://tracker.example.com:6969/announce must run tracker with info-hash 'c12fe1c06bba254a9dc9f519b335aa7c1367a88a'
+
+ By default at least one peer must be returned, to only check that the
+ tracker replies specify a different minimum:
+
+    udp://tracker.example.com:6969/announce must run tracker with info-hash 'c12fe1c06bba254a9dc9f519b335aa7c1367a88a' with min-peers 0
+
+ If you need to disable failures due to expired, broken, or otherwise
+ bogus TLS certificates you can do so via the tls setting:
+
+    https://tracker.example.com/announce must run tracker with info-hash 'c12fe1c06bba254a9dc9f519b335aa7c1367a88a' with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we announce ourselves, and count the peers we're given.
+func (s *TRACKERTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	u, err := url.Parse(tst.Target)
+	if err != nil {
+		return err
+	}
+
+	if tst.Arguments["info-hash"] == "" {
+		return errors.New("an info-hash is required")
+	}
+	infoHash, err := hex.DecodeString(tst.Arguments["info-hash"])
+	if err != nil {
+		return err
+	}
+
+	minPeers := 1
+	if tst.Arguments["min-peers"] != "" {
+		minPeers, err = strconv.Atoi(tst.Arguments["min-peers"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// We pretend to be a client which has nothing, and wants everything.
+	//
+	peerID := make([]byte, 20)
+	copy(peerID, "-OS0001-")
+	if _, err = rand.Read(peerID[8:]); err != nil {
+		return err
+	}
+
+	var peers int
+	switch u.Scheme {
+	case "http", "https":
+		peers, err = s.announceHTTP(u, target, infoHash, peerID, tst, opts)
+	case "udp":
+		peers, err = s.announceUDP(u, target, infoHash, peerID, opts)
+	default:
+		return fmt.Errorf("unsupported tracker scheme '%s', expected http, https, or udp", u.Scheme)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tThe tracker returned %d peers\n", peers)
+	}
+
+	if peers < minPeers {
+		return fmt.Errorf("the tracker returned %d peers, fewer than %d", peers, minPeers)
+	}
+	return nil
+}
+
+// announceHTTP announces to a HTTP tracker, returning the number of peers.
+func (s *TRACKERTest) announceHTTP(u *url.URL, target string, infoHash []byte, peerID []byte, tst test.Test, opts test.Options) (int, error) {
+
+	//
+	// The info-hash and peer-id are binary, so we build the query by
+	// hand to keep them escaped as trackers expect.
+	//
+	query := fmt.Sprintf("info_hash=%s&peer_id=%s&port=6881&uploaded=0&downloaded=0&left=0&compact=1&numwant=50",
+		url.QueryEscape(string(infoHash)), url.QueryEscape(string(peerID)))
+
+	announce := *u
+	if announce.RawQuery != "" {
+		announce.RawQuery += "&" + query
+	} else {
+		announce.RawQuery = query
+	}
+
+	client := newPinnedHTTPClient(target, tst.Arguments["tls"] == "insecure", opts.Timeout)
+
+	req, err := http.NewRequest("GET", announce.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	response, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status code was %d not %d", response.StatusCode, http.StatusOK)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxHTTPBodySize))
+	if err != nil {
+		return 0, err
+	}
+
+	value, _, err := bdecode(body)
+	if err != nil {
+		return 0, fmt.Errorf("invalid announce response: %w", err)
+	}
+	dict, ok := value.(map[string]interface{})
+	if !ok {
+		return 0, errors.New("invalid announce response: not a dictionary")
+	}
+
+	if reason, ok := dict["failure reason"].(string); ok {
+		return 0, fmt.Errorf("the tracker refused the announce: %s", reason)
+	}
+
+	//
+	// Peers are either compact strings, or lists of dictionaries.
+	//
+	_, hasPeers := dict["peers"]
+	_, hasPeers6 := dict["peers6"]
+	if !hasPeers && !hasPeers6 {
+		return 0, errors.New("invalid announce response: there is no peer list")
+	}
+
+	count := 0
+	switch peers := dict["peers"].(type) {
+	case string:
+		count += len(peers) / 6
+	case []interface{}:
+		count += len(peers)
+	}
+	if peers6, ok := dict["peers6"].(string); ok {
+		count += len(peers6) / 18
+	}
+
+	return count, nil
+}
+
+// announceUDP announces to a UDP tracker, returning the number of peers.
+func (s *TRACKERTest) announceUDP(u *url.URL, target string, infoHash []byte, peerID []byte, opts test.Options) (int, error) {
+	port := u.Port()
+	if port == "" {
+		return 0, errors.New("a port is required for UDP trackers")
+	}
+
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(target, port), opts.Timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(opts.Timeout))
+	}
+
+	//
+	// First we obtain a connection ID.
+	//
+	connect := make([]byte, 16)
+	binary.BigEndian.PutUint64(connect[0:], trackerUDPProtocolID)
+	binary.BigEndian.PutUint32(connect[8:], trackerActionConnect)
+
+	reply, err := s.exchangeUDP(conn, connect, trackerActionConnect, 16)
+	if err != nil {
+		return 0, err
+	}
+	connectionID := binary.BigEndian.Uint64(reply[8:])
+
+	//
+	// Then we announce.
+	//
+	announce := make([]byte, 98)
+	binary.BigEndian.PutUint64(announce[0:], connectionID)
+	binary.BigEndian.PutUint32(announce[8:], trackerActionAnnounce)
+	copy(announce[16:], infoHash)
+	copy(announce[36:], peerID)
+	// downloaded, left, uploaded, event, and IP are all zero
+	rand.Read(announce[88:92])
+	binary.BigEndian.PutUint32(announce[92:], 50)
+	binary.BigEndian.PutUint16(announce[96:], 6881)
+
+	reply, err = s.exchangeUDP(conn, announce, trackerActionAnnounce, 20)
+	if err != nil {
+		return 0, err
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tThe tracker reports %d seeders and %d leechers\n", binary.BigEndian.Uint32(reply[16:]), binary.BigEndian.Uint32(reply[12:]))
+	}
+
+	// The peers are IPv6 addresses if we spoke IPv6
+	size := 6
+	if strings.Contains(target, ":") {
+		size = 18
+	}
+	return (len(reply) - 20) / size, nil
+}
+
+// exchangeUDP sends a request to a UDP tracker, and returns the response
+// after validating its action and transaction ID.
+func (s *TRACKERTest) exchangeUDP(conn net.Conn, request []byte, action uint32, minSize int) ([]byte, error) {
+	transaction := make([]byte, 4)
+	if _, err := rand.Read(transaction); err != nil {
+		return nil, err
+	}
+	copy(request[12:], transaction)
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		reply := buf[:n]
+
+		// Ignore anything which isn't a reply to us
+		if n < 8 || !bytes.Equal(reply[4:8], transaction) {
+			continue
+		}
+
+		got := binary.BigEndian.Uint32(reply[0:])
+		if got == trackerActionError {
+			return nil, fmt.Errorf("the tracker refused the request: %s", reply[8:])
+		}
+		if got != action || n < minSize {
+			return nil, fmt.Errorf("invalid response from the tracker, action %d and %d bytes", got, n)
+		}
+
+		return reply, nil
+	}
+}
+
+// bdecode decodes a single bencoded value, returning it along with the
+// remaining input.
+//
+// Strings are returned as strings, integers as int64s, lists as slices,
+// and dictionaries as maps.
+func bdecode(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	switch {
+	case data[0] == 'i':
+		end := bytes.IndexByte(data, 'e')
+		if end < 0 {
+			return nil, nil, errors.New("unterminated integer")
+		}
+		i, err := strconv.ParseInt(string(data[1:end]), 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		return i, data[end+1:], nil
+
+	case data[0] == 'l':
+		var list []interface{}
+		data = data[1:]
+		for len(data) > 0 && data[0] != 'e' {
+			var value interface{}
+			var err error
+			value, data, err = bdecode(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			list = append(list, value)
+		}
+		if len(data) == 0 {
+			return nil, nil, errors.New("unterminated list")
+		}
+		return list, data[1:], nil
+
+	case data[0] == 'd':
+		dict := make(map[string]interface{})
+		data = data[1:]
+		for len(data) > 0 && data[0] != 'e' {
+			key, rest, err := bdecode(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, nil, errors.New("dictionary keys must be strings")
+			}
+
+			var value interface{}
+			value, data, err = bdecode(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			dict[name] = value
+		}
+		if len(data) == 0 {
+			return nil, nil, errors.New("unterminated dictionary")
+		}
+		return dict, data[1:], nil
+
+	case data[0] >= '0' && data[0] <= '9':
+		colon := bytes.IndexByte(data, ':')
+		if colon < 0 {
+			return nil, nil, errors.New("unterminated string length")
+		}
+		length, err := strconv.Atoi(string(data[:colon]))
+		if err != nil {
+			return nil, nil, err
+		}
+		if length < 0 || len(data) < colon+1+length {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		return string(data[colon+1 : colon+1+length]), data[colon+1+length:], nil
+	}
+
+	return nil, nil, fmt.Errorf("unexpected '%c'", data[0])
+}
+
+func (s *TRACKERTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("tracker", func() ProtocolTest {
+		return &TRACKERTest{}
+	})
+}
@@ -0,0 +1,192 @@
+// UDP Tester
+//
+// The UDP tester sends a single datagram to a remote host, and ensures
+// that a reply is received.
+//
+// This test is invoked via input like so:
+//
+//    host.example.com must run udp with port 27015 with send 'status' with expect 'online'
+//
+// The port-setting is mandatory, such that the tests knows where to send
+// the payload to.
+//
+// Binary payloads can be sent by prefixing a hex-encoded value with `hex:`:
+//
+//    host.example.com must run udp with port 27015 with send 'hex:ffffffff54536f7572636520456e67696e6520517565727900'
+//
+// The `expect` setting is a regular expression which the reply must match,
+// if it is omitted then any reply is regarded as a success.
+//
+
+package protocols
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// UDPTest is our object
+type UDPTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *UDPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"port":   "^[0-9]+$",
+		"send":   ".*",
+		"expect": ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *UDPTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *UDPTest) Example() string {
+	str := `
+UDP Tester
+----------
+ The UDP tester sends a single datagram to a remote host, and ensures
+ that a reply is received.
+
+ This test is invoked via input like so:
+
+    host.example.com must run udp with port 27015 with send 'status' with expect 'online'
+
+ The port-setting is mandatory, such that the tests knows where to send
+ the payload to.
+
+ Binary payloads can be sent by prefixing a hex-encoded value with 'hex:':
+
+    host.example.com must run udp with port 27015 with send 'hex:ffffffff54536f7572636520456e67696e6520517565727900'
+
+ The 'expect' setting is a regular expression which the reply must match,
+ if it is omitted then any reply is regarded as a success.
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we send the payload to the specified port, and wait for
+// a reply to arrive.
+func (s *UDPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	//
+	// The port is mandatory.
+	//
+	if tst.Arguments["port"] == "" {
+		return errors.New("you must specify the port when running a UDP test")
+	}
+	port, err := strconv.Atoi(tst.Arguments["port"])
+	if err != nil {
+		return err
+	}
+
+	//
+	// Work out what we're going to send.
+	//
+	payload := []byte(tst.Arguments["send"])
+	if strings.HasPrefix(tst.Arguments["send"], "hex:") {
+		payload, err = hex.DecodeString(strings.TrimPrefix(tst.Arguments["send"], "hex:"))
+		if err != nil {
+			return fmt.Errorf("invalid hex payload: %w", err)
+		}
+	}
+
+	//
+	// Compile the regular expression early, so that a broken one
+	// is reported without any network traffic.
+	//
+	var re *regexp.Regexp
+	if tst.Arguments["expect"] != "" {
+		re, err = regexp.Compile("(?ms)" + tst.Arguments["expect"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// Default to connecting to an IPv4-address
+	//
+	address := fmt.Sprintf("%s:%d", target, port)
+
+	//
+	// If we find a ":" we know it is an IPv6 address though
+	//
+	if strings.Contains(target, ":") {
+		address = fmt.Sprintf("[%s]:%d", target, port)
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+
+	if _, err = conn.Write(payload); err != nil {
+		return err
+	}
+
+	//
+	// Wait for the reply.
+	//
+	buf := make([]byte, 65535)
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		if errNet, ok := err.(net.Error); ok && errNet.Timeout() {
+			return fmt.Errorf("no reply received within %s", opts.Timeout)
+		}
+		return err
+	}
+
+	//
+	// If the regexp doesn't match that's an error.
+	//
+	if re != nil {
+		reply := string(buf[:n])
+		if !re.MatchString(reply) {
+			return fmt.Errorf("reply '%s' didn't match the regular expression '%s'", reply, tst.Arguments["expect"])
+		}
+	}
+
+	return nil
+}
+
+func (s *UDPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("udp", func() ProtocolTest {
+		return &UDPTest{}
+	})
+}
@@ -0,0 +1,256 @@
+// Vault Tester
+//
+// The Vault tester queries the health endpoint of a HashiCorp Vault
+// server, and ensures that it is initialized and unsealed.
+//
+// This test is invoked via input like so:
+//
+//    https://vault.example.com:8200/ must run vault
+//
+// A standby node is regarded as healthy, unless the node is expected to
+// be the active one:
+//
+//    https://vault.example.com:8200/ must run vault with active true
+//
+// A token may be given, in which case it is looked up too, which ensures
+// that the storage and authentication of Vault work, and that the token
+// is still valid:
+//
+//    https://vault.example.com:8200/ must run vault with token 's.XXXXXXXX'
+//
+// The certificate of the server may be validated against a private CA,
+// given as a PEM file, or not validated at all:
+//
+//    https://vault.example.com:8200/ must run vault with ca '/etc/ssl/vault-ca.pem'
+//    https://vault.example.com:8200/ must run vault with tls insecure
+//
+
+package protocols
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// VAULTTest is our object.
+type VAULTTest struct {
+}
+
+// vaultHealth is the response of the health endpoint.
+type vaultHealth struct {
+	Initialized        bool   `json:"initialized"`
+	Sealed             bool   `json:"sealed"`
+	Standby            bool   `json:"standby"`
+	PerformanceStandby bool   `json:"performance_standby"`
+	Version            string `json:"version"`
+	ClusterName        string `json:"cluster_name"`
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *VAULTTest) Arguments() map[string]string {
+	known := map[string]string{
+		"active": "^(true|false)$",
+		"ca":     ".+",
+		"tls":    "insecure",
+		"token":  ".*",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *VAULTTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *VAULTTest) Example() string {
+	str := `
+Vault Tester
+------------
+ The Vault tester queries the health endpoint of a HashiCorp Vault
+ server, and ensures that it is initialized and unsealed.
+
+ This test is invoked via input like so:
+
+    https://vault.example.com:8200/ must run vault
+
+ A standby node is regarded as healthy, unless the node is expected to
+ be the active one:
+
+    https://vault.example.com:8200/ must run vault with active true
+
+ A token may be given, in which case it is looked up too, which ensures
+ that the storage and authentication of Vault work, and that the token
+ is still valid:
+
+    https://vault.example.com:8200/ must run vault with token 's.XXXXXXXX'
+
+ The certificate of the server may be validated against a private CA,
+ given as a PEM file, or not validated at all:
+
+    https://vault.example.com:8200/ must run vault with ca '/etc/ssl/vault-ca.pem'
+    https://vault.example.com:8200/ must run vault with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we fetch the health of the server, and optionally look
+// up the token.
+func (s *VAULTTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	u, err := url.Parse(tst.Target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("the target must be a http:// or https:// URL, got '%s'", tst.Target)
+	}
+
+	client := newPinnedHTTPClient(target, tst.Arguments["tls"] == "insecure", opts.Timeout)
+
+	if tst.Arguments["ca"] != "" && tst.Arguments["tls"] != "insecure" {
+		pem, err := ioutil.ReadFile(tst.Arguments["ca"])
+		if err != nil {
+			return &test.ConfigError{Err: fmt.Errorf("failed to read the CA: %w", err)}
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return &test.ConfigError{Err: fmt.Errorf("no certificates were found in the CA '%s'", tst.Arguments["ca"])}
+		}
+		client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	//
+	// The status code describes the health, but every status is
+	// accepted so that the reason is taken from the body.
+	//
+	endpoint := u.ResolveReference(&url.URL{Path: "/v1/sys/health"})
+	endpoint.RawQuery = url.Values{
+		"standbyok":              {"true"},
+		"perfstandbyok":          {"true"},
+		"sealedcode":             {"200"},
+		"uninitcode":             {"200"},
+		"drsecondarycode":        {"200"},
+		"performancestandbycode": {"200"},
+	}.Encode()
+
+	var health vaultHealth
+	if err = s.get(client, endpoint.String(), "", &health); err != nil {
+		return err
+	}
+
+	opts.Tracef("Vault %s, cluster '%s', initialized: %t, sealed: %t, standby: %t", health.Version, health.ClusterName, health.Initialized, health.Sealed, health.Standby)
+
+	if !health.Initialized {
+		return errors.New("vault isn't initialized")
+	}
+	if health.Sealed {
+		return errors.New("vault is sealed")
+	}
+	if health.Standby && tst.Arguments["active"] == "true" {
+		if health.PerformanceStandby {
+			return errors.New("vault is a performance standby, not the active node")
+		}
+		return errors.New("vault is a standby, not the active node")
+	}
+
+	if tst.Arguments["token"] == "" {
+		return nil
+	}
+
+	//
+	// Looking up the token needs the storage of Vault to work.
+	//
+	var lookup struct {
+		Data struct {
+			DisplayName string `json:"display_name"`
+			TTL         int64  `json:"ttl"`
+		} `json:"data"`
+	}
+
+	endpoint = u.ResolveReference(&url.URL{Path: "/v1/auth/token/lookup-self"})
+	if err = s.get(client, endpoint.String(), tst.Arguments["token"], &lookup); err != nil {
+		return fmt.Errorf("looking up the token failed: %w", err)
+	}
+
+	ttl := "never"
+	if lookup.Data.TTL > 0 {
+		ttl = (time.Duration(lookup.Data.TTL) * time.Second).String()
+	}
+	opts.Tracef("Token '%s' expires in %s", lookup.Data.DisplayName, ttl)
+
+	return nil
+}
+
+// get fetches the given URL, which must return JSON, using the token if
+// one is given.
+func (s *VAULTTest) get(client *http.Client, endpoint string, token string, result interface{}) error {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxHTTPBodySize))
+	if err != nil {
+		return err
+	}
+
+	//
+	// Errors are described by a list of messages.
+	//
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(body, &failure) == nil && len(failure.Errors) > 0 {
+			return fmt.Errorf("status code was %d not %d: %s", response.StatusCode, http.StatusOK, strings.Join(failure.Errors, ", "))
+		}
+		return fmt.Errorf("status code was %d not %d", response.StatusCode, http.StatusOK)
+	}
+
+	if err = json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+func (s *VAULTTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("vault", func() ProtocolTest {
+		return &VAULTTest{}
+	})
+}
@@ -7,16 +7,20 @@
 //
 //    host.example.com must run vnc [with port 5900]
 //
+// The banner is the version of the RFB protocol which the server offers,
+// such as "RFB 003.008", which must be sent within the timeout.
+//
 
 package protocols
 
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"net"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
 )
@@ -25,6 +29,9 @@ import (
 type VNCTest struct {
 }
 
+// vncBanner matches the protocol version sent by the server.
+var vncBanner = regexp.MustCompile(`^RFB [0-9]{3}\.[0-9]{3}\n$`)
+
 // Arguments returns the names of arguments which this protocol-test
 // understands, along with corresponding regular-expressions to validate
 // their values.
@@ -51,6 +58,9 @@ VNC Tester
  This test is invoked via input like so:
 
     host.example.com must run vnc
+
+ The banner is the version of the RFB protocol which the server offers,
+ such as "RFB 003.008", which must be sent within the timeout.
 `
 	return str
 }
@@ -84,16 +94,9 @@ func (s *VNCTest) RunTest(tst test.Test, target string, opts test.Options) error
 	d := net.Dialer{Timeout: opts.Timeout}
 
 	//
-	// Default to connecting to an IPv4-address
-	//
-	address := fmt.Sprintf("%s:%d", target, port)
-
-	//
-	// If we find a ":" we know it is an IPv6 address though
+	// The address to connect to, with IPv6 addresses in brackets
 	//
-	if strings.Contains(target, ":") {
-		address = fmt.Sprintf("[%s]:%d", target, port)
-	}
+	address := net.JoinHostPort(target, strconv.Itoa(port))
 
 	//
 	// Make the TCP connection.
@@ -102,6 +105,13 @@ func (s *VNCTest) RunTest(tst test.Test, target string, opts test.Options) error
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
 
 	//
 	// Read the banner.
@@ -110,12 +120,13 @@ func (s *VNCTest) RunTest(tst test.Test, target string, opts test.Options) error
 	if err != nil {
 		return err
 	}
-	conn.Close()
 
-	if !strings.Contains(banner, "RFB") {
+	if !vncBanner.MatchString(banner) {
 		return errors.New("banner doesn't look like VNC")
 	}
 
+	opts.Tracef("Server offers %s", strings.TrimSpace(banner))
+
 	return nil
 }
 
@@ -0,0 +1,201 @@
+// WebDAV Tester
+//
+// The WebDAV tester issues a PROPFIND request against a collection on a
+// WebDAV server, and ensures that it replies with "207 Multi-Status".
+//
+// This test is invoked via input like so:
+//
+//    https://dav.example.com/files/ must run webdav with username 'steve' with password 'secret'
+//
+// By default the collection given in the target is listed, but a
+// different one can be given:
+//
+//    https://dav.example.com/ must run webdav with username 'steve' with password 'secret' with path '/files/steve/'
+//
+// To also ensure that a particular file, or collection, is present
+// within the listing specify its name:
+//
+//    https://dav.example.com/files/ must run webdav with username 'steve' with password 'secret' with file 'report.pdf'
+//
+// If you need to disable failures due to expired, broken, or otherwise
+// bogus TLS certificates you can do so via the tls setting:
+//
+//    https://dav.example.com/files/ must run webdav with tls insecure
+//
+
+package protocols
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// WEBDAVTest is our object.
+type WEBDAVTest struct {
+}
+
+// webdavMultiStatus is the body of a "207 Multi-Status" response, of
+// which we only care about the names of the resources.
+type webdavMultiStatus struct {
+	Responses []struct {
+		Href string `xml:"href"`
+	} `xml:"DAV: response"`
+}
+
+// webdavPropfind is the body of our request, asking for the minimum.
+const webdavPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/></D:prop></D:propfind>`
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *WEBDAVTest) Arguments() map[string]string {
+	known := map[string]string{
+		"username": ".*",
+		"password": ".*",
+		"path":     "^/.*$",
+		"file":     "^[^/]+/?$",
+		"tls":      "insecure",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *WEBDAVTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *WEBDAVTest) Example() string {
+	str := `
+WebDAV Tester
+-------------
+ The WebDAV tester issues a PROPFIND request against a collection on a
+ WebDAV server, and ensures that it replies with "207 Multi-Status".
+
+ This test is invoked via input like so:
+
+    https://dav.example.com/files/ must run webdav with username 'steve' with password 'secret'
+
+ By default the collection given in the target is listed, but a
+ different one can be given:
+
+    https://dav.example.com/ must run webdav with username 'steve' with password 'secret' with path '/files/steve/'
+
+ To also ensure that a particular file, or collection, is present
+ within the listing specify its name:
+
+    https://dav.example.com/files/ must run webdav with username 'steve' with password 'secret' with file 'report.pdf'
+
+ If you need to disable failures due to expired, broken, or otherwise
+ bogus TLS certificates you can do so via the tls setting:
+
+    https://dav.example.com/files/ must run webdav with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we list the collection, and look for the file within it.
+func (s *WEBDAVTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	u, err := url.Parse(tst.Target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("the target must be a http:// or https:// URL, got '%s'", tst.Target)
+	}
+
+	if tst.Arguments["path"] != "" {
+		u.Path = tst.Arguments["path"]
+		u.RawPath = ""
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	client := newPinnedHTTPClient(target, tst.Arguments["tls"] == "insecure", opts.Timeout)
+
+	req, err := http.NewRequest("PROPFIND", u.String(), strings.NewReader(webdavPropfind))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	if tst.Arguments["username"] != "" {
+		req.SetBasicAuth(tst.Arguments["username"], tst.Arguments["password"])
+	}
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusMultiStatus {
+		return fmt.Errorf("PROPFIND %s returned status code %d, not %d", u.Path, response.StatusCode, http.StatusMultiStatus)
+	}
+
+	// Large collections have large listings, but don't read silly amounts
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxHTTPBodySize))
+	if err != nil {
+		return err
+	}
+
+	var status webdavMultiStatus
+	err = xml.Unmarshal(body, &status)
+	if err != nil {
+		return fmt.Errorf("invalid PROPFIND response: %w", err)
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tPROPFIND %s listed %d resources\n", u.Path, len(status.Responses))
+	}
+
+	//
+	// Look for the file, if we were asked to.
+	//
+	file := strings.TrimSuffix(tst.Arguments["file"], "/")
+	if file == "" {
+		return nil
+	}
+
+	for _, resource := range status.Responses {
+		href, err := url.Parse(strings.TrimSpace(resource.Href))
+		if err != nil {
+			continue
+		}
+
+		if path.Base(strings.TrimSuffix(href.Path, "/")) == file {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("'%s' wasn't found in the listing of %s", file, u.Path)
+}
+
+func (s *WEBDAVTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("webdav", func() ProtocolTest {
+		return &WEBDAVTest{}
+	})
+}
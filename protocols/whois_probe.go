@@ -0,0 +1,369 @@
+// WHOIS Tester
+//
+// The WHOIS tester looks up the registration of a domain, and ensures
+// that it won't expire soon.
+//
+// This test is invoked via input like so:
+//
+//    example.com must run whois
+//
+// By default the test fails if the domain expires within the next 30
+// days, to change the period specify it like so:
+//
+//    example.com must run whois with expiry 2160h
+//
+// The WHOIS server of the top-level domain is found via whois.iana.org,
+// unless you specify one:
+//
+//    example.com must run whois with server 'whois.verisign-grs.com'
+//
+// WHOIS formats vary wildly, and the expiry date is found via a set of
+// common patterns.  If those fail for your registry you can specify a
+// regular expression, whose first group matches the date:
+//
+//    example.ru must run whois with regex 'free-date:\s*(\S+)'
+//
+
+package protocols
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// WHOISTest is our object.
+type WHOISTest struct {
+}
+
+// whoisPatterns match the expiry date in the responses of most registries
+// and registrars.
+var whoisPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?im)^\s*registry expiry date:\s*(.+)$`),
+	regexp.MustCompile(`(?im)^\s*registrar registration expiration date:\s*(.+)$`),
+	regexp.MustCompile(`(?im)^\s*(?:expiration|expiry|expire|expires|renewal) (?:date|time|on)\s*[:.]+\s*(.+)$`),
+	regexp.MustCompile(`(?im)^\s*(?:expir(?:es|y|e|ation)|paid-till|valid-until|renewal)\s*:\s*(.+)$`),
+	regexp.MustCompile(`(?im)^\s*\[(?:expires on|state)\]\s*(.+)$`),
+}
+
+// whoisLayouts are the date formats we understand.
+var whoisLayouts = []string{
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006.01.02",
+	"2006/01/02",
+	"02-Jan-2006",
+	"02-January-2006",
+	"02.01.2006",
+	"January 2 2006",
+	"2 January 2006",
+	"Mon Jan 2 15:04:05 MST 2006",
+}
+
+// whoisReferral matches the server a response refers us to.
+var whoisReferral = regexp.MustCompile(`(?im)^\s*(?:refer|whois|registrar whois server):\s*(\S+)\s*$`)
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *WHOISTest) Arguments() map[string]string {
+	known := map[string]string{
+		"expiry": expiryArgument,
+		"regex":  ".*",
+		"server": `^[^\s/]+$`,
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *WHOISTest) ShouldResolveHostname() bool {
+	return false
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *WHOISTest) Example() string {
+	str := `
+WHOIS Tester
+------------
+ The WHOIS tester looks up the registration of a domain, and ensures
+ that it won't expire soon.
+
+ This test is invoked via input like so:
+
+    example.com must run whois
+
+ By default the test fails if the domain expires within the next 30
+ days, to change the period specify it like so:
+
+    example.com must run whois with expiry 2160h
+
+ The WHOIS server of the top-level domain is found via whois.iana.org,
+ unless you specify one:
+
+    example.com must run whois with server 'whois.verisign-grs.com'
+
+ WHOIS formats vary wildly, and the expiry date is found via a set of
+ common patterns.  If those fail for your registry you can specify a
+ regular expression, whose first group matches the date:
+
+    example.ru must run whois with regex 'free-date:\s*(\S+)'
+`
+	return str
+}
+
+// query sends the given query to a WHOIS server, and returns the response.
+func (s *WHOISTest) query(server string, query string, timeout time.Duration) (string, error) {
+	address := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		address = net.JoinHostPort(server, "43")
+	}
+
+	dialer := &net.Dialer{
+		Timeout: timeout,
+	}
+
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	_, err = fmt.Fprintf(conn, "%s\r\n", query)
+	if err != nil {
+		return "", err
+	}
+
+	// The server closes the connection once it has replied
+	response, err := ioutil.ReadAll(io.LimitReader(conn, 1024*1024))
+	if err != nil {
+		return "", err
+	}
+
+	return string(response), nil
+}
+
+// referral returns the WHOIS server the given response refers to, if any.
+func (s *WHOISTest) referral(response string) string {
+	m := whoisReferral.FindStringSubmatch(response)
+	if m == nil {
+		return ""
+	}
+
+	server := strings.TrimSpace(m[1])
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		server = u.Host
+	}
+	return server
+}
+
+// expiry finds the expiry date within a response, returning the zero time
+// if there is none.
+func (s *WHOISTest) expiry(response string, custom *regexp.Regexp) (time.Time, error) {
+	patterns := whoisPatterns
+	if custom != nil {
+		patterns = []*regexp.Regexp{custom}
+	}
+
+	for _, pattern := range patterns {
+		m := pattern.FindStringSubmatch(response)
+		if m == nil {
+			continue
+		}
+
+		value := m[0]
+		if len(m) > 1 {
+			value = m[1]
+		}
+
+		date, err := s.parseDate(value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return date, nil
+	}
+
+	return time.Time{}, nil
+}
+
+// parseDate parses a date in any of the formats we understand.
+//
+// Dates are often followed by a comment, or a timezone, so we also try
+// the value with words removed from its end.
+func (s *WHOISTest) parseDate(value string) (time.Time, error) {
+	fields := strings.Fields(strings.TrimSpace(value))
+
+	for n := len(fields); n > 0; n-- {
+		candidate := strings.Join(fields[:n], " ")
+
+		for _, layout := range whoisLayouts {
+			date, err := time.Parse(layout, candidate)
+			if err == nil {
+				return date, nil
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("failed to parse the expiry date '%s'", strings.TrimSpace(value))
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we find the WHOIS server, query it, and check the expiry
+// date it reports.
+func (s *WHOISTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	//
+	// The target might be a URL, rather than a plain domain.
+	//
+	domain := tst.Target
+	if u, err := url.Parse(domain); err == nil && u.Hostname() != "" {
+		domain = u.Hostname()
+	}
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	if !strings.Contains(domain, ".") {
+		return fmt.Errorf("'%s' is not a domain name", domain)
+	}
+
+	window := 30 * 24 * time.Hour
+	if tst.Arguments["expiry"] != "" {
+		var err error
+		window, err = time.ParseDuration(tst.Arguments["expiry"])
+		if err != nil {
+			return err
+		}
+	}
+
+	var custom *regexp.Regexp
+	if tst.Arguments["regex"] != "" {
+		var err error
+		custom, err = regexp.Compile("(?im)" + tst.Arguments["regex"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// Find the server for the top-level domain, unless we were told.
+	//
+	server := tst.Arguments["server"]
+	if server == "" {
+		tld := domain[strings.LastIndex(domain, ".")+1:]
+
+		response, err := s.query("whois.iana.org", tld, opts.Timeout)
+		if err != nil {
+			return fmt.Errorf("failed to find the WHOIS server of '.%s': %w", tld, err)
+		}
+
+		server = s.referral(response)
+		if server == "" {
+			return fmt.Errorf("there's no WHOIS server for '.%s'", tld)
+		}
+	}
+
+	response, err := s.query(server, domain, opts.Timeout)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", server, err)
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tQueried %s for %s\n", server, domain)
+	}
+
+	expires, err := s.expiry(response, custom)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Thin registries only know the registrar, which knows the rest.
+	//
+	if expires.IsZero() {
+		registrar := s.referral(response)
+		if registrar != "" && !strings.EqualFold(registrar, server) {
+			response, err = s.query(registrar, domain, opts.Timeout)
+			if err != nil {
+				return fmt.Errorf("failed to query %s: %w", registrar, err)
+			}
+
+			if opts.Verbose {
+				fmt.Printf("\tQueried %s for %s\n", registrar, domain)
+			}
+
+			expires, err = s.expiry(response, custom)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if expires.IsZero() {
+		if s.notFound(response) {
+			return fmt.Errorf("the domain %s isn't registered", domain)
+		}
+		return fmt.Errorf("no expiry date was found in the WHOIS response for %s", domain)
+	}
+
+	remaining := time.Until(expires)
+
+	if opts.Verbose {
+		fmt.Printf("\tDomain %s expires on %s, in %s\n", domain, expires.Format(time.RFC3339), remaining.Truncate(time.Second))
+	}
+
+	if remaining < 0 {
+		return fmt.Errorf("the domain %s expired on %s", domain, expires.Format("2006-01-02"))
+	}
+	if remaining < window {
+		return fmt.Errorf("the domain %s expires in %s, which is within %s", domain, remaining.Truncate(time.Second), window)
+	}
+
+	return nil
+}
+
+// notFound returns true if the response says the domain isn't registered.
+func (s *WHOISTest) notFound(response string) bool {
+	scanner := bufio.NewScanner(bytes.NewBufferString(response))
+	for scanner.Scan() {
+		line := strings.ToLower(scanner.Text())
+
+		for _, marker := range []string{"no match", "not found", "no data found", "no entries found", "status: free", "status: available"} {
+			if strings.Contains(line, marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *WHOISTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("whois", func() ProtocolTest {
+		return &WHOISTest{}
+	})
+}
@@ -0,0 +1,228 @@
+// ZooKeeper Tester
+//
+// The ZooKeeper tester connects to a ZooKeeper node, and ensures that it
+// reports itself as healthy via the `ruok` command.
+//
+// This test is invoked via input like so:
+//
+//    zk1.example.com must run zookeeper [with port 2181]
+//
+// The role of the node within its ensemble can be checked too, via the
+// `mntr` command:
+//
+//    zk1.example.com must run zookeeper with mode leader
+//
+// A leader can be required to have a minimum number of followers which
+// are in sync with it:
+//
+//    zk1.example.com must run zookeeper with mode leader with synced-followers 2
+//
+// NOTE: Since ZooKeeper 3.5 these commands must be allowed by the
+// `4lw.commands.whitelist` setting of the server.
+//
+
+package protocols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// ZOOKEEPERTest is our object.
+type ZOOKEEPERTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *ZOOKEEPERTest) Arguments() map[string]string {
+	known := map[string]string{
+		"mode":             "^(leader|follower|observer|standalone)$",
+		"port":             "^[0-9]+$",
+		"synced-followers": "^[0-9]+$",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *ZOOKEEPERTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *ZOOKEEPERTest) Example() string {
+	str := `
+ZooKeeper Tester
+----------------
+ The ZooKeeper tester connects to a ZooKeeper node, and ensures that it
+ reports itself as healthy via the 'ruok' command.
+
+ This test is invoked via input like so:
+
+    zk1.example.com must run zookeeper [with port 2181]
+
+ The role of the node within its ensemble can be checked too, via the
+ 'mntr' command:
+
+    zk1.example.com must run zookeeper with mode leader
+
+ A leader can be required to have a minimum number of followers which
+ are in sync with it:
+
+    zk1.example.com must run zookeeper with mode leader with synced-followers 2
+
+ NOTE: Since ZooKeeper 3.5 these commands must be allowed by the
+ '4lw.commands.whitelist' setting of the server.
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we send the ruok command, and optionally mntr.
+func (s *ZOOKEEPERTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	port := 2181
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// Default to connecting to an IPv4-address
+	//
+	address := fmt.Sprintf("%s:%d", target, port)
+
+	//
+	// If we find a ":" we know it is an IPv6 address though
+	//
+	if strings.Contains(target, ":") {
+		address = fmt.Sprintf("[%s]:%d", target, port)
+	}
+
+	reply, err := s.command(address, "ruok", opts.Timeout)
+	if err != nil {
+		return err
+	}
+	if reply != "imok" {
+		return fmt.Errorf("the server replied '%s' rather than 'imok'", reply)
+	}
+
+	if tst.Arguments["mode"] == "" && tst.Arguments["synced-followers"] == "" {
+		return nil
+	}
+
+	reply, err = s.command(address, "mntr", opts.Timeout)
+	if err != nil {
+		return err
+	}
+
+	//
+	// The output is a list of tab-separated keys and values, e.g.
+	// "zk_server_state	leader".
+	//
+	stats := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(reply))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) == 2 {
+			stats[fields[0]] = strings.TrimSpace(fields[1])
+		}
+	}
+
+	mode := stats["zk_server_state"]
+	if mode == "" {
+		return fmt.Errorf("the mntr output didn't include the server state")
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tThe server is running as %s\n", mode)
+	}
+
+	if tst.Arguments["mode"] != "" && mode != tst.Arguments["mode"] {
+		return fmt.Errorf("the server is running as %s, not %s", mode, tst.Arguments["mode"])
+	}
+
+	if tst.Arguments["synced-followers"] != "" {
+		expected, err := strconv.Atoi(tst.Arguments["synced-followers"])
+		if err != nil {
+			return err
+		}
+
+		// Only the leader reports on its followers
+		if mode != "leader" {
+			return fmt.Errorf("the server is running as %s, only a leader reports its synced followers", mode)
+		}
+
+		synced, err := strconv.Atoi(stats["zk_synced_followers"])
+		if err != nil {
+			return fmt.Errorf("the mntr output had an invalid number of synced followers '%s'", stats["zk_synced_followers"])
+		}
+		if synced < expected {
+			return fmt.Errorf("the leader has %d synced followers, less than %d", synced, expected)
+		}
+	}
+
+	return nil
+}
+
+// command sends a four-letter command, and returns the reply the server
+// sends before closing the connection.
+func (s *ZOOKEEPERTest) command(address string, command string, timeout time.Duration) (string, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err = io.WriteString(conn, command); err != nil {
+		return "", err
+	}
+
+	reply, err := ioutil.ReadAll(io.LimitReader(conn, 1024*1024))
+	if err != nil {
+		return "", err
+	}
+
+	//
+	// Commands which aren't whitelisted are refused with a message,
+	// e.g. "ruok is not executed because it is not in the whitelist."
+	//
+	text := strings.TrimSpace(string(reply))
+	if strings.Contains(text, "not in the whitelist") {
+		return "", fmt.Errorf("the server refused the '%s' command, it must be added to 4lw.commands.whitelist", command)
+	}
+
+	return text, nil
+}
+
+func (s *ZOOKEEPERTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("zookeeper", func() ProtocolTest {
+		return &ZOOKEEPERTest{}
+	})
+}
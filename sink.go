@@ -0,0 +1,247 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+
+	_ "github.com/go-sql-driver/mysql" // Register the mysql driver
+	_ "github.com/lib/pq"              // Register the postgres driver
+)
+
+// sink stores the result of every test run, alongside the results-queue,
+// e.g. to keep their history.
+type sink interface {
+	// Write stores the result, which may happen in the background.
+	Write(result *test.Result) error
+
+	// Close stores any pending results, and releases the sink.
+	Close() error
+}
+
+// sqlDialect holds what differs between the SQL databases we support.
+type sqlDialect struct {
+	// The name of the database/sql driver
+	driver string
+
+	// The column-type of the time of the results
+	timeType string
+
+	// The placeholder of the i-th (zero-based) argument of a statement
+	placeholder func(i int) string
+}
+
+// sqlDialects are the databases a sqlSink can use, by name.
+var sqlDialects = map[string]sqlDialect{
+	"mysql": {
+		driver:      "mysql",
+		timeType:    "DATETIME",
+		placeholder: func(i int) string { return "?" },
+	},
+	"postgres": {
+		driver:      "postgres",
+		timeType:    "TIMESTAMP WITH TIME ZONE",
+		placeholder: func(i int) string { return fmt.Sprintf("$%d", i+1) },
+	},
+}
+
+// The table-names a sqlSink accepts, as they can't be given as arguments.
+var sqlTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// The columns of each row, in the order of sqlSink.row.
+var sqlColumns = []string{"time", "target", "protocol", "status", "error", "duration", "input", "tag"}
+
+// sqlSink inserts a row per result into a table of a SQL database, which
+// is created if missing.
+//
+// Results are inserted in batches, by a goroutine, once the batch is full
+// or a second after its first result.
+type sqlSink struct {
+	db      *sql.DB
+	dialect sqlDialect
+	table   string
+
+	// How many results are inserted by a single statement, at most
+	batchSize int
+
+	results chan *test.Result
+	done    chan struct{}
+
+	// Set once the sink is closed, as workers abandoned at shutdown may
+	// still write results
+	closed bool
+	lock   sync.Mutex
+}
+
+// newSQLSink connects to the database, of the given dialect, and creates
+// the table if missing.
+func newSQLSink(dialect string, dsn string, table string, batchSize int) (*sqlSink, error) {
+	d, ok := sqlDialects[dialect]
+	if !ok {
+		return nil, fmt.Errorf("unknown SQL driver '%s', must be 'mysql' or 'postgres'", dialect)
+	}
+	if !sqlTableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid SQL table-name '%s'", table)
+	}
+	if batchSize < 1 {
+		return nil, errors.New("the SQL batch-size must be at least 1")
+	}
+
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	time %s NOT NULL,
+	target VARCHAR(255) NOT NULL,
+	protocol VARCHAR(64) NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	error TEXT NULL,
+	duration BIGINT NULL,
+	input TEXT NOT NULL,
+	tag VARCHAR(255) NOT NULL
+)`, table, d.timeType))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create the table '%s': %s", table, err.Error())
+	}
+
+	s := &sqlSink{
+		db:        db,
+		dialect:   d,
+		table:     table,
+		batchSize: batchSize,
+		results:   make(chan *test.Result, batchSize*10),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+
+	return s, nil
+}
+
+// Write queues a copy of the result to be inserted, failing rather than
+// blocking the worker should the database fall behind.
+func (s *sqlSink) Write(result *test.Result) error {
+	copied := *result
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return errors.New("the SQL sink is closed, dropping the result")
+	}
+
+	select {
+	case s.results <- &copied:
+		return nil
+	default:
+		return errors.New("too many results are waiting to be inserted, dropping the result")
+	}
+}
+
+// Close inserts the queued results, and closes the database.
+func (s *sqlSink) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	close(s.results)
+	s.lock.Unlock()
+
+	<-s.done
+	return s.db.Close()
+}
+
+// run inserts the queued results, in batches, until the sink is closed.
+func (s *sqlSink) run() {
+	defer close(s.done)
+
+	var batch []*test.Result
+	var timeout <-chan time.Time
+
+	for {
+		select {
+		case result, ok := <-s.results:
+			if !ok {
+				s.insert(batch)
+				return
+			}
+			batch = append(batch, result)
+			if len(batch) == 1 {
+				timeout = time.After(time.Second)
+			}
+			if len(batch) < s.batchSize {
+				continue
+			}
+
+		case <-timeout:
+		}
+
+		s.insert(batch)
+		batch = nil
+		timeout = nil
+	}
+}
+
+// insert inserts the given results with a single statement, and only
+// logs a failure, so that a broken database can't affect the testing.
+func (s *sqlSink) insert(batch []*test.Result) {
+	if len(batch) == 0 {
+		return
+	}
+
+	query, args := s.statement(batch)
+	if _, err := s.db.Exec(query, args...); err != nil {
+		fmt.Printf("Failed to insert %d results into '%s': %s\n", len(batch), s.table, err.Error())
+	}
+}
+
+// statement returns the INSERT statement of the given results, and its
+// arguments.
+func (s *sqlSink) statement(batch []*test.Result) (string, []interface{}) {
+	rows := make([]string, 0, len(batch))
+	args := make([]interface{}, 0, len(batch)*len(sqlColumns))
+
+	for _, result := range batch {
+		placeholders := make([]string, len(sqlColumns))
+		for i := range placeholders {
+			placeholders[i] = s.dialect.placeholder(len(args) + i)
+		}
+		rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
+		args = append(args, s.row(result)...)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", s.table, strings.Join(sqlColumns, ", "), strings.Join(rows, ", "))
+	return query, args
+}
+
+// row returns the values of the columns for the given result.
+func (s *sqlSink) row(result *test.Result) []interface{} {
+	status := "passed"
+	var errorValue sql.NullString
+	if result.Error != nil {
+		status = "failed"
+		errorValue = sql.NullString{String: *result.Error, Valid: true}
+	}
+
+	var duration sql.NullInt64
+	if result.Duration != nil {
+		duration = sql.NullInt64{Int64: *result.Duration, Valid: true}
+	}
+
+	return []interface{}{
+		time.Unix(result.Time, 0).UTC(),
+		result.Target,
+		result.Type,
+		status,
+		errorValue,
+		duration,
+		result.Input,
+		result.Tag,
+	}
+}
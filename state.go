@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// The state-stores, which record the last state of each test for the
+// "changes" notify-mode.
+const (
+	// In the memory of the worker
+	stateStoreMemory = "memory"
+
+	// In redis, shared by every worker and kept across restarts
+	stateStoreRedis = "redis"
+)
+
+// stateStore records whether each test failed last time it was run, keyed
+// by the hash of its result.
+type stateStore interface {
+	// Swap records the new state of a test, and returns the previous
+	// one, which is false (passed) for a test which wasn't seen yet.
+	Swap(hash string, failed bool) (bool, error)
+}
+
+// memoryStateStore keeps the states in the memory of the worker, so that
+// each worker tracks the tests it ran itself, from when it started.
+type memoryStateStore struct {
+	states map[string]bool
+	lock   sync.Mutex
+}
+
+// newMemoryStateStore returns an empty store.
+func newMemoryStateStore() *memoryStateStore {
+	return &memoryStateStore{states: make(map[string]bool)}
+}
+
+// Swap records the new state of a test, and returns the previous one.
+func (m *memoryStateStore) Swap(hash string, failed bool) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	previous := m.states[hash]
+	m.states[hash] = failed
+	return previous, nil
+}
+
+// redisStateStore keeps the states in redis, so that they're shared by
+// every worker and survive restarts.
+type redisStateStore struct {
+	r *redis.Client
+
+	// How long the state of a test which isn't run anymore is kept
+	expiry time.Duration
+}
+
+// newRedisStateStore returns a store using the given redis-server.
+func newRedisStateStore(r *redis.Client, expiry time.Duration) *redisStateStore {
+	return &redisStateStore{r: r, expiry: expiry}
+}
+
+func (s *redisStateStore) key(hash string) string {
+	return fmt.Sprintf("overseer.state.%s", hash)
+}
+
+// Swap records the new state of a test, and returns the previous one.
+func (s *redisStateStore) Swap(hash string, failed bool) (bool, error) {
+	key := s.key(hash)
+
+	var getSet *redis.StringCmd
+	_, err := s.r.TxPipelined(func(pipe redis.Pipeliner) error {
+		getSet = pipe.GetSet(key, failed)
+		pipe.Expire(key, s.expiry)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	previous, err := getSet.Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return previous == "1", nil
+}
@@ -0,0 +1,170 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/utils"
+)
+
+// ConditionalArgument is the value an argument takes while a condition
+// holds, given as `with status[sat,sun] 503`.
+type ConditionalArgument struct {
+	// The name of the argument, e.g. `status`
+	Name string
+
+	// The condition, e.g. `sat,sun`, see ParseCondition
+	Condition string
+
+	// The value of the argument while the condition holds
+	Value string
+}
+
+// Condition is a set of terms, all of which must hold for the condition
+// to be active.
+type Condition struct {
+	// The days on which the condition holds, all if empty
+	days map[time.Weekday]bool
+
+	// The daily period during which the condition holds, if any
+	period *utils.QuietHours
+
+	// The environment-variable which must be set, and the value it
+	// must have if not empty
+	env   string
+	value string
+}
+
+// The names of the days, as used in conditions.
+var conditionDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseCondition parses a condition, which is made of terms joined by
+// `+`, each of which is one of:
+//
+//    sat,sun          the days on which the condition holds
+//    02:00-04:00      the daily period during which it holds, in local time
+//    env:NAME         the environment-variable NAME is set to a non-empty value
+//    env:NAME=VALUE   the environment-variable NAME has the given value
+//
+// For example `sat,sun+02:00-04:00` holds in the small hours of weekends.
+func ParseCondition(value string) (*Condition, error) {
+	if value == "" {
+		return nil, fmt.Errorf("empty condition")
+	}
+
+	c := &Condition{}
+
+	for _, term := range strings.Split(value, "+") {
+		switch {
+		case strings.HasPrefix(term, "env:"):
+			if c.env != "" {
+				return nil, fmt.Errorf("invalid condition '%s', only one environment-variable may be given", value)
+			}
+			c.env = strings.TrimPrefix(term, "env:")
+			if i := strings.Index(c.env, "="); i >= 0 {
+				c.value = c.env[i+1:]
+				c.env = c.env[:i]
+			}
+			if c.env == "" {
+				return nil, fmt.Errorf("invalid condition '%s', the environment-variable has no name", value)
+			}
+
+		case strings.Contains(term, ":"):
+			if c.period != nil {
+				return nil, fmt.Errorf("invalid condition '%s', only one period may be given", value)
+			}
+			period, err := utils.ParseQuietHours(term)
+			if err != nil {
+				return nil, fmt.Errorf("invalid condition '%s', the period must be e.g. 02:00-04:00", value)
+			}
+			c.period = period
+
+		default:
+			if c.days != nil {
+				return nil, fmt.Errorf("invalid condition '%s', only one list of days may be given", value)
+			}
+			c.days = make(map[time.Weekday]bool)
+			for _, name := range strings.Split(term, ",") {
+				day, ok := conditionDays[strings.ToLower(name)]
+				if !ok {
+					return nil, fmt.Errorf("invalid condition '%s', unknown day '%s'", value, name)
+				}
+				c.days[day] = true
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// Active returns true if the condition holds at the given time.
+func (c *Condition) Active(t time.Time) bool {
+	if c.days != nil && !c.days[t.Local().Weekday()] {
+		return false
+	}
+	if c.period != nil && !c.period.Active(t) {
+		return false
+	}
+	if c.env != "" {
+		set := os.Getenv(c.env)
+		if set == "" || (c.value != "" && set != c.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolve returns a copy of the test in which the conditional arguments
+// whose conditions hold at the given time replace the plain arguments,
+// along with the conditions which were active.
+//
+// Should several conditions of the same argument hold, the first in the
+// order of their conditions wins.
+func (obj *Test) Resolve(t time.Time) (Test, []string) {
+	if len(obj.ConditionalArguments) == 0 {
+		return *obj, nil
+	}
+
+	conditionals := append([]ConditionalArgument{}, obj.ConditionalArguments...)
+	sort.SliceStable(conditionals, func(i, j int) bool {
+		return conditionals[i].Condition < conditionals[j].Condition
+	})
+
+	resolved := *obj
+	resolved.Arguments = make(map[string]string)
+	for name, value := range obj.Arguments {
+		resolved.Arguments[name] = value
+	}
+
+	replaced := make(map[string]bool)
+	var active []string
+
+	for _, arg := range conditionals {
+		if replaced[arg.Name] {
+			continue
+		}
+
+		// Conditions were validated when the test was parsed
+		c, err := ParseCondition(arg.Condition)
+		if err != nil || !c.Active(t) {
+			continue
+		}
+
+		resolved.Arguments[arg.Name] = arg.Value
+		replaced[arg.Name] = true
+		active = append(active, fmt.Sprintf("%s[%s]", arg.Name, arg.Condition))
+	}
+
+	return resolved, active
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"regexp/syntax"
+	"strconv"
+	"syscall"
+)
+
+// FailureKind describes why a test failed.
+type FailureKind int
+
+// The kinds of failure a test may report, in increasing order of
+// precedence.
+const (
+	// FailureNone means the test passed.
+	FailureNone FailureKind = iota
+
+	// FailureAssertion means the target was reached, but didn't behave
+	// as the test expected.
+	FailureAssertion
+
+	// FailureConnectivity means the target couldn't be reached at all.
+	FailureConnectivity
+
+	// FailureConfig means the test itself is invalid.
+	FailureConfig
+)
+
+// String returns the name of the failure kind.
+func (k FailureKind) String() string {
+	switch k {
+	case FailureNone:
+		return "none"
+	case FailureAssertion:
+		return "assertion"
+	case FailureConnectivity:
+		return "connectivity"
+	case FailureConfig:
+		return "config"
+	}
+	return "unknown"
+}
+
+// ConnectivityError is returned when the target of a test couldn't be
+// reached.
+type ConnectivityError struct {
+	Err error
+}
+
+func (e *ConnectivityError) Error() string { return e.Err.Error() }
+func (e *ConnectivityError) Unwrap() error { return e.Err }
+
+// ConfigError is returned when a test is invalid, e.g. because one of its
+// arguments can't be used.
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string { return e.Err.Error() }
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// Classify returns the kind of failure the given error represents.
+//
+// Protocol-testers may return a ConnectivityError or a ConfigError to be
+// explicit, otherwise network errors are regarded as connectivity
+// failures, errors parsing arguments as configuration failures, and
+// anything else as an assertion failure.
+func Classify(err error) FailureKind {
+	if err == nil {
+		return FailureNone
+	}
+
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		return FailureConfig
+	}
+	var connectivityErr *ConnectivityError
+	if errors.As(err, &connectivityErr) {
+		return FailureConnectivity
+	}
+
+	var numErr *strconv.NumError
+	var syntaxErr *syntax.Error
+	if errors.As(err, &numErr) || errors.As(err, &syntaxErr) {
+		return FailureConfig
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return FailureConnectivity
+	}
+	for _, target := range []error{io.EOF, io.ErrUnexpectedEOF, syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EHOSTUNREACH, syscall.ENETUNREACH} {
+		if errors.Is(err, target) {
+			return FailureConnectivity
+		}
+	}
+
+	return FailureAssertion
+}
@@ -0,0 +1,61 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// timeoutError is a net.Error which timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Test the classification of the errors of tests
+func TestClassify(t *testing.T) {
+	_, numErr := strconv.Atoi("ten")
+	_, regexpErr := regexp.Compile("(")
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}
+
+	tests := []struct {
+		name string
+		err  error
+		kind FailureKind
+	}{
+		{"nil", nil, FailureNone},
+		{"plain", errors.New("status code was 500 not 200"), FailureAssertion},
+		{"plain formatted", fmt.Errorf("expected %d results, got %d", 2, 1), FailureAssertion},
+
+		{"explicit connectivity", &ConnectivityError{Err: errors.New("test timed out after 15s")}, FailureConnectivity},
+		{"wrapped connectivity", fmt.Errorf("%w (after 5 attempts)", &ConnectivityError{Err: errors.New("down")}), FailureConnectivity},
+		{"timeout", timeoutError{}, FailureConnectivity},
+		{"wrapped timeout", fmt.Errorf("the http leg failed: %w", timeoutError{}), FailureConnectivity},
+		{"refused", refused, FailureConnectivity},
+		{"wrapped refused", fmt.Errorf("connection failed: %w", refused), FailureConnectivity},
+		{"bare refused", syscall.ECONNREFUSED, FailureConnectivity},
+		{"eof", fmt.Errorf("no connection confirm was received: %w", io.ErrUnexpectedEOF), FailureConnectivity},
+
+		{"explicit config", &ConfigError{Err: errors.New("failed to read the CA")}, FailureConfig},
+		{"strconv", numErr, FailureConfig},
+		{"wrapped strconv", fmt.Errorf("invalid max-loss: %w", numErr), FailureConfig},
+		{"regexp", regexpErr, FailureConfig},
+
+		// Flattened errors lose their type
+		{"flattened timeout", fmt.Errorf("the http leg failed: %s", timeoutError{}.Error()), FailureAssertion},
+	}
+
+	for _, tst := range tests {
+		if kind := Classify(tst.err); kind != tst.kind {
+			t.Errorf("%s: expected %s, got %s", tst.name, tst.kind, kind)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Filter selects tests by their label or target.
+//
+// The pattern is a glob, in which `*` matches any run of characters and `?`
+// a single one, e.g. `payments-*`.  A pattern enclosed in slashes is a
+// regular expression instead, e.g. `/^payments-(eu|us)$/`.
+type Filter struct {
+	re *regexp.Regexp
+}
+
+// NewFilter creates a filter from the given pattern.
+func NewFilter(pattern string) (*Filter, error) {
+	expr := ""
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expr = pattern[1 : len(pattern)-1]
+	} else {
+		expr = regexp.QuoteMeta(pattern)
+		expr = strings.Replace(expr, `\*`, ".*", -1)
+		expr = strings.Replace(expr, `\?`, ".", -1)
+		expr = "^" + expr + "$"
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{re: re}, nil
+}
+
+// Match returns true if the label, or the target, of the test matches.
+//
+// A nil filter matches every test.
+func (f *Filter) Match(tst Test) bool {
+	if f == nil {
+		return true
+	}
+
+	if tst.TestLabel != nil && f.re.MatchString(*tst.TestLabel) {
+		return true
+	}
+	return f.re.MatchString(tst.Target)
+}
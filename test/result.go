@@ -9,12 +9,36 @@ import (
 )
 
 // Result contains a single test result
+//
+// Results are published by the worker as JSON objects, which consumers
+// may rely upon, e.g:
+//
+//    {
+//      "input": "example.com must run http with status '200'",
+//      "target": "93.184.216.34",
+//      "time": 1589810400,
+//      "duration": 182,
+//      "type": "http",
+//      "tag": "",
+//      "error": "status code was 500 not 200",
+//      ...
+//    }
+//
+// A test passed when its error is null. Fields are only ever added, never
+// renamed or removed.
 type Result struct {
+	// The sanitized input of the test, and the address it was run against
 	Input  string `json:"input"`
 	Target string `json:"target"`
-	Time   int64  `json:"time"`
-	Type   string `json:"type"`
-	Tag    string `json:"tag"`
+
+	// Unix time, in seconds, at which the result was published
+	Time int64 `json:"time"`
+
+	// How long the test took, in milliseconds, if it was run
+	Duration *int64 `json:"duration,omitempty"`
+
+	Type string `json:"type"`
+	Tag  string `json:"tag"`
 
 	// If not nil, test has failed
 	Error *string `json:"error"`
@@ -36,6 +60,9 @@ type Result struct {
 
 	// If not nil, describes result with a custom label
 	TestLabel *string `json:"testLabel"`
+
+	// Severity of the test, if one was given
+	Severity string `json:"severity,omitempty"`
 }
 
 // Hash generates a unique identifier for the original test (e.g. to deduplicate same results)
@@ -19,6 +19,8 @@ package test
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"sort"
 	"time"
 )
@@ -52,7 +54,7 @@ type Test struct {
 	// If not nil, avoid re-triggering the same notification on failure for the defined amount of time, or until test succeeds again
 	DedupDuration *time.Duration
 
-	// Total-test timeout
+	// Total-test timeout, overriding the one in the options if set
 	Timeout *time.Duration
 
 	// Arguments contains a map of any optional arguments supplied to
@@ -63,6 +65,13 @@ type Test struct {
 	//
 	Arguments map[string]string
 
+	// ConditionalArguments replace the values of Arguments while their
+	// conditions hold, see Resolve.
+	//
+	// For example `with status[sat,sun] 503` expects a different status
+	// at weekends.
+	ConditionalArguments []ConditionalArgument
+
 	// PeriodTestDuration triggers a period test: Overseer will execute the defined test repeatedly for the specified
 	// duration, with pauses between subsequent tests determined by PeriodTestSleep.
 	PeriodTestDuration *time.Duration
@@ -78,6 +87,49 @@ type Test struct {
 
 	// It not nil, describes the test with a custom tag/label
 	TestLabel *string
+
+	// Severity of a failure of this test, e.g. SeverityCritical
+	Severity string
+
+	// Priority of the test in the queue, e.g. PriorityHigh, or empty for PriorityNormal
+	Priority string
+
+	// If not nil, the test fails when its latency exceeds this percentile [1-99] of its recent latencies
+	LatencyPercentile *float64
+
+	// If not nil, overrides the percentage [0-1] by which the latency may exceed the percentile
+	LatencyMargin *float32
+}
+
+// The severities a test may be given.
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// The priorities a test may be given.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// sensitiveArguments are the arguments whose values must never be shown.
+var sensitiveArguments = map[string]bool{
+	"password": true,
+	"token":    true,
+	"psk":      true,
+	"secret":   true,
+	"bearer":   true,
+
+	"client-secret":  true,
+	"proxy-password": true,
+	"oauth-token":    true,
+
+	"community":     true,
+	"auth-password": true,
+	"priv-password": true,
 }
 
 // Sanitize returns a copy of the input string, but with any password
@@ -98,9 +150,9 @@ func (obj *Test) Sanitize() string {
 	for _, k := range keys {
 		tmp := ""
 
-		// Censor passwords
-		if k == "password" {
-			tmp = " with password 'CENSORED'"
+		// Censor passwords, and other secrets
+		if sensitiveArguments[k] {
+			tmp = fmt.Sprintf(" with %s 'CENSORED'", k)
 		} else {
 
 			// Otherwise leave alone.
@@ -109,6 +161,23 @@ func (obj *Test) Sanitize() string {
 		res += tmp
 	}
 
+	// Conditional arguments, in the order they're resolved
+	conditionals := append([]ConditionalArgument{}, obj.ConditionalArguments...)
+	sort.SliceStable(conditionals, func(i, j int) bool {
+		if conditionals[i].Name != conditionals[j].Name {
+			return conditionals[i].Name < conditionals[j].Name
+		}
+		return conditionals[i].Condition < conditionals[j].Condition
+	})
+
+	for _, arg := range conditionals {
+		if sensitiveArguments[arg.Name] {
+			res += fmt.Sprintf(" with %s[%s] 'CENSORED'", arg.Name, arg.Condition)
+		} else {
+			res += fmt.Sprintf(" with %s[%s] '%s'", arg.Name, arg.Condition, arg.Value)
+		}
+	}
+
 	return res
 }
 
@@ -122,7 +191,32 @@ type Options struct {
 	// Should the protocol-tests run verbosely?
 	Verbose bool
 
+	// Where the protocol-tests write the steps they take when running
+	// verbosely, see Tracef.  If nil, they're written to STDOUT.
+	Trace io.Writer
+
+	// How many times should a failing test be re-run before it is
+	// regarded as a failure?
+	Retry int
+
+	// How long to wait between attempts of a failing test.
+	RetryDelay time.Duration
+
 	// If this is a period test, we may want to replace vars in the target address
 	PeriodTestIndex     int
 	PeriodTestStartTime int64
 }
+
+// Tracef records a step taken by a protocol-test, if it is running
+// verbosely.
+func (o Options) Tracef(format string, args ...interface{}) {
+	if !o.Verbose {
+		return
+	}
+
+	out := o.Trace
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintf(out, "\t"+format+"\n", args...)
+}
@@ -25,9 +25,11 @@ func onSignalInterrupt(fn func()) {
 }
 
 func onSignals(fn func(), sig ...os.Signal) {
+	// Registered before returning, so that no signal is missed
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, sig...)
+
 	go func() {
-		signalCh := make(chan os.Signal, 1)
-		signal.Notify(signalCh, sig...)
 		<-signalCh
 
 		fn()
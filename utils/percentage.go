@@ -2,7 +2,9 @@ package utils
 
 import (
 	"fmt"
+	"math"
 	"regexp"
+	"sort"
 	"strconv"
 )
 
@@ -44,3 +46,24 @@ func (i *PercentageValue) Set(s string) error {
 func (i *PercentageValue) Get() interface{} { return float32(*i) }
 
 func (i *PercentageValue) String() string { return fmt.Sprintf("%.2f%%", float32(*i)*100) }
+
+// Percentile returns the nearest-rank percentile [0-100] of the given values
+func Percentile(values []float64, percentile float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+
+	return sorted[rank-1]
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var quietHoursRegex = regexp.MustCompile(`^(\d{1,2}):(\d{2})-(\d{1,2}):(\d{2})$`)
+
+// QuietHours is a daily period, such as 22:00-07:00, during which
+// non-critical notifications should be held back.
+//
+// Times are in the local timezone, which can be changed via $TZ.
+type QuietHours struct {
+	// Start and end of the period, in minutes since midnight
+	start int
+	end   int
+}
+
+// ParseQuietHours parses a period such as "22:00-07:00".  An empty value
+// means there are no quiet hours, and nil is returned.
+func ParseQuietHours(value string) (*QuietHours, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	matches := quietHoursRegex.FindStringSubmatch(value)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("invalid quiet hours '%s', must be e.g. 22:00-07:00", value)
+	}
+
+	var minutes [4]int
+	for i := range minutes {
+		minutes[i], _ = strconv.Atoi(matches[i+1])
+	}
+
+	if minutes[0] > 23 || minutes[2] > 23 || minutes[1] > 59 || minutes[3] > 59 {
+		return nil, fmt.Errorf("invalid quiet hours '%s', times must be between 00:00 and 23:59", value)
+	}
+
+	q := &QuietHours{
+		start: minutes[0]*60 + minutes[1],
+		end:   minutes[2]*60 + minutes[3],
+	}
+	if q.start == q.end {
+		return nil, fmt.Errorf("invalid quiet hours '%s', the period is empty", value)
+	}
+
+	return q, nil
+}
+
+// Active returns true if the given time is within the quiet hours.
+func (q *QuietHours) Active(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+
+	t = t.Local()
+	minute := t.Hour()*60 + t.Minute()
+
+	// The period may span midnight
+	if q.start < q.end {
+		return minute >= q.start && minute < q.end
+	}
+	return minute >= q.start || minute < q.end
+}
+
+func (q *QuietHours) String() string {
+	if q == nil {
+		return ""
+	}
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", q.start/60, q.start%60, q.end/60, q.end%60)
+}
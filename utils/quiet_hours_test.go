@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// Test parsing valid, and invalid, quiet hours
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		Value    string
+		Valid    bool
+		Expected string
+	}{
+		{"22:00-07:00", true, "22:00-07:00"},
+		{"9:30-17:45", true, "09:30-17:45"},
+		{"00:00-23:59", true, "00:00-23:59"},
+		{"23:59-00:00", true, "23:59-00:00"},
+		{"22:00", false, ""},
+		{"22-07", false, ""},
+		{"22:00-7", false, ""},
+		{"24:00-07:00", false, ""},
+		{"22:00-07:60", false, ""},
+		{"07:00-07:00", false, ""},
+		{" 22:00-07:00", false, ""},
+	}
+
+	for _, tst := range tests {
+		q, err := ParseQuietHours(tst.Value)
+
+		if !tst.Valid {
+			if err == nil {
+				t.Errorf("Expected '%s' to be invalid", tst.Value)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Expected '%s' to be valid, got %s", tst.Value, err)
+			continue
+		}
+		if q.String() != tst.Expected {
+			t.Errorf("Expected '%s' to be parsed as '%s', got '%s'", tst.Value, tst.Expected, q.String())
+		}
+	}
+}
+
+// Test that no quiet hours are never active
+func TestNoQuietHours(t *testing.T) {
+	q, err := ParseQuietHours("")
+	if err != nil {
+		t.Fatalf("Expected no quiet hours, got %s", err)
+	}
+	if q != nil {
+		t.Fatalf("Expected no quiet hours, got '%s'", q)
+	}
+
+	if q.Active(time.Now()) {
+		t.Errorf("Expected no quiet hours not to be active")
+	}
+}
+
+// Test when quiet hours are active, within a day and across midnight
+func TestQuietHoursActive(t *testing.T) {
+	tests := []struct {
+		Period string
+		Time   string
+		Active bool
+	}{
+		// Within a day, the start being before the end
+		{"09:00-17:00", "08:59", false},
+		{"09:00-17:00", "09:00", true},
+		{"09:00-17:00", "12:30", true},
+		{"09:00-17:00", "16:59", true},
+		{"09:00-17:00", "17:00", false},
+		{"09:00-17:00", "23:00", false},
+
+		// Across midnight, the start being after the end
+		{"22:00-07:00", "21:59", false},
+		{"22:00-07:00", "22:00", true},
+		{"22:00-07:00", "23:59", true},
+		{"22:00-07:00", "00:00", true},
+		{"22:00-07:00", "06:59", true},
+		{"22:00-07:00", "07:00", false},
+		{"22:00-07:00", "12:00", false},
+
+		// Ending at midnight
+		{"20:00-00:00", "19:59", false},
+		{"20:00-00:00", "23:59", true},
+		{"20:00-00:00", "00:00", false},
+	}
+
+	for _, tst := range tests {
+		q, err := ParseQuietHours(tst.Period)
+		if err != nil {
+			t.Fatalf("Failed to parse '%s': %s", tst.Period, err)
+		}
+
+		clock, err := time.Parse("15:04", tst.Time)
+		if err != nil {
+			t.Fatalf("Failed to parse '%s': %s", tst.Time, err)
+		}
+		now := time.Date(2020, time.March, 1, clock.Hour(), clock.Minute(), 30, 0, time.Local)
+
+		if q.Active(now) != tst.Active {
+			t.Errorf("Expected '%s' active at %s to be %t", tst.Period, tst.Time, tst.Active)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// LoadTemplate parses the text/template held in the given file, which lets
+// the messages a notifier sends be customized.  If no file is given the
+// fallback is returned instead.
+//
+// Leading, and trailing, whitespace is removed from the template, as it is
+// from the built-in ones.
+func LoadTemplate(path string, fallback *template.Template) (*template.Template, error) {
+	if path == "" {
+		return fallback, nil
+	}
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return template.New(filepath.Base(path)).Parse(strings.TrimSpace(string(src)))
+}